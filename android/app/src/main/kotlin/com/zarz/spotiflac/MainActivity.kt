@@ -865,6 +865,33 @@ class MainActivity: FlutterFragmentActivity() {
         return if (lastModified > 0L) "$normalizedPath|$lastModified" else normalizedPath
     }
 
+    /**
+     * Returns the library entries of a scanned SAF audio file with their
+     * stable paths: the "#trackNN" tracks of an embedded cue sheet, or the
+     * file itself.
+     */
+    private fun safLibraryEntries(
+        metadataObj: JSONObject,
+        stableUri: String,
+    ): List<Pair<String, JSONObject>> {
+        val cueTracks = metadataObj.optJSONArray("cueTracks")
+        metadataObj.remove("cueTracks")
+        val entries = mutableListOf<Pair<String, JSONObject>>()
+        if (cueTracks != null) {
+            for (i in 0 until cueTracks.length()) {
+                val track = cueTracks.optJSONObject(i) ?: continue
+                val trackPath = track.optString("filePath", "")
+                val hashIdx = trackPath.lastIndexOf("#track")
+                if (hashIdx < 0) continue
+                entries.add(stableUri + trackPath.substring(hashIdx) to track)
+            }
+        }
+        if (entries.isEmpty()) {
+            entries.add(stableUri to metadataObj)
+        }
+        return entries
+    }
+
     private fun readAudioMetadataFromUri(
         uri: Uri,
         displayNameHint: String? = null,
@@ -1416,10 +1443,12 @@ class MainActivity: FlutterFragmentActivity() {
                 errors++
             } else {
                 try {
-                    metadataObj.put("id", buildStableLibraryId(stableUri))
-                    metadataObj.put("filePath", stableUri)
-                    metadataObj.put("fileModTime", lastModified)
-                    results.put(metadataObj)
+                    for ((entryPath, entry) in safLibraryEntries(metadataObj, stableUri)) {
+                        entry.put("id", buildStableLibraryId(entryPath))
+                        entry.put("filePath", entryPath)
+                        entry.put("fileModTime", lastModified)
+                        results.put(entry)
+                    }
                 } catch (_: Exception) {
                     errors++
                 }
@@ -1583,7 +1612,12 @@ class MainActivity: FlutterFragmentActivity() {
                                 cueFilesToScan.add(Triple(child, dir, lastModified))
                             }
                         } else if (ext.isNotBlank() && supportedAudioExt.contains(".$ext")) {
+                            // A FLAC with an embedded cue sheet is stored as its
+                            // "#trackNN" entries, which stay valid while the file
+                            // is unchanged.
+                            val virtualPaths = existingCueVirtualPaths[uriStr]
                             val existingModified = existingFiles[uriStr]
+                                ?: virtualPaths?.firstOrNull()?.let { existingFiles[it] }
                             val lastModified = try {
                                 child.lastModified()
                             } catch (_: Exception) {
@@ -1592,6 +1626,8 @@ class MainActivity: FlutterFragmentActivity() {
 
                             if (existingModified == null || existingModified != lastModified) {
                                 audioFiles.add(Triple(child, path, lastModified))
+                            } else if (virtualPaths != null) {
+                                currentUris.addAll(virtualPaths)
                             }
                         }
                     }
@@ -1819,11 +1855,21 @@ class MainActivity: FlutterFragmentActivity() {
                 errors++
             } else {
                 try {
-                    metadataObj.put("id", buildStableLibraryId(stableUri))
-                    metadataObj.put("filePath", stableUri)
-                    metadataObj.put("fileModTime", safeLastModified)
-                    metadataObj.put("lastModified", safeLastModified)
-                    results.put(metadataObj)
+                    // A FLAC that gained or lost an embedded cue sheet switches
+                    // between its own entry and "#trackNN" entries; entries of
+                    // the old form are left out of currentUris and removed.
+                    val entries = safLibraryEntries(metadataObj, stableUri)
+                    if (entries.first().first != stableUri) {
+                        currentUris.remove(stableUri)
+                    }
+                    for ((entryPath, entry) in entries) {
+                        entry.put("id", buildStableLibraryId(entryPath))
+                        entry.put("filePath", entryPath)
+                        entry.put("fileModTime", safeLastModified)
+                        entry.put("lastModified", safeLastModified)
+                        results.put(entry)
+                        currentUris.add(entryPath)
+                    }
                 } catch (_: Exception) {
                     errors++
                 }
@@ -3439,6 +3485,13 @@ class MainActivity: FlutterFragmentActivity() {
                             }
                             result.success(null)
                         }
+                        "setDuplicateIndexCacheDir" -> {
+                            val cacheDir = call.argument<String>("cache_dir") ?: ""
+                            withContext(Dispatchers.IO) {
+                                Gobackend.setDuplicateIndexCacheDir(cacheDir)
+                            }
+                            result.success(null)
+                        }
                         "scanLibraryFolder" -> {
                             val folderPath = call.argument<String>("folder_path") ?: ""
                             val response = withContext(Dispatchers.IO) {
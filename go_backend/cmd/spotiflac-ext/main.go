@@ -0,0 +1,243 @@
+// Command spotiflac-ext loads an extension outside the app and calls its
+// provider functions, for extension development and offline CI.
+//
+//	spotiflac-ext [flags] <extension> <function> [args...]
+//	spotiflac-ext [flags] -calls calls.json <extension>
+//	spotiflac-ext -validate <extension>
+//	spotiflac-ext -schema
+//
+// <extension> is a .spotiflac-ext package or an unpacked extension directory.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	gobackend "github.com/zarz/spotiflac_android/go_backend"
+)
+
+type harnessCall struct {
+	Function string   `json:"function"`
+	Args     []string `json:"args"`
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	settingsFlag := flag.String("settings", "", "extension settings as a JSON object, or @file to read them from a file")
+	cassette := flag.String("cassette", "", "replay HTTP requests from this network cassette instead of the network")
+	record := flag.Bool("record", false, "make real requests and record them to the -cassette file")
+	workDir := flag.String("work", "", "scratch directory for the installed extension (default: a temporary directory)")
+	callsFile := flag.String("calls", "", "JSON file with a list of {\"function\", \"args\"} calls to run in order")
+	jsonOutput := flag.Bool("json", false, "print results as a JSON array")
+	verbose := flag.Bool("v", false, "print extension and backend logs")
+	validate := flag.Bool("validate", false, "check the extension's manifest and files without running it")
+	schema := flag.Bool("schema", false, "print the manifest.json JSON Schema")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if *schema {
+		out, err := gobackend.GetExtensionManifestSchemaJSON()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Print(out)
+		return 0
+	}
+	if *validate {
+		if len(args) != 1 {
+			usage()
+			return 2
+		}
+		return runValidate(args[0], *jsonOutput)
+	}
+	if len(args) < 1 || (*callsFile == "" && len(args) < 2) {
+		usage()
+		return 2
+	}
+	if *record && *cassette == "" {
+		fmt.Fprintln(os.Stderr, "-record needs -cassette")
+		return 2
+	}
+
+	calls, err := loadCalls(*callsFile, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	settings, err := loadSettings(*settingsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	dir := *workDir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "spotiflac-ext-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	gobackend.SetLoggingEnabled(*verbose)
+	harness, err := gobackend.NewExtensionHarness(args[0], gobackend.ExtensionHarnessOptions{
+		WorkDir:        dir,
+		Settings:       settings,
+		CassettePath:   *cassette,
+		RecordCassette: *record,
+	})
+	printLogs(*verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load extension: %v\n", err)
+		return 1
+	}
+
+	failed := false
+	results := make([]gobackend.HarnessCallResult, 0, len(calls))
+	for _, call := range calls {
+		result := harness.Call(call.Function, call.Args)
+		printLogs(*verbose)
+		if result.Error != "" {
+			failed = true
+		}
+		if *jsonOutput {
+			results = append(results, result)
+		} else {
+			printResult(result)
+		}
+	}
+
+	if err := harness.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save cassette: %v\n", err)
+		failed = true
+	}
+
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(out))
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: spotiflac-ext [flags] <extension> <function> [args...]\n")
+	fmt.Fprintf(os.Stderr, "       spotiflac-ext [flags] -calls calls.json <extension>\n")
+	fmt.Fprintf(os.Stderr, "       spotiflac-ext [-json] -validate <extension>\n")
+	fmt.Fprintf(os.Stderr, "       spotiflac-ext -schema\n\nfunctions:\n")
+	for _, line := range gobackend.HarnessFunctionUsage() {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func runValidate(path string, jsonOutput bool) int {
+	out, err := gobackend.ValidateExtensionPackageJSON(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var report gobackend.ExtensionPackageReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if jsonOutput {
+		fmt.Println(out)
+	} else {
+		for _, e := range report.Errors {
+			fmt.Printf("error: %s: %s\n", e.Field, e.Message)
+		}
+		for _, w := range report.Warnings {
+			fmt.Printf("warning: %s: %s\n", w.Field, w.Message)
+		}
+		fmt.Printf("%s %s: %d error(s), %d warning(s)\n", report.Name, report.Version, len(report.Errors), len(report.Warnings))
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+func loadCalls(path string, args []string) ([]harnessCall, error) {
+	if path == "" {
+		return []harnessCall{{Function: args[0], Args: args[1:]}}, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var calls []harnessCall
+	if err := json.Unmarshal(data, &calls); err != nil {
+		return nil, fmt.Errorf("invalid calls file %s: %w", path, err)
+	}
+	return calls, nil
+}
+
+func loadSettings(value string) (map[string]interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data := []byte(value)
+	if strings.HasPrefix(value, "@") {
+		var err error
+		data, err = os.ReadFile(value[1:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("settings must be a JSON object: %w", err)
+	}
+	return settings, nil
+}
+
+func printLogs(verbose bool) {
+	if !verbose {
+		return
+	}
+	var logs []gobackend.LogEntry
+	if err := json.Unmarshal([]byte(gobackend.GetLogs()), &logs); err == nil {
+		for _, entry := range logs {
+			fmt.Fprintf(os.Stderr, "%s %-5s [%s] %s\n", entry.Timestamp, entry.Level, entry.Tag, entry.Message)
+		}
+	}
+	gobackend.ClearLogs()
+}
+
+func printResult(result gobackend.HarnessCallResult) {
+	fmt.Printf("== %s(%s) %dms\n", result.Function, strings.Join(quoteAll(result.Args), ", "), result.DurationMs)
+	if result.Result != nil {
+		out, err := json.MarshalIndent(result.Result, "", "  ")
+		if err != nil {
+			fmt.Printf("<unprintable result: %v>\n", err)
+		} else {
+			fmt.Println(string(out))
+		}
+	}
+	if result.Error != "" {
+		fmt.Printf("error: %s\n", result.Error)
+	}
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
@@ -12,25 +12,61 @@ import (
 )
 
 type CueSheet struct {
-	Performer string     `json:"performer"`
-	Title     string     `json:"title"`
-	FileName  string     `json:"file_name"`
-	FileType  string     `json:"file_type"` // WAVE, FLAC, MP3, AIFF, etc.
-	Genre     string     `json:"genre,omitempty"`
-	Date      string     `json:"date,omitempty"`
-	Comment   string     `json:"comment,omitempty"`
-	Composer  string     `json:"composer,omitempty"`
-	Tracks    []CueTrack `json:"tracks"`
+	Performer  string     `json:"performer"`
+	Title      string     `json:"title"`
+	FileName   string     `json:"file_name"` // first FILE entry
+	FileType   string     `json:"file_type"` // WAVE, FLAC, MP3, AIFF, etc.
+	Files      []CueFile  `json:"files,omitempty"`
+	Catalog    string     `json:"catalog,omitempty"`
+	CDTextFile string     `json:"cdtext_file,omitempty"`
+	Songwriter string     `json:"songwriter,omitempty"`
+	Genre      string     `json:"genre,omitempty"`
+	Date       string     `json:"date,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	Composer   string     `json:"composer,omitempty"`
+	Rem        []CueRem   `json:"rem,omitempty"` // all REM lines in order, including the ones above
+	Tracks     []CueTrack `json:"tracks"`
+}
+
+type CueFile struct {
+	FileName string `json:"file_name"`
+	FileType string `json:"file_type"`
+}
+
+type CueRem struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Quoted bool   `json:"quoted,omitempty"`
+}
+
+type CueIndex struct {
+	Number   int     `json:"number"`
+	Time     float64 `json:"time"`      // seconds from the start of FileName
+	FileName string  `json:"file_name"` // FILE in effect when the index was read
 }
 
 type CueTrack struct {
-	Number    int     `json:"number"`
-	Title     string  `json:"title"`
-	Performer string  `json:"performer"`
-	ISRC      string  `json:"isrc,omitempty"`
-	Composer  string  `json:"composer,omitempty"`
-	StartTime float64 `json:"start_time"` // INDEX 01 in seconds
-	PreGap    float64 `json:"pre_gap"`    // INDEX 00 in seconds (or -1 if not present)
+	Number     int      `json:"number"`
+	Title      string   `json:"title"`
+	Performer  string   `json:"performer"`
+	ISRC       string   `json:"isrc,omitempty"`
+	Composer   string   `json:"composer,omitempty"`
+	Songwriter string   `json:"songwriter,omitempty"`
+	DataType   string   `json:"data_type,omitempty"` // AUDIO, MODE1/2352, ...
+	Flags      []string `json:"flags,omitempty"`     // DCP, 4CH, PRE, SCMS
+	Rem        []CueRem `json:"rem,omitempty"`
+	FileName   string   `json:"file_name,omitempty"` // audio file holding INDEX 01
+	FileType   string   `json:"file_type,omitempty"`
+	StartTime  float64  `json:"start_time"` // INDEX 01 in seconds
+	// PreGap is the position of INDEX 00 in seconds, or -1 if it is not
+	// present or lies in another file. It is audio that exists in the file.
+	PreGap  float64    `json:"pre_gap"`
+	Indexes []CueIndex `json:"indexes,omitempty"`
+	// PregapSilence and PostgapSilence are the lengths in seconds of the
+	// PREGAP and POSTGAP commands: silence a player or burner generates,
+	// not present in the file.
+	PregapSilence  float64 `json:"pregap_silence,omitempty"`
+	PostgapSilence float64 `json:"postgap_silence,omitempty"`
 }
 
 type CueSplitInfo struct {
@@ -44,17 +80,18 @@ type CueSplitInfo struct {
 }
 
 type CueSplitTrack struct {
-	Number   int     `json:"number"`
-	Title    string  `json:"title"`
-	Artist   string  `json:"artist"`
-	ISRC     string  `json:"isrc,omitempty"`
-	Composer string  `json:"composer,omitempty"`
-	StartSec float64 `json:"start_sec"`
-	EndSec   float64 `json:"end_sec"` // -1 means until end of file
+	Number    int     `json:"number"`
+	Title     string  `json:"title"`
+	Artist    string  `json:"artist"`
+	ISRC      string  `json:"isrc,omitempty"`
+	Composer  string  `json:"composer,omitempty"`
+	AudioPath string  `json:"audio_path,omitempty"` // set when the sheet references several files
+	StartSec  float64 `json:"start_sec"`
+	EndSec    float64 `json:"end_sec"` // -1 means until end of file
 }
 
 var (
-	reRemCommand = regexp.MustCompile(`^REM\s+(\S+)\s+(.+)$`)
+	reRemCommand = regexp.MustCompile(`^REM\s+(\S+)\s*(.*)$`)
 	reQuoted     = regexp.MustCompile(`"([^"]*)"`)
 )
 
@@ -65,10 +102,23 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 	}
 	defer f.Close()
 
+	sheet, err := parseCueSheet(bufio.NewScanner(f))
+	if err != nil {
+		return nil, err
+	}
+	return sheet, nil
+}
+
+// ParseCueString parses cue sheet text, e.g. one embedded in an audio file.
+func ParseCueString(content string) (*CueSheet, error) {
+	return parseCueSheet(bufio.NewScanner(strings.NewReader(content)))
+}
+
+func parseCueSheet(scanner *bufio.Scanner) (*CueSheet, error) {
 	sheet := &CueSheet{}
 	var currentTrack *CueTrack
+	var currentFile CueFile
 
-	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
@@ -86,7 +136,14 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 			matches := reRemCommand.FindStringSubmatch(line)
 			if len(matches) == 3 {
 				key := strings.ToUpper(matches[1])
-				value := unquoteCue(matches[2])
+				rawValue := strings.TrimSpace(matches[2])
+				value := unquoteCue(rawValue)
+				rem := CueRem{Key: key, Value: value, Quoted: strings.HasPrefix(rawValue, "\"")}
+				if currentTrack != nil {
+					currentTrack.Rem = append(currentTrack.Rem, rem)
+				} else {
+					sheet.Rem = append(sheet.Rem, rem)
+				}
 				switch key {
 				case "GENRE":
 					sheet.Genre = value
@@ -125,11 +182,25 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 			continue
 		}
 
+		if strings.HasPrefix(upper, "CATALOG ") {
+			sheet.Catalog = strings.TrimSpace(line[len("CATALOG "):])
+			continue
+		}
+
+		if strings.HasPrefix(upper, "CDTEXTFILE ") {
+			sheet.CDTextFile = unquoteCue(line[len("CDTEXTFILE "):])
+			continue
+		}
+
 		if strings.HasPrefix(upper, "FILE ") {
 			rest := line[len("FILE "):]
 			fname, ftype := parseCueFileLine(rest)
-			sheet.FileName = fname
-			sheet.FileType = ftype
+			currentFile = CueFile{FileName: fname, FileType: ftype}
+			sheet.Files = append(sheet.Files, currentFile)
+			if len(sheet.Files) == 1 {
+				sheet.FileName = fname
+				sheet.FileType = ftype
+			}
 			continue
 		}
 
@@ -143,10 +214,17 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 			if len(parts) >= 2 {
 				trackNum, _ = strconv.Atoi(parts[1])
 			}
+			dataType := ""
+			if len(parts) >= 3 {
+				dataType = strings.ToUpper(parts[2])
+			}
 
 			currentTrack = &CueTrack{
-				Number: trackNum,
-				PreGap: -1,
+				Number:   trackNum,
+				DataType: dataType,
+				FileName: currentFile.FileName,
+				FileType: currentFile.FileType,
+				PreGap:   -1,
 			}
 			continue
 		}
@@ -156,11 +234,23 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 			if len(parts) >= 3 {
 				indexNum, _ := strconv.Atoi(parts[1])
 				timeSec := parseCueTimestamp(parts[2])
+				currentTrack.Indexes = append(currentTrack.Indexes, CueIndex{
+					Number:   indexNum,
+					Time:     timeSec,
+					FileName: currentFile.FileName,
+				})
 				switch indexNum {
 				case 0:
 					currentTrack.PreGap = timeSec
 				case 1:
 					currentTrack.StartTime = timeSec
+					if currentTrack.FileName != currentFile.FileName {
+						// The pregap lives at the end of the previous file
+						// (EAC "gaps appended to previous track").
+						currentTrack.PreGap = -1
+					}
+					currentTrack.FileName = currentFile.FileName
+					currentTrack.FileType = currentFile.FileType
 				}
 			}
 			continue
@@ -171,12 +261,33 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 			continue
 		}
 
+		if strings.HasPrefix(upper, "FLAGS ") && currentTrack != nil {
+			currentTrack.Flags = strings.Fields(strings.ToUpper(line[len("FLAGS "):]))
+			continue
+		}
+
+		if strings.HasPrefix(upper, "PREGAP ") && currentTrack != nil {
+			currentTrack.PregapSilence = parseCueTimestamp(strings.TrimSpace(line[len("PREGAP "):]))
+			continue
+		}
+
+		if strings.HasPrefix(upper, "POSTGAP ") && currentTrack != nil {
+			currentTrack.PostgapSilence = parseCueTimestamp(strings.TrimSpace(line[len("POSTGAP "):]))
+			continue
+		}
+
 		if strings.HasPrefix(upper, "SONGWRITER ") {
 			value := unquoteCue(line[len("SONGWRITER "):])
 			if currentTrack != nil {
-				currentTrack.Composer = value
+				currentTrack.Songwriter = value
+				if currentTrack.Composer == "" {
+					currentTrack.Composer = value
+				}
 			} else {
-				sheet.Composer = value
+				sheet.Songwriter = value
+				if sheet.Composer == "" {
+					sheet.Composer = value
+				}
 			}
 			continue
 		}
@@ -197,6 +308,36 @@ func ParseCueFile(cuePath string) (*CueSheet, error) {
 	return sheet, nil
 }
 
+// IsMultiFile reports whether the sheet's tracks span more than one audio file.
+func (s *CueSheet) IsMultiFile() bool {
+	return len(s.Files) > 1
+}
+
+// trackFileName returns the audio file holding the track's INDEX 01, falling
+// back to the sheet's first FILE for sheets built without per-track files.
+func (s *CueSheet) trackFileName(track CueTrack) string {
+	if track.FileName != "" {
+		return track.FileName
+	}
+	return s.FileName
+}
+
+// trackEndTime returns where track i ends within its own file, or -1 when it
+// runs to the end of the file.
+func (s *CueSheet) trackEndTime(i int) float64 {
+	if i+1 >= len(s.Tracks) {
+		return -1
+	}
+	next := s.Tracks[i+1]
+	if s.trackFileName(next) != s.trackFileName(s.Tracks[i]) {
+		return -1
+	}
+	if next.PreGap >= 0 {
+		return next.PreGap
+	}
+	return next.StartTime
+}
+
 func parseCueTimestamp(ts string) float64 {
 	parts := strings.Split(ts, ":")
 	if len(parts) != 3 {
@@ -308,6 +449,43 @@ func ResolveCueAudioPath(cuePath string, cueFileName string) string {
 	return ""
 }
 
+// resolveCueTrackAudioPath resolves a FILE entry of a multi-file sheet next
+// to the sheet's already resolved first audio file. Unlike ResolveCueAudioPath
+// it never falls back to unrelated audio files in the folder.
+func resolveCueTrackAudioPath(primaryAudioPath, fileName string) string {
+	dir := filepath.Dir(primaryAudioPath)
+
+	candidate := filepath.Join(dir, fileName)
+	if _, err := os.Stat(candidate); err == nil {
+		return candidate
+	}
+
+	baseName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	for _, ext := range []string{".flac", ".wav", ".ape", ".mp3", ".ogg", ".wv", ".m4a"} {
+		candidate = filepath.Join(dir, baseName+ext)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// resolveCueSheetAudioPaths maps every FILE entry of sheet to a local path.
+// The first entry maps to primaryAudioPath; unresolved entries are omitted.
+func resolveCueSheetAudioPaths(sheet *CueSheet, primaryAudioPath string) map[string]string {
+	paths := map[string]string{sheet.FileName: primaryAudioPath}
+	for _, file := range sheet.Files {
+		if _, ok := paths[file.FileName]; ok {
+			continue
+		}
+		if resolved := resolveCueTrackAudioPath(primaryAudioPath, file.FileName); resolved != "" {
+			paths[file.FileName] = resolved
+		}
+	}
+	return paths
+}
+
 func BuildCueSplitInfo(cuePath string, sheet *CueSheet, audioDir string) (*CueSplitInfo, error) {
 	resolveDir := cuePath
 	if audioDir != "" {
@@ -327,6 +505,11 @@ func BuildCueSplitInfo(cuePath string, sheet *CueSheet, audioDir string) (*CueSp
 		Date:      sheet.Date,
 	}
 
+	var audioPaths map[string]string
+	if sheet.IsMultiFile() {
+		audioPaths = resolveCueSheetAudioPaths(sheet, audioPath)
+	}
+
 	for i, track := range sheet.Tracks {
 		performer := track.Performer
 		if performer == "" {
@@ -338,24 +521,24 @@ func BuildCueSplitInfo(cuePath string, sheet *CueSheet, audioDir string) (*CueSp
 			composer = sheet.Composer
 		}
 
-		endSec := float64(-1)
-		if i+1 < len(sheet.Tracks) {
-			nextTrack := sheet.Tracks[i+1]
-			if nextTrack.PreGap >= 0 {
-				endSec = nextTrack.PreGap
-			} else {
-				endSec = nextTrack.StartTime
+		trackAudioPath := ""
+		if audioPaths != nil {
+			fileName := sheet.trackFileName(track)
+			trackAudioPath = audioPaths[fileName]
+			if trackAudioPath == "" {
+				return nil, fmt.Errorf("audio file not found for cue track %d: %s (referenced: %s)", track.Number, cuePath, fileName)
 			}
 		}
 
 		info.Tracks = append(info.Tracks, CueSplitTrack{
-			Number:   track.Number,
-			Title:    track.Title,
-			Artist:   performer,
-			ISRC:     track.ISRC,
-			Composer: composer,
-			StartSec: track.StartTime,
-			EndSec:   endSec,
+			Number:    track.Number,
+			Title:     track.Title,
+			Artist:    performer,
+			ISRC:      track.ISRC,
+			Composer:  composer,
+			AudioPath: trackAudioPath,
+			StartSec:  track.StartTime,
+			EndSec:    sheet.trackEndTime(i),
 		})
 	}
 
@@ -444,25 +627,20 @@ func scanCueSheetForLibrary(cuePath string, sheet *CueSheet, audioPath, virtualP
 		return nil, fmt.Errorf("cue sheet is nil for %s", cuePath)
 	}
 
-	var bitDepth, sampleRate int
-	var totalDurationSec float64
-	audioExt := strings.ToLower(filepath.Ext(audioPath))
-	switch audioExt {
-	case ".flac":
-		quality, qErr := GetAudioQuality(audioPath)
-		if qErr == nil {
-			bitDepth = quality.BitDepth
-			sampleRate = quality.SampleRate
-			if quality.SampleRate > 0 && quality.TotalSamples > 0 {
-				totalDurationSec = float64(quality.TotalSamples) / float64(quality.SampleRate)
-			}
+	audioPaths := resolveCueSheetAudioPaths(sheet, audioPath)
+	audioInfos := make(map[string]cueAudioFileInfo)
+	audioInfoFor := func(track CueTrack) cueAudioFileInfo {
+		fileName := sheet.trackFileName(track)
+		if info, ok := audioInfos[fileName]; ok {
+			return info
 		}
-	case ".mp3":
-		quality, qErr := GetMP3Quality(audioPath)
-		if qErr == nil {
-			sampleRate = quality.SampleRate
-			totalDurationSec = float64(quality.Duration)
+		path := audioPaths[fileName]
+		if path == "" {
+			path = audioPath
 		}
+		info := probeCueAudioFile(path)
+		audioInfos[fileName] = info
+		return info
 	}
 
 	var coverPath string
@@ -518,15 +696,13 @@ func scanCueSheetForLibrary(cuePath string, sheet *CueSheet, audioPath, virtualP
 			composer = sheet.Composer
 		}
 
+		audioInfo := audioInfoFor(track)
+
 		var duration int
-		if i+1 < len(sheet.Tracks) {
-			nextStart := sheet.Tracks[i+1].StartTime
-			if sheet.Tracks[i+1].PreGap >= 0 {
-				nextStart = sheet.Tracks[i+1].PreGap
-			}
-			duration = int(nextStart - track.StartTime)
-		} else if totalDurationSec > 0 {
-			duration = int(totalDurationSec - track.StartTime)
+		if endTime := sheet.trackEndTime(i); endTime >= 0 {
+			duration = int(endTime - track.StartTime)
+		} else if audioInfo.totalDurationSec > 0 {
+			duration = int(audioInfo.totalDurationSec - track.StartTime)
 		}
 
 		id := generateLibraryID(fmt.Sprintf("%s#track%d", pathBase, track.Number))
@@ -549,11 +725,11 @@ func scanCueSheetForLibrary(cuePath string, sheet *CueSheet, audioPath, virtualP
 			TotalDiscs:  1,
 			Duration:    duration,
 			ReleaseDate: sheet.Date,
-			BitDepth:    bitDepth,
-			SampleRate:  sampleRate,
+			BitDepth:    audioInfo.bitDepth,
+			SampleRate:  audioInfo.sampleRate,
 			Genre:       sheet.Genre,
 			Composer:    composer,
-			Format:      "cue+" + strings.TrimPrefix(audioExt, "."),
+			Format:      "cue+" + strings.TrimPrefix(audioInfo.ext, "."),
 		}
 
 		result.FileModTime = modTime
@@ -563,3 +739,32 @@ func scanCueSheetForLibrary(cuePath string, sheet *CueSheet, audioPath, virtualP
 
 	return results, nil
 }
+
+type cueAudioFileInfo struct {
+	ext              string
+	bitDepth         int
+	sampleRate       int
+	totalDurationSec float64
+}
+
+func probeCueAudioFile(audioPath string) cueAudioFileInfo {
+	info := cueAudioFileInfo{ext: strings.ToLower(filepath.Ext(audioPath))}
+	switch info.ext {
+	case ".flac":
+		quality, qErr := GetAudioQuality(audioPath)
+		if qErr == nil {
+			info.bitDepth = quality.BitDepth
+			info.sampleRate = quality.SampleRate
+			if quality.SampleRate > 0 && quality.TotalSamples > 0 {
+				info.totalDurationSec = float64(quality.TotalSamples) / float64(quality.SampleRate)
+			}
+		}
+	case ".mp3":
+		quality, qErr := GetMP3Quality(audioPath)
+		if qErr == nil {
+			info.sampleRate = quality.SampleRate
+			info.totalDurationSec = float64(quality.Duration)
+		}
+	}
+	return info
+}
@@ -0,0 +1,146 @@
+package gobackend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const eacMultiFileCue = `REM GENRE Rock
+REM DATE 1999
+REM DISCID 8A0B6C0B
+REM COMMENT "ExactAudioCopy v1.6"
+CATALOG 0724384260927
+PERFORMER "Some Artist"
+TITLE "Some Album"
+FILE "01 - Intro.flac" WAVE
+  TRACK 01 AUDIO
+    FLAGS DCP PRE
+    TITLE "Intro"
+    PERFORMER "Some Artist"
+    SONGWRITER "Writer One"
+    ISRC GBAYE9900001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second"
+    PERFORMER "Some Artist"
+    REM REPLAYGAIN_TRACK_GAIN -7.89
+    INDEX 00 03:58:40
+FILE "02 - Second.flac" WAVE
+    INDEX 01 00:00:00
+    INDEX 02 01:00:00
+`
+
+func TestParseCueStringMultiFileAndCDText(t *testing.T) {
+	sheet, err := ParseCueString(eacMultiFileCue)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+
+	if !sheet.IsMultiFile() || len(sheet.Files) != 2 {
+		t.Fatalf("expected 2 files, got %+v", sheet.Files)
+	}
+	if sheet.FileName != "01 - Intro.flac" {
+		t.Fatalf("expected first file to stay in FileName, got %q", sheet.FileName)
+	}
+	if sheet.Catalog != "0724384260927" {
+		t.Fatalf("unexpected catalog %q", sheet.Catalog)
+	}
+
+	first := sheet.Tracks[0]
+	if strings.Join(first.Flags, " ") != "DCP PRE" || first.Songwriter != "Writer One" || first.Composer != "Writer One" {
+		t.Fatalf("unexpected first track fields: %+v", first)
+	}
+
+	second := sheet.Tracks[1]
+	if second.FileName != "02 - Second.flac" || second.StartTime != 0 {
+		t.Fatalf("expected track 2 to start at 0 in its own file, got %+v", second)
+	}
+	if second.PreGap != -1 {
+		t.Fatalf("expected pregap in the previous file to be ignored, got %v", second.PreGap)
+	}
+	if len(second.Rem) != 1 || second.Rem[0].Key != "REPLAYGAIN_TRACK_GAIN" {
+		t.Fatalf("expected per-track REM to be kept, got %+v", second.Rem)
+	}
+	if end := sheet.trackEndTime(0); end != -1 {
+		t.Fatalf("expected track 1 to run to the end of its file, got %v", end)
+	}
+}
+
+func TestFormatCueSheetRoundTripsEACSheet(t *testing.T) {
+	sheet, err := ParseCueString(eacMultiFileCue)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+
+	if got := FormatCueSheet(sheet); got != eacMultiFileCue {
+		t.Fatalf("round trip mismatch:\n--- got ---\n%s\n--- want ---\n%s", got, eacMultiFileCue)
+	}
+}
+
+func TestBuildCueSplitInfoResolvesPerTrackFiles(t *testing.T) {
+	dir := t.TempDir()
+	cuePath := filepath.Join(dir, "album.cue")
+	for _, name := range []string{"01 - Intro.flac", "02 - Second.flac", "unrelated.flac"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("fLaC"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	sheet, err := ParseCueString(eacMultiFileCue)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+
+	info, err := BuildCueSplitInfo(cuePath, sheet, "")
+	if err != nil {
+		t.Fatalf("BuildCueSplitInfo failed: %v", err)
+	}
+	if info.Tracks[1].AudioPath != filepath.Join(dir, "02 - Second.flac") {
+		t.Fatalf("unexpected audio path for track 2: %q", info.Tracks[1].AudioPath)
+	}
+	if info.Tracks[0].EndSec != -1 {
+		t.Fatalf("expected track 1 to end with its file, got %v", info.Tracks[0].EndSec)
+	}
+}
+
+func TestBuildAlbumCueSheetSingleFileOffsets(t *testing.T) {
+	sheet, err := BuildAlbumCueSheet(CueAlbumInput{
+		Title:     "Album",
+		Performer: "Artist",
+		Date:      "2024",
+		FileName:  "Artist - Album.flac",
+		Tracks: []CueInputTrack{
+			{Title: "One", DurationMS: 61_000, ISRC: "USAAA0000001"},
+			{Title: "Two", DurationMS: 30_500},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildAlbumCueSheet failed: %v", err)
+	}
+
+	want := `REM DATE 2024
+PERFORMER "Artist"
+TITLE "Album"
+FILE "Artist - Album.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "One"
+    ISRC USAAA0000001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Two"
+    INDEX 01 01:01:00
+`
+	if got := FormatCueSheet(sheet); got != want {
+		t.Fatalf("unexpected cue sheet:\n%s", got)
+	}
+
+	reparsed, err := ParseCueString(want)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+	if reparsed.Tracks[1].StartTime != 61 {
+		t.Fatalf("unexpected reparsed start time %v", reparsed.Tracks[1].StartTime)
+	}
+}
@@ -0,0 +1,318 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CueAlbumInput describes a downloaded album to generate a cue sheet for.
+// When FileName is set the album is a single-file image and track offsets are
+// accumulated from the durations; otherwise every track names its own file.
+type CueAlbumInput struct {
+	Title     string          `json:"album"`
+	Performer string          `json:"artist"`
+	Genre     string          `json:"genre,omitempty"`
+	Date      string          `json:"date,omitempty"`
+	Comment   string          `json:"comment,omitempty"`
+	Catalog   string          `json:"catalog,omitempty"` // UPC/EAN
+	FileName  string          `json:"file_name,omitempty"`
+	Tracks    []CueInputTrack `json:"tracks"`
+}
+
+type CueInputTrack struct {
+	Title      string `json:"title"`
+	Performer  string `json:"artist,omitempty"`
+	ISRC       string `json:"isrc,omitempty"`
+	Songwriter string `json:"composer,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	FileName   string `json:"file_name,omitempty"`
+}
+
+// cueFileTypeForPath picks the FILE type keyword for an audio file. Players
+// treat WAVE as "decodable audio", so FLAC and other lossless formats use it.
+func cueFileTypeForPath(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".mp3":
+		return "MP3"
+	case ".aif", ".aiff":
+		return "AIFF"
+	default:
+		return "WAVE"
+	}
+}
+
+// BuildAlbumCueSheet converts an album track list into a CueSheet.
+func BuildAlbumCueSheet(album CueAlbumInput) (*CueSheet, error) {
+	if len(album.Tracks) == 0 {
+		return nil, fmt.Errorf("album has no tracks")
+	}
+
+	sheet := &CueSheet{
+		Performer: album.Performer,
+		Title:     album.Title,
+		Catalog:   album.Catalog,
+		Genre:     album.Genre,
+		Date:      album.Date,
+		Comment:   album.Comment,
+	}
+
+	if album.FileName != "" {
+		file := CueFile{FileName: album.FileName, FileType: cueFileTypeForPath(album.FileName)}
+		sheet.Files = []CueFile{file}
+		sheet.FileName = file.FileName
+		sheet.FileType = file.FileType
+	}
+
+	var offset float64
+	for i, input := range album.Tracks {
+		track := CueTrack{
+			Number:     i + 1,
+			Title:      input.Title,
+			Performer:  input.Performer,
+			ISRC:       input.ISRC,
+			Songwriter: input.Songwriter,
+			DataType:   "AUDIO",
+			PreGap:     -1,
+		}
+
+		if album.FileName != "" {
+			if input.DurationMS <= 0 && i+1 < len(album.Tracks) {
+				return nil, fmt.Errorf("track %d has no duration", i+1)
+			}
+			track.FileName = album.FileName
+			track.FileType = sheet.FileType
+			track.StartTime = offset
+			offset += float64(input.DurationMS) / 1000
+		} else {
+			if input.FileName == "" {
+				return nil, fmt.Errorf("track %d has no file name", i+1)
+			}
+			track.FileName = input.FileName
+			track.FileType = cueFileTypeForPath(input.FileName)
+			sheet.Files = append(sheet.Files, CueFile{FileName: track.FileName, FileType: track.FileType})
+			if i == 0 {
+				sheet.FileName = track.FileName
+				sheet.FileType = track.FileType
+			}
+		}
+
+		sheet.Tracks = append(sheet.Tracks, track)
+	}
+
+	return sheet, nil
+}
+
+// FormatCueSheet renders sheet in the layout EAC produces. Sheets returned by
+// ParseCueFile keep their REM order, flags and index/file placement.
+func FormatCueSheet(sheet *CueSheet) string {
+	var b strings.Builder
+
+	writeCueRems(&b, "", sheet.Rem, []CueRem{
+		{Key: "GENRE", Value: sheet.Genre},
+		{Key: "DATE", Value: sheet.Date},
+		{Key: "COMMENT", Value: sheet.Comment},
+		{Key: "COMPOSER", Value: cueComposerRem(sheet.Rem, sheet.Composer, sheet.Songwriter)},
+	})
+	if sheet.Catalog != "" {
+		fmt.Fprintf(&b, "CATALOG %s\n", sheet.Catalog)
+	}
+	if sheet.CDTextFile != "" {
+		fmt.Fprintf(&b, "CDTEXTFILE %s\n", quoteCue(sheet.CDTextFile))
+	}
+	if sheet.Performer != "" {
+		fmt.Fprintf(&b, "PERFORMER %s\n", quoteCue(sheet.Performer))
+	}
+	if sheet.Title != "" {
+		fmt.Fprintf(&b, "TITLE %s\n", quoteCue(sheet.Title))
+	}
+	if sheet.Songwriter != "" {
+		fmt.Fprintf(&b, "SONGWRITER %s\n", quoteCue(sheet.Songwriter))
+	}
+
+	fileTypes := make(map[string]string, len(sheet.Files))
+	for _, file := range sheet.Files {
+		fileTypes[file.FileName] = file.FileType
+	}
+	if sheet.FileName != "" {
+		if _, ok := fileTypes[sheet.FileName]; !ok {
+			fileTypes[sheet.FileName] = sheet.FileType
+		}
+	}
+
+	currentFile := ""
+	openFile := func(fileName string) {
+		if fileName == "" || fileName == currentFile {
+			return
+		}
+		fileType := fileTypes[fileName]
+		if fileType == "" {
+			fileType = cueFileTypeForPath(fileName)
+		}
+		fmt.Fprintf(&b, "FILE %s %s\n", quoteCue(fileName), fileType)
+		currentFile = fileName
+	}
+
+	for _, track := range sheet.Tracks {
+		indexes := cueTrackIndexes(sheet, track)
+		if len(indexes) > 0 {
+			openFile(indexes[0].FileName)
+		} else {
+			openFile(sheet.trackFileName(track))
+		}
+
+		dataType := track.DataType
+		if dataType == "" {
+			dataType = "AUDIO"
+		}
+		fmt.Fprintf(&b, "  TRACK %02d %s\n", track.Number, dataType)
+		if len(track.Flags) > 0 {
+			fmt.Fprintf(&b, "    FLAGS %s\n", strings.Join(track.Flags, " "))
+		}
+		if track.Title != "" {
+			fmt.Fprintf(&b, "    TITLE %s\n", quoteCue(track.Title))
+		}
+		if track.Performer != "" {
+			fmt.Fprintf(&b, "    PERFORMER %s\n", quoteCue(track.Performer))
+		}
+		if track.Songwriter != "" {
+			fmt.Fprintf(&b, "    SONGWRITER %s\n", quoteCue(track.Songwriter))
+		}
+		writeCueRems(&b, "    ", track.Rem, []CueRem{
+			{Key: "COMPOSER", Value: cueComposerRem(track.Rem, track.Composer, track.Songwriter)},
+		})
+		if track.ISRC != "" {
+			fmt.Fprintf(&b, "    ISRC %s\n", track.ISRC)
+		}
+		if track.PregapSilence > 0 {
+			fmt.Fprintf(&b, "    PREGAP %s\n", formatCueFrames(track.PregapSilence))
+		}
+		for _, index := range indexes {
+			openFile(index.FileName)
+			fmt.Fprintf(&b, "    INDEX %02d %s\n", index.Number, formatCueFrames(index.Time))
+		}
+		if track.PostgapSilence > 0 {
+			fmt.Fprintf(&b, "    POSTGAP %s\n", formatCueFrames(track.PostgapSilence))
+		}
+	}
+
+	return b.String()
+}
+
+// cueTrackIndexes returns the parsed INDEX lines of track, or synthesizes
+// INDEX 00/01 from PreGap and StartTime for sheets built in code.
+func cueTrackIndexes(sheet *CueSheet, track CueTrack) []CueIndex {
+	if len(track.Indexes) > 0 {
+		return track.Indexes
+	}
+
+	fileName := sheet.trackFileName(track)
+	var indexes []CueIndex
+	if track.PreGap >= 0 && track.PreGap < track.StartTime {
+		indexes = append(indexes, CueIndex{Number: 0, Time: track.PreGap, FileName: fileName})
+	}
+	return append(indexes, CueIndex{Number: 1, Time: track.StartTime, FileName: fileName})
+}
+
+// writeCueRems writes the parsed REM lines in their original order with the
+// known keys replaced by their current values, then any known key that was
+// not in the original sheet.
+func writeCueRems(b *strings.Builder, indent string, rems []CueRem, known []CueRem) {
+	knownValues := make(map[string]string, len(known))
+	for _, rem := range known {
+		knownValues[rem.Key] = rem.Value
+	}
+
+	written := make(map[string]bool)
+	for _, rem := range rems {
+		if value, ok := knownValues[rem.Key]; ok {
+			if written[rem.Key] || value == "" {
+				continue
+			}
+			if value != rem.Value {
+				rem = CueRem{Key: rem.Key, Value: value, Quoted: rem.Quoted || cueNeedsQuotes(value)}
+			}
+			written[rem.Key] = true
+		}
+		writeCueRem(b, indent, rem)
+	}
+
+	for _, rem := range known {
+		if rem.Value == "" || written[rem.Key] {
+			continue
+		}
+		rem.Quoted = cueNeedsQuotes(rem.Value)
+		writeCueRem(b, indent, rem)
+	}
+}
+
+func writeCueRem(b *strings.Builder, indent string, rem CueRem) {
+	value := rem.Value
+	if rem.Quoted {
+		value = quoteCue(value)
+	}
+	fmt.Fprintf(b, "%sREM %s %s\n", indent, rem.Key, value)
+}
+
+// cueComposerRem returns the value for REM COMPOSER. The parser copies
+// SONGWRITER into Composer, so the REM is only added when the original sheet
+// had one or the composer differs from the songwriter.
+func cueComposerRem(rems []CueRem, composer, songwriter string) string {
+	for _, rem := range rems {
+		if rem.Key == "COMPOSER" {
+			return composer
+		}
+	}
+	if composer == songwriter {
+		return ""
+	}
+	return composer
+}
+
+func cueNeedsQuotes(value string) bool {
+	return value == "" || strings.ContainsAny(value, " \t\"")
+}
+
+func quoteCue(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, "'") + `"`
+}
+
+// formatCueFrames renders seconds as an MM:SS:FF cue timestamp (75 frames
+// per second). Minutes are not wrapped, as cue sheets allow values past 99.
+func formatCueFrames(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	frames := int(math.Round(seconds * 75))
+	return fmt.Sprintf("%02d:%02d:%02d", frames/(75*60), (frames/75)%60, frames%75)
+}
+
+// WriteCueSheet writes sheet to outputPath as UTF-8.
+func WriteCueSheet(sheet *CueSheet, outputPath string) error {
+	if sheet == nil {
+		return fmt.Errorf("cue sheet is nil")
+	}
+	if err := os.WriteFile(outputPath, []byte(FormatCueSheet(sheet)), 0644); err != nil {
+		return fmt.Errorf("failed to write cue sheet: %w", err)
+	}
+	return nil
+}
+
+// WriteAlbumCueSheetJSON builds a cue sheet from a CueAlbumInput JSON object
+// and writes it to outputPath.
+func WriteAlbumCueSheetJSON(albumJSON, outputPath string) error {
+	var album CueAlbumInput
+	if err := json.Unmarshal([]byte(albumJSON), &album); err != nil {
+		return fmt.Errorf("failed to parse album JSON: %w", err)
+	}
+
+	sheet, err := BuildAlbumCueSheet(album)
+	if err != nil {
+		return err
+	}
+
+	return WriteCueSheet(sheet, outputPath)
+}
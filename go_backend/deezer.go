@@ -3,6 +3,7 @@ package gobackend
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -25,7 +26,10 @@ const (
 
 	deezerMaxParallelISRC = 10
 
-	// Deezer API timeout and retry configuration for mobile networks
+	// Deezer API timeout and retry configuration for mobile networks. The
+	// api.deezer.com host policy rate limits and retries failed requests,
+	// timeouts and error statuses, so getJSON only retries responses whose
+	// body fails to read or decode.
 	deezerAPITimeoutMobile = 25 * time.Second
 	deezerMaxRetries       = 2
 	deezerRetryDelay       = 500 * time.Millisecond
@@ -1267,16 +1271,8 @@ func (c *DeezerClient) getJSON(ctx context.Context, endpoint string, dst interfa
 		}
 
 		lastErr = err
-		errStr := err.Error()
-
-		isRetryable := strings.Contains(errStr, "timeout") ||
-			strings.Contains(errStr, "connection reset") ||
-			strings.Contains(errStr, "connection refused") ||
-			strings.Contains(errStr, "EOF") ||
-			strings.Contains(errStr, "status 5") ||
-			strings.Contains(errStr, "status 429")
-
-		if !isRetryable {
+		var bodyErr *deezerBodyError
+		if !errors.As(err, &bodyErr) || ctx.Err() != nil {
 			return err
 		}
 
@@ -1286,6 +1282,20 @@ func (c *DeezerClient) getJSON(ctx context.Context, endpoint string, dst interfa
 	return fmt.Errorf("all %d attempts failed: %w", deezerMaxRetries+1, lastErr)
 }
 
+// deezerBodyError is a response whose body failed to read or decode. The
+// host policy never sees these, so getJSON retries them itself.
+type deezerBodyError struct {
+	err error
+}
+
+func (e *deezerBodyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deezerBodyError) Unwrap() error {
+	return e.err
+}
+
 func (c *DeezerClient) doGetJSON(ctx context.Context, endpoint string, dst interface{}) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
@@ -1302,14 +1312,17 @@ func (c *DeezerClient) doGetJSON(ctx context.Context, endpoint string, dst inter
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return &deezerBodyError{err: err}
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("deezer API returned status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return json.Unmarshal(body, dst)
+	if err := json.Unmarshal(body, dst); err != nil {
+		return &deezerBodyError{err: err}
+	}
+	return nil
 }
 
 func parseDeezerURL(input string) (string, string, error) {
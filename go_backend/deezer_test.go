@@ -0,0 +1,47 @@
+package gobackend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDeezerGetJSON_OnlyRetriesBodyErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		response func() *http.Response
+		want     int
+	}{
+		{"error status", func() *http.Response {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader("busy"))}
+		}, 1},
+		{"truncated body", func() *http.Response {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(io.MultiReader(strings.NewReader(`{"id":`), iotest.ErrReader(errors.New("connection reset by peer"))))}
+		}, deezerMaxRetries + 1},
+		{"invalid JSON", func() *http.Response {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"id":`))}
+		}, deezerMaxRetries + 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			client := &DeezerClient{httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				resp := tt.response()
+				resp.Header = make(http.Header)
+				resp.Request = req
+				return resp, nil
+			})}}
+			var dst map[string]interface{}
+			if err := client.getJSON(context.Background(), deezerBaseURL+"/track/1", &dst); err == nil {
+				t.Fatal("getJSON succeeded")
+			}
+			if calls != tt.want {
+				t.Fatalf("requests = %d, want %d", calls, tt.want)
+			}
+		})
+	}
+}
@@ -5,25 +5,93 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	isrcIndexFileVersion = 3
+	isrcIndexFilePrefix  = "isrc_index_"
+
+	// Fuzzy matches below this confidence are not reported as duplicates.
+	fuzzyDuplicateMinConfidence = 0.85
+	// Tracks whose durations differ by more than this are never fuzzy matches.
+	fuzzyDuplicateDurationToleranceSec = 5
+)
+
+// isrcIndexedFormats lists the audio formats whose tags are read when
+// building the duplicate index. Cue sheets are skipped; their audio file is
+// indexed on its own.
+var isrcIndexedFormats = map[string]bool{
+	".flac": true,
+	".m4a":  true,
+	".mp3":  true,
+	".opus": true,
+	".ogg":  true,
+	".ape":  true,
+	".wv":   true,
+	".mpc":  true,
+}
+
+type isrcIndexEntry struct {
+	ModTime  int64  `json:"mod_time"` // Unix milliseconds
+	Size     int64  `json:"size"`
+	ISRC     string `json:"isrc,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+	Album    string `json:"album,omitempty"`
+	Duration int    `json:"duration,omitempty"` // seconds
+}
+
+type isrcIndexFile struct {
+	Version   int                       `json:"version"`
+	OutputDir string                    `json:"output_dir"`
+	Files     map[string]isrcIndexEntry `json:"files"`
+}
+
 type ISRCIndex struct {
-	index     map[string]string // ISRC (uppercase) -> file path
+	index     map[string]string         // ISRC (uppercase) -> file path
+	files     map[string]isrcIndexEntry // file path -> last seen stat + tags
+	artists   map[string][]string       // normalized artist token -> file paths
 	outputDir string
 	buildTime time.Time
+	dirty     bool
 	mu        sync.RWMutex
 }
 
 var (
-	isrcIndexCache   = make(map[string]*ISRCIndex)
-	isrcIndexCacheMu sync.RWMutex
-	isrcBuildingMu   sync.Map // Per-directory build lock to prevent concurrent builds
-	isrcIndexTTL     = 5 * time.Minute
+	isrcIndexCache    = make(map[string]*ISRCIndex)
+	isrcIndexCacheMu  sync.RWMutex
+	isrcBuildingMu    sync.Map // Per-directory build lock to prevent concurrent builds
+	isrcIndexTTL      = 5 * time.Minute
+	isrcIndexCacheDir string
+	isrcIndexDirMu    sync.RWMutex
 )
 
+// SetISRCIndexCacheDir sets where duplicate indexes are persisted between
+// runs. An empty dir keeps indexes in memory only.
+func SetISRCIndexCacheDir(cacheDir string) {
+	isrcIndexDirMu.Lock()
+	isrcIndexCacheDir = cacheDir
+	isrcIndexDirMu.Unlock()
+}
+
+func getISRCIndexCacheDir() string {
+	isrcIndexDirMu.RLock()
+	defer isrcIndexDirMu.RUnlock()
+	return isrcIndexCacheDir
+}
+
+func isrcIndexFilePath(outputDir string) string {
+	cacheDir := getISRCIndexCacheDir()
+	if cacheDir == "" || outputDir == "" {
+		return ""
+	}
+	return filepath.Join(cacheDir, fmt.Sprintf("%s%08x.json", isrcIndexFilePrefix, hashString(outputDir)))
+}
+
 func GetISRCIndex(outputDir string) *ISRCIndex {
 	isrcIndexCacheMu.RLock()
 	idx, exists := isrcIndexCache[outputDir]
@@ -49,9 +117,30 @@ func GetISRCIndex(outputDir string) *ISRCIndex {
 	return buildISRCIndex(outputDir)
 }
 
+// buildISRCIndex refreshes the index for outputDir. Entries from the previous
+// in-memory index or the persisted index file are reused when a file's size
+// and mtime are unchanged, so only new or modified files have their tags read.
 func buildISRCIndex(outputDir string) *ISRCIndex {
+	isrcIndexCacheMu.RLock()
+	previous := isrcIndexCache[outputDir]
+	isrcIndexCacheMu.RUnlock()
+
+	var known map[string]isrcIndexEntry
+	if previous != nil {
+		previous.mu.RLock()
+		known = make(map[string]isrcIndexEntry, len(previous.files))
+		for path, entry := range previous.files {
+			known[path] = entry
+		}
+		previous.mu.RUnlock()
+	} else {
+		known = loadISRCIndexFile(outputDir)
+	}
+
 	idx := &ISRCIndex{
 		index:     make(map[string]string),
+		files:     make(map[string]isrcIndexEntry, len(known)),
+		artists:   make(map[string][]string),
 		outputDir: outputDir,
 		buildTime: time.Now(),
 	}
@@ -61,7 +150,7 @@ func buildISRCIndex(outputDir string) *ISRCIndex {
 	}
 
 	startTime := time.Now()
-	fileCount := 0
+	readCount := 0
 
 	filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
@@ -69,22 +158,39 @@ func buildISRCIndex(outputDir string) *ISRCIndex {
 		}
 
 		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".flac" {
+		if !isrcIndexedFormats[ext] {
 			return nil
 		}
 
-		metadata, err := ReadMetadata(path)
-		if err != nil || metadata.ISRC == "" {
-			return nil
+		modTime := info.ModTime().UnixMilli()
+		entry, ok := known[path]
+		if !ok || entry.ModTime != modTime || entry.Size != info.Size() {
+			entry = readIndexedAudioFile(path, ext)
+			entry.ModTime = modTime
+			entry.Size = info.Size()
+			readCount++
+			idx.dirty = true
 		}
 
-		idx.index[strings.ToUpper(metadata.ISRC)] = path
-		fileCount++
+		idx.putLocked(path, entry)
 		return nil
 	})
 
-	fmt.Printf("[ISRCIndex] Built index for %s: %d files in %v\n",
-		outputDir, fileCount, time.Since(startTime).Round(time.Millisecond))
+	if len(idx.files) != len(known) {
+		idx.dirty = true
+	}
+	if previous != nil {
+		previous.mu.RLock()
+		if previous.dirty {
+			idx.dirty = true
+		}
+		previous.mu.RUnlock()
+	}
+
+	fmt.Printf("[ISRCIndex] Built index for %s: %d files (%d read, %d with ISRC) in %v\n",
+		outputDir, len(idx.files), readCount, len(idx.index), time.Since(startTime).Round(time.Millisecond))
+
+	idx.save()
 
 	isrcIndexCacheMu.Lock()
 	isrcIndexCache[outputDir] = idx
@@ -93,6 +199,138 @@ func buildISRCIndex(outputDir string) *ISRCIndex {
 	return idx
 }
 
+// readIndexedAudioFile reads the tags used for duplicate matching with the
+// reader matching ext. Stat fields are left for the caller to fill in.
+func readIndexedAudioFile(path, ext string) isrcIndexEntry {
+	var metadata *AudioMetadata
+	duration := 0
+
+	switch ext {
+	case ".flac":
+		flacMeta, err := ReadMetadata(path)
+		if err != nil {
+			return isrcIndexEntry{}
+		}
+		metadata = &AudioMetadata{
+			Title:  flacMeta.Title,
+			Artist: flacMeta.Artist,
+			Album:  flacMeta.Album,
+			ISRC:   flacMeta.ISRC,
+		}
+		if quality, err := GetAudioQuality(path); err == nil && quality.SampleRate > 0 {
+			duration = int(quality.TotalSamples / int64(quality.SampleRate))
+		}
+	case ".m4a":
+		metadata, _ = ReadM4ATags(path)
+		if seconds, err := GetM4ADuration(path); err == nil {
+			duration = seconds
+		}
+	case ".mp3":
+		metadata, _ = ReadID3Tags(path)
+		if quality, err := GetMP3Quality(path); err == nil {
+			duration = quality.Duration
+		}
+	case ".opus", ".ogg":
+		metadata, _ = ReadOggVorbisComments(path)
+		if quality, err := GetOggQuality(path); err == nil {
+			duration = quality.Duration
+		}
+	case ".ape", ".wv", ".mpc":
+		if tag, err := ReadAPETags(path); err == nil {
+			metadata = APETagToAudioMetadata(tag)
+		}
+	}
+
+	if metadata == nil {
+		return isrcIndexEntry{}
+	}
+
+	return isrcIndexEntry{
+		ISRC:     strings.TrimSpace(metadata.ISRC),
+		Title:    strings.TrimSpace(metadata.Title),
+		Artist:   strings.TrimSpace(metadata.Artist),
+		Album:    strings.TrimSpace(metadata.Album),
+		Duration: duration,
+	}
+}
+
+// putLocked records entry for path in the ISRC and artist lookups. The caller
+// must hold idx.mu or own an index that is not yet shared.
+func (idx *ISRCIndex) putLocked(path string, entry isrcIndexEntry) {
+	idx.files[path] = entry
+	if entry.ISRC != "" {
+		idx.index[strings.ToUpper(entry.ISRC)] = path
+	}
+	for _, token := range strings.Fields(normalizeLooseArtistName(entry.Artist)) {
+		idx.artists[token] = append(idx.artists[token], path)
+	}
+}
+
+func loadISRCIndexFile(outputDir string) map[string]isrcIndexEntry {
+	indexPath := isrcIndexFilePath(outputDir)
+	if indexPath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil
+	}
+
+	var stored isrcIndexFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		GoLog("[ISRCIndex] Ignoring unreadable index %s: %v\n", indexPath, err)
+		return nil
+	}
+	if stored.Version != isrcIndexFileVersion || stored.OutputDir != outputDir {
+		return nil
+	}
+
+	return stored.Files
+}
+
+// save writes the index to the cache dir when it has unsaved changes.
+func (idx *ISRCIndex) save() {
+	indexPath := isrcIndexFilePath(idx.outputDir)
+	if indexPath == "" {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.dirty {
+		return
+	}
+
+	data, err := json.Marshal(isrcIndexFile{
+		Version:   isrcIndexFileVersion,
+		OutputDir: idx.outputDir,
+		Files:     idx.files,
+	})
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		GoLog("[ISRCIndex] Failed to create cache dir: %v\n", err)
+		return
+	}
+
+	tmpPath := indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		GoLog("[ISRCIndex] Failed to write index: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		os.Remove(tmpPath)
+		GoLog("[ISRCIndex] Failed to replace index: %v\n", err)
+		return
+	}
+
+	idx.dirty = false
+}
+
 func (idx *ISRCIndex) lookup(isrc string) (string, bool) {
 	if isrc == "" {
 		return "", false
@@ -113,7 +351,12 @@ func (idx *ISRCIndex) remove(isrc string) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	delete(idx.index, strings.ToUpper(isrc))
+	key := strings.ToUpper(isrc)
+	if path, ok := idx.index[key]; ok {
+		delete(idx.files, path)
+		idx.dirty = true
+	}
+	delete(idx.index, key)
 }
 
 func (idx *ISRCIndex) Lookup(isrc string) (string, error) {
@@ -126,16 +369,161 @@ func (idx *ISRCIndex) Add(isrc, filePath string) {
 		return
 	}
 
+	entry := isrcIndexEntry{}
+	info, statErr := os.Stat(filePath)
+	if statErr == nil {
+		entry = readIndexedAudioFile(filePath, strings.ToLower(filepath.Ext(filePath)))
+		entry.ModTime = info.ModTime().UnixMilli()
+		entry.Size = info.Size()
+	}
+	if entry.ISRC == "" {
+		entry.ISRC = isrc
+	}
+
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
 	idx.index[strings.ToUpper(isrc)] = filePath
+	if statErr == nil {
+		idx.putLocked(filePath, entry)
+		idx.dirty = true
+	}
+}
+
+// lookupFuzzy finds an indexed file whose title, artist, album and duration
+// loosely match the given track, for when no ISRC match exists. Files tagged
+// with a different ISRC than a non-empty isrc are never matched. It returns
+// the best candidate's path with a confidence in [0, 1].
+func (idx *ISRCIndex) lookupFuzzy(isrc, title, artist, album string, durationSec int) (string, float64, bool) {
+	normTitle := normalizeLooseTitle(title)
+	normArtist := normalizeLooseArtistName(artist)
+	if normTitle == "" || normArtist == "" {
+		return "", 0, false
+	}
+	normAlbum := normalizeLooseTitle(album)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	bestPath := ""
+	bestScore := 0.0
+
+	for _, token := range strings.Fields(normArtist) {
+		for _, path := range idx.artists[token] {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+
+			entry, ok := idx.files[path]
+			if !ok {
+				continue
+			}
+			if isrc != "" && entry.ISRC != "" && !strings.EqualFold(isrc, entry.ISRC) {
+				continue
+			}
+
+			score := fuzzyDuplicateScore(normTitle, normArtist, normAlbum, durationSec, entry)
+			if score > bestScore {
+				bestScore = score
+				bestPath = path
+			}
+		}
+	}
+
+	if bestPath == "" || bestScore < fuzzyDuplicateMinConfidence {
+		return "", bestScore, false
+	}
+	return bestPath, bestScore, true
 }
 
+// fuzzyDuplicateScore weighs title and artist similarity most heavily; album
+// and duration adjust the score. Tracks are only compared when both
+// durations are known, since the duration gate is what keeps different
+// recordings with similar tags apart, and when their titles carry the same
+// numbers ("Part 1" is not a duplicate of "Part 2").
+func fuzzyDuplicateScore(normTitle, normArtist, normAlbum string, durationSec int, entry isrcIndexEntry) float64 {
+	if durationSec <= 0 || entry.Duration <= 0 {
+		return 0
+	}
+	diff := durationSec - entry.Duration
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > fuzzyDuplicateDurationToleranceSec {
+		return 0
+	}
+
+	entryTitle := normalizeLooseTitle(entry.Title)
+	if !sameTitleNumbers(normTitle, entryTitle) {
+		return 0
+	}
+	titleScore := calculateStringSimilarity(normTitle, entryTitle)
+	artistScore := calculateStringSimilarity(normArtist, normalizeLooseArtistName(entry.Artist))
+	if titleScore < 0.8 || artistScore < 0.5 {
+		return 0
+	}
+
+	score := titleScore*0.55 + artistScore*0.35
+	weight := 0.9
+
+	if entryAlbum := normalizeLooseTitle(entry.Album); normAlbum != "" && entryAlbum != "" {
+		score += calculateStringSimilarity(normAlbum, entryAlbum) * 0.05
+		weight += 0.05
+	}
+	score += (1 - float64(diff)/float64(fuzzyDuplicateDurationToleranceSec+1)) * 0.05
+	weight += 0.05
+
+	return score / weight
+}
+
+var ordinalTitleWords = map[string]bool{
+	"first": true, "second": true, "third": true, "fourth": true, "fifth": true,
+	"sixth": true, "seventh": true, "eighth": true, "ninth": true, "tenth": true,
+}
+
+// titleNumberTokens returns the tokens of a normalized title that number
+// it: anything with a digit ("10", "2nd"), roman numerals up to xxxix and
+// ordinal words.
+func titleNumberTokens(normTitle string) []string {
+	var tokens []string
+	for _, token := range strings.Fields(normTitle) {
+		if strings.ContainsAny(token, "0123456789") || ordinalTitleWords[token] || isSmallRomanNumeral(token) {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.Sort(tokens)
+	return tokens
+}
+
+func isSmallRomanNumeral(token string) bool {
+	rest := strings.TrimLeft(token, "x")
+	if len(token)-len(rest) > 3 {
+		return false
+	}
+	switch rest {
+	case "", "i", "ii", "iii", "iv", "v", "vi", "vii", "viii", "ix":
+		return token != ""
+	}
+	return false
+}
+
+func sameTitleNumbers(a, b string) bool {
+	return slices.Equal(titleNumberTokens(a), titleNumberTokens(b))
+}
+
+// InvalidateISRCCache drops the in-memory index so the next lookup re-walks
+// outputDir. The persisted index is kept; unchanged files are not re-read.
 func InvalidateISRCCache(outputDir string) {
 	isrcIndexCacheMu.Lock()
+	idx := isrcIndexCache[outputDir]
 	delete(isrcIndexCache, outputDir)
 	isrcIndexCacheMu.Unlock()
+
+	if idx != nil {
+		idx.save()
+	}
 }
 
 func checkISRCExistsInternal(outputDir, isrc string) (string, bool) {
@@ -172,19 +560,25 @@ func CheckFileExists(filePath string) bool {
 }
 
 type FileExistenceResult struct {
+	ISRC       string  `json:"isrc"`
+	Exists     bool    `json:"exists"`
+	FilePath   string  `json:"file_path,omitempty"`
+	TrackName  string  `json:"track_name,omitempty"`
+	ArtistName string  `json:"artist_name,omitempty"`
+	MatchType  string  `json:"match_type,omitempty"` // "isrc" or "fuzzy"
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+type fileExistenceRequest struct {
 	ISRC       string `json:"isrc"`
-	Exists     bool   `json:"exists"`
-	FilePath   string `json:"file_path,omitempty"`
-	TrackName  string `json:"track_name,omitempty"`
-	ArtistName string `json:"artist_name,omitempty"`
+	TrackName  string `json:"track_name"`
+	ArtistName string `json:"artist_name"`
+	AlbumName  string `json:"album_name"`
+	DurationMS int    `json:"duration_ms"`
 }
 
 func CheckFilesExistParallel(outputDir string, tracksJSON string) (string, error) {
-	var tracks []struct {
-		ISRC       string `json:"isrc"`
-		TrackName  string `json:"track_name"`
-		ArtistName string `json:"artist_name"`
-	}
+	var tracks []fileExistenceRequest
 	if err := json.Unmarshal([]byte(tracksJSON), &tracks); err != nil {
 		return "", fmt.Errorf("failed to parse tracks JSON: %w", err)
 	}
@@ -196,11 +590,7 @@ func CheckFilesExistParallel(outputDir string, tracksJSON string) (string, error
 	var wg sync.WaitGroup
 	for i, track := range tracks {
 		wg.Add(1)
-		go func(resultIdx int, t struct {
-			ISRC       string `json:"isrc"`
-			TrackName  string `json:"track_name"`
-			ArtistName string `json:"artist_name"`
-		}) {
+		go func(resultIdx int, t fileExistenceRequest) {
 			defer wg.Done()
 
 			result := FileExistenceResult{
@@ -214,6 +604,18 @@ func CheckFilesExistParallel(outputDir string, tracksJSON string) (string, error
 				if filePath, exists := isrcIdx.lookup(t.ISRC); exists {
 					result.Exists = true
 					result.FilePath = filePath
+					result.MatchType = "isrc"
+					result.Confidence = 1
+				}
+			}
+
+			if !result.Exists {
+				filePath, confidence, exists := isrcIdx.lookupFuzzy(t.ISRC, t.TrackName, t.ArtistName, t.AlbumName, t.DurationMS/1000)
+				if exists {
+					result.Exists = true
+					result.FilePath = filePath
+					result.MatchType = "fuzzy"
+					result.Confidence = confidence
 				}
 			}
 
@@ -0,0 +1,193 @@
+package gobackend
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeAPETaggedTestFile(t *testing.T, path string, items ...APETagItem) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("MAC fake audio payload"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := WriteAPETags(path, &APETag{Version: apeTagVersion2, Items: items}); err != nil {
+		t.Fatalf("WriteAPETags failed: %v", err)
+	}
+}
+
+func TestISRCIndexReadsNonFLACFormats(t *testing.T) {
+	outputDir := t.TempDir()
+	SetISRCIndexCacheDir("")
+	defer InvalidateISRCCache(outputDir)
+
+	apePath := filepath.Join(outputDir, "Artist - Song.ape")
+	writeAPETaggedTestFile(t, apePath, APETagItem{Key: "ISRC", Value: "usabc1234567"})
+
+	idx := buildISRCIndex(outputDir)
+	got, ok := idx.lookup("USABC1234567")
+	if !ok || got != apePath {
+		t.Fatalf("expected ISRC to resolve to %q, got %q (found=%v)", apePath, got, ok)
+	}
+}
+
+func TestISRCIndexPersistsAndReusesUnchangedEntries(t *testing.T) {
+	outputDir := t.TempDir()
+	cacheDir := t.TempDir()
+	SetISRCIndexCacheDir(cacheDir)
+	defer SetISRCIndexCacheDir("")
+	defer InvalidateISRCCache(outputDir)
+
+	apePath := filepath.Join(outputDir, "track.ape")
+	writeAPETaggedTestFile(t, apePath, APETagItem{Key: "ISRC", Value: "USABC1234567"})
+
+	buildISRCIndex(outputDir)
+	InvalidateISRCCache(outputDir)
+
+	indexPath := isrcIndexFilePath(outputDir)
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("expected persisted index at %s: %v", indexPath, err)
+	}
+
+	// Rewrite the stored ISRC: an unchanged file must be served from the
+	// persisted entry rather than having its tags re-read.
+	var stored isrcIndexFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("failed to parse persisted index: %v", err)
+	}
+	entry := stored.Files[apePath]
+	entry.ISRC = "GBXYZ7654321"
+	stored.Files[apePath] = entry
+	data, _ = json.Marshal(stored)
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	idx := buildISRCIndex(outputDir)
+	if _, ok := idx.lookup("GBXYZ7654321"); !ok {
+		t.Fatal("expected unchanged file to reuse persisted entry")
+	}
+
+	os.Remove(apePath)
+	InvalidateISRCCache(outputDir)
+	idx = buildISRCIndex(outputDir)
+	if _, ok := idx.lookup("GBXYZ7654321"); ok {
+		t.Fatal("expected deleted file to be dropped from index")
+	}
+}
+
+func TestISRCIndexFuzzyMatchesTracksWithoutISRC(t *testing.T) {
+	outputDir := t.TempDir()
+	SetISRCIndexCacheDir("")
+	defer InvalidateISRCCache(outputDir)
+
+	apePath := filepath.Join(outputDir, "01 - Song.ape")
+	writeAPETaggedTestFile(t, apePath,
+		APETagItem{Key: "Title", Value: "Song Title!"},
+		APETagItem{Key: "Artist", Value: "Beyoncé"},
+		APETagItem{Key: "Album", Value: "Album"},
+	)
+	taggedPath := filepath.Join(outputDir, "02 - Other.ape")
+	writeAPETaggedTestFile(t, taggedPath,
+		APETagItem{Key: "Title", Value: "Other Song"},
+		APETagItem{Key: "Artist", Value: "Beyonce"},
+		APETagItem{Key: "ISRC", Value: "USAAA0000001"},
+	)
+
+	idx := buildISRCIndex(outputDir)
+
+	// APE durations are not read, and without them the duration gate cannot
+	// tell different recordings apart.
+	if _, _, ok := idx.lookupFuzzy("", "Song-Title", "Beyonce", "Album", 200); ok {
+		t.Fatal("expected no fuzzy match while the file's duration is unknown")
+	}
+	idx.mu.Lock()
+	for path, entry := range idx.files {
+		entry.Duration = 200
+		idx.putLocked(path, entry)
+	}
+	idx.mu.Unlock()
+	if _, _, ok := idx.lookupFuzzy("", "Song-Title", "Beyonce", "Album", 0); ok {
+		t.Fatal("expected no fuzzy match for a track without a duration")
+	}
+
+	got, confidence, ok := idx.lookupFuzzy("", "Song-Title", "Beyonce", "Album", 201)
+	if !ok || got != apePath {
+		t.Fatalf("expected fuzzy match %q, got %q (confidence=%.2f)", apePath, got, confidence)
+	}
+	if confidence < fuzzyDuplicateMinConfidence || confidence > 1 {
+		t.Fatalf("unexpected confidence %.2f", confidence)
+	}
+
+	if _, _, ok := idx.lookupFuzzy("", "Completely Different", "Beyonce", "", 200); ok {
+		t.Fatal("expected no match for a different title")
+	}
+	if _, _, ok := idx.lookupFuzzy("", "Song-Title", "Beyonce", "Album", 230); ok {
+		t.Fatal("expected no match for a different duration")
+	}
+	if _, _, ok := idx.lookupFuzzy("USBBB0000002", "Other Song", "Beyonce", "", 200); ok {
+		t.Fatal("expected files tagged with a different ISRC to be skipped")
+	}
+
+	resultJSON, err := CheckFilesExistParallel(outputDir, `[{"track_name":"Song Titel","artist_name":"Beyonce","album_name":"Album","duration_ms":199000}]`)
+	if err != nil {
+		t.Fatalf("CheckFilesExistParallel failed: %v", err)
+	}
+	var results []FileExistenceResult
+	if err := json.Unmarshal([]byte(resultJSON), &results); err != nil {
+		t.Fatalf("failed to parse results: %v", err)
+	}
+	if len(results) != 1 || !results[0].Exists || results[0].MatchType != "fuzzy" {
+		t.Fatalf("unexpected batch result: %s", resultJSON)
+	}
+}
+
+func TestFuzzyDuplicateScoreRequiresMatchingNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		title, indexed string
+		match          bool
+	}{
+		{"Part 1", "Part 2", false},
+		{"Track 10", "Track 11", false},
+		{"Interlude 1", "Interlude 2", false},
+		{"Symphony Part II", "Symphony Part III", false},
+		{"The First Time", "The Second Time", false},
+		{"Intro", "Intro 2", false},
+		{"Part 1", "Part-1", true},
+		{"Track 10 (Remastered)", "Track 10 Remastered", true},
+	} {
+		entry := isrcIndexEntry{Title: tc.indexed, Artist: "Artist", Duration: 180}
+		score := fuzzyDuplicateScore(normalizeLooseTitle(tc.title), normalizeLooseArtistName("Artist"), "", 180, entry)
+		if got := score >= fuzzyDuplicateMinConfidence; got != tc.match {
+			t.Errorf("%q vs %q: score %.2f, match = %v, want %v", tc.title, tc.indexed, score, got, tc.match)
+		}
+	}
+}
+
+func TestGetM4ADurationReadsMovieHeader(t *testing.T) {
+	atom := func(typ string, payload []byte) []byte {
+		out := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+		return append(append(out, typ...), payload...)
+	}
+	v0 := make([]byte, 20)
+	binary.BigEndian.PutUint32(v0[12:16], 44100)
+	binary.BigEndian.PutUint32(v0[16:20], 44100*215)
+	v1 := make([]byte, 32)
+	v1[0] = 1
+	binary.BigEndian.PutUint32(v1[20:24], 1000)
+	binary.BigEndian.PutUint64(v1[24:32], 61500)
+
+	for want, mvhd := range map[int][]byte{215: v0, 61: v1} {
+		path := filepath.Join(t.TempDir(), "track.m4a")
+		data := append(atom("ftyp", []byte("M4A \x00\x00\x00\x00")), atom("moov", atom("mvhd", mvhd))...)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := GetM4ADuration(path); err != nil || got != want {
+			t.Fatalf("GetM4ADuration = %d, %v; want %d", got, err, want)
+		}
+	}
+}
@@ -37,6 +37,157 @@ func SetSongLinkNetworkOptions(allowHTTP, insecureTLS bool) {
 	SetNetworkCompatibilityOptions(allowHTTP, insecureTLS)
 }
 
+// SetProxyConfigJSON routes outbound connections through an HTTP CONNECT or
+// SOCKS5 proxy. configJSON is a ProxyConfig, e.g.
+// {"enabled": true, "type": "socks5", "host": "10.0.0.2", "port": 1080,
+// "default_route": "direct", "services": {"qobuz": "proxy", "lyrics": "proxy"}}.
+func SetProxyConfigJSON(configJSON string) error {
+	var cfg ProxyConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return fmt.Errorf("invalid proxy config: %w", err)
+	}
+	return setProxyConfig(cfg)
+}
+
+func GetProxyConfigJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getProxyConfig())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// TestProxyConnectionJSON requests testURL through a proxy and reports the
+// status and latency. An empty configJSON tests the saved proxy and an
+// empty testURL uses a connectivity check endpoint.
+func TestProxyConnectionJSON(configJSON, testURL string) (string, error) {
+	cfg := getProxyConfig()
+	if strings.TrimSpace(configJSON) != "" {
+		cfg = ProxyConfig{}
+		if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+			return "", fmt.Errorf("invalid proxy config: %w", err)
+		}
+	}
+
+	jsonBytes, err := json.Marshal(testProxyConnection(cfg, testURL))
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// SetDNSConfigJSON selects the resolver used by the built-in and extension
+// HTTP clients. configJSON is a DNSConfig, e.g.
+// {"mode": "doh", "doh_endpoints": ["https://1.1.1.1/dns-query"],
+// "hosts": {"api.example.com": ["203.0.113.7"]}}.
+func SetDNSConfigJSON(configJSON string) error {
+	var cfg DNSConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return fmt.Errorf("invalid DNS config: %w", err)
+	}
+	return setDNSConfig(cfg)
+}
+
+func GetDNSConfigJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getDNSConfig())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// SetHostPoliciesJSON replaces the per-host rate limit, retry and circuit
+// breaker table with a JSON array of HostPolicy. An empty string restores
+// the defaults.
+func SetHostPoliciesJSON(policiesJSON string) error {
+	if strings.TrimSpace(policiesJSON) == "" {
+		return setHostPolicies(nil)
+	}
+	policies := []HostPolicy{}
+	if err := json.Unmarshal([]byte(policiesJSON), &policies); err != nil {
+		return fmt.Errorf("invalid host policies: %w", err)
+	}
+	return setHostPolicies(policies)
+}
+
+func GetHostPoliciesJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getHostPolicies())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// GetHostPolicyStatsJSON returns request, retry and circuit breaker
+// counters for every host policy.
+func GetHostPolicyStatsJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getHostPolicyStats())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// SetHTTPCacheConfigJSON enables the on-disk metadata response cache.
+// configJSON is an HTTPCacheConfig, e.g. {"dir": "/cache/http",
+// "max_size_mb": 50, "rules": [{"host": "api.deezer.com", "path_prefix":
+// "/2.0/album/", "ttl_seconds": 3600}]}. Omitted rules use the defaults and
+// an empty dir disables the cache.
+func SetHTTPCacheConfigJSON(configJSON string) error {
+	var cfg HTTPCacheConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return fmt.Errorf("invalid HTTP cache config: %w", err)
+	}
+	return metadataHTTPCache.configure(cfg)
+}
+
+func GetHTTPCacheConfigJSON() (string, error) {
+	jsonBytes, err := json.Marshal(metadataHTTPCache.config())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// SetHTTPCacheOffline answers metadata requests only from the cache,
+// including stale entries, until it is turned off again.
+func SetHTTPCacheOffline(offline bool) {
+	metadataHTTPCache.setOffline(offline)
+}
+
+func GetHTTPCacheStatsJSON() (string, error) {
+	jsonBytes, err := json.Marshal(metadataHTTPCache.getStats())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func ClearHTTPCache() error {
+	return metadataHTTPCache.clear()
+}
+
+// StartNetworkCassette records every request of the built-in and extension
+// HTTP clients to path (mode "record"), or answers them from the cassette at
+// path without touching the network (mode "replay"). Credentials are
+// redacted from recordings.
+func StartNetworkCassette(mode, path string) error {
+	return startNetworkCassette(mode, path)
+}
+
+// StopNetworkCassette ends recording or replay and writes a recording to
+// the path given to StartNetworkCassette.
+func StopNetworkCassette() error {
+	return stopNetworkCassette()
+}
+
 const musicBrainzAPIBase = "https://musicbrainz.org/ws/2"
 
 type musicBrainzTag struct {
@@ -728,7 +879,7 @@ func buildDownloadSuccessResponse(
 		coverURL = strings.TrimSpace(req.CoverURL)
 	}
 
-	return DownloadResponse{
+	resp := DownloadResponse{
 		Success:          true,
 		Message:          message,
 		FilePath:         filePath,
@@ -755,6 +906,8 @@ func buildDownloadSuccessResponse(
 		DecryptionKey:    result.DecryptionKey,
 		Decryption:       normalizeDownloadDecryptionInfo(result.Decryption, result.DecryptionKey),
 	}
+	emitDownloadCompletedEvent(req, resp)
+	return resp
 }
 
 func shouldSkipQualityProbe(filePath string) bool {
@@ -1437,6 +1590,23 @@ func ParseCueSheet(cuePath string, audioDir string) (string, error) {
 	return ParseCueFileJSON(cuePath, audioDir)
 }
 
+// WriteAlbumCueSheet writes a cue sheet for a downloaded album. albumJSON is a
+// CueAlbumInput: set "file_name" for a single-file image (offsets come from the
+// track durations) or a per-track "file_name" for one file per track.
+func WriteAlbumCueSheet(albumJSON, outputPath string) error {
+	return WriteAlbumCueSheetJSON(albumJSON, outputPath)
+}
+
+// EmbedCueSheetToFLAC parses cuePath and embeds it into the single-file FLAC
+// image at flacPath as a CUESHEET block and CUESHEET Vorbis comment.
+func EmbedCueSheetToFLAC(flacPath, cuePath string) error {
+	sheet, err := ParseCueFile(cuePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse cue file: %w", err)
+	}
+	return EmbedCueSheetInFLAC(flacPath, sheet)
+}
+
 // ScanCueSheetForLibrary parses a .cue file and returns a JSON array of
 // LibraryScanResult entries (one per track). This is the SAF-friendly variant:
 //   - audioDir overrides where the referenced audio file is resolved
@@ -1682,6 +1852,10 @@ func InvalidateDuplicateIndex(outputDir string) {
 	InvalidateISRCCache(outputDir)
 }
 
+func SetDuplicateIndexCacheDir(cacheDir string) {
+	SetISRCIndexCacheDir(cacheDir)
+}
+
 func BuildFilename(template string, metadataJSON string) (string, error) {
 	var metadata map[string]interface{}
 	if err := json.Unmarshal([]byte(metadataJSON), &metadata); err != nil {
@@ -1807,6 +1981,7 @@ func EmbedLyricsToFile(filePath, lyrics string) (string, error) {
 	if err != nil {
 		return errorResponse("Failed to embed lyrics: " + err.Error())
 	}
+	emitLyricsEmbeddedEvent(filePath, lyrics)
 
 	resp := map[string]interface{}{
 		"success": true,
@@ -2734,6 +2909,8 @@ func InitExtensionSystem(extensionsDir, dataDir string) error {
 		return err
 	}
 
+	manager.applyStagedUpdates()
+	manager.startExtensionAutoUpdates()
 	return nil
 }
 
@@ -2813,11 +2990,200 @@ func UpgradeExtensionFromPath(filePath string) (string, error) {
 	return string(jsonBytes), nil
 }
 
+// GetExtensionVersionsJSON lists the archived versions an extension can roll
+// back to, with its pinned version and stored data version.
+func GetExtensionVersionsJSON(extensionID string) (string, error) {
+	history, err := getExtensionManager().listExtensionVersions(extensionID)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(history)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// RollbackExtension reinstalls an archived version of an extension. An empty
+// version rolls back to the previous one.
+func RollbackExtension(extensionID, version string) (string, error) {
+	ext, err := getExtensionManager().RollbackExtension(extensionID, version)
+	if err != nil {
+		return "", err
+	}
+
+	result := map[string]interface{}{
+		"id":           ext.ID,
+		"display_name": ext.Manifest.DisplayName,
+		"version":      ext.Manifest.Version,
+		"enabled":      ext.Enabled,
+	}
+
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// PinExtensionVersion holds an extension at version; an empty version unpins
+// it.
+func PinExtensionVersion(extensionID, version string) error {
+	return getExtensionManager().PinExtensionVersion(extensionID, version)
+}
+
+// SetExtensionVersionHistory sets how many replaced versions are kept per
+// extension (default 3, 0 disables rollback).
+func SetExtensionVersionHistory(limit int) {
+	setExtensionVersionHistory(limit)
+}
+
 func CheckExtensionUpgradeFromPath(filePath string) (string, error) {
 	manager := getExtensionManager()
 	return manager.CheckExtensionUpgradeJSON(filePath)
 }
 
+// CheckExtensionUpdatesJSON lists installed extensions with a newer version
+// in the store, with changelogs and whether the app meets minAppVersion.
+func CheckExtensionUpdatesJSON() (string, error) {
+	store := getExtensionStore()
+	if store == nil {
+		return "", fmt.Errorf("extension store not initialized")
+	}
+
+	updates, err := getExtensionManager().checkExtensionUpdates(store, false)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(updates)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// SetExtensionAutoUpdate turns automatic extension updates on or off. They
+// are off by default. The choice is saved in the extensions directory; while
+// it is on, InitExtensionSystem stages compatible updates once a day.
+func SetExtensionAutoUpdate(enabled bool) error {
+	return getExtensionManager().setExtensionAutoUpdate(enabled)
+}
+
+func IsExtensionAutoUpdateEnabled() bool {
+	return getExtensionManager().isExtensionAutoUpdateEnabled()
+}
+
+// RunExtensionAutoUpdateJSON downloads compatible updates that need no new
+// permissions and stages them; they are installed by the next
+// InitExtensionSystem. Returns one result per update.
+func RunExtensionAutoUpdateJSON() (string, error) {
+	if !getExtensionManager().isExtensionAutoUpdateEnabled() {
+		return "", fmt.Errorf("automatic extension updates are disabled")
+	}
+	store := getExtensionStore()
+	if store == nil {
+		return "", fmt.Errorf("extension store not initialized")
+	}
+
+	results, err := getExtensionManager().runExtensionAutoUpdate(store)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// GetAppliedExtensionUpdatesJSON returns what happened to the updates staged
+// for the last InitExtensionSystem: applied, rolled_back or discarded.
+func GetAppliedExtensionUpdatesJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getExtensionUpdateResults())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// ExportExtensionsBundle writes installed extensions with their settings,
+// storage and credentials to a bundle. optionsJSON is
+// {"path": ..., "passphrase": ..., "extension_ids": [...]}; credentials are
+// only exported when a passphrase is given.
+func ExportExtensionsBundle(optionsJSON string) (string, error) {
+	var opts ExtensionBundleExportOptions
+	if err := json.Unmarshal([]byte(optionsJSON), &opts); err != nil {
+		return "", fmt.Errorf("invalid export options: %w", err)
+	}
+
+	info, err := getExtensionManager().exportExtensionsBundle(opts)
+	if err != nil {
+		return "", err
+	}
+	info.KDF = nil
+
+	jsonBytes, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// InspectExtensionsBundleJSON lists what a bundle holds without restoring it.
+func InspectExtensionsBundleJSON(bundlePath string) (string, error) {
+	info, err := inspectExtensionsBundle(bundlePath)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// ImportExtensionsBundle restores everything in a bundle. Pass an empty
+// passphrase to restore without credentials.
+func ImportExtensionsBundle(bundlePath, passphrase string) (string, error) {
+	return importExtensionsBundleJSON(bundlePath, passphrase, nil)
+}
+
+// ImportExtensionsBundleSelectiveJSON restores only the extensions in
+// selectionJSON, a map of extension ID to
+// {"package": bool, "settings": bool, "storage": bool, "credentials": bool}.
+func ImportExtensionsBundleSelectiveJSON(bundlePath, passphrase, selectionJSON string) (string, error) {
+	selection := map[string]ExtensionRestoreOptions{}
+	if err := json.Unmarshal([]byte(selectionJSON), &selection); err != nil {
+		return "", fmt.Errorf("invalid restore selection: %w", err)
+	}
+	return importExtensionsBundleJSON(bundlePath, passphrase, selection)
+}
+
+func importExtensionsBundleJSON(bundlePath, passphrase string, selection map[string]ExtensionRestoreOptions) (string, error) {
+	results, err := getExtensionManager().importExtensionsBundle(bundlePath, passphrase, selection)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(results)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
 func GetInstalledExtensions() (string, error) {
 	manager := getExtensionManager()
 	return manager.GetInstalledExtensionsJSON()
@@ -2902,21 +3268,73 @@ func GetExtensionSettingsJSON(extensionID string) (string, error) {
 	return string(jsonBytes), nil
 }
 
+// SetExtensionSettingsJSON validates settings against the extension's
+// manifest, stores them with values coerced to the declared types and
+// passes them to initialize(). Invalid settings are rejected with an
+// *ExtensionSettingsError listing every failing key.
 func SetExtensionSettingsJSON(extensionID, settingsJSON string) error {
 	var settings map[string]interface{}
 	if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
 		return err
 	}
 
+	manager := getExtensionManager()
+	if ext, err := manager.GetExtension(extensionID); err == nil {
+		validated, errs := validateExtensionSettings(ext.Manifest, settings)
+		if len(errs) > 0 {
+			return &ExtensionSettingsError{Errors: errs}
+		}
+		settings = validated
+	}
+
+	// Only a settings migration advances the data version.
 	store := GetExtensionSettingsStore()
+	delete(settings, dataVersionSettingKey)
+	if value, err := store.Get(extensionID, dataVersionSettingKey); err == nil {
+		settings[dataVersionSettingKey] = value
+	}
 	if err := store.SetAll(extensionID, settings); err != nil {
 		return err
 	}
 
-	manager := getExtensionManager()
+	if ext, err := manager.GetExtension(extensionID); err == nil && len(requiredSettingErrors(ext.Manifest, settings)) > 0 {
+		// Initialize once every required setting has a value.
+		return nil
+	}
 	return manager.InitializeExtension(extensionID, settings)
 }
 
+// ValidateExtensionSettingsJSON checks settings without saving them. It
+// returns {"valid": bool, "settings": {...}, "errors": [{"key", "message"}]}
+// with the coerced settings, so the app can show errors next to each field.
+func ValidateExtensionSettingsJSON(extensionID, settingsJSON string) (string, error) {
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
+		return "", err
+	}
+
+	ext, err := getExtensionManager().GetExtension(extensionID)
+	if err != nil {
+		return "", err
+	}
+	validated, errs := validateExtensionSettings(ext.Manifest, settings)
+	errs = append(errs, requiredSettingErrors(ext.Manifest, validated)...)
+	if errs == nil {
+		errs = []SettingValidationError{}
+	}
+
+	jsonBytes, err := json.Marshal(map[string]interface{}{
+		"valid":    len(errs) == 0,
+		"settings": validated,
+		"errors":   errs,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
 func SearchTracksWithExtensionsJSON(query string, limit int) (string, error) {
 	manager := getExtensionManager()
 	tracks, err := manager.SearchTracksWithExtensions(query, limit)
@@ -3019,6 +3437,115 @@ func GetExtensionPendingAuthJSON(extensionID string) (string, error) {
 	return string(jsonBytes), nil
 }
 
+// SetExtensionQuotaLimitsJSON overrides an extension's resource quotas.
+// Fields left out of limitsJSON keep their defaults; 0 disables a check.
+func SetExtensionQuotaLimitsJSON(extensionID, limitsJSON string) error {
+	limits := defaultExtensionQuotaLimits()
+	if err := json.Unmarshal([]byte(limitsJSON), &limits); err != nil {
+		return err
+	}
+
+	manager := getExtensionManager()
+	return manager.SetExtensionQuotaLimits(extensionID, limits)
+}
+
+// GetExtensionAuditLogJSON returns the privileged calls recorded for an
+// extension, oldest first.
+func GetExtensionAuditLogJSON(extensionID string) (string, error) {
+	jsonBytes, err := json.Marshal(getExtensionAuditLog(extensionID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func ClearExtensionAuditLog(extensionID string) {
+	clearExtensionAuditLog(extensionID)
+}
+
+func GetPendingPermissionRequestsJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getPendingPermissionRequests())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func RespondToExtensionPermissionRequest(extensionID, scope string, granted bool) error {
+	return setPermissionGrant(extensionID, scope, granted)
+}
+
+func RevokeExtensionPermission(extensionID, scope string) error {
+	return revokePermissionGrant(extensionID, scope)
+}
+
+func GetPendingClipboardWritesJSON() (string, error) {
+	jsonBytes, err := json.Marshal(takePendingClipboardWrites())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// SetExtensionSignaturePolicy sets how unsigned or untrusted packages are
+// handled on install and upgrade: "off", "warn" (default) or "require".
+func SetExtensionSignaturePolicy(policy string) error {
+	return setExtensionSignaturePolicy(policy)
+}
+
+func GetExtensionSignaturePolicy() string {
+	return getExtensionSignaturePolicy()
+}
+
+// SetTrustedExtensionKeysJSON replaces the trusted publisher keys with a JSON
+// array of {"name", "public_key"} objects.
+func SetTrustedExtensionKeysJSON(keysJSON string) error {
+	var keys []TrustedExtensionKey
+	if err := json.Unmarshal([]byte(keysJSON), &keys); err != nil {
+		return err
+	}
+
+	return setTrustedExtensionKeys(keys)
+}
+
+func GetTrustedExtensionKeysJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getTrustedExtensionKeys())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// ValidateExtensionPackageJSON checks a .spotiflac-ext package or extension
+// directory without installing it. It returns {valid, name, version, errors,
+// warnings}, where each error and warning has a field path and a message.
+func ValidateExtensionPackageJSON(path string) (string, error) {
+	report, err := validateExtensionPackage(path)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// GetExtensionManifestSchemaJSON returns the JSON Schema for manifest.json.
+func GetExtensionManifestSchemaJSON() (string, error) {
+	data, err := ExtensionManifestSchemaJSON()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func SetExtensionAuthCodeByID(extensionID, authCode string) {
 	SetExtensionAuthCode(extensionID, authCode)
 }
@@ -3838,6 +4365,45 @@ func GetStoreRegistryURLJSON() (string, error) {
 	return store.getRegistryURL(), nil
 }
 
+// SetStoreRegistriesJSON replaces the registry list with registriesJSON, an
+// array of {name, url, mirrors, enabled, priority, trust}. The registry named
+// "default" is the one SetStoreRegistryURLJSON manages.
+func SetStoreRegistriesJSON(registriesJSON string) error {
+	store := getExtensionStore()
+	if store == nil {
+		return fmt.Errorf("extension store not initialized")
+	}
+
+	registries, err := parseStoreRegistries(registriesJSON)
+	if err != nil {
+		return err
+	}
+	registries, err = normalizeStoreRegistries(registries)
+	if err != nil {
+		return err
+	}
+
+	store.setRegistries(registries)
+	return nil
+}
+
+// GetStoreRegistriesJSON returns the configured registries with the result
+// of their last fetch. The output can be passed back to
+// SetStoreRegistriesJSON as is.
+func GetStoreRegistriesJSON() (string, error) {
+	store := getExtensionStore()
+	if store == nil {
+		return "", fmt.Errorf("extension store not initialized")
+	}
+
+	jsonBytes, err := json.Marshal(store.getRegistries())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
 func GetStoreExtensionsJSON(forceRefresh bool) (string, error) {
 	store := getExtensionStore()
 	if store == nil {
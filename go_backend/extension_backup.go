@@ -0,0 +1,634 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// An extensions bundle is a zip with bundle.json at the root and one folder
+// per extension under extensions/<id>/ holding package.spotiflac-ext,
+// settings.json, storage.json and credentials.enc. Credentials are
+// decrypted with the device key and encrypted again with a key derived from
+// the user's passphrase, so the bundle can be restored on another device.
+const (
+	extensionBundleFormat       = 1
+	extensionBundleManifestName = "bundle.json"
+	bundlePackageName           = "package.spotiflac-ext"
+	bundleSettingsName          = "settings.json"
+	bundleStorageName           = storageFileName
+	bundleCredentialsName       = "credentials.enc"
+
+	bundleScryptN = 1 << 15
+	bundleScryptR = 8
+	bundleScryptP = 1
+
+	// The KDF parameters come from the bundle, so they are capped before
+	// deriving: scrypt needs 128*N*r*p bytes, which these caps hold to
+	// 128 MiB, four times the defaults.
+	maxBundleScryptN = 1 << 17
+	maxBundleScryptR = 8
+	maxBundleScryptP = 1
+
+	// maxBundleFileSize caps each file read from a bundle, so a small zip
+	// cannot expand into more memory than a restore needs.
+	maxBundleFileSize = 64 * 1024 * 1024
+
+	// bundlePassphraseCheck is encrypted into bundle.json so a wrong
+	// passphrase is reported before anything is restored.
+	bundlePassphraseCheck = "spotiflac-extensions-bundle"
+)
+
+// ExtensionBundleEntry lists what a bundle holds for one extension.
+type ExtensionBundleEntry struct {
+	ID          string `json:"id"`
+	Version     string `json:"version"`
+	DisplayName string `json:"display_name,omitempty"`
+	Package     bool   `json:"package"`
+	Settings    bool   `json:"settings"`
+	Storage     bool   `json:"storage"`
+	Credentials bool   `json:"credentials"`
+}
+
+// ExtensionBundleInfo is the content of bundle.json.
+type ExtensionBundleInfo struct {
+	Format     int                    `json:"format"`
+	CreatedAt  int64                  `json:"created_at"`
+	AppVersion string                 `json:"app_version,omitempty"`
+	KDF        *extensionBundleKDF    `json:"kdf,omitempty"`
+	Extensions []ExtensionBundleEntry `json:"extensions"`
+}
+
+type extensionBundleKDF struct {
+	Name  string `json:"name"`
+	Salt  string `json:"salt"`
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Check string `json:"check"`
+}
+
+// ExtensionBundleExportOptions selects what ExportExtensionsBundle writes.
+// Credentials are only exported when a passphrase is given.
+type ExtensionBundleExportOptions struct {
+	Path         string   `json:"path"`
+	Passphrase   string   `json:"passphrase,omitempty"`
+	ExtensionIDs []string `json:"extension_ids,omitempty"`
+}
+
+// ExtensionRestoreOptions selects the parts restored for one extension.
+type ExtensionRestoreOptions struct {
+	Package     bool `json:"package"`
+	Settings    bool `json:"settings"`
+	Storage     bool `json:"storage"`
+	Credentials bool `json:"credentials"`
+}
+
+// ExtensionRestoreResult reports what was restored for one extension.
+type ExtensionRestoreResult struct {
+	ExtensionID string   `json:"extension_id"`
+	Restored    []string `json:"restored"`
+	Skipped     []string `json:"skipped,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+func deriveBundleKey(passphrase string, kdf *extensionBundleKDF) ([]byte, error) {
+	if kdf.Name != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation: %s", kdf.Name)
+	}
+	if kdf.N < 2 || kdf.N > maxBundleScryptN || kdf.N&(kdf.N-1) != 0 ||
+		kdf.R < 1 || kdf.R > maxBundleScryptR || kdf.P < 1 || kdf.P > maxBundleScryptP {
+		return nil, fmt.Errorf("unsupported key derivation parameters: N=%d r=%d p=%d", kdf.N, kdf.R, kdf.P)
+	}
+	salt, err := base64.StdEncoding.DecodeString(kdf.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle salt")
+	}
+	return scrypt.Key([]byte(passphrase), salt, kdf.N, kdf.R, kdf.P, 32)
+}
+
+func newBundleKDF(passphrase string) (*extensionBundleKDF, []byte, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kdf := &extensionBundleKDF{
+		Name: "scrypt",
+		Salt: base64.StdEncoding.EncodeToString(salt),
+		N:    bundleScryptN,
+		R:    bundleScryptR,
+		P:    bundleScryptP,
+	}
+	key, err := deriveBundleKey(passphrase, kdf)
+	if err != nil {
+		return nil, nil, err
+	}
+	check, err := encryptAES([]byte(bundlePassphraseCheck), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	kdf.Check = base64.StdEncoding.EncodeToString(check)
+	return kdf, key, nil
+}
+
+// readExtensionCredentials decrypts the credentials an extension stored in
+// dataDir, or returns nil when it has none.
+func readExtensionCredentials(extensionID, dataDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, credentialsFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	salt, err := os.ReadFile(filepath.Join(dataDir, credentialsSaltFileName))
+	if err != nil {
+		return nil, fmt.Errorf("credentials salt is missing: %w", err)
+	}
+	return decryptAES(data, extensionCredentialsKey(extensionID, salt))
+}
+
+func writeExtensionCredentials(extensionID, dataDir string, plaintext []byte) error {
+	salt, err := getOrCreateCredentialsSalt(filepath.Join(dataDir, credentialsSaltFileName))
+	if err != nil {
+		return err
+	}
+	encrypted, err := encryptAES(plaintext, extensionCredentialsKey(extensionID, salt))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dataDir, credentialsFileName), encrypted, 0600)
+}
+
+// packExtensionDir zips an installed extension back into a package.
+func packExtensionDir(dir string) ([]byte, error) {
+	entries, err := dirPackageEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		fw, err := w.Create(filepath.ToSlash(entry.name))
+		if err != nil {
+			return nil, err
+		}
+		rc, err := entry.open()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(fw, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportExtensionsBundle writes the selected extensions, or all installed
+// ones, to opts.Path.
+func (m *extensionManager) exportExtensionsBundle(opts ExtensionBundleExportOptions) (*ExtensionBundleInfo, error) {
+	if strings.TrimSpace(opts.Path) == "" {
+		return nil, fmt.Errorf("bundle path is empty")
+	}
+
+	extensions := m.GetAllExtensions()
+	if len(opts.ExtensionIDs) > 0 {
+		selected := extensions[:0]
+		for _, ext := range extensions {
+			if containsString(opts.ExtensionIDs, ext.ID) {
+				selected = append(selected, ext)
+			}
+		}
+		extensions = selected
+	}
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i].ID < extensions[j].ID })
+
+	info := &ExtensionBundleInfo{
+		Format:     extensionBundleFormat,
+		CreatedAt:  time.Now().Unix(),
+		AppVersion: GetAppVersion(),
+		Extensions: []ExtensionBundleEntry{},
+	}
+	var bundleKey []byte
+	if opts.Passphrase != "" {
+		kdf, key, err := newBundleKDF(opts.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		info.KDF = kdf
+		bundleKey = key
+	}
+
+	tmpPath := opts.Path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	w := zip.NewWriter(out)
+	fail := func(err error) (*ExtensionBundleInfo, error) {
+		w.Close()
+		out.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	writeFile := func(name string, data []byte) error {
+		fw, err := w.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	}
+
+	for _, ext := range extensions {
+		prefix := path.Join("extensions", ext.ID) + "/"
+		entry := ExtensionBundleEntry{ID: ext.ID, Version: ext.Manifest.Version, DisplayName: ext.Manifest.DisplayName}
+
+		// Storage writes are batched, so flush the latest values first.
+		ext.VMMu.Lock()
+		if ext.runtime != nil {
+			if err := ext.runtime.flushStorageNow(); err != nil {
+				GoLog("[Extension] Failed to flush storage for %s: %v\n", ext.ID, err)
+			}
+		}
+		ext.VMMu.Unlock()
+
+		if ext.SourceDir != "" {
+			pkg, err := packExtensionDir(ext.SourceDir)
+			if err != nil {
+				return fail(fmt.Errorf("%s: failed to pack extension: %w", ext.ID, err))
+			}
+			if err := writeFile(prefix+bundlePackageName, pkg); err != nil {
+				return fail(err)
+			}
+			entry.Package = true
+		}
+
+		if settings := GetExtensionSettingsStore().GetAll(ext.ID); len(settings) > 0 {
+			data, err := json.Marshal(settings)
+			if err != nil {
+				return fail(err)
+			}
+			if err := writeFile(prefix+bundleSettingsName, data); err != nil {
+				return fail(err)
+			}
+			entry.Settings = true
+		}
+
+		if data, err := os.ReadFile(filepath.Join(ext.DataDir, storageFileName)); err == nil {
+			if err := writeFile(prefix+bundleStorageName, data); err != nil {
+				return fail(err)
+			}
+			entry.Storage = true
+		}
+
+		if bundleKey != nil {
+			creds, err := readExtensionCredentials(ext.ID, ext.DataDir)
+			if err != nil {
+				return fail(fmt.Errorf("%s: failed to read credentials: %w", ext.ID, err))
+			}
+			if creds != nil {
+				encrypted, err := encryptAES(creds, bundleKey)
+				if err != nil {
+					return fail(err)
+				}
+				if err := writeFile(prefix+bundleCredentialsName, encrypted); err != nil {
+					return fail(err)
+				}
+				entry.Credentials = true
+			}
+		}
+
+		info.Extensions = append(info.Extensions, entry)
+	}
+
+	manifest, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fail(err)
+	}
+	if err := writeFile(extensionBundleManifestName, manifest); err != nil {
+		return fail(err)
+	}
+	if err := w.Close(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, opts.Path); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	GoLog("[Extension] Exported %d extensions to %s\n", len(info.Extensions), opts.Path)
+	return info, nil
+}
+
+type extensionBundleReader struct {
+	zip   *zip.ReadCloser
+	info  ExtensionBundleInfo
+	files map[string]*zip.File
+}
+
+func openExtensionsBundle(bundlePath string) (*extensionBundleReader, error) {
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open bundle: %w", err)
+	}
+	b := &extensionBundleReader{zip: zr, files: make(map[string]*zip.File, len(zr.File))}
+	for _, file := range zr.File {
+		b.files[file.Name] = file
+	}
+	data, err := b.read(extensionBundleManifestName)
+	if err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("not an extensions bundle: %w", err)
+	}
+	if err := json.Unmarshal(data, &b.info); err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("invalid bundle.json: %w", err)
+	}
+	if b.info.Format != extensionBundleFormat {
+		zr.Close()
+		return nil, fmt.Errorf("unsupported bundle format %d", b.info.Format)
+	}
+	return b, nil
+}
+
+func (b *extensionBundleReader) read(name string) ([]byte, error) {
+	file, ok := b.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	if file.UncompressedSize64 > maxBundleFileSize {
+		return nil, fmt.Errorf("%s is too large", name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// The zip header's size is not trusted on its own.
+	data, err := io.ReadAll(io.LimitReader(rc, maxBundleFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBundleFileSize {
+		return nil, fmt.Errorf("%s is too large", name)
+	}
+	return data, nil
+}
+
+func (b *extensionBundleReader) Close() error {
+	return b.zip.Close()
+}
+
+// inspectExtensionsBundle returns the bundle contents without restoring
+// anything.
+func inspectExtensionsBundle(bundlePath string) (*ExtensionBundleInfo, error) {
+	b, err := openExtensionsBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	info := b.info
+	info.KDF = nil
+	return &info, nil
+}
+
+// importExtensionsBundle restores a bundle. selection maps extension IDs to
+// the parts to restore; a nil selection restores everything. Without a
+// passphrase, credentials are skipped; a wrong one fails before anything is
+// written.
+func (m *extensionManager) importExtensionsBundle(bundlePath, passphrase string, selection map[string]ExtensionRestoreOptions) ([]ExtensionRestoreResult, error) {
+	b, err := openExtensionsBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	m.mu.RLock()
+	dataDir := m.dataDir
+	m.mu.RUnlock()
+	if dataDir == "" {
+		return nil, fmt.Errorf("extension system not initialized")
+	}
+
+	var bundleKey []byte
+	if passphrase != "" && b.info.KDF != nil {
+		key, err := deriveBundleKey(passphrase, b.info.KDF)
+		if err != nil {
+			return nil, err
+		}
+		check, err := base64.StdEncoding.DecodeString(b.info.KDF.Check)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle passphrase check")
+		}
+		if plain, err := decryptAES(check, key); err != nil || string(plain) != bundlePassphraseCheck {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+		bundleKey = key
+	}
+
+	results := []ExtensionRestoreResult{}
+	for _, entry := range b.info.Extensions {
+		opts := ExtensionRestoreOptions{Package: true, Settings: true, Storage: true, Credentials: true}
+		if selection != nil {
+			selected, ok := selection[entry.ID]
+			if !ok {
+				continue
+			}
+			opts = selected
+		}
+		result := m.restoreBundleEntry(b, entry, opts, bundleKey, dataDir)
+		if result.Restored == nil {
+			result.Restored = []string{}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (m *extensionManager) restoreBundleEntry(b *extensionBundleReader, entry ExtensionBundleEntry, opts ExtensionRestoreOptions, bundleKey []byte, dataDir string) ExtensionRestoreResult {
+	result := ExtensionRestoreResult{ExtensionID: entry.ID}
+	if err := validateExtensionID(entry.ID); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	prefix := path.Join("extensions", entry.ID) + "/"
+
+	if opts.Package && entry.Package {
+		// The installed version is kept unless the bundle has a newer one.
+		if existing, err := m.GetExtension(entry.ID); err == nil && compareVersions(entry.Version, existing.Manifest.Version) <= 0 {
+			result.Skipped = append(result.Skipped, "package")
+		} else if err := m.restoreBundlePackage(b, prefix+bundlePackageName, entry); err != nil {
+			result.Error = err.Error()
+			return result
+		} else {
+			result.Restored = append(result.Restored, "package")
+		}
+	}
+
+	var credentials []byte
+	if opts.Credentials && entry.Credentials {
+		if bundleKey == nil {
+			result.Skipped = append(result.Skipped, "credentials")
+		} else {
+			encrypted, err := b.read(prefix + bundleCredentialsName)
+			if err == nil {
+				credentials, err = decryptAES(encrypted, bundleKey)
+			}
+			if err != nil {
+				result.Error = fmt.Sprintf("failed to read credentials: %v", err)
+				return result
+			}
+		}
+	}
+
+	extDataDir := filepath.Join(dataDir, entry.ID)
+	if err := os.MkdirAll(extDataDir, 0755); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// A running extension caches storage and credentials, and would write
+	// its cache over the restored files, so stop it first. It starts again
+	// on its next call.
+	ext, _ := m.GetExtension(entry.ID)
+	if ext != nil {
+		ext.VMMu.Lock()
+		teardownVMLocked(ext)
+	}
+	var settings map[string]interface{}
+	err := func() error {
+		if opts.Settings && entry.Settings {
+			data, err := b.read(prefix + bundleSettingsName)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &settings); err != nil {
+				return fmt.Errorf("invalid settings: %w", err)
+			}
+			restored, err := restoredExtensionSettings(ext, entry.ID, settings)
+			if err != nil {
+				return err
+			}
+			if err := GetExtensionSettingsStore().SetAll(entry.ID, restored); err != nil {
+				return err
+			}
+			result.Restored = append(result.Restored, "settings")
+		}
+		if opts.Storage && entry.Storage {
+			data, err := b.read(prefix + bundleStorageName)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(extDataDir, storageFileName), data, 0600); err != nil {
+				return err
+			}
+			result.Restored = append(result.Restored, "storage")
+		}
+		if credentials != nil {
+			if err := writeExtensionCredentials(entry.ID, extDataDir, credentials); err != nil {
+				return err
+			}
+			result.Restored = append(result.Restored, "credentials")
+		}
+		return nil
+	}()
+	if ext != nil {
+		ext.VMMu.Unlock()
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if enabled, _ := settings["_enabled"].(bool); enabled && ext != nil && !ext.Enabled {
+		if err := m.SetExtensionEnabled(entry.ID, true); err != nil {
+			GoLog("[Extension] Failed to enable restored extension %s: %v\n", entry.ID, err)
+		}
+	}
+	GoLog("[Extension] Restored %s: %s\n", entry.ID, strings.Join(result.Restored, ", "))
+	return result
+}
+
+// restoredExtensionSettings validates bundled settings against the
+// installed manifest the way SetExtensionSettingsJSON does. Keys starting
+// with "_" are internal state of this device, so the bundle's are dropped
+// and the local ones kept.
+func restoredExtensionSettings(ext *loadedExtension, extensionID string, bundled map[string]interface{}) (map[string]interface{}, error) {
+	settings := make(map[string]interface{}, len(bundled))
+	for key, value := range bundled {
+		if !strings.HasPrefix(key, "_") {
+			settings[key] = value
+		}
+	}
+	if ext != nil {
+		validated, errs := validateExtensionSettings(ext.Manifest, settings)
+		if len(errs) > 0 {
+			return nil, &ExtensionSettingsError{Errors: errs}
+		}
+		settings = validated
+	}
+	for key, value := range GetExtensionSettingsStore().GetAll(extensionID) {
+		if strings.HasPrefix(key, "_") {
+			settings[key] = value
+		}
+	}
+	return settings, nil
+}
+
+// restoreBundlePackage installs the bundled package, or upgrades to it when
+// it is newer than the installed version.
+func (m *extensionManager) restoreBundlePackage(b *extensionBundleReader, name string, entry ExtensionBundleEntry) error {
+	data, err := b.read(name)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "spotiflac-restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	packagePath := filepath.Join(tmpDir, entry.ID+".spotiflac-ext")
+	if err := os.WriteFile(packagePath, data, 0644); err != nil {
+		return err
+	}
+	ext, err := m.LoadExtensionFromFile(packagePath)
+	if err != nil {
+		return err
+	}
+	if ext.ID != entry.ID {
+		return fmt.Errorf("bundled package is '%s', not '%s'", ext.ID, entry.ID)
+	}
+	return nil
+}
+
+// validateExtensionID rejects IDs that would escape the data directory.
+func validateExtensionID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid extension id %q", id)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useEmptySettingsStore is useTempSettingsStore with no settings loaded, so
+// a restore target starts like a fresh install.
+func useEmptySettingsStore(t *testing.T) {
+	t.Helper()
+	useTempSettingsStore(t)
+	store := GetExtensionSettingsStore()
+	store.mu.Lock()
+	previousSettings := store.settings
+	store.settings = make(map[string]map[string]interface{})
+	store.mu.Unlock()
+	t.Cleanup(func() {
+		store.mu.Lock()
+		store.settings = previousSettings
+		store.mu.Unlock()
+	})
+}
+
+func TestExtensionBackup_RoundTrip(t *testing.T) {
+	source := newUpdateTestManager(t, t.TempDir())
+	newSigningTestManager(t, SignaturePolicyOff)
+	pkg := filepath.Join(t.TempDir(), "backup-ext.spotiflac-ext")
+	writeTestExtensionPackage(t, pkg, "backup-ext", "1.2.0", signingTestScript)
+	ext, err := source.LoadExtensionFromFile(pkg)
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if err := GetExtensionSettingsStore().SetAll("backup-ext", map[string]interface{}{"quality": "lossless", "_pinned_version": "1.0.0"}); err != nil {
+		t.Fatalf("SetAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(ext.DataDir, storageFileName), []byte(`{"history":[1,2]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExtensionCredentials("backup-ext", ext.DataDir, []byte(`{"token":"secret"}`)); err != nil {
+		t.Fatalf("writeExtensionCredentials: %v", err)
+	}
+
+	bundlePath := filepath.Join(t.TempDir(), "extensions.bundle")
+	info, err := source.exportExtensionsBundle(ExtensionBundleExportOptions{Path: bundlePath, Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(info.Extensions) != 1 || !info.Extensions[0].Package || !info.Extensions[0].Settings || !info.Extensions[0].Storage || !info.Extensions[0].Credentials {
+		t.Fatalf("exported = %+v", info.Extensions)
+	}
+
+	// Restore onto a fresh install.
+	useEmptySettingsStore(t)
+	target := newUpdateTestManager(t, t.TempDir())
+	if _, err := target.importExtensionsBundle(bundlePath, "wrong", nil); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("wrong passphrase: err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target.extensionsDir, "backup-ext")); !os.IsNotExist(err) {
+		t.Fatalf("a wrong passphrase must not restore anything: %v", err)
+	}
+
+	results, err := target.importExtensionsBundle(bundlePath, "", map[string]ExtensionRestoreOptions{
+		"backup-ext": {Package: true, Settings: true, Credentials: true},
+	})
+	if err != nil {
+		t.Fatalf("selective import: %v", err)
+	}
+	if got := strings.Join(results[0].Restored, ","); got != "package,settings" || strings.Join(results[0].Skipped, ",") != "credentials" {
+		t.Fatalf("selective result = %+v", results[0])
+	}
+	dataDir := filepath.Join(target.dataDir, "backup-ext")
+	if _, err := os.Stat(filepath.Join(dataDir, storageFileName)); !os.IsNotExist(err) {
+		t.Fatalf("storage was not selected but restored: %v", err)
+	}
+
+	// Internal "_" keys are device state: the bundle's are dropped and the
+	// local ones kept.
+	local := GetExtensionSettingsStore().GetAll("backup-ext")
+	if _, ok := local["_pinned_version"]; ok {
+		t.Fatalf("bundled internal key restored: %v", local)
+	}
+	local["_pinned_version"] = "1.2.0"
+	GetExtensionSettingsStore().SetAll("backup-ext", local)
+
+	results, err = target.importExtensionsBundle(bundlePath, "correct horse", nil)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if got := strings.Join(results[0].Restored, ","); got != "settings,storage,credentials" || results[0].Skipped[0] != "package" {
+		t.Fatalf("import result = %+v", results[0])
+	}
+	if installedVersion(t, target, "backup-ext") != "1.2.0" {
+		t.Fatal("package not restored")
+	}
+	if got := GetExtensionSettingsStore().GetAll("backup-ext")["quality"]; got != "lossless" {
+		t.Fatalf("restored setting = %v", got)
+	}
+	if got := GetExtensionSettingsStore().GetAll("backup-ext")["_pinned_version"]; got != "1.2.0" {
+		t.Fatalf("local internal key = %v, want it kept", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dataDir, storageFileName)); string(data) != `{"history":[1,2]}` {
+		t.Fatalf("restored storage = %s", data)
+	}
+	creds, err := readExtensionCredentials("backup-ext", dataDir)
+	if err != nil || string(creds) != `{"token":"secret"}` {
+		t.Fatalf("restored credentials = %s, %v", creds, err)
+	}
+	sourceSalt, _ := os.ReadFile(filepath.Join(ext.DataDir, credentialsSaltFileName))
+	targetSalt, _ := os.ReadFile(filepath.Join(dataDir, credentialsSaltFileName))
+	if string(sourceSalt) == string(targetSalt) {
+		t.Fatal("restored credentials should be encrypted with the target's own salt")
+	}
+}
+
+func TestExtensionBackup_RejectsExpensiveKDF(t *testing.T) {
+	for _, kdf := range []extensionBundleKDF{
+		{Name: "scrypt", N: 1 << 24, R: 8, P: 1},
+		{Name: "scrypt", N: 1 << 18, R: 8, P: 1},
+		{Name: "scrypt", N: 1 << 15, R: 16, P: 1},
+		{Name: "scrypt", N: 1 << 15, R: 8, P: 2},
+		{Name: "scrypt", N: 30000, R: 8, P: 1},
+	} {
+		if _, err := deriveBundleKey("pass", &kdf); err == nil || !strings.Contains(err.Error(), "parameters") {
+			t.Errorf("N=%d r=%d p=%d: err = %v", kdf.N, kdf.R, kdf.P, err)
+		}
+	}
+}
+
+func TestExtensionBackup_RejectsOversizedFiles(t *testing.T) {
+	bundlePath := filepath.Join(t.TempDir(), "big.spotiflac-backup")
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		t.Fatalf("create bundle: %v", err)
+	}
+	w := zip.NewWriter(f)
+	fw, err := w.Create(extensionBundleManifestName)
+	if err != nil {
+		t.Fatalf("create manifest: %v", err)
+	}
+	fw.Write([]byte(`{"format":1,"extensions":[]}`))
+	fw, err = w.Create("extensions/big-ext/" + bundleSettingsName)
+	if err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	fw.Write(bytes.Repeat([]byte(" "), maxBundleFileSize+1))
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	f.Close()
+
+	b, err := openExtensionsBundle(bundlePath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer b.Close()
+	if _, err := b.read("extensions/big-ext/" + bundleSettingsName); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("read = %v, want a size error", err)
+	}
+}
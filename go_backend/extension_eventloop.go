@@ -0,0 +1,313 @@
+package gobackend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// extensionEventLoop queues work that must run on the goroutine currently
+// driving an extension VM. Goroutines (fetch, timers) hand their results back
+// through enqueue; the loop is only spun while a caller holds VMMu and awaits
+// a promise, so callbacks never run concurrently with other VM access.
+type extensionEventLoop struct {
+	vm *goja.Runtime
+
+	mu      sync.Mutex
+	queue   []func()
+	wakeup  chan struct{}
+	pending int
+	closed  bool
+
+	timers      map[int64]*extensionTimer
+	nextTimerID int64
+}
+
+type extensionTimer struct {
+	id       int64
+	timer    *time.Timer
+	callback goja.Callable
+	args     []goja.Value
+	interval time.Duration
+	repeat   bool
+}
+
+var (
+	extensionEventLoops   = make(map[*goja.Runtime]*extensionEventLoop)
+	extensionEventLoopsMu sync.RWMutex
+)
+
+func newExtensionEventLoop(vm *goja.Runtime) *extensionEventLoop {
+	loop := &extensionEventLoop{
+		vm:     vm,
+		wakeup: make(chan struct{}, 1),
+		timers: make(map[int64]*extensionTimer),
+	}
+
+	extensionEventLoopsMu.Lock()
+	extensionEventLoops[vm] = loop
+	extensionEventLoopsMu.Unlock()
+
+	return loop
+}
+
+func getExtensionEventLoop(vm *goja.Runtime) *extensionEventLoop {
+	extensionEventLoopsMu.RLock()
+	defer extensionEventLoopsMu.RUnlock()
+	return extensionEventLoops[vm]
+}
+
+// close stops all timers and drops queued work. Jobs enqueued afterwards by
+// in-flight goroutines are discarded.
+func (l *extensionEventLoop) close() {
+	extensionEventLoopsMu.Lock()
+	if extensionEventLoops[l.vm] == l {
+		delete(extensionEventLoops, l.vm)
+	}
+	extensionEventLoopsMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for id, t := range l.timers {
+		t.timer.Stop()
+		delete(l.timers, id)
+	}
+	l.queue = nil
+	l.pending = 0
+}
+
+func (l *extensionEventLoop) enqueue(job func()) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.queue = append(l.queue, job)
+	l.mu.Unlock()
+
+	select {
+	case l.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// startAsync registers an operation that completes off the VM goroutine and
+// returns the function used to hand its completion back to the loop. The
+// returned function must be called exactly once.
+func (l *extensionEventLoop) startAsync() func(job func()) {
+	l.mu.Lock()
+	l.pending++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func(job func()) {
+		once.Do(func() {
+			l.mu.Lock()
+			if l.pending > 0 {
+				l.pending--
+			}
+			l.mu.Unlock()
+			l.enqueue(job)
+		})
+	}
+}
+
+func (l *extensionEventLoop) takeQueue() ([]func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	jobs := l.queue
+	l.queue = nil
+	idle := len(jobs) == 0 && l.pending == 0 && len(l.timers) == 0
+	return jobs, idle
+}
+
+// runPending runs the callbacks that are already queued without waiting.
+func (l *extensionEventLoop) runPending() {
+	jobs, _ := l.takeQueue()
+	for _, job := range jobs {
+		job()
+	}
+}
+
+// await spins the loop until promise settles, ctx is done, or nothing is left
+// that could settle it.
+func (l *extensionEventLoop) await(ctx context.Context, promise *goja.Promise) (goja.Value, error) {
+	for {
+		switch promise.State() {
+		case goja.PromiseStateFulfilled:
+			return promise.Result(), nil
+		case goja.PromiseStateRejected:
+			return nil, promiseRejectionError(promise.Result())
+		}
+
+		jobs, idle := l.takeQueue()
+		if len(jobs) > 0 {
+			for _, job := range jobs {
+				job()
+			}
+			continue
+		}
+		if idle {
+			return nil, &JSExecutionError{Message: "promise never settled: no pending timers or requests"}
+		}
+
+		// Waiting for timers and requests is not JavaScript execution.
+		leaveHost := func() {}
+		if quota := getExtensionQuota(l.vm); quota != nil {
+			leaveHost = quota.enterHostCall()
+		}
+		select {
+		case <-l.wakeup:
+			leaveHost()
+		case <-ctx.Done():
+			leaveHost()
+			return nil, &JSExecutionError{
+				Message:   "execution timeout exceeded",
+				IsTimeout: true,
+			}
+		}
+	}
+}
+
+func promiseRejectionError(reason goja.Value) error {
+	if reason == nil || goja.IsUndefined(reason) {
+		return &JSExecutionError{Message: "promise rejected"}
+	}
+	if obj, ok := reason.(*goja.Object); ok {
+		if stack := obj.Get("stack"); stack != nil && !goja.IsUndefined(stack) && stack.String() != "" {
+			return &JSExecutionError{Message: stack.String()}
+		}
+	}
+	return &JSExecutionError{Message: reason.String()}
+}
+
+func (l *extensionEventLoop) addTimer(callback goja.Callable, delay time.Duration, args []goja.Value, repeat bool) int64 {
+	if delay < 0 {
+		delay = 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0
+	}
+
+	l.nextTimerID++
+	t := &extensionTimer{
+		id:       l.nextTimerID,
+		callback: callback,
+		args:     args,
+		interval: delay,
+		repeat:   repeat,
+	}
+	t.timer = time.AfterFunc(delay, func() { l.enqueue(func() { l.fireTimer(t) }) })
+	l.timers[t.id] = t
+	return t.id
+}
+
+func (l *extensionEventLoop) fireTimer(t *extensionTimer) {
+	l.mu.Lock()
+	if _, active := l.timers[t.id]; !active {
+		l.mu.Unlock()
+		return
+	}
+	if !t.repeat {
+		delete(l.timers, t.id)
+	}
+	l.mu.Unlock()
+
+	if _, err := t.callback(goja.Undefined(), t.args...); err != nil {
+		GoLog("[extensionRuntime] timer callback error: %v\n", err)
+	}
+
+	if t.repeat {
+		l.mu.Lock()
+		if _, active := l.timers[t.id]; active && !l.closed {
+			t.timer.Reset(t.interval)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *extensionEventLoop) clearTimer(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.timers[id]; ok {
+		t.timer.Stop()
+		delete(l.timers, id)
+	}
+}
+
+func (l *extensionEventLoop) register(vm *goja.Runtime) {
+	newTimer := func(repeat bool) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			if len(call.Arguments) < 1 {
+				panic(vm.NewTypeError("callback is required"))
+			}
+			callback, ok := goja.AssertFunction(call.Arguments[0])
+			if !ok {
+				panic(vm.NewTypeError("callback must be a function"))
+			}
+			var delay time.Duration
+			if len(call.Arguments) > 1 {
+				delay = time.Duration(call.Arguments[1].ToFloat() * float64(time.Millisecond))
+			}
+			if repeat && delay < time.Millisecond {
+				delay = time.Millisecond
+			}
+			var args []goja.Value
+			if len(call.Arguments) > 2 {
+				args = append(args, call.Arguments[2:]...)
+			}
+			return vm.ToValue(l.addTimer(callback, delay, args, repeat))
+		}
+	}
+	clearTimer := func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) > 0 {
+			l.clearTimer(call.Arguments[0].ToInteger())
+		}
+		return goja.Undefined()
+	}
+
+	vm.Set("setTimeout", newTimer(false))
+	vm.Set("setInterval", newTimer(true))
+	vm.Set("clearTimeout", clearTimer)
+	vm.Set("clearInterval", clearTimer)
+
+	_, _ = vm.RunString(`
+		function queueMicrotask(callback) {
+			if (typeof callback !== 'function') {
+				throw new TypeError('callback must be a function');
+			}
+			Promise.resolve().then(function() { callback(); });
+		}
+	`)
+}
+
+// awaitExtensionResult resolves value when it is a promise created by an
+// extension VM with an event loop. Other values are returned unchanged.
+func awaitExtensionResult(ctx context.Context, vm *goja.Runtime, value goja.Value) (goja.Value, error) {
+	if value == nil {
+		return value, nil
+	}
+	promise, ok := value.Export().(*goja.Promise)
+	if !ok {
+		return value, nil
+	}
+
+	loop := getExtensionEventLoop(vm)
+	if loop == nil {
+		if promise.State() == goja.PromiseStateFulfilled {
+			return promise.Result(), nil
+		}
+		if promise.State() == goja.PromiseStateRejected {
+			return nil, promiseRejectionError(promise.Result())
+		}
+		return nil, fmt.Errorf("extension returned a pending promise but has no event loop")
+	}
+	return loop.await(ctx, promise)
+}
@@ -0,0 +1,144 @@
+package gobackend
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var eventLoopTestPermissions = ExtensionPermissions{Network: []string{"api.allowed.com"}}
+
+func TestEventLoop_AwaitsAsyncFunctionWithTimers(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	script := `
+		(async function() {
+			var wait = function(ms, value) {
+				return new Promise(function(resolve) { setTimeout(resolve, ms, value); });
+			};
+			var values = await Promise.all([wait(30, 'a'), wait(10, 'b'), wait(20, 'c')]);
+			var ticks = 0;
+			await new Promise(function(resolve) {
+				var id = setInterval(function() {
+					ticks++;
+					if (ticks === 3) {
+						clearInterval(id);
+						resolve();
+					}
+				}, 5);
+			});
+			return values.join('') + ticks;
+		})()
+	`
+
+	result, err := RunWithTimeoutAndRecover(vm, script, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RunWithTimeout failed: %v", err)
+	}
+	if got := result.String(); got != "abc3" {
+		t.Fatalf("result = %q, want abc3", got)
+	}
+}
+
+func TestEventLoop_QueueMicrotaskRunsBeforeTimers(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	script := `
+		new Promise(function(resolve) {
+			var order = [];
+			setTimeout(function() { order.push('timeout'); resolve(order.join(',')); }, 0);
+			queueMicrotask(function() { order.push('microtask'); });
+			order.push('sync');
+		})
+	`
+
+	result, err := RunWithTimeoutAndRecover(vm, script, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RunWithTimeout failed: %v", err)
+	}
+	if got := result.String(); got != "sync,microtask,timeout" {
+		t.Fatalf("order = %q", got)
+	}
+}
+
+func TestEventLoop_RejectedPromiseReturnsError(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	_, err := RunWithTimeoutAndRecover(vm, `(async function() { throw new Error('boom'); })()`, 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected rejection error, got %v", err)
+	}
+}
+
+func TestEventLoop_PendingPromiseTimesOut(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	start := time.Now()
+	_, err := RunWithTimeoutAndRecover(vm, `new Promise(function() { setInterval(function() {}, 10); })`, 100*time.Millisecond)
+	if !IsTimeoutError(err) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("timeout took too long: %v", time.Since(start))
+	}
+
+	// The VM stays usable after the timeout.
+	result, err := RunWithTimeoutAndRecover(vm, `1 + 1`, time.Second)
+	if err != nil || result.ToInteger() != 2 {
+		t.Fatalf("VM unusable after timeout: %v, %v", result, err)
+	}
+}
+
+func TestEventLoop_UnsettledPromiseWithoutWorkFailsFast(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	_, err := RunWithTimeoutAndRecover(vm, `new Promise(function() {})`, 5*time.Second)
+	if err == nil || IsTimeoutError(err) {
+		t.Fatalf("expected never-settled error, got %v", err)
+	}
+}
+
+func TestEventLoop_FetchReturnsPromise(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	script := `
+		(async function() {
+			var pending = fetch('https://blocked.example.com/data');
+			var syncFields = String(pending.ok) + ':' + String(pending.status);
+			var asyncResult;
+			try {
+				await pending;
+				asyncResult = 'resolved';
+			} catch (e) {
+				asyncResult = e instanceof TypeError && e.message.indexOf('not in allowed list') >= 0 ? 'rejected' : 'wrong error';
+			}
+			return syncFields + ',' + asyncResult + ',' + (pending instanceof Promise);
+		})()
+	`
+
+	result, err := RunWithTimeoutAndRecover(vm, script, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RunWithTimeout failed: %v", err)
+	}
+	if got := result.String(); got != "undefined:undefined,rejected,true" {
+		t.Fatalf("result = %q", got)
+	}
+}
+
+func TestEventLoop_CloseStopsTimers(t *testing.T) {
+	runtime, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	if _, err := vm.RunString(`var fired = false; setTimeout(function() { fired = true; }, 10);`); err != nil {
+		t.Fatalf("RunString failed: %v", err)
+	}
+	runtime.eventLoop.close()
+	time.Sleep(30 * time.Millisecond)
+	runtime.eventLoop.runPending()
+
+	if vm.Get("fired").ToBoolean() {
+		t.Fatal("timer fired after the loop was closed")
+	}
+	if getExtensionEventLoop(vm) != nil {
+		t.Fatal("closed loop is still registered")
+	}
+}
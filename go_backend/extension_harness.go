@@ -0,0 +1,335 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExtensionHarness runs a single extension outside the app, for extension
+// development and CI. Calls go through extensionProviderWrapper, so they
+// behave the same as in the app.
+type ExtensionHarness struct {
+	manager  *extensionManager
+	ext      *loadedExtension
+	provider *extensionProviderWrapper
+	cassette bool
+}
+
+// ExtensionHarnessOptions configures NewExtensionHarness.
+type ExtensionHarnessOptions struct {
+	// WorkDir holds the extension's data and settings, and the installed copy
+	// when a package is loaded. It should be a scratch directory, never the
+	// app's own data directory.
+	WorkDir  string
+	Settings map[string]interface{}
+	// CassettePath replays HTTP requests from a network cassette instead of
+	// the network. With RecordCassette, real traffic is recorded there
+	// instead, redacted the same way as the app's cassettes.
+	CassettePath   string
+	RecordCassette bool
+}
+
+// HarnessCallResult is the outcome of one harness call.
+type HarnessCallResult struct {
+	Function   string      `json:"function"`
+	Args       []string    `json:"args"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+type harnessFunction struct {
+	usage   string
+	minArgs int
+	call    func(p *extensionProviderWrapper, args []string) (interface{}, error)
+}
+
+var harnessFunctions = map[string]harnessFunction{
+	"searchTracks": {
+		usage:   "<query> [limit]",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			limit, err := harnessIntArg(args, 1, 20)
+			if err != nil {
+				return nil, err
+			}
+			return p.SearchTracks(args[0], limit)
+		},
+	},
+	"getTrack": {
+		usage:   "<trackId>",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.GetTrack(args[0])
+		},
+	},
+	"getAlbum": {
+		usage:   "<albumId>",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.GetAlbum(args[0])
+		},
+	},
+	"getArtist": {
+		usage:   "<artistId>",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.GetArtist(args[0])
+		},
+	},
+	"checkAvailability": {
+		usage:   "<isrc> <trackName> <artistName> [spotifyId] [deezerId]",
+		minArgs: 3,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.CheckAvailability(args[0], args[1], args[2], harnessArg(args, 3), harnessArg(args, 4))
+		},
+	},
+	"getDownloadUrl": {
+		usage:   "<trackId> [quality]",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.GetDownloadURL(args[0], harnessArg(args, 1))
+		},
+	},
+	"download": {
+		usage:   "<trackId> <quality> <outputPath>",
+		minArgs: 3,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.Download(args[0], args[1], args[2], "", nil)
+		},
+	},
+	"fetchLyrics": {
+		usage:   "<trackName> <artistName> [albumName] [durationSec]",
+		minArgs: 2,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			duration := 0.0
+			if raw := harnessArg(args, 3); raw != "" {
+				parsed, err := strconv.ParseFloat(raw, 64)
+				if err != nil {
+					return nil, fmt.Errorf("durationSec must be a number")
+				}
+				duration = parsed
+			}
+			return p.FetchLyrics(args[0], args[1], harnessArg(args, 2), duration)
+		},
+	},
+	"handleUrl": {
+		usage:   "<url>",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			return p.HandleURL(args[0])
+		},
+	},
+	"customSearch": {
+		usage:   "<query> [optionsJSON]",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			options, err := harnessJSONArg(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return p.CustomSearch(args[0], options)
+		},
+	},
+	"postProcess": {
+		usage:   "<filePath> [hookId] [metadataJSON]",
+		minArgs: 1,
+		call: func(p *extensionProviderWrapper, args []string) (interface{}, error) {
+			metadata, err := harnessJSONArg(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			input := PostProcessInput{Path: args[0], Name: filepath.Base(args[0])}
+			if info, err := os.Stat(args[0]); err == nil {
+				input.Size = info.Size()
+			}
+			return p.PostProcessV2(input, metadata, harnessArg(args, 1))
+		},
+	},
+}
+
+func harnessArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+func harnessIntArg(args []string, i, fallback int) (int, error) {
+	raw := harnessArg(args, i)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("argument %d must be an integer", i+1)
+	}
+	return value, nil
+}
+
+func harnessJSONArg(args []string, i int) (map[string]interface{}, error) {
+	raw := harnessArg(args, i)
+	if raw == "" {
+		return map[string]interface{}{}, nil
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, fmt.Errorf("argument %d must be a JSON object: %w", i+1, err)
+	}
+	return value, nil
+}
+
+// HarnessFunctionUsage lists the functions a harness can call with their
+// arguments, e.g. "getTrack <trackId>".
+func HarnessFunctionUsage() []string {
+	usage := make([]string, 0, len(harnessFunctions))
+	for name, fn := range harnessFunctions {
+		usage = append(usage, name+" "+fn.usage)
+	}
+	sort.Strings(usage)
+	return usage
+}
+
+// NewExtensionHarness loads the extension at path, enables it and applies
+// opts.Settings. A package is installed into opts.WorkDir; a directory is
+// loaded in place.
+func NewExtensionHarness(path string, opts ExtensionHarnessOptions) (*ExtensionHarness, error) {
+	if opts.WorkDir == "" {
+		return nil, fmt.Errorf("work directory is required")
+	}
+
+	h := &ExtensionHarness{
+		manager: &extensionManager{extensions: make(map[string]*loadedExtension)},
+	}
+	if err := h.manager.SetDirectories(filepath.Join(opts.WorkDir, "extensions"), filepath.Join(opts.WorkDir, "data")); err != nil {
+		return nil, err
+	}
+	if err := GetExtensionSettingsStore().SetDataDir(filepath.Join(opts.WorkDir, "settings")); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.CassettePath != "" {
+		mode := NetworkCassetteReplay
+		if opts.RecordCassette {
+			mode = NetworkCassetteRecord
+		}
+		if err := startNetworkCassette(mode, opts.CassettePath); err != nil {
+			return nil, err
+		}
+		h.cassette = true
+	}
+
+	var ext *loadedExtension
+	if info.IsDir() {
+		ext, err = h.manager.loadExtensionFromDirectory(path)
+	} else {
+		ext, err = h.manager.LoadExtensionFromFile(path)
+	}
+	if err != nil {
+		h.discardCassette()
+		return nil, err
+	}
+	if ext.Error != "" {
+		h.discardCassette()
+		return nil, fmt.Errorf("extension failed to load: %s", ext.Error)
+	}
+
+	if len(opts.Settings) > 0 {
+		if err := GetExtensionSettingsStore().SetAll(ext.ID, opts.Settings); err != nil {
+			h.discardCassette()
+			return nil, err
+		}
+	}
+	if err := h.manager.SetExtensionEnabled(ext.ID, true); err != nil {
+		h.discardCassette()
+		return nil, err
+	}
+
+	h.ext = ext
+	h.provider = newExtensionProviderWrapper(ext)
+	return h, nil
+}
+
+// ExtensionID returns the loaded extension's id.
+func (h *ExtensionHarness) ExtensionID() string {
+	return h.ext.ID
+}
+
+// Call invokes function with string arguments and reports the result and
+// how long it took.
+func (h *ExtensionHarness) Call(function string, args []string) HarnessCallResult {
+	result := HarnessCallResult{Function: function, Args: args}
+	if result.Args == nil {
+		result.Args = []string{}
+	}
+
+	fn, ok := harnessFunctions[function]
+	if !ok {
+		result.Error = fmt.Sprintf("unknown function: %s", function)
+		return result
+	}
+	if len(args) < fn.minArgs {
+		result.Error = fmt.Sprintf("usage: %s %s", function, fn.usage)
+		return result
+	}
+
+	start := time.Now()
+	value, err := fn.call(h.provider, args)
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if value != nil {
+		result.Result = value
+		if errMsg := harnessResultError(value); errMsg != "" && result.Error == "" {
+			result.Error = errMsg
+		}
+	}
+	return result
+}
+
+// harnessResultError surfaces failures that the wrapper reports inside the
+// result instead of as an error.
+func harnessResultError(value interface{}) string {
+	switch v := value.(type) {
+	case *ExtDownloadResult:
+		if v != nil && !v.Success {
+			return strings.TrimSpace(v.ErrorMessage)
+		}
+	case *PostProcessResult:
+		if v != nil && !v.Success {
+			return strings.TrimSpace(v.Error)
+		}
+	}
+	return ""
+}
+
+// Close unloads the extension and ends the cassette, writing it when it was
+// recording.
+func (h *ExtensionHarness) Close() error {
+	h.manager.UnloadAllExtensions()
+	if !h.cassette {
+		return nil
+	}
+	h.cassette = false
+	return stopNetworkCassette()
+}
+
+// discardCassette ends the cassette without writing a recording, when the
+// extension fails to load.
+func (h *ExtensionHarness) discardCassette() {
+	if h.cassette {
+		discardNetworkCassette()
+		h.cassette = false
+	}
+}
@@ -0,0 +1,143 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const harnessTestScript = `
+var apiKey = '';
+registerExtension({
+	initialize: function(settings) { apiKey = settings.api_key || ''; },
+	searchTracks: function(query, limit) {
+		var res = http.get('https://api.fixture.test/search?q=' + encodeURIComponent(query) + '&key=' + apiKey);
+		if (res.error) { throw new Error(res.error); }
+		var data = JSON.parse(res.body);
+		return { tracks: data.items.slice(0, limit), total: data.items.length };
+	},
+	handleUrl: function(url) {
+		return { type: 'track', track: { id: url.split('/').pop(), name: 'From URL', artists: 'A', album_name: 'B' } };
+	}
+});
+`
+
+func writeHarnessTestExtension(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	manifest := `{
+		"name": "harness-ext",
+		"version": "1.0.0",
+		"description": "d",
+		"type": ["metadata_provider"],
+		"permissions": {"network": ["api.fixture.test"]},
+		"urlHandler": {"enabled": true, "patterns": ["fixture.test"]}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "index.js"), []byte(harnessTestScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func restoreSettingsStoreDir(t *testing.T) {
+	t.Helper()
+	store := GetExtensionSettingsStore()
+	store.mu.RLock()
+	previousDir := store.dataDir
+	store.mu.RUnlock()
+	t.Cleanup(func() {
+		store.mu.Lock()
+		store.dataDir = previousDir
+		store.mu.Unlock()
+	})
+}
+
+func TestExtensionHarness_ReplaysCassette(t *testing.T) {
+	restoreSettingsStoreDir(t)
+	extDir := writeHarnessTestExtension(t)
+
+	// The key query parameter is redacted in cassettes; requests are
+	// redacted the same way before they are matched.
+	cassette := NetworkCassette{Version: networkCassetteVersion, Entries: []NetworkCassetteEntry{{
+		Request: NetworkCassetteRequest{Method: "GET", URL: "https://api.fixture.test/search?key=REDACTED&q=hello"},
+		Response: &NetworkCassetteResponse{
+			Status: 200,
+			Body:   `{"items":[{"id":"1","name":"Hello","artists":"Adele","album_name":"25"},{"id":"2","name":"Hello Again","artists":"X","album_name":"Y"}]}`,
+		},
+	}}}
+	cassettePath := filepath.Join(t.TempDir(), "cassette.json")
+	data, _ := json.Marshal(cassette)
+	os.WriteFile(cassettePath, data, 0644)
+
+	harness, err := NewExtensionHarness(extDir, ExtensionHarnessOptions{
+		WorkDir:      t.TempDir(),
+		Settings:     map[string]interface{}{"api_key": "secret"},
+		CassettePath: cassettePath,
+	})
+	if err != nil {
+		t.Fatalf("NewExtensionHarness: %v", err)
+	}
+	defer harness.Close()
+
+	result := harness.Call("searchTracks", []string{"hello", "1"})
+	if result.Error != "" {
+		t.Fatalf("searchTracks error: %s", result.Error)
+	}
+	search, ok := result.Result.(*ExtSearchResult)
+	if !ok || len(search.Tracks) != 1 || search.Tracks[0].Name != "Hello" || search.Total != 2 {
+		t.Fatalf("search result = %#v", result.Result)
+	}
+	if search.Tracks[0].ProviderID != "harness-ext" {
+		t.Fatalf("provider id = %q, want the wrapper to set it", search.Tracks[0].ProviderID)
+	}
+
+	missing := harness.Call("searchTracks", []string{"unrecorded"})
+	if !strings.Contains(missing.Error, "no cassette entry") {
+		t.Fatalf("unrecorded request error = %q", missing.Error)
+	}
+
+	handled := harness.Call("handleUrl", []string{"https://fixture.test/track/42"})
+	if urlResult, ok := handled.Result.(*ExtURLHandleResult); handled.Error != "" || !ok || urlResult.Track == nil || urlResult.Track.ID != "42" {
+		t.Fatalf("handleUrl = %+v", handled)
+	}
+
+	if usage := harness.Call("getTrack", nil); !strings.HasPrefix(usage.Error, "usage: getTrack") {
+		t.Fatalf("missing args error = %q", usage.Error)
+	}
+	if unknown := harness.Call("nope", nil); unknown.Error != "unknown function: nope" {
+		t.Fatalf("unknown function error = %q", unknown.Error)
+	}
+
+	if err := harness.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if networkCassetteActive() {
+		t.Fatal("Close left the cassette active")
+	}
+}
+
+func TestExtensionHarness_LoadFailureDiscardsRecording(t *testing.T) {
+	restoreSettingsStoreDir(t)
+	t.Cleanup(func() { stopNetworkCassette() })
+	cassettePath := filepath.Join(t.TempDir(), "cassette.json")
+
+	_, err := NewExtensionHarness(t.TempDir(), ExtensionHarnessOptions{
+		WorkDir:        t.TempDir(),
+		CassettePath:   cassettePath,
+		RecordCassette: true,
+	})
+	if err == nil {
+		t.Fatal("expected an empty directory to fail to load")
+	}
+	if networkCassetteActive() {
+		t.Fatal("failed load left the cassette recording")
+	}
+	if _, err := os.Stat(cassettePath); !os.IsNotExist(err) {
+		t.Fatalf("failed load wrote a cassette: %v", err)
+	}
+}
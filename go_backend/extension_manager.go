@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -50,11 +51,13 @@ type loadedExtension struct {
 	VMMu        sync.Mutex         `json:"-"`
 	runtime     *extensionRuntime
 	initialized bool
-	Enabled     bool   `json:"enabled"`
-	Error       string `json:"error,omitempty"`
-	DataDir     string `json:"data_dir"`
-	SourceDir   string `json:"source_dir"`
-	IconPath    string `json:"icon_path"`
+	Enabled     bool                    `json:"enabled"`
+	Error       string                  `json:"error,omitempty"`
+	DataDir     string                  `json:"data_dir"`
+	SourceDir   string                  `json:"source_dir"`
+	IconPath    string                  `json:"icon_path"`
+	Signature   *ExtensionSignatureInfo `json:"signature,omitempty"`
+	quota       *extensionQuota
 }
 
 func getExtensionInitSettings(extensionID string) map[string]interface{} {
@@ -83,6 +86,12 @@ func ensureRuntimeReadyLocked(ext *loadedExtension, applyStoredSettings bool) er
 	}
 
 	if applyStoredSettings && !ext.initialized {
+		if err := migrateExtensionSettingsLocked(ext); err != nil {
+			teardownVMLocked(ext)
+			ext.Error = fmt.Sprintf("settings migration failed: %v", err)
+			ext.Enabled = false
+			return fmt.Errorf("settings migration failed: %w", err)
+		}
 		settings := getExtensionInitSettings(ext.ID)
 		if len(settings) > 0 {
 			if err := initializeExtensionWithSettingsLocked(ext, settings); err != nil {
@@ -219,6 +228,11 @@ func (m *extensionManager) LoadExtensionFromFile(filePath string) (*loadedExtens
 		}
 	}
 
+	signature, err := m.enforcePackageSignature(manifest.Name, zipPackageEntries(&zipReader.Reader), filePath)
+	if err != nil {
+		return nil, err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -231,7 +245,45 @@ func (m *extensionManager) LoadExtensionFromFile(filePath string) (*loadedExtens
 		return nil, fmt.Errorf("failed to create extension directory: %w", err)
 	}
 
-	for _, file := range zipReader.File {
+	if err := extractExtensionPackage(&zipReader.Reader, extDir); err != nil {
+		return nil, err
+	}
+
+	extDataDir := filepath.Join(m.dataDir, manifest.Name)
+	if err := os.MkdirAll(extDataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create extension data directory: %w", err)
+	}
+
+	ext := &loadedExtension{
+		ID:        manifest.Name,
+		Manifest:  manifest,
+		Enabled:   false, // New extensions start disabled
+		DataDir:   extDataDir,
+		SourceDir: extDir,
+		Signature: signature,
+		quota:     newExtensionQuota(manifest.Name),
+	}
+	if err := saveRegistrySignature(extDir, signature); err != nil {
+		GoLog("[Extension] Warning: failed to save registry signature: %v\n", err)
+	}
+	m.pinPublisherKey(manifest.Name, signature)
+
+	if err := validateExtensionLoad(ext); err != nil {
+		ext.Error = err.Error()
+		ext.Enabled = false
+		GoLog("[Extension] Failed to validate extension %s: %v\n", manifest.Name, err)
+	}
+
+	m.extensions[manifest.Name] = ext
+	GoLog("[Extension] Loaded extension: %s v%s\n", manifest.DisplayName, manifest.Version)
+
+	return ext, nil
+}
+
+// extractExtensionPackage writes the files of a package into extDir,
+// skipping entries that would land outside it.
+func extractExtensionPackage(reader *zip.Reader, extDir string) error {
+	for _, file := range reader.File {
 		if file.FileInfo().IsDir() {
 			continue
 		}
@@ -245,54 +297,40 @@ func (m *extensionManager) LoadExtensionFromFile(filePath string) (*loadedExtens
 
 		destDir := filepath.Dir(destPath)
 		if err := os.MkdirAll(destDir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create directory %s: %w", destDir, err)
+			return fmt.Errorf("failed to create directory %s: %w", destDir, err)
 		}
 
 		destFile, err := os.Create(destPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create file %s: %w", destPath, err)
+			return fmt.Errorf("failed to create file %s: %w", destPath, err)
 		}
 
 		srcFile, err := file.Open()
 		if err != nil {
 			destFile.Close()
-			return nil, fmt.Errorf("failed to open file in archive: %w", err)
+			return fmt.Errorf("failed to open file in archive: %w", err)
 		}
 
 		_, err = io.Copy(destFile, srcFile)
 		srcFile.Close()
 		destFile.Close()
 		if err != nil {
-			return nil, fmt.Errorf("failed to extract file: %w", err)
+			return fmt.Errorf("failed to extract file: %w", err)
 		}
 	}
+	return nil
+}
 
-	extDataDir := filepath.Join(m.dataDir, manifest.Name)
-	if err := os.MkdirAll(extDataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create extension data directory: %w", err)
+func initializeVMLocked(ext *loadedExtension) error {
+	if ext.runtime != nil && ext.runtime.eventLoop != nil {
+		ext.runtime.eventLoop.close()
 	}
-
-	ext := &loadedExtension{
-		ID:        manifest.Name,
-		Manifest:  manifest,
-		Enabled:   false, // New extensions start disabled
-		DataDir:   extDataDir,
-		SourceDir: extDir,
+	if ext.runtime != nil {
+		ext.runtime.closeHostEvents()
 	}
-
-	if err := validateExtensionLoad(ext); err != nil {
-		ext.Error = err.Error()
-		ext.Enabled = false
-		GoLog("[Extension] Failed to validate extension %s: %v\n", manifest.Name, err)
+	if ext.VM != nil {
+		unregisterExtensionQuota(ext.VM)
 	}
-
-	m.extensions[manifest.Name] = ext
-	GoLog("[Extension] Loaded extension: %s v%s\n", manifest.DisplayName, manifest.Version)
-
-	return ext, nil
-}
-
-func initializeVMLocked(ext *loadedExtension) error {
 	ext.VM = nil
 	ext.runtime = nil
 	ext.initialized = false
@@ -330,7 +368,11 @@ func initializeVMLocked(ext *loadedExtension) error {
 		return goja.Undefined()
 	})
 
-	_, err = vm.RunString(string(jsCode))
+	if runtime.modules != nil {
+		_, err = runtime.modules.runScript(indexPath, jsCode)
+	} else {
+		_, err = vm.RunString(string(jsCode))
+	}
 	if err != nil {
 		return fmt.Errorf("failed to execute extension code: %w", err)
 	}
@@ -455,6 +497,15 @@ func teardownVMLocked(ext *loadedExtension) {
 			GoLog("[Extension] Failed to flush storage for %s: %v\n", ext.ID, err)
 		}
 		ext.runtime.closeStorageFlusher()
+		if ext.runtime.eventLoop != nil {
+			ext.runtime.eventLoop.close()
+		}
+		ext.runtime.closeHTTPStreams()
+		ext.runtime.closeWebSockets()
+		ext.runtime.closeHostEvents()
+	}
+	if ext.VM != nil {
+		unregisterExtensionQuota(ext.VM)
 	}
 	ext.runtime = nil
 	ext.VM = nil
@@ -523,6 +574,9 @@ func (m *extensionManager) SetExtensionEnabled(extensionID string, enabled bool)
 	}
 
 	if enabled {
+		if errs := requiredSettingErrors(ext.Manifest, GetExtensionSettingsStore().GetAll(extensionID)); len(errs) > 0 {
+			return &ExtensionSettingsError{Errors: errs}
+		}
 		ext.Enabled = true
 		if err := ext.ensureRuntimeReady(); err != nil {
 			store := GetExtensionSettingsStore()
@@ -595,7 +649,7 @@ func (m *extensionManager) loadExtensionFromDirectory(dirPath string) (*loadedEx
 		return nil, fmt.Errorf("failed to read manifest.json: %w", err)
 	}
 
-	manifest, err := ParseManifest(manifestData)
+	manifest, err := parseInstalledManifest(manifestData)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid extension manifest: %w", err)
 	}
@@ -621,6 +675,11 @@ func (m *extensionManager) loadExtensionFromDirectory(dirPath string) (*loadedEx
 		Enabled:   false, // Will be restored from settings store
 		DataDir:   extDataDir,
 		SourceDir: dirPath,
+		quota:     newExtensionQuota(manifest.Name),
+	}
+	if err := m.checkInstalledSignature(ext); err != nil {
+		ext.Error = err.Error()
+		GoLog("[Extension] %s: %v\n", manifest.Name, err)
 	}
 
 	store := GetExtensionSettingsStore()
@@ -631,7 +690,9 @@ func (m *extensionManager) loadExtensionFromDirectory(dirPath string) (*loadedEx
 		}
 	}
 
-	if err := validateExtensionLoad(ext); err != nil {
+	if ext.Error != "" {
+		ext.Enabled = false
+	} else if err := validateExtensionLoad(ext); err != nil {
 		ext.Error = err.Error()
 		ext.Enabled = false
 		GoLog("[Extension] Failed to validate extension %s: %v\n", manifest.Name, err)
@@ -659,11 +720,32 @@ func (m *extensionManager) RemoveExtension(extensionID string) error {
 		}
 	}
 
+	if versionsDir := m.extensionVersionsDir(extensionID); versionsDir != "" {
+		if err := os.RemoveAll(versionsDir); err != nil {
+			GoLog("[Extension] Warning: failed to remove version history: %v\n", err)
+		}
+	}
+
+	clearExtensionAuditLog(extensionID)
+	if err := m.forgetPublisherKey(extensionID); err != nil {
+		GoLog("[Extension] Warning: failed to forget publisher key: %v\n", err)
+	}
+	if err := GetExtensionSettingsStore().Remove(extensionID, pinnedVersionSettingKey); err != nil {
+		GoLog("[Extension] Warning: failed to remove version pin: %v\n", err)
+	}
+
 	return nil
 }
 
 // Only allows upgrades (new version > current version), not downgrades
 func (m *extensionManager) UpgradeExtension(filePath string) (*loadedExtension, error) {
+	return m.replaceExtension(filePath, false)
+}
+
+// replaceExtension installs a package over the installed version of the same
+// extension. The installed package is archived first so RollbackExtension
+// can bring it back. Downgrades are only allowed for rollbacks.
+func (m *extensionManager) replaceExtension(filePath string, allowDowngrade bool) (*loadedExtension, error) {
 	if !strings.HasSuffix(strings.ToLower(filePath), ".spotiflac-ext") {
 		return nil, fmt.Errorf("Invalid file format. Please select a .spotiflac-ext file")
 	}
@@ -716,12 +798,23 @@ func (m *extensionManager) UpgradeExtension(filePath string) (*loadedExtension,
 	}
 
 	versionCompare := compareVersions(newManifest.Version, existing.Manifest.Version)
-	if versionCompare < 0 {
+	if versionCompare < 0 && !allowDowngrade {
 		return nil, fmt.Errorf("Cannot downgrade extension. Current version: %s, New version: %s", existing.Manifest.Version, newManifest.Version)
 	}
 	if versionCompare == 0 {
 		return nil, fmt.Errorf("Extension is already at version %s", existing.Manifest.Version)
 	}
+	if pinned := getPinnedExtensionVersion(newManifest.Name); pinned != "" && !allowDowngrade {
+		return nil, fmt.Errorf("Extension '%s' is pinned to v%s. Unpin it to upgrade", existing.Manifest.DisplayName, pinned)
+	}
+	if err := checkExtensionDataVersion(newManifest); err != nil {
+		return nil, err
+	}
+
+	signature, err := m.enforcePackageSignature(newManifest.Name, zipPackageEntries(&zipReader.Reader), filePath)
+	if err != nil {
+		return nil, err
+	}
 
 	GoLog("[Extension] Upgrading %s from v%s to v%s\n", newManifest.DisplayName, existing.Manifest.Version, newManifest.Version)
 
@@ -732,6 +825,9 @@ func (m *extensionManager) UpgradeExtension(filePath string) (*loadedExtension,
 	m.UnloadExtension(existing.ID)
 
 	if extDir != "" {
+		if err := m.archiveExtensionVersion(existing.ID, extDir); err != nil {
+			GoLog("[Extension] Warning: failed to archive v%s of %s: %v\n", existing.Manifest.Version, existing.ID, err)
+		}
 		if err := os.RemoveAll(extDir); err != nil {
 			GoLog("[Extension] Warning: failed to remove old source dir: %v\n", err)
 		}
@@ -741,40 +837,8 @@ func (m *extensionManager) UpgradeExtension(filePath string) (*loadedExtension,
 		return nil, fmt.Errorf("failed to create extension directory: %w", err)
 	}
 
-	for _, file := range zipReader.File {
-		if file.FileInfo().IsDir() {
-			continue
-		}
-
-		relPath := filepath.Clean(file.Name)
-		if strings.HasPrefix(relPath, "..") || filepath.IsAbs(relPath) {
-			GoLog("[Extension] Skipping unsafe path in archive: %s\n", file.Name)
-			continue
-		}
-		destPath := filepath.Join(extDir, relPath)
-
-		destDir := filepath.Dir(destPath)
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create directory %s: %w", destDir, err)
-		}
-
-		destFile, err := os.Create(destPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create file %s: %w", destPath, err)
-		}
-
-		srcFile, err := file.Open()
-		if err != nil {
-			destFile.Close()
-			return nil, fmt.Errorf("failed to open file in archive: %w", err)
-		}
-
-		_, err = io.Copy(destFile, srcFile)
-		srcFile.Close()
-		destFile.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to extract file: %w", err)
-		}
+	if err := extractExtensionPackage(&zipReader.Reader, extDir); err != nil {
+		return nil, err
 	}
 
 	ext := &loadedExtension{
@@ -783,7 +847,13 @@ func (m *extensionManager) UpgradeExtension(filePath string) (*loadedExtension,
 		Enabled:   wasEnabled, // Preserve enabled state from before upgrade
 		DataDir:   extDataDir,
 		SourceDir: extDir,
+		Signature: signature,
+		quota:     existing.quota, // Upgrading must not reset the usage window
 	}
+	if err := saveRegistrySignature(extDir, signature); err != nil {
+		GoLog("[Extension] Warning: failed to save registry signature: %v\n", err)
+	}
+	m.pinPublisherKey(newManifest.Name, signature)
 
 	if wasEnabled {
 		if err := ext.ensureRuntimeReady(); err != nil {
@@ -805,11 +875,19 @@ func (m *extensionManager) UpgradeExtension(filePath string) (*loadedExtension,
 }
 
 type ExtensionUpgradeInfo struct {
-	ExtensionID    string `json:"extension_id"`
-	CurrentVersion string `json:"current_version"`
-	NewVersion     string `json:"new_version"`
-	CanUpgrade     bool   `json:"can_upgrade"`
-	IsInstalled    bool   `json:"is_installed"`
+	ExtensionID       string          `json:"extension_id"`
+	CurrentVersion    string          `json:"current_version"`
+	NewVersion        string          `json:"new_version"`
+	CanUpgrade        bool            `json:"can_upgrade"`
+	IsInstalled       bool            `json:"is_installed"`
+	PermissionChanges *PermissionDiff `json:"permission_changes"`
+	// NewPermissions is true when the package asks for anything the installed
+	// version could not do, so the app should confirm before upgrading.
+	NewPermissions bool `json:"new_permissions"`
+	// Signature is the verification result under the current policy.
+	// SignatureError is set instead when installing would be refused.
+	Signature      *ExtensionSignatureInfo `json:"signature,omitempty"`
+	SignatureError string                  `json:"signature_error,omitempty"`
 }
 
 func (m *extensionManager) checkExtensionUpgradeInternal(filePath string) (*ExtensionUpgradeInfo, error) {
@@ -860,12 +938,24 @@ func (m *extensionManager) checkExtensionUpgradeInternal(filePath string) (*Exte
 		IsInstalled: exists,
 	}
 
+	var currentPermissions *ExtensionPermissions
 	if !exists {
 		info.CurrentVersion = ""
 		info.CanUpgrade = false
 	} else {
 		info.CurrentVersion = existing.Manifest.Version
 		info.CanUpgrade = compareVersions(newManifest.Version, existing.Manifest.Version) > 0
+		currentPermissions = &existing.Manifest.Permissions
+	}
+	info.PermissionChanges = diffExtensionPermissions(currentPermissions, &newManifest.Permissions)
+	info.NewPermissions = len(info.PermissionChanges.Added) > 0
+
+	signature, err := m.enforcePackageSignature(newManifest.Name, zipPackageEntries(&zipReader.Reader), filePath)
+	if err != nil {
+		info.SignatureError = err.Error()
+		info.CanUpgrade = false
+	} else {
+		info.Signature = signature
 	}
 
 	return info, nil
@@ -889,40 +979,36 @@ func (m *extensionManager) GetInstalledExtensionsJSON() (string, error) {
 	extensions := m.GetAllExtensions()
 
 	type ExtensionInfo struct {
-		ID                     string                 `json:"id"`
-		Name                   string                 `json:"name"`
-		DisplayName            string                 `json:"display_name"`
-		Version                string                 `json:"version"`
-		Description            string                 `json:"description"`
-		Homepage               string                 `json:"homepage,omitempty"`
-		IconPath               string                 `json:"icon_path,omitempty"`
-		Types                  []ExtensionType        `json:"types"`
-		Enabled                bool                   `json:"enabled"`
-		Status                 string                 `json:"status"`
-		Error                  string                 `json:"error_message,omitempty"`
-		Settings               []ExtensionSetting     `json:"settings,omitempty"`
-		QualityOptions         []QualityOption        `json:"quality_options,omitempty"`
-		Permissions            []string               `json:"permissions"`
-		HasMetadataProvider    bool                   `json:"has_metadata_provider"`
-		HasDownloadProvider    bool                   `json:"has_download_provider"`
-		HasLyricsProvider      bool                   `json:"has_lyrics_provider"`
-		SkipMetadataEnrichment bool                   `json:"skip_metadata_enrichment"`
-		SkipLyrics             bool                   `json:"skip_lyrics"`
-		SearchBehavior         *SearchBehaviorConfig  `json:"search_behavior,omitempty"`
-		TrackMatching          *TrackMatchingConfig   `json:"track_matching,omitempty"`
-		PostProcessing         *PostProcessingConfig  `json:"post_processing,omitempty"`
-		Capabilities           map[string]interface{} `json:"capabilities,omitempty"`
+		ID                     string                  `json:"id"`
+		Name                   string                  `json:"name"`
+		DisplayName            string                  `json:"display_name"`
+		Version                string                  `json:"version"`
+		Description            string                  `json:"description"`
+		Homepage               string                  `json:"homepage,omitempty"`
+		IconPath               string                  `json:"icon_path,omitempty"`
+		Types                  []ExtensionType         `json:"types"`
+		Enabled                bool                    `json:"enabled"`
+		Status                 string                  `json:"status"`
+		Error                  string                  `json:"error_message,omitempty"`
+		Settings               []ExtensionSetting      `json:"settings,omitempty"`
+		QualityOptions         []QualityOption         `json:"quality_options,omitempty"`
+		Permissions            []string                `json:"permissions"`
+		HasMetadataProvider    bool                    `json:"has_metadata_provider"`
+		HasDownloadProvider    bool                    `json:"has_download_provider"`
+		HasLyricsProvider      bool                    `json:"has_lyrics_provider"`
+		SkipMetadataEnrichment bool                    `json:"skip_metadata_enrichment"`
+		SkipLyrics             bool                    `json:"skip_lyrics"`
+		SearchBehavior         *SearchBehaviorConfig   `json:"search_behavior,omitempty"`
+		TrackMatching          *TrackMatchingConfig    `json:"track_matching,omitempty"`
+		PostProcessing         *PostProcessingConfig   `json:"post_processing,omitempty"`
+		Capabilities           map[string]interface{}  `json:"capabilities,omitempty"`
+		Quota                  *ExtensionQuotaStats    `json:"quota,omitempty"`
+		Signature              *ExtensionSignatureInfo `json:"signature,omitempty"`
 	}
 
 	infos := make([]ExtensionInfo, len(extensions))
 	for i, ext := range extensions {
-		permissions := []string{}
-		for _, domain := range ext.Manifest.Permissions.Network {
-			permissions = append(permissions, "network:"+domain)
-		}
-		if ext.Manifest.Permissions.Storage {
-			permissions = append(permissions, "storage:enabled")
-		}
+		permissions := ext.Manifest.Permissions.Scopes()
 
 		status := "loaded"
 		if ext.Error != "" {
@@ -969,6 +1055,11 @@ func (m *extensionManager) GetInstalledExtensionsJSON() (string, error) {
 			TrackMatching:          ext.Manifest.TrackMatching,
 			PostProcessing:         ext.Manifest.PostProcessing,
 			Capabilities:           ext.Manifest.Capabilities,
+			Signature:              ext.Signature,
+		}
+		if ext.quota != nil {
+			stats := ext.quota.stats()
+			infos[i].Quota = &stats
 		}
 	}
 
@@ -980,6 +1071,26 @@ func (m *extensionManager) GetInstalledExtensionsJSON() (string, error) {
 	return string(jsonBytes), nil
 }
 
+// SetExtensionQuotaLimits replaces the quota limits of an installed
+// extension. Usage recorded so far is kept.
+func (m *extensionManager) SetExtensionQuotaLimits(extensionID string, limits ExtensionQuotaLimits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ext, exists := m.extensions[extensionID]
+	if !exists {
+		return fmt.Errorf("extension '%s' not found", extensionID)
+	}
+	if ext.quota == nil {
+		return fmt.Errorf("extension '%s' has no quota tracker", extensionID)
+	}
+	if limits.CPUTimeMs > 0 && !threadCPUTimeSupported {
+		return fmt.Errorf("cpu time quota is not supported on %s", runtime.GOOS)
+	}
+	ext.quota.setLimits(limits)
+	return nil
+}
+
 func (m *extensionManager) InitializeExtension(extensionID string, settings map[string]interface{}) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -989,6 +1100,10 @@ func (m *extensionManager) InitializeExtension(extensionID string, settings map[
 		return fmt.Errorf("Extension not found")
 	}
 
+	if errs := requiredSettingErrors(ext.Manifest, settings); len(errs) > 0 {
+		return &ExtensionSettingsError{Errors: errs}
+	}
+
 	ext.VMMu.Lock()
 	defer ext.VMMu.Unlock()
 
@@ -3,6 +3,7 @@ package gobackend
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -25,9 +26,16 @@ const (
 )
 
 type ExtensionPermissions struct {
-	Network []string `json:"network"`
-	Storage bool     `json:"storage"`
-	File    bool     `json:"file"`
+	Network     []string `json:"network"`
+	Storage     bool     `json:"storage"`
+	File        bool     `json:"file"`
+	FileAccess  string   `json:"fileAccess,omitempty"`  // "read" or "readwrite" (default)
+	Directories []string `json:"directories,omitempty"` // sandbox subdirectories; empty means the whole sandbox
+	FFmpeg      *bool    `json:"ffmpeg,omitempty"`
+	Credentials *bool    `json:"credentials,omitempty"`
+	Auth        *bool    `json:"auth,omitempty"`
+	Clipboard   bool     `json:"clipboard,omitempty"`
+	Events      bool     `json:"events,omitempty"` // host events such as finished downloads
 }
 
 type ExtensionSetting struct {
@@ -40,6 +48,9 @@ type ExtensionSetting struct {
 	Default     interface{} `json:"default,omitempty"`
 	Options     []string    `json:"options,omitempty"`
 	Action      string      `json:"action,omitempty"`
+	Min         *float64    `json:"min,omitempty"`     // number settings only
+	Max         *float64    `json:"max,omitempty"`     // number settings only
+	Pattern     string      `json:"pattern,omitempty"` // regular expression the whole string value must match
 }
 
 type QualityOption struct {
@@ -106,6 +117,7 @@ type ExtensionManifest struct {
 	DisplayName            string                 `json:"displayName"`
 	Version                string                 `json:"version"`
 	Description            string                 `json:"description"`
+	Author                 string                 `json:"author,omitempty"`
 	Homepage               string                 `json:"homepage,omitempty"`
 	Icon                   string                 `json:"icon,omitempty"`
 	Types                  []ExtensionType        `json:"type"`
@@ -113,6 +125,7 @@ type ExtensionManifest struct {
 	Settings               []ExtensionSetting     `json:"settings,omitempty"`
 	QualityOptions         []QualityOption        `json:"qualityOptions,omitempty"`
 	MinAppVersion          string                 `json:"minAppVersion,omitempty"`
+	DataVersion            int                    `json:"dataVersion,omitempty"` // layout version of storage and settings; raise it on incompatible changes
 	SkipMetadataEnrichment bool                   `json:"skipMetadataEnrichment,omitempty"`
 	SkipLyrics             bool                   `json:"skipLyrics,omitempty"`
 	SkipBuiltInFallback    bool                   `json:"skipBuiltInFallback,omitempty"`
@@ -124,18 +137,48 @@ type ExtensionManifest struct {
 }
 
 type ManifestValidationError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (e *ManifestValidationError) Error() string {
 	return fmt.Sprintf("manifest validation error: %s - %s", e.Field, e.Message)
 }
 
+// ParseManifest parses and validates manifest.json strictly: unknown fields,
+// wrong types and invalid enum values are rejected, with the offending field
+// path in the returned *ManifestValidationError.
 func ParseManifest(data []byte) (*ExtensionManifest, error) {
+	if errs := validateManifestSchema(data); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return decodeManifest(data)
+}
+
+// parseInstalledManifest parses the manifest of an extension that is already
+// installed. Schema problems and the consistency checks added after it was
+// installed are only logged, so extensions that loaded with an older app
+// version keep loading.
+func parseInstalledManifest(data []byte) (*ExtensionManifest, error) {
+	var manifest ExtensionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest JSON: %s", describeJSONError(data, err))
+	}
+	if errs := manifest.requiredFieldErrors(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	warnings := append(validateManifestSchema(data), manifest.consistencyErrors()...)
+	for _, warning := range warnings {
+		GoLog("[Extension] %s: manifest warning: %s - %s\n", manifest.Name, warning.Field, warning.Message)
+	}
+	return &manifest, nil
+}
+
+func decodeManifest(data []byte) (*ExtensionManifest, error) {
 	var manifest ExtensionManifest
 	if err := json.Unmarshal(data, &manifest); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest JSON: %w", err)
+		return nil, fmt.Errorf("failed to parse manifest JSON: %s", describeJSONError(data, err))
 	}
 
 	if err := manifest.Validate(); err != nil {
@@ -145,64 +188,154 @@ func ParseManifest(data []byte) (*ExtensionManifest, error) {
 	return &manifest, nil
 }
 
+// Validate returns the first problem found by validationErrors.
 func (m *ExtensionManifest) Validate() error {
+	if errs := m.validationErrors(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// validationErrors lists every problem that blocks installing the manifest.
+func (m *ExtensionManifest) validationErrors() []*ManifestValidationError {
+	return append(m.requiredFieldErrors(), m.consistencyErrors()...)
+}
+
+// requiredFieldErrors covers the checks every installed manifest has already
+// passed, so they are still enforced when an installed extension loads.
+func (m *ExtensionManifest) requiredFieldErrors() []*ManifestValidationError {
+	var errs []*ManifestValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ManifestValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
 	if strings.TrimSpace(m.Name) == "" {
-		return &ManifestValidationError{Field: "name", Message: "name is required"}
+		add("name", "name is required")
 	}
 
 	if strings.TrimSpace(m.Version) == "" {
-		return &ManifestValidationError{Field: "version", Message: "version is required"}
+		add("version", "version is required")
 	}
 
 	if strings.TrimSpace(m.Description) == "" {
-		return &ManifestValidationError{Field: "description", Message: "description is required"}
+		add("description", "description is required")
 	}
 
 	if len(m.Types) == 0 {
-		return &ManifestValidationError{Field: "type", Message: "at least one type is required"}
+		add("type", "at least one type is required")
 	}
 
 	for _, t := range m.Types {
 		if t != ExtensionTypeMetadataProvider && t != ExtensionTypeDownloadProvider && t != ExtensionTypeLyricsProvider {
-			return &ManifestValidationError{
-				Field:   "type",
-				Message: fmt.Sprintf("invalid extension type: %s (must be 'metadata_provider', 'download_provider', or 'lyrics_provider')", t),
-			}
+			add("type", "invalid extension type: %s (must be 'metadata_provider', 'download_provider', or 'lyrics_provider')", t)
+		}
+	}
+
+	if err := m.Permissions.validate(); err != nil {
+		if validationErr, ok := err.(*ManifestValidationError); ok {
+			errs = append(errs, validationErr)
+		} else {
+			add("permissions", "%v", err)
 		}
 	}
 
 	for i, setting := range m.Settings {
 		if strings.TrimSpace(setting.Key) == "" {
-			return &ManifestValidationError{
-				Field:   fmt.Sprintf("settings[%d].key", i),
-				Message: "setting key is required",
-			}
+			add(fmt.Sprintf("settings[%d].key", i), "setting key is required")
 		}
 
 		if setting.Type == "" {
-			return &ManifestValidationError{
-				Field:   fmt.Sprintf("settings[%d].type", i),
-				Message: "setting type is required",
-			}
+			add(fmt.Sprintf("settings[%d].type", i), "setting type is required")
 		}
 
 		// Select type requires options
 		if setting.Type == SettingTypeSelect && len(setting.Options) == 0 {
-			return &ManifestValidationError{
-				Field:   fmt.Sprintf("settings[%d].options", i),
-				Message: "select type requires options",
-			}
+			add(fmt.Sprintf("settings[%d].options", i), "select type requires options")
 		}
 
 		if setting.Type == SettingTypeButton && setting.Action == "" {
-			return &ManifestValidationError{
-				Field:   fmt.Sprintf("settings[%d].action", i),
-				Message: "button type requires action (JS function name)",
+			add(fmt.Sprintf("settings[%d].action", i), "button type requires action (JS function name)")
+		}
+
+		if (setting.Min != nil || setting.Max != nil) && setting.Type != SettingTypeNumber {
+			add(fmt.Sprintf("settings[%d].min", i), "min and max only apply to number settings")
+		} else if setting.Min != nil && setting.Max != nil && *setting.Min > *setting.Max {
+			add(fmt.Sprintf("settings[%d].max", i), "max must not be less than min")
+		}
+		if setting.Pattern != "" {
+			if setting.Type != SettingTypeString {
+				add(fmt.Sprintf("settings[%d].pattern", i), "pattern only applies to string settings")
+			} else if _, err := regexp.Compile(setting.Pattern); err != nil {
+				add(fmt.Sprintf("settings[%d].pattern", i), "invalid pattern: %v", err)
 			}
 		}
 	}
 
-	return nil
+	return errs
+}
+
+// consistencyErrors covers checks added after extensions were already being
+// installed; they only block new installs and upgrades.
+func (m *ExtensionManifest) consistencyErrors() []*ManifestValidationError {
+	var errs []*ManifestValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ManifestValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if name := strings.TrimSpace(m.Name); strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		add("name", "name must not contain path separators or '..'")
+	}
+
+	settingKeys := make(map[string]int)
+	for i, setting := range m.Settings {
+		if strings.TrimSpace(setting.Key) == "" {
+			continue
+		}
+		if first, dup := settingKeys[setting.Key]; dup {
+			add(fmt.Sprintf("settings[%d].key", i), "duplicate setting key %q (also settings[%d])", setting.Key, first)
+		} else {
+			settingKeys[setting.Key] = i
+		}
+	}
+
+	qualityIDs := make(map[string]bool)
+	for i, option := range m.QualityOptions {
+		field := fmt.Sprintf("qualityOptions[%d]", i)
+		if strings.TrimSpace(option.ID) == "" {
+			add(field+".id", "quality option id is required")
+		} else if qualityIDs[option.ID] {
+			add(field+".id", "duplicate quality option id %q", option.ID)
+		}
+		qualityIDs[option.ID] = true
+		if strings.TrimSpace(option.Label) == "" {
+			add(field+".label", "quality option label is required")
+		}
+		for j, setting := range option.Settings {
+			settingField := fmt.Sprintf("%s.settings[%d]", field, j)
+			if strings.TrimSpace(setting.Key) == "" {
+				add(settingField+".key", "setting key is required")
+			}
+			if setting.Type == "" {
+				add(settingField+".type", "setting type is required")
+			}
+			if setting.Type == SettingTypeSelect && len(setting.Options) == 0 {
+				add(settingField+".options", "select type requires options")
+			}
+		}
+	}
+
+	hookIDs := make(map[string]bool)
+	for i, hook := range m.GetPostProcessingHooks() {
+		field := fmt.Sprintf("postProcessing.hooks[%d].id", i)
+		if strings.TrimSpace(hook.ID) == "" {
+			add(field, "hook id is required")
+		} else if hookIDs[hook.ID] {
+			add(field, "duplicate hook id %q", hook.ID)
+		}
+		hookIDs[hook.ID] = true
+	}
+
+	return errs
 }
 
 func (m *ExtensionManifest) HasType(t ExtensionType) bool {
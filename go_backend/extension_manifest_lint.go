@@ -0,0 +1,243 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ManifestLintWarning is a manifest problem that does not stop the extension
+// from loading but is most likely a mistake.
+type ManifestLintWarning struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var manifestVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+([-+][0-9A-Za-z.-]+)?$`)
+
+// urlPatternRegexChars hints that a urlHandler pattern was written as a
+// regular expression. Patterns are matched as plain substrings.
+const urlPatternRegexChars = `^$*()[]{}|\+?`
+
+// lint reports likely mistakes that Validate accepts.
+func (m *ExtensionManifest) lint() []ManifestLintWarning {
+	var warnings []ManifestLintWarning
+	warn := func(field, format string, args ...interface{}) {
+		warnings = append(warnings, ManifestLintWarning{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(m.MinAppVersion) == "" {
+		warn("minAppVersion", "minAppVersion is not set; older app versions will install the extension even if they lack APIs it uses")
+	}
+	if strings.TrimSpace(m.DisplayName) == "" {
+		warn("displayName", "displayName is not set; the app will show the internal name")
+	}
+	if strings.TrimSpace(m.Author) == "" {
+		warn("author", "author is not set")
+	}
+	if m.Version != "" && !manifestVersionPattern.MatchString(m.Version) {
+		warn("version", "version %q is not in x.y.z form; update checks may not order it correctly", m.Version)
+	}
+
+	for i, entry := range m.Permissions.Network {
+		field := fmt.Sprintf("permissions.network[%d]", i)
+		host := strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case host == "*":
+			warn(field, "a bare '*' never matches; list the hosts the extension needs")
+		case strings.Contains(host, "://") || strings.Contains(host, "/"):
+			warn(field, "%q is a URL, not a host name, and never matches", entry)
+		case strings.HasPrefix(host, "*.") && strings.Count(host, ".") < 2:
+			warn(field, "%q allows every domain under a top-level domain; use a narrower wildcard", entry)
+		}
+	}
+
+	for i, setting := range m.Settings {
+		if setting.Type != SettingTypeSelect || setting.Default == nil {
+			continue
+		}
+		if def, ok := setting.Default.(string); !ok || !containsString(setting.Options, def) {
+			warn(fmt.Sprintf("settings[%d].default", i), "default %v is not one of the options", setting.Default)
+		}
+	}
+
+	if m.URLHandler != nil {
+		if m.URLHandler.Enabled && len(m.URLHandler.Patterns) == 0 {
+			warn("urlHandler.patterns", "urlHandler is enabled but has no patterns, so it never runs")
+		}
+		for i, pattern := range m.URLHandler.Patterns {
+			field := fmt.Sprintf("urlHandler.patterns[%d]", i)
+			trimmed := strings.ToLower(strings.TrimSpace(pattern))
+			switch {
+			case trimmed == "":
+				warn(field, "an empty pattern matches every URL")
+			case strings.ContainsAny(trimmed, urlPatternRegexChars):
+				warn(field, "%q looks like a regular expression; patterns are matched as plain substrings", pattern)
+			case trimmed == "http://" || trimmed == "https://" || trimmed == "www." || len(trimmed) < 4:
+				warn(field, "%q matches almost every URL", pattern)
+			}
+		}
+	}
+
+	return warnings
+}
+
+// lintPackageFiles checks the manifest against the files shipped with it.
+// sources maps each .js file to its content and files holds every file name.
+func (m *ExtensionManifest) lintPackageFiles(files map[string]bool, sources map[string]string) []ManifestLintWarning {
+	var warnings []ManifestLintWarning
+	var allSources strings.Builder
+	for _, src := range sources {
+		allSources.WriteString(src)
+		allSources.WriteByte('\n')
+	}
+	code := allSources.String()
+
+	for i, setting := range m.Settings {
+		if setting.Key == "" {
+			continue
+		}
+		if setting.Type == SettingTypeButton {
+			if setting.Action != "" && !strings.Contains(code, setting.Action) {
+				warnings = append(warnings, ManifestLintWarning{
+					Field:   fmt.Sprintf("settings[%d].action", i),
+					Message: fmt.Sprintf("action %q is not defined in the extension's scripts", setting.Action),
+				})
+			}
+			continue
+		}
+		if !strings.Contains(code, setting.Key) {
+			warnings = append(warnings, ManifestLintWarning{
+				Field:   fmt.Sprintf("settings[%d].key", i),
+				Message: fmt.Sprintf("setting %q is never read by the extension's scripts", setting.Key),
+			})
+		}
+	}
+
+	icon := strings.TrimSpace(m.Icon)
+	if icon != "" && !strings.HasPrefix(icon, "http://") && !strings.HasPrefix(icon, "https://") &&
+		!files[path.Clean(filepath.ToSlash(icon))] {
+		warnings = append(warnings, ManifestLintWarning{
+			Field:   "icon",
+			Message: fmt.Sprintf("icon file %q is not in the package", m.Icon),
+		})
+	}
+
+	return warnings
+}
+
+// ExtensionPackageReport is the result of validateExtensionPackage.
+type ExtensionPackageReport struct {
+	Valid    bool                       `json:"valid"`
+	Name     string                     `json:"name,omitempty"`
+	Version  string                     `json:"version,omitempty"`
+	Errors   []*ManifestValidationError `json:"errors"`
+	Warnings []ManifestLintWarning      `json:"warnings"`
+}
+
+// validateExtensionPackage checks a .spotiflac-ext package or an unpacked
+// extension directory the same way install does, and lints it. Every problem
+// is collected instead of stopping at the first one.
+func validateExtensionPackage(packagePath string) (*ExtensionPackageReport, error) {
+	info, err := os.Stat(packagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []packageEntry
+	if info.IsDir() {
+		entries, err = dirPackageEntries(packagePath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		reader, err := zip.OpenReader(packagePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open extension package: %w", err)
+		}
+		defer reader.Close()
+		entries = zipPackageEntries(&reader.Reader)
+	}
+
+	report := &ExtensionPackageReport{
+		Errors:   []*ManifestValidationError{},
+		Warnings: []ManifestLintWarning{},
+	}
+	files := make(map[string]bool)
+	sources := make(map[string]string)
+	var manifestData []byte
+	hasIndexJS := false
+
+	for _, entry := range entries {
+		name := path.Clean(filepath.ToSlash(entry.name))
+		files[name] = true
+		base := path.Base(name)
+		if base != "manifest.json" && !strings.HasSuffix(base, ".js") {
+			continue
+		}
+		rc, err := entry.open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if base == "manifest.json" {
+			manifestData = data
+		} else {
+			sources[name] = string(data)
+			hasIndexJS = hasIndexJS || base == "index.js"
+		}
+	}
+
+	if !hasIndexJS {
+		report.Errors = append(report.Errors, &ManifestValidationError{Field: "index.js", Message: "index.js not found"})
+	}
+	if manifestData == nil {
+		report.Errors = append(report.Errors, &ManifestValidationError{Field: "manifest.json", Message: "manifest.json not found"})
+		return report, nil
+	}
+
+	report.Errors = append(report.Errors, validateManifestSchema(manifestData)...)
+	var manifest ExtensionManifest
+	if err := json.Unmarshal(manifestData, &manifest); err == nil {
+		report.Name = manifest.Name
+		report.Version = manifest.Version
+		report.Errors = appendMissingValidationErrors(report.Errors, manifest.validationErrors())
+		report.Warnings = append(report.Warnings, manifest.lint()...)
+		report.Warnings = append(report.Warnings, manifest.lintPackageFiles(files, sources)...)
+	}
+
+	report.Valid = len(report.Errors) == 0
+	return report, nil
+}
+
+// appendMissingValidationErrors adds errors for fields, and their children,
+// that the schema check did not already report.
+func appendMissingValidationErrors(errs, more []*ManifestValidationError) []*ManifestValidationError {
+	reported := make([]string, 0, len(errs))
+	for _, err := range errs {
+		reported = append(reported, err.Field)
+	}
+	for _, err := range more {
+		covered := false
+		for _, field := range reported {
+			if field == err.Field || strings.HasPrefix(field, err.Field+"[") || strings.HasPrefix(field, err.Field+".") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
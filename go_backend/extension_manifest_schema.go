@@ -0,0 +1,307 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const manifestSchemaID = "https://zarzet.github.io/SpotiFLAC-Mobile/extension-manifest.schema.json"
+
+// manifestRequiredFields lists required properties per struct, by JSON name.
+var manifestRequiredFields = map[reflect.Type][]string{
+	reflect.TypeOf(ExtensionManifest{}):      {"name", "version", "description", "type"},
+	reflect.TypeOf(ExtensionSetting{}):       {"key", "type"},
+	reflect.TypeOf(QualityOption{}):          {"id", "label"},
+	reflect.TypeOf(QualitySpecificSetting{}): {"key", "type"},
+	reflect.TypeOf(PostProcessingHook{}):     {"id", "name"},
+	reflect.TypeOf(SearchFilter{}):           {"id"},
+}
+
+// manifestTypeEnums restricts string types to their known values.
+var manifestTypeEnums = map[reflect.Type][]string{
+	reflect.TypeOf(ExtensionType("")): {
+		string(ExtensionTypeMetadataProvider),
+		string(ExtensionTypeDownloadProvider),
+		string(ExtensionTypeLyricsProvider),
+	},
+	reflect.TypeOf(SettingType("")): {
+		string(SettingTypeString),
+		string(SettingTypeNumber),
+		string(SettingTypeBool),
+		string(SettingTypeSelect),
+		string(SettingTypeButton),
+	},
+}
+
+// manifestFieldEnums restricts individual string fields, keyed by
+// "<Go struct>.<json name>".
+var manifestFieldEnums = map[string][]string{
+	"ExtensionPermissions.fileAccess":     {FileAccessRead, FileAccessReadWrite},
+	"SearchBehaviorConfig.thumbnailRatio": {"square", "wide", "portrait"},
+	"TrackMatchingConfig.strategy":        {"isrc", "name", "duration", "custom"},
+}
+
+// ExtensionManifestSchema returns the JSON Schema for manifest.json,
+// generated from ExtensionManifest and the tables above.
+func ExtensionManifestSchema() map[string]interface{} {
+	schema := manifestSchemaForType(reflect.TypeOf(ExtensionManifest{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = manifestSchemaID
+	schema["title"] = "SpotiFLAC extension manifest"
+
+	props := schema["properties"].(map[string]interface{})
+	props["$schema"] = map[string]interface{}{"type": "string"}
+	props["type"].(map[string]interface{})["minItems"] = 1
+	return schema
+}
+
+// ExtensionManifestSchemaJSON is the schema as published in
+// site/extension-manifest.schema.json.
+func ExtensionManifestSchemaJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(ExtensionManifestSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func manifestSchemaForType(t reflect.Type) map[string]interface{} {
+	if enum, ok := manifestTypeEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": enum}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return manifestSchemaForType(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": manifestSchemaForType(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": true}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			prop := manifestSchemaForType(field.Type)
+			if enum, ok := manifestFieldEnums[t.Name()+"."+name]; ok {
+				prop["enum"] = enum
+			}
+			props[name] = prop
+		}
+		schema := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if required, ok := manifestRequiredFields[t]; ok {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// validateManifestSchema checks raw manifest JSON against the schema and
+// returns every problem found, with field paths such as "settings[2].type".
+func validateManifestSchema(data []byte) []*ManifestValidationError {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return []*ManifestValidationError{{Field: "manifest.json", Message: describeJSONError(data, err)}}
+	}
+
+	var errs []*ManifestValidationError
+	validateSchemaValue(raw, ExtensionManifestSchema(), "", &errs)
+	return errs
+}
+
+func validateSchemaValue(value interface{}, schema map[string]interface{}, path string, errs *[]*ManifestValidationError) {
+	fail := func(format string, args ...interface{}) {
+		field := path
+		if field == "" {
+			field = "(root)"
+		}
+		*errs = append(*errs, &ManifestValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	wantType, _ := schema["type"].(string)
+	if wantType != "" && !schemaTypeMatches(value, wantType) {
+		fail("expected %s, got %s", wantType, schemaTypeName(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]string); ok {
+		s, _ := value.(string)
+		if !containsString(enum, s) {
+			fail("invalid value %q (must be one of: %s)", s, strings.Join(enum, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]string); ok {
+			for _, key := range required {
+				if _, present := v[key]; !present {
+					*errs = append(*errs, &ManifestValidationError{
+						Field:   joinSchemaPath(path, key),
+						Message: key + " is required",
+					})
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propSchema, known := props[key].(map[string]interface{})
+			if !known {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					msg := "unknown field"
+					if suggestion := closestSchemaKey(key, props); suggestion != "" {
+						msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+					}
+					*errs = append(*errs, &ManifestValidationError{Field: joinSchemaPath(path, key), Message: msg})
+				}
+				continue
+			}
+			validateSchemaValue(v[key], propSchema, joinSchemaPath(path, key), errs)
+		}
+	case []interface{}:
+		if minItems, ok := schema["minItems"].(int); ok && len(v) < minItems {
+			fail("must contain at least %d item(s)", minItems)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateSchemaValue(item, items, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+func schemaTypeMatches(value interface{}, want string) bool {
+	switch want {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	}
+	return true
+}
+
+func schemaTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestSchemaKey suggests a known key for a likely typo: same letters in a
+// different case, or at most two edits away.
+func closestSchemaKey(key string, props map[string]interface{}) string {
+	best := ""
+	bestDistance := 3
+	for candidate := range props {
+		if strings.EqualFold(candidate, key) {
+			return candidate
+		}
+		if d := levenshteinDistance(strings.ToLower(key), strings.ToLower(candidate)); d < bestDistance ||
+			(d == bestDistance && best != "" && candidate < best) {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+// describeJSONError adds a line and column to JSON syntax errors.
+func describeJSONError(data []byte, err error) string {
+	var offset int64 = -1
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+	if offset < 0 {
+		return "invalid JSON: " + err.Error()
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	col := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, col, err)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
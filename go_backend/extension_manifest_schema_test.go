@@ -0,0 +1,209 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestSchema_PublishedFileIsCurrent(t *testing.T) {
+	generated, err := ExtensionManifestSchemaJSON()
+	if err != nil {
+		t.Fatalf("generate schema: %v", err)
+	}
+	published, err := os.ReadFile(filepath.Join("..", "site", "extension-manifest.schema.json"))
+	if err != nil {
+		t.Fatalf("read published schema: %v", err)
+	}
+	if !bytes.Equal(generated, published) {
+		t.Fatal("site/extension-manifest.schema.json is stale; regenerate it with: go run ./cmd/spotiflac-ext -schema > ../site/extension-manifest.schema.json")
+	}
+}
+
+func TestParseManifest_StrictSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		field    string
+		message  string
+	}{
+		{
+			name:     "unknown field",
+			manifest: `{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"permisions":{}}`,
+			field:    "permisions",
+			message:  `did you mean "permissions"?`,
+		},
+		{
+			name:     "nested setting type",
+			manifest: `{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"settings":[{"key":"a","type":"string"},{"key":"b","type":"text"}]}`,
+			field:    "settings[1].type",
+			message:  `invalid value "text"`,
+		},
+		{
+			name:     "wrong type",
+			manifest: `{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"searchBehavior":{"enabled":true,"thumbnailWidth":"100"}}`,
+			field:    "searchBehavior.thumbnailWidth",
+			message:  "expected integer, got string",
+		},
+		{
+			name:     "enum field",
+			manifest: `{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"searchBehavior":{"enabled":true,"thumbnailRatio":"round"}}`,
+			field:    "searchBehavior.thumbnailRatio",
+			message:  "must be one of: square, wide, portrait",
+		},
+		{
+			name:     "syntax error",
+			manifest: "{\n  \"name\": \"x\",\n  \"version\": 1.0.0\n}",
+			field:    "manifest.json",
+			message:  "line 3",
+		},
+		{
+			name:     "path in name",
+			manifest: `{"name":"../x","version":"1.0.0","description":"d","type":["metadata_provider"]}`,
+			field:    "name",
+			message:  "path separators",
+		},
+		{
+			name:     "duplicate setting key",
+			manifest: `{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"settings":[{"key":"a","type":"string"},{"key":"a","type":"number"}]}`,
+			field:    "settings[1].key",
+			message:  "duplicate setting key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseManifest([]byte(tt.manifest))
+			validationErr, ok := err.(*ManifestValidationError)
+			if !ok {
+				t.Fatalf("err = %v (%T), want *ManifestValidationError", err, err)
+			}
+			if validationErr.Field != tt.field || !strings.Contains(validationErr.Message, tt.message) {
+				t.Fatalf("got %s - %s, want %s containing %q", validationErr.Field, validationErr.Message, tt.field, tt.message)
+			}
+		})
+	}
+}
+
+func TestLoadExtensionFromDirectory_ToleratesUnknownManifestFields(t *testing.T) {
+	useTempSettingsStore(t)
+	dir := t.TempDir()
+	manifest := `{"name":"legacy-ext","version":"1.0.0","description":"d","type":["metadata_provider"],"legacyField":true}`
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644)
+	os.WriteFile(filepath.Join(dir, "index.js"), []byte(`registerExtension({});`), 0644)
+
+	root := t.TempDir()
+	manager := &extensionManager{
+		extensions:    make(map[string]*loadedExtension),
+		extensionsDir: filepath.Join(root, "extensions"),
+		dataDir:       filepath.Join(root, "data"),
+	}
+	ext, err := manager.loadExtensionFromDirectory(dir)
+	if err != nil {
+		t.Fatalf("installed manifest with an unknown field should still load: %v", err)
+	}
+	if ext.ID != "legacy-ext" {
+		t.Fatalf("id = %q", ext.ID)
+	}
+}
+
+func TestParseInstalledManifest_OnlyWarnsOnNewConsistencyChecks(t *testing.T) {
+	manifest := `{
+		"name": "legacy/ext",
+		"version": "1.0.0",
+		"description": "d",
+		"type": ["download_provider"],
+		"settings": [
+			{"key": "quality", "type": "string"},
+			{"key": "quality", "type": "string"}
+		],
+		"qualityOptions": [{"id": "", "label": ""}],
+		"postProcessing": {"enabled": true, "hooks": [{"id": "tag", "name": "a"}, {"id": "tag", "name": "b"}]}
+	}`
+
+	if _, err := ParseManifest([]byte(manifest)); err == nil {
+		t.Fatal("install should reject duplicate keys, empty quality options and unsafe names")
+	}
+	parsed, err := parseInstalledManifest([]byte(manifest))
+	if err != nil {
+		t.Fatalf("installed manifest should still load: %v", err)
+	}
+	if len(parsed.Settings) != 2 {
+		t.Fatalf("settings = %+v", parsed.Settings)
+	}
+
+	if _, err := parseInstalledManifest([]byte(`{"name":"x","version":"1.0.0","type":["metadata_provider"]}`)); err == nil {
+		t.Fatal("installed manifest without a description should still fail")
+	}
+}
+
+func TestValidateExtensionPackageJSON_ReportsErrorsAndWarnings(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{
+		"name": "lint-ext",
+		"version": "1.0",
+		"description": "d",
+		"type": ["metadata_provider", "video_provider"],
+		"icon": "icon.png",
+		"permissions": {"network": ["*.com", "https://api.example.com"]},
+		"settings": [
+			{"key": "apiKey", "type": "string"},
+			{"key": "unused", "type": "string"},
+			{"key": "login", "type": "button", "action": "startLogin"}
+		],
+		"urlHandler": {"enabled": true, "patterns": ["example\\.com/track/.*"]},
+		"qualityOptions": [{"id": "HI", "label": ""}]
+	}`
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644)
+	os.WriteFile(filepath.Join(dir, "index.js"), []byte(`registerExtension({ initialize: function(s) { return s.apiKey; } });`), 0644)
+
+	out, err := ValidateExtensionPackageJSON(dir)
+	if err != nil {
+		t.Fatalf("ValidateExtensionPackageJSON: %v", err)
+	}
+	var report ExtensionPackageReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if report.Valid || report.Name != "lint-ext" || report.Version != "1.0" {
+		t.Fatalf("report = %s", out)
+	}
+
+	errorFields := make(map[string]bool)
+	for _, e := range report.Errors {
+		errorFields[e.Field] = true
+	}
+	for _, field := range []string{"type[1]", "qualityOptions[0].label"} {
+		if !errorFields[field] {
+			t.Errorf("missing error for %s in %s", field, out)
+		}
+	}
+	if errorFields["type"] {
+		t.Errorf("type error reported twice: %s", out)
+	}
+
+	warningFields := make(map[string]bool)
+	for _, w := range report.Warnings {
+		warningFields[w.Field] = true
+	}
+	for _, field := range []string{
+		"minAppVersion",
+		"version",
+		"permissions.network[0]",
+		"permissions.network[1]",
+		"settings[1].key",
+		"settings[2].action",
+		"urlHandler.patterns[0]",
+		"icon",
+	} {
+		if !warningFields[field] {
+			t.Errorf("missing warning for %s in %s", field, out)
+		}
+	}
+	if warningFields["settings[0].key"] {
+		t.Errorf("apiKey is read by index.js and should not be flagged: %s", out)
+	}
+}
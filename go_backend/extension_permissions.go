@@ -0,0 +1,376 @@
+package gobackend
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permission scopes as reported to the app and recorded in the audit log.
+const (
+	PermissionNetwork     = "network"
+	PermissionStorage     = "storage"
+	PermissionFileRead    = "file:read"
+	PermissionFileWrite   = "file:write"
+	PermissionFFmpeg      = "ffmpeg"
+	PermissionCredentials = "credentials"
+	PermissionAuth        = "auth"
+	PermissionClipboard   = "clipboard"
+	PermissionEvents      = "events"
+
+	FileAccessRead      = "read"
+	FileAccessReadWrite = "readwrite"
+)
+
+// promptPermissions are scopes that also need the user's approval at runtime
+// before the first use, on top of being declared in the manifest.
+var promptPermissions = map[string]bool{
+	PermissionClipboard: true,
+}
+
+const grantedPermissionsSettingKey = "_permission_grants"
+
+// CanReadFiles reports whether the file API may read.
+func (p *ExtensionPermissions) CanReadFiles() bool {
+	return p.File
+}
+
+// CanWriteFiles reports whether the file API may write. "file": true without
+// fileAccess keeps the original read/write behavior.
+func (p *ExtensionPermissions) CanWriteFiles() bool {
+	return p.File && p.FileAccess != FileAccessRead
+}
+
+// AllowsFFmpeg, AllowsCredentials and AllowsAuth default to true when the
+// manifest does not mention them, so extensions written before these scopes
+// existed keep working. Declaring false drops the API.
+func (p *ExtensionPermissions) AllowsFFmpeg() bool {
+	return p.FFmpeg == nil || *p.FFmpeg
+}
+
+func (p *ExtensionPermissions) AllowsCredentials() bool {
+	return p.Credentials == nil || *p.Credentials
+}
+
+func (p *ExtensionPermissions) AllowsAuth() bool {
+	return p.Auth == nil || *p.Auth
+}
+
+// Allows reports whether scope is declared. Network is checked per domain by
+// IsDomainAllowed instead.
+func (p *ExtensionPermissions) Allows(scope string) bool {
+	switch scope {
+	case PermissionStorage:
+		return true
+	case PermissionFileRead:
+		return p.CanReadFiles()
+	case PermissionFileWrite:
+		return p.CanWriteFiles()
+	case PermissionFFmpeg:
+		return p.AllowsFFmpeg()
+	case PermissionCredentials:
+		return p.AllowsCredentials()
+	case PermissionAuth:
+		return p.AllowsAuth()
+	case PermissionClipboard:
+		return p.Clipboard
+	case PermissionEvents:
+		return p.Events
+	}
+	return false
+}
+
+// Scopes returns the effective permissions as display strings, e.g.
+// "network:api.example.com", "file:read" or "file:dir:cache".
+func (p *ExtensionPermissions) Scopes() []string {
+	scopes := []string{}
+	for _, domain := range p.Network {
+		scopes = append(scopes, PermissionNetwork+":"+domain)
+	}
+	if p.Storage {
+		scopes = append(scopes, PermissionStorage+":enabled")
+	}
+	if p.CanReadFiles() {
+		scopes = append(scopes, PermissionFileRead)
+	}
+	if p.CanWriteFiles() {
+		scopes = append(scopes, PermissionFileWrite)
+	}
+	if p.File {
+		for _, dir := range p.Directories {
+			scopes = append(scopes, "file:dir:"+filepath.ToSlash(filepath.Clean(dir)))
+		}
+	}
+	if p.AllowsFFmpeg() {
+		scopes = append(scopes, PermissionFFmpeg)
+	}
+	if p.AllowsCredentials() {
+		scopes = append(scopes, PermissionCredentials)
+	}
+	if p.AllowsAuth() {
+		scopes = append(scopes, PermissionAuth)
+	}
+	if p.Clipboard {
+		scopes = append(scopes, PermissionClipboard)
+	}
+	if p.Events {
+		scopes = append(scopes, PermissionEvents)
+	}
+	return scopes
+}
+
+func (p *ExtensionPermissions) validate() error {
+	switch p.FileAccess {
+	case "", FileAccessRead, FileAccessReadWrite:
+	default:
+		return &ManifestValidationError{
+			Field:   "permissions.fileAccess",
+			Message: fmt.Sprintf("invalid file access: %s (must be 'read' or 'readwrite')", p.FileAccess),
+		}
+	}
+
+	for i, dir := range p.Directories {
+		clean := filepath.Clean(filepath.FromSlash(strings.TrimSpace(dir)))
+		if clean == "." || filepath.IsAbs(clean) || strings.HasPrefix(dir, "/") || clean == ".." ||
+			strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return &ManifestValidationError{
+				Field:   fmt.Sprintf("permissions.directories[%d]", i),
+				Message: "directory must be a relative path inside the extension sandbox",
+			}
+		}
+	}
+	return nil
+}
+
+// PermissionDiff lists scopes requested by a new manifest that the installed
+// one did not have, and scopes it no longer asks for.
+type PermissionDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+func diffExtensionPermissions(oldPerms, newPerms *ExtensionPermissions) *PermissionDiff {
+	oldSet := make(map[string]bool)
+	if oldPerms != nil {
+		for _, scope := range oldPerms.Scopes() {
+			oldSet[scope] = true
+		}
+	}
+	newSet := make(map[string]bool)
+	for _, scope := range newPerms.Scopes() {
+		newSet[scope] = true
+	}
+
+	diff := &PermissionDiff{Added: []string{}, Removed: []string{}}
+	for scope := range newSet {
+		if !oldSet[scope] {
+			diff.Added = append(diff.Added, scope)
+		}
+	}
+	for scope := range oldSet {
+		if !newSet[scope] {
+			diff.Removed = append(diff.Removed, scope)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// ExtensionAuditEntry records one privileged call made by an extension.
+type ExtensionAuditEntry struct {
+	Time    time.Time `json:"time"`
+	API     string    `json:"api"`
+	Scope   string    `json:"scope"`
+	Target  string    `json:"target,omitempty"`
+	Allowed bool      `json:"allowed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+const maxExtensionAuditEntries = 500
+
+var (
+	extensionAuditLogs   = make(map[string][]ExtensionAuditEntry)
+	extensionAuditLogsMu sync.RWMutex
+)
+
+func recordExtensionAudit(extensionID string, entry ExtensionAuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	extensionAuditLogsMu.Lock()
+	defer extensionAuditLogsMu.Unlock()
+	entries := append(extensionAuditLogs[extensionID], entry)
+	if len(entries) > maxExtensionAuditEntries {
+		entries = append([]ExtensionAuditEntry(nil), entries[len(entries)-maxExtensionAuditEntries:]...)
+	}
+	extensionAuditLogs[extensionID] = entries
+}
+
+func getExtensionAuditLog(extensionID string) []ExtensionAuditEntry {
+	extensionAuditLogsMu.RLock()
+	defer extensionAuditLogsMu.RUnlock()
+	return append([]ExtensionAuditEntry{}, extensionAuditLogs[extensionID]...)
+}
+
+func clearExtensionAuditLog(extensionID string) {
+	extensionAuditLogsMu.Lock()
+	defer extensionAuditLogsMu.Unlock()
+	delete(extensionAuditLogs, extensionID)
+}
+
+// audit records a privileged call. err is the reason the call was refused, or
+// nil when it was allowed.
+func (r *extensionRuntime) audit(api, scope, target string, err error) {
+	entry := ExtensionAuditEntry{
+		API:     api,
+		Scope:   scope,
+		Target:  target,
+		Allowed: err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	recordExtensionAudit(r.extensionID, entry)
+}
+
+// requirePermission checks scope for api, asks the user for prompt scopes
+// that have not been decided yet, and records the outcome.
+func (r *extensionRuntime) requirePermission(api, scope, target string) error {
+	err := r.checkPermission(api, scope)
+	r.audit(api, scope, target, err)
+	return err
+}
+
+func (r *extensionRuntime) checkPermission(api, scope string) error {
+	if !r.manifest.Permissions.Allows(scope) {
+		return fmt.Errorf("permission denied: extension does not have '%s' permission", scope)
+	}
+	if !promptPermissions[scope] {
+		return nil
+	}
+
+	granted, decided := getPermissionGrant(r.extensionID, scope)
+	if granted {
+		return nil
+	}
+	if decided {
+		return fmt.Errorf("permission denied: '%s' was declined by the user", scope)
+	}
+	queuePermissionRequest(r.extensionID, scope, api)
+	return fmt.Errorf("permission pending: '%s' is waiting for user approval", scope)
+}
+
+// PendingPermissionRequest asks the app to prompt the user for a scope.
+type PendingPermissionRequest struct {
+	ExtensionID string    `json:"extension_id"`
+	Scope       string    `json:"scope"`
+	API         string    `json:"api"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+var (
+	pendingPermissionRequests   = make(map[string]*PendingPermissionRequest)
+	pendingPermissionRequestsMu sync.Mutex
+)
+
+func permissionRequestKey(extensionID, scope string) string {
+	return extensionID + "\x00" + scope
+}
+
+func queuePermissionRequest(extensionID, scope, api string) {
+	pendingPermissionRequestsMu.Lock()
+	defer pendingPermissionRequestsMu.Unlock()
+	key := permissionRequestKey(extensionID, scope)
+	if _, exists := pendingPermissionRequests[key]; exists {
+		return
+	}
+	pendingPermissionRequests[key] = &PendingPermissionRequest{
+		ExtensionID: extensionID,
+		Scope:       scope,
+		API:         api,
+		RequestedAt: time.Now(),
+	}
+	GoLog("[Extension:%s] Permission requested: %s\n", extensionID, scope)
+}
+
+func getPendingPermissionRequests() []*PendingPermissionRequest {
+	pendingPermissionRequestsMu.Lock()
+	defer pendingPermissionRequestsMu.Unlock()
+	requests := make([]*PendingPermissionRequest, 0, len(pendingPermissionRequests))
+	for _, req := range pendingPermissionRequests {
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestedAt.Before(requests[j].RequestedAt)
+	})
+	return requests
+}
+
+// getPermissionGrant returns the user's stored decision for a prompt scope.
+func getPermissionGrant(extensionID, scope string) (granted bool, decided bool) {
+	value, err := GetExtensionSettingsStore().Get(extensionID, grantedPermissionsSettingKey)
+	if err != nil {
+		return false, false
+	}
+	grants, ok := value.(map[string]interface{})
+	if !ok {
+		return false, false
+	}
+	decision, ok := grants[scope].(bool)
+	if !ok {
+		return false, false
+	}
+	return decision, true
+}
+
+// setPermissionGrant stores the user's decision for scope and clears any
+// pending prompt for it.
+func setPermissionGrant(extensionID, scope string, granted bool) error {
+	if !promptPermissions[scope] {
+		return fmt.Errorf("'%s' does not need runtime approval", scope)
+	}
+
+	store := GetExtensionSettingsStore()
+	grants := make(map[string]interface{})
+	if value, err := store.Get(extensionID, grantedPermissionsSettingKey); err == nil {
+		if existing, ok := value.(map[string]interface{}); ok {
+			for k, v := range existing {
+				grants[k] = v
+			}
+		}
+	}
+	grants[scope] = granted
+	if err := store.Set(extensionID, grantedPermissionsSettingKey, grants); err != nil {
+		return err
+	}
+
+	pendingPermissionRequestsMu.Lock()
+	delete(pendingPermissionRequests, permissionRequestKey(extensionID, scope))
+	pendingPermissionRequestsMu.Unlock()
+	return nil
+}
+
+// revokePermissionGrant forgets the decision so the next use prompts again.
+func revokePermissionGrant(extensionID, scope string) error {
+	store := GetExtensionSettingsStore()
+	value, err := store.Get(extensionID, grantedPermissionsSettingKey)
+	if err != nil {
+		return nil
+	}
+	existing, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	grants := make(map[string]interface{}, len(existing))
+	for k, v := range existing {
+		if k != scope {
+			grants[k] = v
+		}
+	}
+	return store.Set(extensionID, grantedPermissionsSettingKey, grants)
+}
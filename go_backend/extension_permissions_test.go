@@ -0,0 +1,205 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useTempSettingsStore(t *testing.T) {
+	t.Helper()
+	store := GetExtensionSettingsStore()
+	store.mu.RLock()
+	previousDir := store.dataDir
+	store.mu.RUnlock()
+	if err := store.SetDataDir(t.TempDir()); err != nil {
+		t.Fatalf("SetDataDir: %v", err)
+	}
+	t.Cleanup(func() {
+		store.mu.Lock()
+		store.dataDir = previousDir
+		store.mu.Unlock()
+	})
+}
+
+func TestPermissions_ReadOnlyFilesAndDirectories(t *testing.T) {
+	runtime, _ := newTestExtensionRuntime(t, "perm-files", ExtensionPermissions{
+		File:        true,
+		FileAccess:  FileAccessRead,
+		Directories: []string{"cache"},
+	})
+
+	if _, err := runtime.validateReadPath("cache/index.json"); err != nil {
+		t.Fatalf("read inside declared directory denied: %v", err)
+	}
+	if _, err := runtime.validatePath("cache/index.json"); err == nil || !strings.Contains(err.Error(), "read access") {
+		t.Fatalf("write with read-only access: err = %v", err)
+	}
+	if _, err := runtime.validateReadPath("other/file.txt"); err == nil || !strings.Contains(err.Error(), "declared directories") {
+		t.Fatalf("read outside declared directories: err = %v", err)
+	}
+	if _, err := runtime.validateReadPath("cache/../other.txt"); err == nil {
+		t.Fatal("expected traversal out of the declared directory to be denied")
+	}
+}
+
+func TestPermissions_OptOutScopesAndAuditLog(t *testing.T) {
+	denied := false
+	_, vm := newTestExtensionRuntime(t, "perm-audit", ExtensionPermissions{
+		Network:     []string{"api.allowed.com"},
+		FFmpeg:      &denied,
+		Credentials: &denied,
+	})
+
+	result, err := vm.RunString(`
+		[
+			ffmpeg.execute('-i in.flac out.mp3').error,
+			String(credentials.get('token')),
+			String(auth.isAuthenticated()),
+			http.get('https://blocked.example/x').error
+		].join('|')
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	parts := strings.Split(result.String(), "|")
+	if !strings.Contains(parts[0], "'ffmpeg' permission") {
+		t.Fatalf("ffmpeg error = %q", parts[0])
+	}
+	if parts[1] != "undefined" || parts[2] != "false" {
+		t.Fatalf("credentials/auth results = %q", result.String())
+	}
+
+	raw, err := GetExtensionAuditLogJSON("perm-audit")
+	if err != nil {
+		t.Fatalf("GetExtensionAuditLogJSON: %v", err)
+	}
+	var entries []ExtensionAuditEntry
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		t.Fatalf("decode audit log: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("audit entries = %d, want 4: %s", len(entries), raw)
+	}
+	want := []struct {
+		api     string
+		scope   string
+		allowed bool
+	}{
+		{"ffmpeg.execute", PermissionFFmpeg, false},
+		{"credentials.get", PermissionCredentials, false},
+		{"auth.isAuthenticated", PermissionAuth, true},
+		{"http", PermissionNetwork, false},
+	}
+	for i, w := range want {
+		if entries[i].API != w.api || entries[i].Scope != w.scope || entries[i].Allowed != w.allowed {
+			t.Fatalf("entry %d = %+v, want %+v", i, entries[i], w)
+		}
+	}
+	if entries[3].Target != "blocked.example" {
+		t.Fatalf("network audit target = %q", entries[3].Target)
+	}
+}
+
+func TestPermissions_ClipboardPromptsForApproval(t *testing.T) {
+	useTempSettingsStore(t)
+	_, vm := newTestExtensionRuntime(t, "perm-clipboard", ExtensionPermissions{Clipboard: true})
+	_ = takePendingClipboardWrites()
+
+	result, err := vm.RunString(`clipboard.writeText('hello').error`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if !strings.Contains(result.String(), "waiting for user approval") {
+		t.Fatalf("first write error = %q", result.String())
+	}
+
+	var pending []PendingPermissionRequest
+	raw, _ := GetPendingPermissionRequestsJSON()
+	if err := json.Unmarshal([]byte(raw), &pending); err != nil {
+		t.Fatalf("decode pending requests: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ExtensionID != "perm-clipboard" || pending[0].Scope != PermissionClipboard {
+		t.Fatalf("pending requests = %s", raw)
+	}
+
+	if err := RespondToExtensionPermissionRequest("perm-clipboard", PermissionClipboard, true); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	result, err = vm.RunString(`clipboard.writeText('hello', 'Track link').success`)
+	if err != nil || !result.ToBoolean() {
+		t.Fatalf("write after grant: %v %v", result, err)
+	}
+
+	var writes []PendingClipboardWrite
+	raw, _ = GetPendingClipboardWritesJSON()
+	if err := json.Unmarshal([]byte(raw), &writes); err != nil {
+		t.Fatalf("decode clipboard writes: %v", err)
+	}
+	if len(writes) != 1 || writes[0].Text != "hello" || writes[0].Label != "Track link" {
+		t.Fatalf("clipboard writes = %s", raw)
+	}
+
+	if err := RespondToExtensionPermissionRequest("perm-clipboard", PermissionClipboard, false); err != nil {
+		t.Fatalf("deny: %v", err)
+	}
+	result, _ = vm.RunString(`clipboard.writeText('again').error`)
+	if !strings.Contains(result.String(), "declined by the user") {
+		t.Fatalf("write after deny = %q", result.String())
+	}
+
+	_, undeclared := newTestExtensionRuntime(t, "perm-no-clipboard", ExtensionPermissions{})
+	result, _ = undeclared.RunString(`clipboard.writeText('x').error`)
+	if !strings.Contains(result.String(), "'clipboard' permission") {
+		t.Fatalf("undeclared clipboard error = %q", result.String())
+	}
+}
+
+func TestPermissions_DiffAndValidation(t *testing.T) {
+	yes := true
+	oldPerms := &ExtensionPermissions{Network: []string{"api.example.com"}, File: true}
+	newPerms := &ExtensionPermissions{
+		Network:    []string{"api.example.com", "cdn.example.com"},
+		File:       true,
+		FileAccess: FileAccessRead,
+		Clipboard:  true,
+		FFmpeg:     &yes,
+	}
+
+	diff := diffExtensionPermissions(oldPerms, newPerms)
+	if strings.Join(diff.Added, ",") != "clipboard,network:cdn.example.com" {
+		t.Fatalf("added = %v", diff.Added)
+	}
+	if strings.Join(diff.Removed, ",") != "file:write" {
+		t.Fatalf("removed = %v", diff.Removed)
+	}
+
+	fresh := diffExtensionPermissions(nil, oldPerms)
+	if len(fresh.Added) != len(oldPerms.Scopes()) || len(fresh.Removed) != 0 {
+		t.Fatalf("fresh install diff = %+v", fresh)
+	}
+
+	for _, manifest := range []string{
+		`{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"permissions":{"fileAccess":"all"}}`,
+		`{"name":"x","version":"1.0.0","description":"d","type":["metadata_provider"],"permissions":{"directories":["../up"]}}`,
+	} {
+		if _, err := ParseManifest([]byte(manifest)); err == nil || !strings.Contains(err.Error(), "permissions.") {
+			t.Fatalf("expected permissions validation error, got %v", err)
+		}
+	}
+}
+
+func TestPermissions_AuditLogIsBounded(t *testing.T) {
+	defer clearExtensionAuditLog("perm-bounded")
+	for i := 0; i < maxExtensionAuditEntries+25; i++ {
+		recordExtensionAudit("perm-bounded", ExtensionAuditEntry{API: "http", Time: time.Unix(int64(i), 0)})
+	}
+	entries := getExtensionAuditLog("perm-bounded")
+	if len(entries) != maxExtensionAuditEntries {
+		t.Fatalf("entries = %d", len(entries))
+	}
+	if entries[0].Time.Unix() != 25 {
+		t.Fatalf("oldest entry = %v, want the first 25 dropped", entries[0].Time.Unix())
+	}
+}
@@ -659,6 +659,8 @@ func (p *extensionProviderWrapper) Download(trackID, quality, outputPath, itemID
 		if IsTimeoutError(err) {
 			errMsg = "download timeout: extension took too long to complete"
 			errType = "timeout"
+		} else if IsQuotaError(err) {
+			errType = "quota_exceeded"
 		}
 		return &ExtDownloadResult{
 			Success:      false,
@@ -1119,7 +1121,23 @@ func (m *extensionManager) SearchTracksWithMetadataProviders(query string, limit
 	return tracks, nil
 }
 
+// DownloadWithExtensionFallback downloads through the provider priority list
+// and tells listening extensions how it went. Cancellations are not reported.
 func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, error) {
+	resp, err := downloadWithExtensionFallback(req)
+	switch {
+	case err != nil:
+		emitDownloadFailedEvent(req, &DownloadResponse{Error: err.Error(), ErrorType: "unknown"})
+	case resp == nil:
+	case resp.Success:
+		emitDownloadCompletedEvent(req, *resp)
+	case resp.ErrorType != "cancelled":
+		emitDownloadFailedEvent(req, resp)
+	}
+	return resp, err
+}
+
+func downloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, error) {
 	priority := GetProviderPriority()
 	extManager := getExtensionManager()
 	strictMode := !req.UseFallback
@@ -0,0 +1,474 @@
+package gobackend
+
+import (
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// ExtensionQuotaLimits bounds the resources a single extension may use.
+// Zero disables the corresponding check.
+type ExtensionQuotaLimits struct {
+	CPUTimeMs         int64  `json:"cpu_time_ms"`
+	CPUWindowSeconds  int64  `json:"cpu_window_seconds"`
+	MemoryBytes       uint64 `json:"memory_bytes"`
+	RequestsPerMinute int    `json:"requests_per_minute"`
+	StorageBytes      int64  `json:"storage_bytes"`
+	CredentialsBytes  int64  `json:"credentials_bytes"`
+}
+
+func defaultExtensionQuotaLimits() ExtensionQuotaLimits {
+	limits := ExtensionQuotaLimits{
+		CPUTimeMs:         60 * 1000,
+		CPUWindowSeconds:  5 * 60,
+		MemoryBytes:       256 * 1024 * 1024,
+		RequestsPerMinute: 300,
+		StorageBytes:      5 * 1024 * 1024,
+		CredentialsBytes:  256 * 1024,
+	}
+	if !threadCPUTimeSupported {
+		limits.CPUTimeMs = 0
+	}
+	return limits
+}
+
+const (
+	QuotaCPU         = "cpu"
+	QuotaMemory      = "memory"
+	QuotaRequests    = "requests"
+	QuotaStorage     = "storage"
+	QuotaCredentials = "credentials"
+)
+
+// ExtensionQuotaError is returned when an extension exceeds one of its
+// quotas. Used and Limit are in milliseconds for cpu, requests per minute for
+// requests, and bytes otherwise.
+type ExtensionQuotaError struct {
+	ExtensionID string    `json:"extension_id"`
+	Quota       string    `json:"quota"`
+	Used        int64     `json:"used"`
+	Limit       int64     `json:"limit"`
+	Time        time.Time `json:"time"`
+}
+
+func (e *ExtensionQuotaError) Error() string {
+	switch e.Quota {
+	case QuotaCPU:
+		return fmt.Sprintf("quota exceeded: cpu time %dms of %dms in the current window", e.Used, e.Limit)
+	case QuotaRequests:
+		return fmt.Sprintf("quota exceeded: more than %d HTTP requests per minute", e.Limit)
+	default:
+		return fmt.Sprintf("quota exceeded: %s %d bytes (limit %d)", e.Quota, e.Used, e.Limit)
+	}
+}
+
+func IsQuotaError(err error) bool {
+	var quotaErr *ExtensionQuotaError
+	return errors.As(err, &quotaErr)
+}
+
+type cpuUsage struct {
+	at   time.Time
+	used time.Duration
+}
+
+// extensionQuota tracks usage for one extension. It lives on the loaded
+// extension rather than its runtime, so reloading the VM does not reset it.
+type extensionQuota struct {
+	extensionID string
+
+	mu            sync.Mutex
+	limits        ExtensionQuotaLimits
+	cpu           []cpuUsage
+	requests      *RateLimiter
+	storageBytes  int64
+	credsBytes    int64
+	peakMemory    uint64
+	violations    map[string]int
+	lastViolation *ExtensionQuotaError
+	active        *quotaRun
+}
+
+func newExtensionQuota(extensionID string) *extensionQuota {
+	q := &extensionQuota{
+		extensionID: extensionID,
+		violations:  make(map[string]int),
+	}
+	q.setLimits(defaultExtensionQuotaLimits())
+	return q
+}
+
+func (q *extensionQuota) setLimits(limits ExtensionQuotaLimits) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limits = limits
+	q.requests = nil
+	if limits.RequestsPerMinute > 0 {
+		q.requests = NewRateLimiter(limits.RequestsPerMinute, time.Minute)
+	}
+}
+
+func (q *extensionQuota) getLimits() ExtensionQuotaLimits {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limits
+}
+
+func (q *extensionQuota) violation(quota string, used, limit int64) *ExtensionQuotaError {
+	err := &ExtensionQuotaError{
+		ExtensionID: q.extensionID,
+		Quota:       quota,
+		Used:        used,
+		Limit:       limit,
+		Time:        time.Now(),
+	}
+	q.mu.Lock()
+	q.violations[quota]++
+	q.lastViolation = err
+	q.mu.Unlock()
+	GoLog("[Extension:%s] %v\n", q.extensionID, err)
+	return err
+}
+
+func (q *extensionQuota) cpuWindowLocked(now time.Time) (used, limit time.Duration) {
+	limit = time.Duration(q.limits.CPUTimeMs) * time.Millisecond
+	cutoff := now.Add(-time.Duration(q.limits.CPUWindowSeconds) * time.Second)
+	keep := 0
+	for _, u := range q.cpu {
+		if u.at.After(cutoff) {
+			q.cpu[keep] = u
+			keep++
+			used += u.used
+		}
+	}
+	q.cpu = q.cpu[:keep]
+	return used, limit
+}
+
+// checkCPU returns a quota error when the window's budget is already spent,
+// counting inFlight CPU time of a call that has not finished yet.
+func (q *extensionQuota) checkCPU(inFlight time.Duration) error {
+	q.mu.Lock()
+	if !threadCPUTimeSupported || q.limits.CPUTimeMs <= 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	used, limit := q.cpuWindowLocked(time.Now())
+	q.mu.Unlock()
+
+	used += inFlight
+	if used < limit {
+		return nil
+	}
+	return q.violation(QuotaCPU, used.Milliseconds(), limit.Milliseconds())
+}
+
+func (q *extensionQuota) recordCPU(used time.Duration) {
+	if used <= 0 {
+		return
+	}
+	q.mu.Lock()
+	q.cpu = append(q.cpu, cpuUsage{at: time.Now(), used: used})
+	q.mu.Unlock()
+}
+
+// checkMemory compares heap growth since a call started with the memory cap.
+// Goja has no per-VM accounting, so the process heap is sampled; the default
+// cap is far above what downloads and other goroutines allocate meanwhile.
+func (q *extensionQuota) checkMemory(startHeap uint64) error {
+	grown := q.noteMemory(startHeap)
+
+	q.mu.Lock()
+	limit := q.limits.MemoryBytes
+	q.mu.Unlock()
+
+	if limit == 0 || grown <= limit {
+		return nil
+	}
+	return q.violation(QuotaMemory, int64(grown), int64(limit))
+}
+
+// noteMemory records heap growth since a call started and returns it.
+func (q *extensionQuota) noteMemory(startHeap uint64) uint64 {
+	current := readHeapObjectBytes()
+	if current <= startHeap {
+		return 0
+	}
+	grown := current - startHeap
+
+	q.mu.Lock()
+	if grown > q.peakMemory {
+		q.peakMemory = grown
+	}
+	q.mu.Unlock()
+	return grown
+}
+
+// enterHostCall stops charging CPU time to the running call until the
+// returned function is called. It is a no-op outside RunWithTimeout.
+func (q *extensionQuota) enterHostCall() func() {
+	q.mu.Lock()
+	run := q.active
+	q.mu.Unlock()
+	if run == nil {
+		return func() {}
+	}
+	return run.enterHost()
+}
+
+func (q *extensionQuota) acquireRequest() error {
+	q.mu.Lock()
+	limiter := q.requests
+	limit := q.limits.RequestsPerMinute
+	q.mu.Unlock()
+
+	if limiter == nil || limiter.TryAcquire() {
+		return nil
+	}
+	return q.violation(QuotaRequests, int64(limit), int64(limit))
+}
+
+// checkStorage and checkCredentials validate a prospective size before it is
+// written and record it when accepted.
+func (q *extensionQuota) checkStorage(size int64) error {
+	q.mu.Lock()
+	limit := q.limits.StorageBytes
+	q.mu.Unlock()
+	if limit > 0 && size > limit {
+		return q.violation(QuotaStorage, size, limit)
+	}
+	q.mu.Lock()
+	q.storageBytes = size
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *extensionQuota) checkCredentials(size int64) error {
+	q.mu.Lock()
+	limit := q.limits.CredentialsBytes
+	q.mu.Unlock()
+	if limit > 0 && size > limit {
+		return q.violation(QuotaCredentials, size, limit)
+	}
+	q.mu.Lock()
+	q.credsBytes = size
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *extensionQuota) noteStorageBytes(size int64) {
+	q.mu.Lock()
+	q.storageBytes = size
+	q.mu.Unlock()
+}
+
+func (q *extensionQuota) noteCredentialsBytes(size int64) {
+	q.mu.Lock()
+	q.credsBytes = size
+	q.mu.Unlock()
+}
+
+// ExtensionQuotaStats is the usage summary reported to the app.
+type ExtensionQuotaStats struct {
+	Limits             ExtensionQuotaLimits `json:"limits"`
+	CPUTimeMs          int64                `json:"cpu_time_ms"`
+	CPUTimeMeasured    bool                 `json:"cpu_time_measured"`
+	RequestsLastMinute int                  `json:"requests_last_minute"`
+	StorageBytes       int64                `json:"storage_bytes"`
+	CredentialsBytes   int64                `json:"credentials_bytes"`
+	PeakMemoryBytes    uint64               `json:"peak_memory_bytes"`
+	Violations         map[string]int       `json:"violations"`
+	LastViolation      *ExtensionQuotaError `json:"last_violation,omitempty"`
+}
+
+func (q *extensionQuota) stats() ExtensionQuotaStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cpuUsed, _ := q.cpuWindowLocked(time.Now())
+	requests := 0
+	if q.requests != nil {
+		requests = q.limits.RequestsPerMinute - q.requests.Available()
+	}
+	violations := make(map[string]int, len(q.violations))
+	for k, v := range q.violations {
+		violations[k] = v
+	}
+	return ExtensionQuotaStats{
+		Limits:             q.limits,
+		CPUTimeMs:          cpuUsed.Milliseconds(),
+		CPUTimeMeasured:    threadCPUTimeSupported,
+		RequestsLastMinute: requests,
+		StorageBytes:       q.storageBytes,
+		CredentialsBytes:   q.credsBytes,
+		PeakMemoryBytes:    q.peakMemory,
+		Violations:         violations,
+		LastViolation:      q.lastViolation,
+	}
+}
+
+var heapObjectsSample = []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+var heapObjectsSampleMu sync.Mutex
+
+func readHeapObjectBytes() uint64 {
+	heapObjectsSampleMu.Lock()
+	defer heapObjectsSampleMu.Unlock()
+	metrics.Read(heapObjectsSample)
+	if heapObjectsSample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return heapObjectsSample[0].Value.Uint64()
+}
+
+var (
+	extensionQuotas   = make(map[*goja.Runtime]*extensionQuota)
+	extensionQuotasMu sync.RWMutex
+)
+
+func registerExtensionQuota(vm *goja.Runtime, q *extensionQuota) {
+	extensionQuotasMu.Lock()
+	extensionQuotas[vm] = q
+	extensionQuotasMu.Unlock()
+}
+
+func unregisterExtensionQuota(vm *goja.Runtime) {
+	extensionQuotasMu.Lock()
+	delete(extensionQuotas, vm)
+	extensionQuotasMu.Unlock()
+}
+
+func getExtensionQuota(vm *goja.Runtime) *extensionQuota {
+	extensionQuotasMu.RLock()
+	defer extensionQuotasMu.RUnlock()
+	return extensionQuotas[vm]
+}
+
+const quotaCheckInterval = 100 * time.Millisecond
+
+// quotaRun measures one RunWithTimeout call against an extension's quotas.
+// Time spent inside host functions (network, files, ffmpeg, sleeps) is not
+// JavaScript execution and is subtracted from the CPU charged to the call.
+type quotaRun struct {
+	quota     *extensionQuota
+	clock     *threadCPUClock
+	startHeap uint64
+	prev      *quotaRun
+
+	mu        sync.Mutex
+	hostDepth int
+	hostEnter time.Duration
+	hostTime  time.Duration
+	stoppedAt time.Duration
+}
+
+// startQuotaRun must be called on the goroutine that runs the VM; it pins
+// that goroutine to its thread so the thread's CPU time can be read.
+func startQuotaRun(q *extensionQuota) *quotaRun {
+	r := &quotaRun{
+		quota:     q,
+		clock:     startThreadCPUClock(),
+		startHeap: readHeapObjectBytes(),
+	}
+	q.mu.Lock()
+	r.prev = q.active
+	q.active = r
+	q.mu.Unlock()
+	return r
+}
+
+// enterHost marks the start of a host call on the VM goroutine and returns
+// the function that ends it. Nested host calls are charged once. Both ends
+// sample the thread clock, so the CPU time is exact at every host boundary.
+func (r *quotaRun) enterHost() func() {
+	r.mu.Lock()
+	r.hostDepth++
+	if r.hostDepth == 1 {
+		r.hostEnter = r.clock.sample()
+	}
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		r.hostDepth--
+		if r.hostDepth == 0 {
+			r.hostTime += r.clock.sample() - r.hostEnter
+		}
+		r.mu.Unlock()
+	}
+}
+
+// charged is the CPU time spent running JavaScript so far. While JavaScript
+// is running it may overestimate by the wall time since the last sample.
+func (r *quotaRun) charged() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	used := r.hostEnter
+	if r.hostDepth == 0 {
+		used = r.clock.estimate()
+	}
+	if used -= r.hostTime; used > 0 {
+		return used
+	}
+	return 0
+}
+
+// check is safe to call from another goroutine while the VM is running.
+func (r *quotaRun) check() error {
+	if err := r.quota.checkMemory(r.startHeap); err != nil {
+		return err
+	}
+	used := r.charged()
+	err := r.quota.checkCPU(used)
+	if err != nil {
+		r.mu.Lock()
+		r.stoppedAt = used
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// finish records the CPU time used and unpins the goroutine. It must be called
+// on the goroutine that called startQuotaRun. A call stopped by the CPU quota
+// is charged at least the estimate it was stopped at, so the window stays
+// spent.
+func (r *quotaRun) finish() {
+	r.quota.noteMemory(r.startHeap)
+	r.clock.sample()
+	used := r.charged()
+	r.mu.Lock()
+	if r.stoppedAt > used {
+		used = r.stoppedAt
+	}
+	r.mu.Unlock()
+	r.quota.recordCPU(used)
+	r.quota.mu.Lock()
+	r.quota.active = r.prev
+	r.quota.mu.Unlock()
+	r.clock.stop()
+}
+
+// monitor checks the quotas periodically until stop is called and reports
+// the first violation to onViolation.
+func (r *quotaRun) monitor(onViolation func(error)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(quotaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := r.check(); err != nil {
+					onViolation(err)
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
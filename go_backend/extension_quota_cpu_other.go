@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+
+package gobackend
+
+import "time"
+
+// threadCPUTimeSupported is false where the CPU time of a single thread
+// cannot be read. Wall-clock time would also count network waits and long
+// downloads, so CPU limits are rejected on these platforms.
+const threadCPUTimeSupported = false
+
+type threadCPUClock struct{}
+
+func startThreadCPUClock() *threadCPUClock {
+	return &threadCPUClock{}
+}
+
+func (c *threadCPUClock) sample() time.Duration {
+	return 0
+}
+
+func (c *threadCPUClock) estimate() time.Duration {
+	return 0
+}
+
+func (c *threadCPUClock) stop() {}
@@ -0,0 +1,66 @@
+//go:build linux || darwin
+
+package gobackend
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// threadCPUTimeSupported reports whether threadCPUClock measures real CPU
+// time, which the CPU quota requires.
+const threadCPUTimeSupported = true
+
+// threadCPUClock reads the CPU time of the OS thread running an extension VM,
+// so time spent blocked on the network or timers does not count.
+// CLOCK_THREAD_CPUTIME_ID only reports the calling thread, so sample must run
+// on the VM goroutine; other goroutines see the last sample plus the wall
+// time since, which is an upper bound while JavaScript keeps running.
+type threadCPUClock struct {
+	start time.Duration
+
+	mu        sync.Mutex
+	sampled   time.Duration
+	sampledAt time.Time
+}
+
+func startThreadCPUClock() *threadCPUClock {
+	runtime.LockOSThread()
+	return &threadCPUClock{start: readThreadCPUTime(), sampledAt: time.Now()}
+}
+
+func readThreadCPUTime() time.Duration {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_THREAD_CPUTIME_ID, &ts); err != nil {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
+
+// sample reads the thread's CPU time used since the clock started. It must
+// be called on the goroutine that called startThreadCPUClock.
+func (c *threadCPUClock) sample() time.Duration {
+	used := readThreadCPUTime() - c.start
+	if used < 0 {
+		used = 0
+	}
+	c.mu.Lock()
+	c.sampled = used
+	c.sampledAt = time.Now()
+	c.mu.Unlock()
+	return used
+}
+
+// estimate is safe to call from any goroutine.
+func (c *threadCPUClock) estimate() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sampled + time.Since(c.sampledAt)
+}
+
+func (c *threadCPUClock) stop() {
+	runtime.UnlockOSThread()
+}
@@ -0,0 +1,167 @@
+package gobackend
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+var quotaTestPermissions = ExtensionPermissions{
+	Network: []string{"api.allowed.com"},
+	Storage: true,
+}
+
+func TestQuota_CPUTimeInterruptsAndBlocksUntilWindowResets(t *testing.T) {
+	if !threadCPUTimeSupported {
+		t.Skip("thread CPU time is not measurable on this platform")
+	}
+	runtime, vm := newTestExtensionRuntime(t, "quota-ext", quotaTestPermissions)
+	runtime.quota.setLimits(ExtensionQuotaLimits{CPUTimeMs: 300, CPUWindowSeconds: 60})
+
+	start := time.Now()
+	_, err := RunWithTimeoutAndRecover(vm, `while (true) {}`, 10*time.Second)
+	var quotaErr *ExtensionQuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Quota != QuotaCPU {
+		t.Fatalf("busy loop error = %v, want cpu quota error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("busy loop ran for %v before the quota stopped it", elapsed)
+	}
+
+	_, err = RunWithTimeoutAndRecover(vm, `1 + 1`, time.Second)
+	if !IsQuotaError(err) {
+		t.Fatalf("call after exhausting the window: err = %v", err)
+	}
+
+	stats := runtime.quota.stats()
+	if stats.CPUTimeMs < 300 || stats.Violations[QuotaCPU] < 2 || stats.LastViolation == nil {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	runtime.quota.setLimits(ExtensionQuotaLimits{CPUTimeMs: 60 * 1000, CPUWindowSeconds: 60})
+	if result, err := RunWithTimeoutAndRecover(vm, `1 + 1`, time.Second); err != nil || result.ToInteger() != 2 {
+		t.Fatalf("call after raising the limit: %v %v", result, err)
+	}
+}
+
+func TestQuota_HostCallsAreNotCharged(t *testing.T) {
+	if !threadCPUTimeSupported {
+		t.Skip("thread CPU time is not measurable on this platform")
+	}
+	runtime, vm := newTestExtensionRuntime(t, "quota-ext", quotaTestPermissions)
+	runtime.quota.setLimits(ExtensionQuotaLimits{CPUTimeMs: 200, CPUWindowSeconds: 60})
+	vm.Set("busyHost", runtime.hostCall(func(goja.FunctionCall) goja.Value {
+		for deadline := time.Now().Add(600 * time.Millisecond); time.Now().Before(deadline); {
+		}
+		return goja.Undefined()
+	}))
+
+	if _, err := RunWithTimeoutAndRecover(vm, `busyHost(); busyHost()`, 10*time.Second); err != nil {
+		t.Fatalf("host-bound call: err = %v", err)
+	}
+	if got := runtime.quota.stats().CPUTimeMs; got >= 200 {
+		t.Fatalf("cpu_time_ms = %d, host time was charged", got)
+	}
+}
+
+func TestQuota_AwaitingIsNotCharged(t *testing.T) {
+	if !threadCPUTimeSupported {
+		t.Skip("thread CPU time is not measurable on this platform")
+	}
+	runtime, vm := newTestExtensionRuntime(t, "quota-ext", quotaTestPermissions)
+	runtime.quota.setLimits(ExtensionQuotaLimits{CPUTimeMs: 200, CPUWindowSeconds: 60})
+
+	script := `new Promise(function(resolve) { setTimeout(function() { resolve('done'); }, 600); })`
+	result, err := RunWithTimeoutAndRecover(vm, script, 5*time.Second)
+	if err != nil || result.String() != "done" {
+		t.Fatalf("awaiting call: %v, %v", result, err)
+	}
+	if got := runtime.quota.stats().CPUTimeMs; got >= 200 {
+		t.Fatalf("cpu_time_ms = %d, waiting on a timer was charged", got)
+	}
+}
+
+func TestQuota_MemoryInterrupts(t *testing.T) {
+	runtime, vm := newTestExtensionRuntime(t, "quota-ext", quotaTestPermissions)
+	runtime.quota.setLimits(ExtensionQuotaLimits{MemoryBytes: 16 * 1024 * 1024})
+
+	start := time.Now()
+	_, err := RunWithTimeoutAndRecover(vm, `var keep = []; while (true) { keep.push(new Array(1024).join('x') + keep.length); }`, 20*time.Second)
+	var quotaErr *ExtensionQuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Quota != QuotaMemory {
+		t.Fatalf("allocating loop error = %v, want memory quota error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("allocating loop ran for %v before the quota stopped it", elapsed)
+	}
+	stats := runtime.quota.stats()
+	if stats.PeakMemoryBytes <= 16*1024*1024 || stats.Violations[QuotaMemory] != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	vm.Set("keep", nil)
+	if result, err := RunWithTimeoutAndRecover(vm, `1 + 1`, time.Second); err != nil || result.ToInteger() != 2 {
+		t.Fatalf("call after the memory violation: %v %v", result, err)
+	}
+}
+
+func TestQuota_RequestsPerMinute(t *testing.T) {
+	runtime, _ := newTestExtensionRuntime(t, "quota-ext", quotaTestPermissions)
+	runtime.quota.setLimits(ExtensionQuotaLimits{RequestsPerMinute: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := runtime.validateDomain("https://api.allowed.com/x"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	err := runtime.validateDomain("https://api.allowed.com/x")
+	if !IsQuotaError(err) || !strings.Contains(err.Error(), "2 HTTP requests per minute") {
+		t.Fatalf("third request: err = %v", err)
+	}
+	if got := runtime.quota.stats().RequestsLastMinute; got != 2 {
+		t.Fatalf("requests_last_minute = %d", got)
+	}
+}
+
+func TestQuota_StorageAndCredentialsSize(t *testing.T) {
+	runtime, vm := newTestExtensionRuntime(t, "quota-ext", quotaTestPermissions)
+	runtime.quota.setLimits(ExtensionQuotaLimits{StorageBytes: 200, CredentialsBytes: 64})
+
+	result, err := vm.RunString(`
+		(function() {
+			storage.set('small', 'ok');
+			try {
+				storage.set('big', new Array(300).join('x'));
+				return 'no error';
+			} catch (e) {
+				return [e.name, e.quota, e.limit, storage.get('big') === undefined, storage.get('small')].join('|');
+			}
+		})()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if result.String() != "QuotaExceededError|storage|200|true|ok" {
+		t.Fatalf("storage result = %q", result.String())
+	}
+	if got := runtime.quota.stats().StorageBytes; got <= 0 || got > 200 {
+		t.Fatalf("storage_bytes = %d", got)
+	}
+
+	result, err = vm.RunString(`
+		(function() {
+			var ok = credentials.store('token', 'abc');
+			var tooBig = credentials.store('blob', new Array(100).join('y'));
+			return [ok.success, tooBig.success, tooBig.quota, credentials.has('blob')].join('|');
+		})()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if result.String() != "true|false|credentials|false" {
+		t.Fatalf("credentials result = %q", result.String())
+	}
+}
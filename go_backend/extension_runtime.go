@@ -1,6 +1,7 @@
 package gobackend
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/dop251/goja"
@@ -89,13 +91,35 @@ type extensionRuntime struct {
 	downloadClient *http.Client
 	cookieJar      http.CookieJar
 	dataDir        string
+	sourceDir      string
 	vm             *goja.Runtime
+	eventLoop      *extensionEventLoop
+	modules        *extensionModuleLoader
+	quota          *extensionQuota
+
+	streamsMu sync.Mutex
+	streams   map[*extensionHTTPStream]struct{}
+
+	webSocketsMu  sync.Mutex
+	webSockets    map[*extensionWebSocket]struct{}
+	webSocketDial func(ctx context.Context, addr, serverName string) (net.Conn, error)
+
+	// vmMu is the owning extension's VMMu; host events take it before
+	// calling listeners.
+	vmMu                *sync.Mutex
+	eventsMu            sync.Mutex
+	eventListeners      []extensionEventListener
+	nextEventListenerID int64
+	eventQueue          chan extensionHostEvent
+	eventsClosed        bool
 
 	activeDownloadMu     sync.RWMutex
 	activeDownloadItemID string
 
 	storageMu      sync.RWMutex
 	storageCache   map[string]interface{}
+	storageSizes   map[string]int64
+	storageBytes   int64
 	storageLoaded  bool
 	storageDirty   bool
 	storageClosed  bool
@@ -108,22 +132,6 @@ type extensionRuntime struct {
 	storageFlushDelay time.Duration
 }
 
-type privateIPCacheEntry struct {
-	isPrivate bool
-	expiresAt time.Time
-}
-
-const (
-	privateIPCacheTTL      = 5 * time.Minute
-	privateIPErrorCacheTTL = 30 * time.Second
-	maxPrivateIPCacheSize  = 1024
-)
-
-var (
-	privateIPCache   = make(map[string]privateIPCacheEntry)
-	privateIPCacheMu sync.RWMutex
-)
-
 func newExtensionRuntime(ext *loadedExtension) *extensionRuntime {
 	jar, _ := newSimpleCookieJar()
 
@@ -133,10 +141,17 @@ func newExtensionRuntime(ext *loadedExtension) *extensionRuntime {
 		settings:          make(map[string]interface{}),
 		cookieJar:         jar,
 		dataDir:           ext.DataDir,
+		sourceDir:         ext.SourceDir,
 		vm:                ext.VM,
+		vmMu:              &ext.VMMu,
 		storageFlushDelay: defaultStorageFlushDelay,
 	}
 
+	if ext.quota == nil {
+		ext.quota = newExtensionQuota(ext.ID)
+	}
+	runtime.quota = ext.quota
+
 	runtime.httpClient = newExtensionHTTPClient(ext, jar, extensionHTTPTimeout(ext, 30*time.Second))
 	runtime.downloadClient = newExtensionHTTPClient(ext, jar, DownloadTimeout)
 
@@ -226,9 +241,9 @@ func newExtensionHTTPClient(ext *loadedExtension, jar http.CookieJar, timeout ti
 	// Extension sandbox enforces HTTPS-only domains. Do not apply global
 	// allow_http scheme downgrade here, because some extension APIs (e.g.
 	// spotify-web) will redirect http -> https and can end up in 301 loops.
-	// We still reuse sharedTransport so insecure TLS compatibility mode remains effective.
+	// extensionTransport still follows the insecure TLS compatibility mode.
 	client := &http.Client{
-		Transport: sharedTransport,
+		Transport: extensionPolicyTransport,
 		Timeout:   timeout,
 		Jar:       jar,
 	}
@@ -285,68 +300,57 @@ func isPrivateIP(host string) bool {
 		return isPrivateIPAddr(ip)
 	}
 
-	if cached, ok := getPrivateIPCache(hostLower); ok {
-		return cached
-	}
-
-	ips, err := net.LookupIP(hostLower)
+	// Use the same resolver as the dialers, so a DoH answer or host
+	// override cannot point an allowed domain at a local address. The
+	// answer is not cached: a name can resolve differently by the time it
+	// is dialed, which dialExtensionContext checks again.
+	ips, err := resolveHostIPs(context.Background(), hostLower)
 	if err != nil {
-		setPrivateIPCache(hostLower, false, privateIPErrorCacheTTL)
 		return false
 	}
-
-	isPrivate := false
 	for _, ip := range ips {
 		if isPrivateIPAddr(ip) {
-			isPrivate = true
-			break
+			return true
 		}
 	}
-
-	setPrivateIPCache(hostLower, isPrivate, privateIPCacheTTL)
-	return isPrivate
+	return false
 }
 
-func getPrivateIPCache(host string) (bool, bool) {
-	now := time.Now()
-
-	privateIPCacheMu.RLock()
-	entry, exists := privateIPCache[host]
-	privateIPCacheMu.RUnlock()
-	if !exists {
-		return false, false
+// rejectPrivateAddress is a net.Dialer Control func that refuses to connect
+// to private and local addresses. It sees every address a name resolves to
+// right before it is dialed, so DNS rebinding cannot get past it.
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
 	}
-
-	if now.Before(entry.expiresAt) {
-		return entry.isPrivate, true
+	if isPrivateIPAddr(net.ParseIP(host)) {
+		return fmt.Errorf("network access denied: private/local network '%s' not allowed", host)
 	}
-
-	privateIPCacheMu.Lock()
-	delete(privateIPCache, host)
-	privateIPCacheMu.Unlock()
-	return false, false
+	return nil
 }
 
-func setPrivateIPCache(host string, isPrivate bool, ttl time.Duration) {
-	expiresAt := time.Now().Add(ttl)
-
-	privateIPCacheMu.Lock()
-	if len(privateIPCache) >= maxPrivateIPCacheSize {
-		now := time.Now()
-		for key, entry := range privateIPCache {
-			if now.After(entry.expiresAt) {
-				delete(privateIPCache, key)
-			}
-		}
-		if len(privateIPCache) >= maxPrivateIPCacheSize {
-			privateIPCache = make(map[string]privateIPCacheEntry)
-		}
+// dialExtension dials addr for an extension, directly with private
+// addresses refused or through the configured proxy. A proxy resolves the
+// name itself, so only the isPrivateIP check applies there.
+func dialExtension(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
 	}
-	privateIPCache[host] = privateIPCacheEntry{
-		isPrivate: isPrivate,
-		expiresAt: expiresAt,
+	if proxyURL := getProxyConfig().route(host); proxyURL != nil {
+		if isPrivateIP(host) {
+			return nil, fmt.Errorf("network access denied: private/local network '%s' not allowed", host)
+		}
+		return dialThroughProxy(ctx, dialer, proxyURL, addr)
 	}
-	privateIPCacheMu.Unlock()
+	direct := *dialer
+	direct.Control = rejectPrivateAddress
+	return resolvingDialer{&direct}.DialContext(ctx, network, addr)
+}
+
+func dialExtensionContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialExtension(ctx, &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}, network, addr)
 }
 
 func isPrivateIPAddr(ip net.IP) bool {
@@ -395,62 +399,92 @@ func (r *extensionRuntime) SetSettings(settings map[string]interface{}) {
 	r.settings = settings
 }
 
+// hostCall wraps a host function so the time it spends is not charged to
+// the extension's CPU quota.
+func (r *extensionRuntime) hostCall(fn func(goja.FunctionCall) goja.Value) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		defer r.quota.enterHostCall()()
+		return fn(call)
+	}
+}
+
 func (r *extensionRuntime) RegisterAPIs(vm *goja.Runtime) {
 	r.vm = vm
+	r.eventLoop = newExtensionEventLoop(vm)
+	r.eventLoop.register(vm)
+	registerExtensionQuota(vm, r.quota)
+
+	// Host functions that wait on the network, disk or subprocesses run
+	// outside the CPU quota.
+	host := r.hostCall
 
 	httpObj := vm.NewObject()
-	httpObj.Set("get", r.httpGet)
-	httpObj.Set("post", r.httpPost)
-	httpObj.Set("put", r.httpPut)
-	httpObj.Set("delete", r.httpDelete)
-	httpObj.Set("patch", r.httpPatch)
-	httpObj.Set("request", r.httpRequest)
-	httpObj.Set("clearCookies", r.httpClearCookies)
+	httpObj.Set("get", host(r.httpGet))
+	httpObj.Set("post", host(r.httpPost))
+	httpObj.Set("put", host(r.httpPut))
+	httpObj.Set("delete", host(r.httpDelete))
+	httpObj.Set("patch", host(r.httpPatch))
+	httpObj.Set("request", host(r.httpRequest))
+	httpObj.Set("stream", host(r.httpStream))
+	httpObj.Set("clearCookies", host(r.httpClearCookies))
 	vm.Set("http", httpObj)
 
+	wsObj := vm.NewObject()
+	wsObj.Set("connect", host(r.wsConnect))
+	vm.Set("ws", wsObj)
+
+	eventsObj := vm.NewObject()
+	eventsObj.Set("on", r.eventsOn)
+	eventsObj.Set("off", r.eventsOff)
+	vm.Set("events", eventsObj)
+
 	storageObj := vm.NewObject()
-	storageObj.Set("get", r.storageGet)
-	storageObj.Set("set", r.storageSet)
-	storageObj.Set("remove", r.storageRemove)
+	storageObj.Set("get", host(r.storageGet))
+	storageObj.Set("set", host(r.storageSet))
+	storageObj.Set("remove", host(r.storageRemove))
 	vm.Set("storage", storageObj)
 
 	credentialsObj := vm.NewObject()
-	credentialsObj.Set("store", r.credentialsStore)
-	credentialsObj.Set("get", r.credentialsGet)
-	credentialsObj.Set("remove", r.credentialsRemove)
-	credentialsObj.Set("has", r.credentialsHas)
+	credentialsObj.Set("store", host(r.credentialsStore))
+	credentialsObj.Set("get", host(r.credentialsGet))
+	credentialsObj.Set("remove", host(r.credentialsRemove))
+	credentialsObj.Set("has", host(r.credentialsHas))
 	vm.Set("credentials", credentialsObj)
 
 	authObj := vm.NewObject()
-	authObj.Set("openAuthUrl", r.authOpenUrl)
-	authObj.Set("getAuthCode", r.authGetCode)
-	authObj.Set("setAuthCode", r.authSetCode)
-	authObj.Set("clearAuth", r.authClear)
-	authObj.Set("isAuthenticated", r.authIsAuthenticated)
-	authObj.Set("getTokens", r.authGetTokens)
-	authObj.Set("generatePKCE", r.authGeneratePKCE)
-	authObj.Set("getPKCE", r.authGetPKCE)
-	authObj.Set("startOAuthWithPKCE", r.authStartOAuthWithPKCE)
-	authObj.Set("exchangeCodeWithPKCE", r.authExchangeCodeWithPKCE)
+	authObj.Set("openAuthUrl", host(r.authOpenUrl))
+	authObj.Set("getAuthCode", host(r.authGetCode))
+	authObj.Set("setAuthCode", host(r.authSetCode))
+	authObj.Set("clearAuth", host(r.authClear))
+	authObj.Set("isAuthenticated", host(r.authIsAuthenticated))
+	authObj.Set("getTokens", host(r.authGetTokens))
+	authObj.Set("generatePKCE", host(r.authGeneratePKCE))
+	authObj.Set("getPKCE", host(r.authGetPKCE))
+	authObj.Set("startOAuthWithPKCE", host(r.authStartOAuthWithPKCE))
+	authObj.Set("exchangeCodeWithPKCE", host(r.authExchangeCodeWithPKCE))
 	vm.Set("auth", authObj)
 
 	fileObj := vm.NewObject()
-	fileObj.Set("download", r.fileDownload)
-	fileObj.Set("exists", r.fileExists)
-	fileObj.Set("delete", r.fileDelete)
-	fileObj.Set("read", r.fileRead)
-	fileObj.Set("readBytes", r.fileReadBytes)
-	fileObj.Set("write", r.fileWrite)
-	fileObj.Set("writeBytes", r.fileWriteBytes)
-	fileObj.Set("copy", r.fileCopy)
-	fileObj.Set("move", r.fileMove)
-	fileObj.Set("getSize", r.fileGetSize)
+	fileObj.Set("download", host(r.fileDownload))
+	fileObj.Set("exists", host(r.fileExists))
+	fileObj.Set("delete", host(r.fileDelete))
+	fileObj.Set("read", host(r.fileRead))
+	fileObj.Set("readBytes", host(r.fileReadBytes))
+	fileObj.Set("write", host(r.fileWrite))
+	fileObj.Set("writeBytes", host(r.fileWriteBytes))
+	fileObj.Set("copy", host(r.fileCopy))
+	fileObj.Set("move", host(r.fileMove))
+	fileObj.Set("getSize", host(r.fileGetSize))
 	vm.Set("file", fileObj)
 
+	clipboardObj := vm.NewObject()
+	clipboardObj.Set("writeText", r.clipboardWriteText)
+	vm.Set("clipboard", clipboardObj)
+
 	ffmpegObj := vm.NewObject()
-	ffmpegObj.Set("execute", r.ffmpegExecute)
-	ffmpegObj.Set("getInfo", r.ffmpegGetInfo)
-	ffmpegObj.Set("convert", r.ffmpegConvert)
+	ffmpegObj.Set("execute", host(r.ffmpegExecute))
+	ffmpegObj.Set("getInfo", host(r.ffmpegGetInfo))
+	ffmpegObj.Set("convert", host(r.ffmpegConvert))
 	vm.Set("ffmpeg", ffmpegObj)
 
 	matchingObj := vm.NewObject()
@@ -477,7 +511,7 @@ func (r *extensionRuntime) RegisterAPIs(vm *goja.Runtime) {
 	utilsObj.Set("randomUserAgent", r.randomUserAgent)
 	utilsObj.Set("appVersion", r.appVersion)
 	utilsObj.Set("appUserAgent", r.appUserAgent)
-	utilsObj.Set("sleep", r.sleep)
+	utilsObj.Set("sleep", host(r.sleep))
 	utilsObj.Set("isDownloadCancelled", r.isDownloadCancelled)
 	vm.Set("utils", utilsObj)
 
@@ -492,7 +526,7 @@ func (r *extensionRuntime) RegisterAPIs(vm *goja.Runtime) {
 	gobackendObj.Set("sanitizeFilename", r.sanitizeFilenameWrapper)
 	vm.Set("gobackend", gobackendObj)
 
-	vm.Set("fetch", r.fetchPolyfill)
+	vm.Set("fetch", host(r.fetchPolyfill))
 
 	vm.Set("atob", r.atobPolyfill)
 	vm.Set("btoa", r.btoaPolyfill)
@@ -502,4 +536,6 @@ func (r *extensionRuntime) RegisterAPIs(vm *goja.Runtime) {
 	r.registerURLClass(vm)
 
 	r.registerJSONGlobal(vm)
+
+	r.registerModuleLoader(vm)
 }
@@ -53,6 +53,13 @@ func summarizeURLForLog(urlStr string) string {
 }
 
 func (r *extensionRuntime) authOpenUrl(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.openAuthUrl", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	if len(call.Arguments) < 1 {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -100,6 +107,10 @@ func (r *extensionRuntime) authOpenUrl(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) authGetCode(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.getAuthCode", PermissionAuth, ""); err != nil {
+		return goja.Undefined()
+	}
+
 	extensionAuthStateMu.RLock()
 	defer extensionAuthStateMu.RUnlock()
 
@@ -112,6 +123,10 @@ func (r *extensionRuntime) authGetCode(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) authSetCode(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.setAuthCode", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(false)
+	}
+
 	if len(call.Arguments) < 1 {
 		return r.vm.ToValue(false)
 	}
@@ -150,6 +165,10 @@ func (r *extensionRuntime) authSetCode(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) authClear(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.clearAuth", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(false)
+	}
+
 	extensionAuthStateMu.Lock()
 	delete(extensionAuthState, r.extensionID)
 	extensionAuthStateMu.Unlock()
@@ -163,6 +182,10 @@ func (r *extensionRuntime) authClear(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) authIsAuthenticated(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.isAuthenticated", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(false)
+	}
+
 	extensionAuthStateMu.RLock()
 	defer extensionAuthStateMu.RUnlock()
 
@@ -179,6 +202,10 @@ func (r *extensionRuntime) authIsAuthenticated(call goja.FunctionCall) goja.Valu
 }
 
 func (r *extensionRuntime) authGetTokens(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.getTokens", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{})
+	}
+
 	extensionAuthStateMu.RLock()
 	defer extensionAuthStateMu.RUnlock()
 
@@ -229,6 +256,13 @@ func generatePKCEChallenge(verifier string) string {
 }
 
 func (r *extensionRuntime) authGeneratePKCE(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.generatePKCE", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	length := 64
 	if len(call.Arguments) > 0 && !goja.IsUndefined(call.Arguments[0]) {
 		if l, ok := call.Arguments[0].Export().(float64); ok && l >= 43 && l <= 128 {
@@ -266,6 +300,10 @@ func (r *extensionRuntime) authGeneratePKCE(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) authGetPKCE(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.getPKCE", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{})
+	}
+
 	extensionAuthStateMu.RLock()
 	defer extensionAuthStateMu.RUnlock()
 
@@ -282,6 +320,13 @@ func (r *extensionRuntime) authGetPKCE(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) authStartOAuthWithPKCE(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.startOAuthWithPKCE", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	if len(call.Arguments) < 1 {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -386,6 +431,13 @@ func (r *extensionRuntime) authStartOAuthWithPKCE(call goja.FunctionCall) goja.V
 }
 
 func (r *extensionRuntime) authExchangeCodeWithPKCE(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("auth.exchangeCodeWithPKCE", PermissionAuth, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	if len(call.Arguments) < 1 {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
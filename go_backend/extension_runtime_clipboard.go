@@ -0,0 +1,78 @@
+package gobackend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const maxClipboardTextBytes = 64 * 1024
+
+// PendingClipboardWrite is text an extension asked the app to put on the
+// system clipboard. The app drains these with GetPendingClipboardWritesJSON.
+type PendingClipboardWrite struct {
+	ExtensionID string    `json:"extension_id"`
+	Text        string    `json:"text"`
+	Label       string    `json:"label,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+var (
+	pendingClipboardWrites   []PendingClipboardWrite
+	pendingClipboardWritesMu sync.Mutex
+)
+
+func takePendingClipboardWrites() []PendingClipboardWrite {
+	pendingClipboardWritesMu.Lock()
+	defer pendingClipboardWritesMu.Unlock()
+	writes := pendingClipboardWrites
+	pendingClipboardWrites = nil
+	if writes == nil {
+		writes = []PendingClipboardWrite{}
+	}
+	return writes
+}
+
+// clipboardWriteText implements clipboard.writeText(text, label). It needs
+// the "clipboard" permission and the user's approval on first use.
+func (r *extensionRuntime) clipboardWriteText(call goja.FunctionCall) goja.Value {
+	if len(call.Arguments) < 1 {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   "text is required",
+		})
+	}
+
+	if err := r.requirePermission("clipboard.writeText", PermissionClipboard, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	text := call.Arguments[0].String()
+	if len(text) > maxClipboardTextBytes {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   "text is too large for the clipboard",
+		})
+	}
+	label := ""
+	if len(call.Arguments) > 1 && !goja.IsUndefined(call.Arguments[1]) && !goja.IsNull(call.Arguments[1]) {
+		label = call.Arguments[1].String()
+	}
+
+	pendingClipboardWritesMu.Lock()
+	pendingClipboardWrites = append(pendingClipboardWrites, PendingClipboardWrite{
+		ExtensionID: r.extensionID,
+		Text:        text,
+		Label:       label,
+		RequestedAt: time.Now(),
+	})
+	pendingClipboardWritesMu.Unlock()
+
+	return r.vm.ToValue(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,312 @@
+package gobackend
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// Host events let extensions react to things the app does on its own, such
+// as finished downloads or library scans, without the app calling them.
+const (
+	ExtensionEventDownloadCompleted = "downloadCompleted"
+	ExtensionEventDownloadFailed    = "downloadFailed"
+	ExtensionEventLibraryScanned    = "libraryScanned"
+	ExtensionEventLyricsEmbedded    = "lyricsEmbedded"
+)
+
+var extensionEventNames = map[string]bool{
+	ExtensionEventDownloadCompleted: true,
+	ExtensionEventDownloadFailed:    true,
+	ExtensionEventLibraryScanned:    true,
+	ExtensionEventLyricsEmbedded:    true,
+}
+
+// extensionEventQueueSize bounds the events waiting for one extension. Events
+// are dropped, not blocked on, when an extension falls this far behind.
+const extensionEventQueueSize = 32
+
+type extensionEventListener struct {
+	id    int64
+	event string
+	fn    goja.Callable
+}
+
+type extensionHostEvent struct {
+	name    string
+	payload map[string]interface{}
+}
+
+// extensionEventSubscribers holds the runtimes with at least one listener.
+var (
+	extensionEventSubscribers   = make(map[*extensionRuntime]struct{})
+	extensionEventSubscribersMu sync.RWMutex
+)
+
+// emitExtensionEvent queues event for every extension listening to it and
+// returns immediately. Listeners run later on a per-extension worker.
+func emitExtensionEvent(name string, payload map[string]interface{}) {
+	extensionEventSubscribersMu.RLock()
+	targets := make([]*extensionRuntime, 0, len(extensionEventSubscribers))
+	for r := range extensionEventSubscribers {
+		targets = append(targets, r)
+	}
+	extensionEventSubscribersMu.RUnlock()
+
+	for _, r := range targets {
+		r.queueHostEvent(extensionHostEvent{name: name, payload: payload})
+	}
+}
+
+func (r *extensionRuntime) eventsOn(call goja.FunctionCall) goja.Value {
+	name := call.Argument(0).String()
+	if !extensionEventNames[name] {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": "unknown event: " + name,
+		})
+	}
+	// Download events carry the user's listening history, so listening to
+	// any event must be declared.
+	if err := r.requirePermission("events.on", PermissionEvents, name); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	fn, ok := goja.AssertFunction(call.Argument(1))
+	if !ok {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": "listener must be a function",
+		})
+	}
+
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	if r.eventsClosed {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": "extension is shutting down",
+		})
+	}
+
+	r.nextEventListenerID++
+	id := r.nextEventListenerID
+	r.eventListeners = append(r.eventListeners, extensionEventListener{id: id, event: name, fn: fn})
+
+	if r.eventQueue == nil {
+		r.eventQueue = make(chan extensionHostEvent, extensionEventQueueSize)
+		go r.runHostEvents(r.eventQueue)
+
+		extensionEventSubscribersMu.Lock()
+		extensionEventSubscribers[r] = struct{}{}
+		extensionEventSubscribersMu.Unlock()
+	}
+	return r.vm.ToValue(id)
+}
+
+func (r *extensionRuntime) eventsOff(call goja.FunctionCall) goja.Value {
+	id := call.Argument(0).ToInteger()
+
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	for i, listener := range r.eventListeners {
+		if listener.id == id {
+			r.eventListeners = append(r.eventListeners[:i], r.eventListeners[i+1:]...)
+			return r.vm.ToValue(true)
+		}
+	}
+	return r.vm.ToValue(false)
+}
+
+func (r *extensionRuntime) queueHostEvent(event extensionHostEvent) {
+	r.eventsMu.Lock()
+	defer r.eventsMu.Unlock()
+	if r.eventsClosed || r.eventQueue == nil || len(r.listenersForLocked(event.name)) == 0 {
+		return
+	}
+	select {
+	case r.eventQueue <- event:
+	default:
+		GoLog("[Extension:%s] Dropped %s event: listener queue is full\n", r.extensionID, event.name)
+	}
+}
+
+func (r *extensionRuntime) listenersForLocked(name string) []extensionEventListener {
+	var listeners []extensionEventListener
+	for _, listener := range r.eventListeners {
+		if listener.event == name {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
+func (r *extensionRuntime) runHostEvents(queue <-chan extensionHostEvent) {
+	for event := range queue {
+		r.deliverHostEvent(event)
+	}
+}
+
+// deliverHostEvent calls the listeners for event while holding the VM lock,
+// with the same timeout and CPU quota as any other call into the extension.
+func (r *extensionRuntime) deliverHostEvent(event extensionHostEvent) {
+	r.vmMu.Lock()
+	defer r.vmMu.Unlock()
+
+	r.eventsMu.Lock()
+	closed := r.eventsClosed
+	listeners := r.listenersForLocked(event.name)
+	r.eventsMu.Unlock()
+	if closed || len(listeners) == 0 {
+		return
+	}
+
+	// Each extension gets its own copy, so listeners cannot change what
+	// other extensions see.
+	payload := make(map[string]interface{}, len(event.payload)+1)
+	for key, value := range event.payload {
+		payload[key] = value
+	}
+	payload["event"] = event.name
+
+	const dispatchVar = "__sf_host_event"
+	global := r.vm.GlobalObject()
+	_ = global.Set(dispatchVar, func(goja.FunctionCall) goja.Value {
+		results := make([]interface{}, 0, len(listeners))
+		for _, listener := range listeners {
+			value, err := listener.fn(goja.Undefined(), r.vm.ToValue(payload))
+			if err != nil {
+				GoLog("[Extension:%s] %s listener error: %v\n", r.extensionID, event.name, err)
+				continue
+			}
+			results = append(results, value)
+		}
+		return r.vm.ToValue(results)
+	})
+	defer global.Delete(dispatchVar)
+
+	const script = `Promise.all(__sf_host_event().map(function(result) {
+		return Promise.resolve(result).catch(function(e) {
+			log.error('event listener failed: ' + (e && e.message ? e.message : e));
+		});
+	}))`
+	if _, err := RunWithTimeoutAndRecover(r.vm, script, DefaultJSTimeout); err != nil {
+		GoLog("[Extension:%s] %s listeners failed: %v\n", r.extensionID, event.name, err)
+	}
+}
+
+// closeHostEvents drops listeners and stops the worker. Called with the VM
+// lock held when the runtime is torn down.
+func (r *extensionRuntime) closeHostEvents() {
+	r.eventsMu.Lock()
+	if r.eventsClosed {
+		r.eventsMu.Unlock()
+		return
+	}
+	r.eventsClosed = true
+	r.eventListeners = nil
+	if r.eventQueue != nil {
+		close(r.eventQueue)
+	}
+	r.eventsMu.Unlock()
+
+	extensionEventSubscribersMu.Lock()
+	delete(extensionEventSubscribers, r)
+	extensionEventSubscribersMu.Unlock()
+}
+
+// eventURLQueryPattern matches the query string of URLs in error messages,
+// which often carries tokens or signatures.
+var eventURLQueryPattern = regexp.MustCompile(`(https?://[^\s?#"']+)[?#][^\s"']*`)
+
+func sanitizeEventMessage(message string) string {
+	return eventURLQueryPattern.ReplaceAllString(strings.TrimSpace(message), "$1")
+}
+
+// eventFileInfo reports a file by name and format only; listeners never see
+// where the user keeps their music.
+func eventFileInfo(filePath string) (name, format string) {
+	filePath = strings.TrimSpace(filePath)
+	if filePath == "" {
+		return "", ""
+	}
+	name = path.Base(filepath.ToSlash(filePath))
+	if name == "." || name == "/" {
+		return "", ""
+	}
+	return name, strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+}
+
+func emitDownloadCompletedEvent(req DownloadRequest, resp DownloadResponse) {
+	fileName, format := eventFileInfo(resp.FilePath)
+	emitExtensionEvent(ExtensionEventDownloadCompleted, map[string]interface{}{
+		"item_id":        req.ItemID,
+		"title":          resp.Title,
+		"artist":         resp.Artist,
+		"album":          resp.Album,
+		"album_artist":   resp.AlbumArtist,
+		"isrc":           resp.ISRC,
+		"track_number":   resp.TrackNumber,
+		"disc_number":    resp.DiscNumber,
+		"release_date":   resp.ReleaseDate,
+		"genre":          resp.Genre,
+		"duration_ms":    req.DurationMS,
+		"cover_url":      resp.CoverURL,
+		"service":        resp.Service,
+		"bit_depth":      resp.ActualBitDepth,
+		"sample_rate":    resp.ActualSampleRate,
+		"file_name":      fileName,
+		"format":         format,
+		"already_exists": resp.AlreadyExists,
+	})
+}
+
+func emitDownloadFailedEvent(req DownloadRequest, resp *DownloadResponse) {
+	service := resp.Service
+	if service == "" {
+		service = req.Service
+	}
+	emitExtensionEvent(ExtensionEventDownloadFailed, map[string]interface{}{
+		"item_id":     req.ItemID,
+		"title":       req.TrackName,
+		"artist":      req.ArtistName,
+		"album":       req.AlbumName,
+		"isrc":        req.ISRC,
+		"duration_ms": req.DurationMS,
+		"service":     service,
+		"error":       sanitizeEventMessage(resp.Error),
+		"error_type":  resp.ErrorType,
+	})
+}
+
+func emitLibraryScannedEvent(incremental bool, totalFiles, scanned, skipped, deleted, errors int) {
+	emitExtensionEvent(ExtensionEventLibraryScanned, map[string]interface{}{
+		"incremental": incremental,
+		"total_files": totalFiles,
+		"scanned":     scanned,
+		"skipped":     skipped,
+		"deleted":     deleted,
+		"errors":      errors,
+	})
+}
+
+func emitLyricsEmbeddedEvent(filePath, lyrics string) {
+	fileName, format := eventFileInfo(filePath)
+	lines := len(parseSyncedLyrics(lyrics))
+	synced := lines > 0
+	if !synced {
+		for _, line := range strings.Split(lyrics, "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines++
+			}
+		}
+	}
+	emitExtensionEvent(ExtensionEventLyricsEmbedded, map[string]interface{}{
+		"file_name": fileName,
+		"format":    format,
+		"synced":    synced,
+		"lines":     lines,
+	})
+}
@@ -0,0 +1,135 @@
+package gobackend
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func runEventsTestScript(t *testing.T, runtime *extensionRuntime, vm *goja.Runtime, script string) goja.Value {
+	t.Helper()
+	runtime.vmMu.Lock()
+	defer runtime.vmMu.Unlock()
+	value, err := vm.RunString(script)
+	if err != nil {
+		t.Fatalf("script %q: %v", script, err)
+	}
+	return value
+}
+
+func waitForEvents(t *testing.T, runtime *extensionRuntime, vm *goja.Runtime, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if runEventsTestScript(t, runtime, vm, `seen.length`).ToInteger() >= want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d events; seen = %v", want, runEventsTestScript(t, runtime, vm, `JSON.stringify(seen)`))
+}
+
+func TestExtensionEvents_DeliversSanitizedPayloads(t *testing.T) {
+	runtime, vm := newTestExtensionRuntime(t, "events-ext", ExtensionPermissions{Events: true})
+	runEventsTestScript(t, runtime, vm, `
+		var seen = [];
+		events.on('downloadCompleted', function(e) { throw new Error('first listener fails'); });
+		events.on('downloadCompleted', function(e) { seen.push(e); });
+		var failedId = events.on('downloadFailed', function(e) { seen.push(e); });
+		var unknown = events.on('queueChanged', function() {});
+	`)
+	if got := runEventsTestScript(t, runtime, vm, `unknown.error`).String(); got != "unknown event: queueChanged" {
+		t.Fatalf("unknown event error = %q", got)
+	}
+
+	req := DownloadRequest{ItemID: "item-1", TrackName: "Song", ArtistName: "Artist", DurationMS: 180000}
+	resp := buildDownloadSuccessResponse(req, DownloadResult{
+		Title:         "Song",
+		Artist:        "Artist",
+		BitDepth:      24,
+		DecryptionKey: "secret-key",
+	}, "tidal", "Download complete", "/storage/emulated/0/Music/Artist - Song.flac", false)
+	if !resp.Success {
+		t.Fatal("expected a success response")
+	}
+	waitForEvents(t, runtime, vm, 1)
+
+	completed := runEventsTestScript(t, runtime, vm, `seen[0]`).ToObject(vm)
+	if completed.Get("event").String() != ExtensionEventDownloadCompleted ||
+		completed.Get("title").String() != "Song" ||
+		completed.Get("file_name").String() != "Artist - Song.flac" ||
+		completed.Get("format").String() != "flac" ||
+		completed.Get("bit_depth").ToInteger() != 24 ||
+		completed.Get("item_id").String() != "item-1" {
+		t.Fatalf("downloadCompleted payload = %v", runEventsTestScript(t, runtime, vm, `JSON.stringify(seen[0])`))
+	}
+	for _, key := range []string{"file_path", "decryption_key", "lyrics_lrc"} {
+		if v := completed.Get(key); v != nil && !goja.IsUndefined(v) {
+			t.Fatalf("payload leaks %s: %v", key, v)
+		}
+	}
+
+	emitDownloadFailedEvent(req, &DownloadResponse{
+		Error:     "request to https://cdn.example.com/file.flac?token=abc123 failed",
+		ErrorType: "network",
+		Service:   "tidal",
+	})
+	waitForEvents(t, runtime, vm, 2)
+	if got := runEventsTestScript(t, runtime, vm, `seen[1].error`).String(); got != "request to https://cdn.example.com/file.flac failed" {
+		t.Fatalf("downloadFailed error = %q", got)
+	}
+
+	if !runEventsTestScript(t, runtime, vm, `events.off(failedId)`).ToBoolean() {
+		t.Fatal("events.off should remove the listener")
+	}
+	emitDownloadFailedEvent(req, &DownloadResponse{Error: "again"})
+	emitLyricsEmbeddedEvent("/music/a.flac", "[00:01.00]hi")
+	buildDownloadSuccessResponse(req, DownloadResult{Title: "Next"}, "tidal", "", "/music/next.flac", true)
+	waitForEvents(t, runtime, vm, 3)
+	if got := runEventsTestScript(t, runtime, vm, `seen[2].title + ' ' + seen[2].already_exists`).String(); got != "Next true" {
+		t.Fatalf("third event = %q, want only the downloadCompleted after off()", got)
+	}
+}
+
+func TestEmitLyricsEmbeddedEvent_CountsSyncedLines(t *testing.T) {
+	runtime, vm := newTestExtensionRuntime(t, "events-ext", ExtensionPermissions{Events: true})
+	runEventsTestScript(t, runtime, vm, `
+		var seen = [];
+		events.on('lyricsEmbedded', function(e) { seen.push(e); });
+	`)
+
+	emitLyricsEmbeddedEvent("/music/a.flac", "[00:01.00]one\n[00:02.50]two\n")
+	emitLyricsEmbeddedEvent("/music/b.mp3", "plain\n\ntext")
+	waitForEvents(t, runtime, vm, 2)
+
+	got := runEventsTestScript(t, runtime, vm, `seen.map(function(e) { return e.file_name + ':' + e.format + ':' + e.synced + ':' + e.lines; }).join(',')`).String()
+	if got != "a.flac:flac:true:2,b.mp3:mp3:false:2" {
+		t.Fatalf("lyricsEmbedded payloads = %q", got)
+	}
+}
+
+func TestExtensionEvents_RequireEventsPermission(t *testing.T) {
+	runtime, vm := newTestExtensionRuntime(t, "events-denied", ExtensionPermissions{})
+	defer clearExtensionAuditLog("events-denied")
+	runEventsTestScript(t, runtime, vm, `
+		var seen = [];
+		var denied = events.on('downloadCompleted', function(e) { seen.push(e); });
+	`)
+	if got := runEventsTestScript(t, runtime, vm, `denied.error`).String(); !strings.Contains(got, "'events' permission") {
+		t.Fatalf("events.on without permission = %q", got)
+	}
+
+	entries := getExtensionAuditLog("events-denied")
+	if len(entries) != 1 || entries[0].API != "events.on" || entries[0].Scope != PermissionEvents ||
+		entries[0].Target != ExtensionEventDownloadCompleted || entries[0].Allowed {
+		t.Fatalf("audit log = %+v", entries)
+	}
+
+	emitExtensionEvent(ExtensionEventDownloadCompleted, map[string]interface{}{"title": "Song"})
+	time.Sleep(50 * time.Millisecond)
+	if got := runEventsTestScript(t, runtime, vm, `seen.length`).ToInteger(); got != 0 {
+		t.Fatalf("denied listener received %d events", got)
+	}
+}
@@ -71,10 +71,33 @@ func isPathWithinBase(baseDir, targetPath string) bool {
 	return true
 }
 
+// validatePath resolves path for writing. Reads go through validateReadPath,
+// which also works for extensions with read-only file access.
 func (r *extensionRuntime) validatePath(path string) (string, error) {
+	return r.resolveFilePath(path, true)
+}
+
+func (r *extensionRuntime) validateReadPath(path string) (string, error) {
+	return r.resolveFilePath(path, false)
+}
+
+func (r *extensionRuntime) resolveFilePath(path string, write bool) (string, error) {
+	scope := PermissionFileRead
+	if write {
+		scope = PermissionFileWrite
+	}
+	absPath, err := r.checkFilePath(path, write)
+	r.audit("file", scope, path, err)
+	return absPath, err
+}
+
+func (r *extensionRuntime) checkFilePath(path string, write bool) (string, error) {
 	if !r.manifest.Permissions.File {
 		return "", fmt.Errorf("file access denied: extension does not have 'file' permission")
 	}
+	if write && !r.manifest.Permissions.CanWriteFiles() {
+		return "", fmt.Errorf("file access denied: extension only has read access")
+	}
 
 	cleanPath := filepath.Clean(path)
 
@@ -103,6 +126,15 @@ func (r *extensionRuntime) validatePath(path string) (string, error) {
 		return "", fmt.Errorf("file access denied: path '%s' is outside sandbox", path)
 	}
 
+	if dirs := r.manifest.Permissions.Directories; len(dirs) > 0 {
+		for _, dir := range dirs {
+			if isPathWithinBase(filepath.Join(absDataDir, filepath.FromSlash(dir)), absPath) {
+				return absPath, nil
+			}
+		}
+		return "", fmt.Errorf("file access denied: path '%s' is outside the declared directories", path)
+	}
+
 	return absPath, nil
 }
 
@@ -278,7 +310,7 @@ func (r *extensionRuntime) fileExists(call goja.FunctionCall) goja.Value {
 	}
 
 	path := call.Arguments[0].String()
-	fullPath, err := r.validatePath(path)
+	fullPath, err := r.validateReadPath(path)
 	if err != nil {
 		return r.vm.ToValue(false)
 	}
@@ -325,7 +357,7 @@ func (r *extensionRuntime) fileRead(call goja.FunctionCall) goja.Value {
 	}
 
 	path := call.Arguments[0].String()
-	fullPath, err := r.validatePath(path)
+	fullPath, err := r.validateReadPath(path)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -356,7 +388,7 @@ func (r *extensionRuntime) fileReadBytes(call goja.FunctionCall) goja.Value {
 	}
 
 	path := call.Arguments[0].String()
-	fullPath, err := r.validatePath(path)
+	fullPath, err := r.validateReadPath(path)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -597,7 +629,7 @@ func (r *extensionRuntime) fileCopy(call goja.FunctionCall) goja.Value {
 	srcPath := call.Arguments[0].String()
 	dstPath := call.Arguments[1].String()
 
-	fullSrc, err := r.validatePath(srcPath)
+	fullSrc, err := r.validateReadPath(srcPath)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -716,7 +748,7 @@ func (r *extensionRuntime) fileGetSize(call goja.FunctionCall) goja.Value {
 	}
 
 	path := call.Arguments[0].String()
-	fullPath, err := r.validatePath(path)
+	fullPath, err := r.validateReadPath(path)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
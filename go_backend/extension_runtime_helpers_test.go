@@ -0,0 +1,35 @@
+package gobackend
+
+import (
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+// newTestExtensionRuntime returns a runtime with all APIs registered for an
+// extension declaring perms. It is torn down like a loaded extension's VM
+// when the test ends.
+func newTestExtensionRuntime(t *testing.T, id string, perms ExtensionPermissions) (*extensionRuntime, *goja.Runtime) {
+	t.Helper()
+
+	ext := &loadedExtension{
+		ID: id,
+		Manifest: &ExtensionManifest{
+			Name:        id,
+			Permissions: perms,
+		},
+		DataDir: t.TempDir(),
+	}
+
+	runtime := newExtensionRuntime(ext)
+	vm := goja.New()
+	runtime.RegisterAPIs(vm)
+	ext.runtime, ext.VM = runtime, vm
+	t.Cleanup(func() {
+		ext.VMMu.Lock()
+		teardownVMLocked(ext)
+		ext.VMMu.Unlock()
+		clearExtensionAuditLog(id)
+	})
+	return runtime, vm
+}
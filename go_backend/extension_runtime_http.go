@@ -18,6 +18,19 @@ type HTTPResponse struct {
 }
 
 func (r *extensionRuntime) validateDomain(urlStr string) error {
+	err := r.checkDomain(urlStr)
+	if err == nil && r.quota != nil {
+		err = r.quota.acquireRequest()
+	}
+	target := urlStr
+	if parsed, parseErr := url.Parse(urlStr); parseErr == nil && parsed.Host != "" {
+		target = parsed.Hostname()
+	}
+	r.audit("http", PermissionNetwork, target, err)
+	return err
+}
+
+func (r *extensionRuntime) checkDomain(urlStr string) error {
 	parsed, err := url.Parse(urlStr)
 	if err != nil {
 		return fmt.Errorf("invalid URL: %w", err)
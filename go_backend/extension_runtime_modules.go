@@ -0,0 +1,245 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+)
+
+// extensionModuleLoader implements CommonJS require() for multi-file
+// extension packages. Modules are resolved inside the package directory only
+// and cached per VM, so every require of the same file shares one exports
+// object. Module names in stack traces are package-relative paths.
+type extensionModuleLoader struct {
+	extensionID string
+	rootDir     string
+	vm          *goja.Runtime
+	cache       map[string]*goja.Object
+}
+
+const moduleWrapperPrefix = "(function(exports, require, module, __filename, __dirname) {"
+
+func newExtensionModuleLoader(extensionID, rootDir string, vm *goja.Runtime) *extensionModuleLoader {
+	if resolved, err := filepath.EvalSymlinks(rootDir); err == nil {
+		rootDir = resolved
+	}
+	if abs, err := filepath.Abs(rootDir); err == nil {
+		rootDir = abs
+	}
+	return &extensionModuleLoader{
+		extensionID: extensionID,
+		rootDir:     rootDir,
+		vm:          vm,
+		cache:       make(map[string]*goja.Object),
+	}
+}
+
+// moduleName returns the package-relative, slash-separated name of absPath.
+func (l *extensionModuleLoader) moduleName(absPath string) string {
+	rel, err := filepath.Rel(l.rootDir, absPath)
+	if err != nil {
+		return filepath.Base(absPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// checkInside rejects paths that leave the package, including through
+// symlinks.
+func (l *extensionModuleLoader) checkInside(absPath string) error {
+	if !isPathWithinBase(l.rootDir, absPath) {
+		return fmt.Errorf("module path '%s' escapes extension package", l.moduleName(absPath))
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil && !isPathWithinBase(l.rootDir, resolved) {
+		return fmt.Errorf("module path '%s' escapes extension package", l.moduleName(absPath))
+	}
+	return nil
+}
+
+// resolve maps a require specifier to a file, trying the exact path, the
+// .js and .json extensions, then package.json "main" and index files for
+// directories. Relative specifiers are resolved against fromDir; bare ones
+// against node_modules at the package root.
+func (l *extensionModuleLoader) resolve(specifier, fromDir string) (string, error) {
+	specifier = strings.TrimSpace(specifier)
+	if specifier == "" {
+		return "", fmt.Errorf("module specifier is required")
+	}
+	if filepath.IsAbs(specifier) || strings.HasPrefix(specifier, "/") {
+		return "", fmt.Errorf("absolute module paths are not allowed: '%s'", specifier)
+	}
+
+	var base string
+	if specifier == "." || specifier == ".." || strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+		base = filepath.Join(fromDir, filepath.FromSlash(specifier))
+	} else {
+		base = filepath.Join(l.rootDir, "node_modules", filepath.FromSlash(specifier))
+	}
+	if err := l.checkInside(base); err != nil {
+		return "", err
+	}
+
+	candidates := []string{base, base + ".js", base + ".json"}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, l.checkInside(candidate)
+		}
+	}
+
+	if info, err := os.Stat(base); err == nil && info.IsDir() {
+		if data, err := os.ReadFile(filepath.Join(base, "package.json")); err == nil {
+			var pkg struct {
+				Main string `json:"main"`
+			}
+			if json.Unmarshal(data, &pkg) == nil && pkg.Main != "" {
+				main := filepath.Join(base, filepath.FromSlash(pkg.Main))
+				for _, candidate := range []string{main, main + ".js", main + ".json", filepath.Join(main, "index.js")} {
+					if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+						return candidate, l.checkInside(candidate)
+					}
+				}
+			}
+		}
+		for _, index := range []string{"index.js", "index.json"} {
+			candidate := filepath.Join(base, index)
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate, l.checkInside(candidate)
+			}
+		}
+	}
+
+	return "", fmt.Errorf("Cannot find module '%s'", specifier)
+}
+
+// sourceMapLoader loads source maps referenced by sourceMappingURL comments.
+// Maps outside the package or missing on disk are ignored rather than
+// failing the parse.
+func (l *extensionModuleLoader) sourceMapLoader(mapPath string) ([]byte, error) {
+	if strings.Contains(mapPath, "://") {
+		return nil, nil
+	}
+	absPath := filepath.Join(l.rootDir, filepath.FromSlash(path.Clean("/"+mapPath)))
+	if l.checkInside(absPath) != nil {
+		GoLog("[Extension:%s] Ignoring source map outside package: %s\n", l.extensionID, mapPath)
+		return nil, nil
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// compile parses src under the package-relative name so stack traces and
+// source maps resolve against the package.
+func (l *extensionModuleLoader) compile(name, src string) (*goja.Program, error) {
+	prg, err := goja.Parse(name, src, parser.WithSourceMapLoader(l.sourceMapLoader))
+	if err != nil {
+		return nil, err
+	}
+	return goja.CompileAST(prg, false)
+}
+
+// runScript executes src from the package file absPath as a global script,
+// the way index.js has always been run.
+func (l *extensionModuleLoader) runScript(absPath string, src []byte) (goja.Value, error) {
+	program, err := l.compile(l.moduleName(absPath), string(src))
+	if err != nil {
+		return nil, err
+	}
+	return l.vm.RunProgram(program)
+}
+
+func (l *extensionModuleLoader) load(absPath string) (*goja.Object, error) {
+	if module, ok := l.cache[absPath]; ok {
+		return module, nil
+	}
+
+	src, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module '%s': %w", l.moduleName(absPath), err)
+	}
+
+	module := l.vm.NewObject()
+	exports := l.vm.NewObject()
+	module.Set("exports", exports)
+	module.Set("id", l.moduleName(absPath))
+	module.Set("filename", l.moduleName(absPath))
+	l.cache[absPath] = module
+
+	if strings.EqualFold(filepath.Ext(absPath), ".json") {
+		parse, _ := goja.AssertFunction(l.vm.Get("JSON").ToObject(l.vm).Get("parse"))
+		value, err := parse(goja.Undefined(), l.vm.ToValue(string(src)))
+		if err != nil {
+			delete(l.cache, absPath)
+			return nil, fmt.Errorf("failed to parse '%s': %w", l.moduleName(absPath), err)
+		}
+		module.Set("exports", value)
+		return module, nil
+	}
+
+	program, err := l.compile(l.moduleName(absPath), moduleWrapperPrefix+string(src)+"\n})")
+	if err != nil {
+		delete(l.cache, absPath)
+		return nil, err
+	}
+	wrapperValue, err := l.vm.RunProgram(program)
+	if err != nil {
+		delete(l.cache, absPath)
+		return nil, err
+	}
+	wrapper, ok := goja.AssertFunction(wrapperValue)
+	if !ok {
+		delete(l.cache, absPath)
+		return nil, fmt.Errorf("failed to load module '%s'", l.moduleName(absPath))
+	}
+
+	dirName := filepath.Dir(absPath)
+	_, err = wrapper(
+		exports,
+		exports,
+		l.requireFunction(dirName),
+		module,
+		l.vm.ToValue(l.moduleName(absPath)),
+		l.vm.ToValue(l.moduleName(dirName)),
+	)
+	if err != nil {
+		delete(l.cache, absPath)
+		return nil, err
+	}
+	return module, nil
+}
+
+// requireFunction returns require() for modules in fromDir.
+func (l *extensionModuleLoader) requireFunction(fromDir string) goja.Value {
+	return l.vm.ToValue(func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) < 1 {
+			panic(l.vm.NewTypeError("module specifier is required"))
+		}
+		resolved, err := l.resolve(call.Arguments[0].String(), fromDir)
+		if err != nil {
+			panic(l.vm.NewGoError(err))
+		}
+		module, err := l.load(resolved)
+		if err != nil {
+			if exception, ok := err.(*goja.Exception); ok {
+				panic(exception.Value())
+			}
+			panic(l.vm.NewGoError(err))
+		}
+		return module.Get("exports")
+	})
+}
+
+func (r *extensionRuntime) registerModuleLoader(vm *goja.Runtime) {
+	if r.sourceDir == "" {
+		return
+	}
+	r.modules = newExtensionModuleLoader(r.extensionID, r.sourceDir, vm)
+	vm.Set("require", r.modules.requireFunction(r.modules.rootDir))
+}
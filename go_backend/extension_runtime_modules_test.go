@@ -0,0 +1,124 @@
+package gobackend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeModuleTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+}
+
+func loadModuleTestExtension(t *testing.T, files map[string]string) *loadedExtension {
+	t.Helper()
+	sourceDir := t.TempDir()
+	writeModuleTestFiles(t, sourceDir, files)
+
+	ext := &loadedExtension{
+		ID:        "modules-ext",
+		Manifest:  &ExtensionManifest{Name: "modules-ext"},
+		DataDir:   t.TempDir(),
+		SourceDir: sourceDir,
+	}
+	if err := initializeVMLocked(ext); err != nil {
+		t.Fatalf("initializeVMLocked failed: %v", err)
+	}
+	t.Cleanup(func() { teardownVMLocked(ext) })
+	return ext
+}
+
+func TestExtensionRequire_LoadsSiblingModulesWithCache(t *testing.T) {
+	ext := loadModuleTestExtension(t, map[string]string{
+		"index.js": `
+			var util = require('./lib/util');
+			var again = require('./lib/util.js');
+			var config = require('./config.json');
+			var vendor = require('helper');
+			registerExtension({
+				value: function() {
+					return [util.greet(config.name), util === again, util.loads, vendor.kind].join('|');
+				}
+			});
+		`,
+		"lib/util.js": `
+			var counter = require('./counter');
+			counter.loads++;
+			exports.greet = function(name) { return 'hi ' + name + ' from ' + __filename; };
+			exports.loads = counter.loads;
+		`,
+		"lib/counter.js":                   `module.exports = { loads: 0 };`,
+		"config.json":                      `{"name": "modules"}`,
+		"node_modules/helper/package.json": `{"main": "main.js"}`,
+		"node_modules/helper/main.js":      `module.exports = { kind: 'vendored' };`,
+	})
+
+	result, err := RunWithTimeoutAndRecover(ext.VM, `extension.value()`, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RunWithTimeout failed: %v", err)
+	}
+	if got := result.String(); got != "hi modules from lib/util.js|true|1|vendored" {
+		t.Fatalf("result = %q", got)
+	}
+}
+
+func TestExtensionRequire_RejectsPathsOutsidePackage(t *testing.T) {
+	outside := t.TempDir()
+	writeModuleTestFiles(t, outside, map[string]string{"secret.js": `module.exports = 'secret';`})
+
+	ext := loadModuleTestExtension(t, map[string]string{
+		"index.js": `
+			registerExtension({
+				load: function(spec) {
+					try {
+						return require(spec);
+					} catch (e) {
+						return 'error: ' + e.message;
+					}
+				}
+			});
+		`,
+	})
+
+	escape, _ := filepath.Rel(ext.SourceDir, filepath.Join(outside, "secret.js"))
+	for _, spec := range []string{"./" + filepath.ToSlash(escape), filepath.Join(outside, "secret.js"), "./missing"} {
+		ext.VM.Set("spec", spec)
+		result, err := RunWithTimeoutAndRecover(ext.VM, `extension.load(spec)`, 5*time.Second)
+		if err != nil {
+			t.Fatalf("RunWithTimeout failed: %v", err)
+		}
+		if got := result.String(); !strings.HasPrefix(got, "error: ") {
+			t.Errorf("require(%q) = %q, want error", spec, got)
+		}
+	}
+}
+
+func TestExtensionRequire_SourceMapsApplyToStackTraces(t *testing.T) {
+	ext := loadModuleTestExtension(t, map[string]string{
+		"index.js": `
+			var mapped = require('./dist/mapped');
+			registerExtension({ fail: mapped });
+		`,
+		"dist/mapped.js":     "module.exports = function() {\n  throw new Error('mapped failure');\n};\n//# sourceMappingURL=mapped.js.map",
+		"dist/mapped.js.map": `{"version":3,"sources":["../src/mapped.ts"],"names":[],"mappings":"AAAA;AACA;AACA"}`,
+	})
+
+	_, err := RunWithTimeoutAndRecover(ext.VM, `extension.fail()`, 5*time.Second)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "mapped.ts:2") {
+		t.Fatalf("stack trace not mapped to source: %v", err)
+	}
+}
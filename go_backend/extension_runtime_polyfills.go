@@ -1,6 +1,8 @@
 package gobackend
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,18 +14,63 @@ import (
 	"github.com/dop251/goja"
 )
 
+type fetchResult struct {
+	status     int
+	statusText string
+	headers    map[string]interface{}
+	url        string
+	body       []byte
+	stream     *extensionHTTPStream
+	err        string
+}
+
+// fetchPolyfill starts the request on a goroutine and returns a Promise for
+// the Response, settled through the event loop. With the non-standard option
+// stream: true the body is not buffered and is read through response.body.
 func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
+	req, streaming, errMsg := r.buildFetchRequest(call)
+
+	promise, resolve, reject := r.vm.NewPromise()
+	settle := func(result *fetchResult) {
+		if result.err != "" {
+			_ = reject(r.vm.NewTypeError(result.err))
+			return
+		}
+		_ = resolve(r.newFetchResponse(result))
+	}
+
+	if errMsg != "" {
+		settle(&fetchResult{err: errMsg})
+		return r.vm.ToValue(promise)
+	}
+
+	if r.eventLoop == nil {
+		settle(r.performFetch(req, streaming))
+		return r.vm.ToValue(promise)
+	}
+
+	complete := r.eventLoop.startAsync()
+	go func() {
+		result := r.performFetch(req, streaming)
+		complete(func() { settle(result) })
+	}()
+
+	return r.vm.ToValue(promise)
+}
+
+func (r *extensionRuntime) buildFetchRequest(call goja.FunctionCall) (*http.Request, bool, string) {
 	if len(call.Arguments) < 1 {
-		return r.createFetchError("URL is required")
+		return nil, false, "URL is required"
 	}
 
 	urlStr := call.Arguments[0].String()
 	if err := r.validateDomain(urlStr); err != nil {
 		GoLog("[Extension:%s] fetch blocked: %v\n", r.extensionID, err)
-		return r.createFetchError(err.Error())
+		return nil, false, err.Error()
 	}
 
 	method := "GET"
+	streaming := false
 	var bodyStr string
 	headers := make(map[string]string)
 
@@ -33,6 +80,7 @@ func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
 			if m, ok := opts["method"].(string); ok {
 				method = strings.ToUpper(m)
 			}
+			streaming = runtimeOptionBool(opts, "stream", false)
 
 			if bodyArg, ok := opts["body"]; ok && bodyArg != nil {
 				switch v := bodyArg.(type) {
@@ -41,7 +89,7 @@ func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
 				case map[string]interface{}, []interface{}:
 					jsonBytes, err := json.Marshal(v)
 					if err != nil {
-						return r.createFetchError(fmt.Sprintf("failed to stringify body: %v", err))
+						return nil, false, fmt.Sprintf("failed to stringify body: %v", err)
 					}
 					bodyStr = string(jsonBytes)
 				default:
@@ -67,7 +115,7 @@ func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
 
 	req, err := http.NewRequest(method, urlStr, reqBody)
 	if err != nil {
-		return r.createFetchError(err.Error())
+		return nil, false, err.Error()
 	}
 	req = r.bindDownloadCancelContext(req)
 
@@ -81,42 +129,95 @@ func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	resp, err := r.httpClient.Do(req)
+	return req, streaming, ""
+}
+
+// performFetch runs off the VM goroutine and must not touch r.vm.
+func (r *extensionRuntime) performFetch(req *http.Request, streaming bool) *fetchResult {
+	client := r.httpClient
+	if streaming {
+		client = r.downloadClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return r.createFetchError(err.Error())
+		return &fetchResult{err: err.Error()}
 	}
-	defer resp.Body.Close()
 
+	result := &fetchResult{
+		status:     resp.StatusCode,
+		statusText: http.StatusText(resp.StatusCode),
+		headers:    flattenResponseHeaders(resp.Header),
+		url:        resp.Request.URL.String(),
+	}
+
+	if streaming {
+		stream, err := r.openHTTPStream(resp.Body, "utf8")
+		if err != nil {
+			return &fetchResult{err: err.Error()}
+		}
+		result.stream = stream
+		return result
+	}
+
+	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return r.createFetchError(err.Error())
+		return &fetchResult{err: err.Error()}
 	}
+	result.body = body
+	return result
+}
 
-	respHeaders := make(map[string]interface{})
-	for k, v := range resp.Header {
-		if len(v) == 1 {
-			respHeaders[k] = v[0]
-		} else {
-			respHeaders[k] = v
-		}
+func (r *extensionRuntime) newFetchResponse(result *fetchResult) *goja.Object {
+	if result.err != "" {
+		return r.createFetchError(result.err)
 	}
 
 	responseObj := r.vm.NewObject()
-	responseObj.Set("ok", resp.StatusCode >= 200 && resp.StatusCode < 300)
-	responseObj.Set("status", resp.StatusCode)
-	responseObj.Set("statusText", http.StatusText(resp.StatusCode))
-	responseObj.Set("headers", respHeaders)
-	responseObj.Set("url", resp.Request.URL.String())
-
-	bodyString := string(body)
+	responseObj.Set("ok", result.status >= 200 && result.status < 300)
+	responseObj.Set("status", result.status)
+	responseObj.Set("statusText", result.statusText)
+	responseObj.Set("headers", result.headers)
+	responseObj.Set("url", result.url)
+
+	// Buffered bodies are exposed through an untracked in-memory stream so
+	// response.body works the same way in both modes.
+	stream := result.stream
+	if stream == nil {
+		stream = &extensionHTTPStream{
+			runtime:  r,
+			body:     io.NopCloser(bytes.NewReader(result.body)),
+			encoding: "utf8",
+		}
+		stream.reader = bufio.NewReader(stream.body)
+	}
+	responseObj.Set("body", r.newStreamHandle(stream))
+
+	var body []byte
+	bodyRead := false
+	readBody := func() []byte {
+		if !bodyRead {
+			bodyRead = true
+			if result.stream != nil {
+				data, err := result.stream.readAll()
+				if err != nil {
+					GoLog("[Extension:%s] fetch body read error: %v\n", r.extensionID, err)
+				}
+				body = data
+			} else {
+				body = result.body
+			}
+		}
+		return body
+	}
 
 	responseObj.Set("text", func(call goja.FunctionCall) goja.Value {
-		return r.vm.ToValue(bodyString)
+		return r.vm.ToValue(string(readBody()))
 	})
 
 	responseObj.Set("json", func(call goja.FunctionCall) goja.Value {
 		var result interface{}
-		if err := json.Unmarshal(body, &result); err != nil {
+		if err := json.Unmarshal(readBody(), &result); err != nil {
 			GoLog("[Extension:%s] fetch json() parse error: %v\n", r.extensionID, err)
 			return goja.Undefined()
 		}
@@ -124,8 +225,9 @@ func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
 	})
 
 	responseObj.Set("arrayBuffer", func(call goja.FunctionCall) goja.Value {
-		byteArray := make([]interface{}, len(body))
-		for i, b := range body {
+		data := readBody()
+		byteArray := make([]interface{}, len(data))
+		for i, b := range data {
 			byteArray[i] = int(b)
 		}
 		return r.vm.ToValue(byteArray)
@@ -134,7 +236,7 @@ func (r *extensionRuntime) fetchPolyfill(call goja.FunctionCall) goja.Value {
 	return responseObj
 }
 
-func (r *extensionRuntime) createFetchError(message string) goja.Value {
+func (r *extensionRuntime) createFetchError(message string) *goja.Object {
 	errorObj := r.vm.NewObject()
 	errorObj.Set("ok", false)
 	errorObj.Set("status", 0)
@@ -21,8 +21,15 @@ const (
 	storageFlushRetryDelay   = 2 * time.Second
 )
 
+// Files in an extension's data directory.
+const (
+	storageFileName         = "storage.json"
+	credentialsFileName     = ".credentials.enc"
+	credentialsSaltFileName = ".cred_salt"
+)
+
 func (r *extensionRuntime) getStoragePath() string {
-	return filepath.Join(r.dataDir, "storage.json")
+	return filepath.Join(r.dataDir, storageFileName)
 }
 
 func cloneInterfaceMap(src map[string]interface{}) map[string]interface{} {
@@ -55,6 +62,8 @@ func (r *extensionRuntime) ensureStorageLoaded() error {
 	if err != nil {
 		if os.IsNotExist(err) {
 			r.storageCache = make(map[string]interface{})
+			r.storageSizes = make(map[string]int64)
+			r.storageBytes = 0
 			r.storageLoaded = true
 			return nil
 		}
@@ -70,10 +79,40 @@ func (r *extensionRuntime) ensureStorageLoaded() error {
 	}
 
 	r.storageCache = storage
+	r.storageSizes = make(map[string]int64, len(storage))
+	r.storageBytes = 0
+	for key, value := range storage {
+		size := storageEntrySize(key, value)
+		r.storageSizes[key] = size
+		r.storageBytes += size
+	}
+	if r.quota != nil {
+		r.quota.noteStorageBytes(r.storageBytes)
+	}
 	r.storageLoaded = true
 	return nil
 }
 
+// storageEntrySize approximates the bytes an entry adds to storage.json.
+func storageEntrySize(key string, value interface{}) int64 {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return int64(len(key))
+	}
+	return int64(len(key) + len(encoded) + 4)
+}
+
+// throwQuotaError raises err in JS as a QuotaExceededError carrying the
+// quota name, usage and limit.
+func (r *extensionRuntime) throwQuotaError(err *ExtensionQuotaError) {
+	jsErr := r.vm.NewGoError(err)
+	jsErr.Set("name", "QuotaExceededError")
+	jsErr.Set("quota", err.Quota)
+	jsErr.Set("used", err.Used)
+	jsErr.Set("limit", err.Limit)
+	panic(jsErr)
+}
+
 func (r *extensionRuntime) loadStorage() (map[string]interface{}, error) {
 	if err := r.ensureStorageLoaded(); err != nil {
 		return nil, err
@@ -206,6 +245,8 @@ func (r *extensionRuntime) storageSet(call goja.FunctionCall) goja.Value {
 		return r.vm.ToValue(false)
 	}
 
+	size := storageEntrySize(key, value)
+
 	r.storageMu.Lock()
 	if r.storageClosed {
 		r.storageMu.Unlock()
@@ -217,7 +258,16 @@ func (r *extensionRuntime) storageSet(call goja.FunctionCall) goja.Value {
 			return r.vm.ToValue(true)
 		}
 	}
+	nextBytes := r.storageBytes - r.storageSizes[key] + size
+	if r.quota != nil {
+		if err := r.quota.checkStorage(nextBytes); err != nil {
+			r.storageMu.Unlock()
+			r.throwQuotaError(err.(*ExtensionQuotaError))
+		}
+	}
 	r.storageCache[key] = value
+	r.storageSizes[key] = size
+	r.storageBytes = nextBytes
 	r.storageDirty = true
 	r.queueStorageFlushLocked(r.storageFlushDelay)
 	r.storageMu.Unlock()
@@ -247,6 +297,11 @@ func (r *extensionRuntime) storageRemove(call goja.FunctionCall) goja.Value {
 		return r.vm.ToValue(true)
 	}
 	delete(r.storageCache, key)
+	r.storageBytes -= r.storageSizes[key]
+	delete(r.storageSizes, key)
+	if r.quota != nil {
+		r.quota.noteStorageBytes(r.storageBytes)
+	}
 	r.storageDirty = true
 	r.queueStorageFlushLocked(r.storageFlushDelay)
 	r.storageMu.Unlock()
@@ -255,16 +310,18 @@ func (r *extensionRuntime) storageRemove(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) getCredentialsPath() string {
-	return filepath.Join(r.dataDir, ".credentials.enc")
+	return filepath.Join(r.dataDir, credentialsFileName)
 }
 
 func (r *extensionRuntime) getSaltPath() string {
-	return filepath.Join(r.dataDir, ".cred_salt")
+	return filepath.Join(r.dataDir, credentialsSaltFileName)
 }
 
 func (r *extensionRuntime) getOrCreateSalt() ([]byte, error) {
-	saltPath := r.getSaltPath()
+	return getOrCreateCredentialsSalt(r.getSaltPath())
+}
 
+func getOrCreateCredentialsSalt(saltPath string) ([]byte, error) {
 	salt, err := os.ReadFile(saltPath)
 	if err == nil && len(salt) == 32 {
 		return salt, nil
@@ -288,9 +345,15 @@ func (r *extensionRuntime) getEncryptionKey() ([]byte, error) {
 		return nil, err
 	}
 
-	combined := append([]byte(r.extensionID), salt...)
+	return extensionCredentialsKey(r.extensionID, salt), nil
+}
+
+// extensionCredentialsKey derives the key for .credentials.enc from the
+// extension ID and the per-install salt.
+func extensionCredentialsKey(extensionID string, salt []byte) []byte {
+	combined := append([]byte(extensionID), salt...)
 	hash := sha256.Sum256(combined)
-	return hash[:], nil
+	return hash[:]
 }
 
 func (r *extensionRuntime) ensureCredentialsLoaded() error {
@@ -334,6 +397,9 @@ func (r *extensionRuntime) ensureCredentialsLoaded() error {
 	if creds == nil {
 		creds = make(map[string]interface{})
 	}
+	if r.quota != nil {
+		r.quota.noteCredentialsBytes(int64(len(decrypted)))
+	}
 
 	r.credentialsCache = creds
 	r.credentialsLoaded = true
@@ -355,6 +421,11 @@ func (r *extensionRuntime) saveCredentials(creds map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
+	if r.quota != nil {
+		if err := r.quota.checkCredentials(int64(len(data))); err != nil {
+			return err
+		}
+	}
 
 	key, err := r.getEncryptionKey()
 	if err != nil {
@@ -378,6 +449,13 @@ func (r *extensionRuntime) saveCredentials(creds map[string]interface{}) error {
 }
 
 func (r *extensionRuntime) credentialsStore(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("credentials.store", PermissionCredentials, ""); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
 	if len(call.Arguments) < 2 {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -403,10 +481,14 @@ func (r *extensionRuntime) credentialsStore(call goja.FunctionCall) goja.Value {
 
 	if err := r.saveCredentials(nextCreds); err != nil {
 		GoLog("[Extension:%s] Credentials save error: %v\n", r.extensionID, err)
-		return r.vm.ToValue(map[string]interface{}{
+		result := map[string]interface{}{
 			"success": false,
 			"error":   err.Error(),
-		})
+		}
+		if quotaErr, ok := err.(*ExtensionQuotaError); ok {
+			result["quota"] = quotaErr.Quota
+		}
+		return r.vm.ToValue(result)
 	}
 
 	return r.vm.ToValue(map[string]interface{}{
@@ -415,6 +497,10 @@ func (r *extensionRuntime) credentialsStore(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) credentialsGet(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("credentials.get", PermissionCredentials, ""); err != nil {
+		return goja.Undefined()
+	}
+
 	if len(call.Arguments) < 1 {
 		return goja.Undefined()
 	}
@@ -440,6 +526,10 @@ func (r *extensionRuntime) credentialsGet(call goja.FunctionCall) goja.Value {
 }
 
 func (r *extensionRuntime) credentialsRemove(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("credentials.remove", PermissionCredentials, ""); err != nil {
+		return r.vm.ToValue(false)
+	}
+
 	if len(call.Arguments) < 1 {
 		return r.vm.ToValue(false)
 	}
@@ -465,6 +555,10 @@ func (r *extensionRuntime) credentialsRemove(call goja.FunctionCall) goja.Value
 }
 
 func (r *extensionRuntime) credentialsHas(call goja.FunctionCall) goja.Value {
+	if err := r.requirePermission("credentials.has", PermissionCredentials, ""); err != nil {
+		return r.vm.ToValue(false)
+	}
+
 	if len(call.Arguments) < 1 {
 		return r.vm.ToValue(false)
 	}
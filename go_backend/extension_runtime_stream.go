@@ -0,0 +1,368 @@
+package gobackend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	defaultStreamReadSize = 64 * 1024
+	maxStreamReadSize     = 4 * 1024 * 1024
+	maxStreamLineBytes    = 4 * 1024 * 1024
+	maxOpenHTTPStreams    = 16
+)
+
+// extensionHTTPStream is an open response body read incrementally by an
+// extension. Streams are tracked on the runtime so teardown closes any the
+// extension forgot about. readMu only serializes readers; close never takes
+// it, so closing the body unblocks a read stuck on a stalled server.
+type extensionHTTPStream struct {
+	runtime  *extensionRuntime
+	body     io.ReadCloser
+	reader   *bufio.Reader
+	encoding string
+
+	readMu sync.Mutex
+	closed atomic.Bool
+}
+
+func (r *extensionRuntime) openHTTPStream(body io.ReadCloser, encoding string) (*extensionHTTPStream, error) {
+	r.streamsMu.Lock()
+	defer r.streamsMu.Unlock()
+	if len(r.streams) >= maxOpenHTTPStreams {
+		body.Close()
+		return nil, fmt.Errorf("too many open streams (max %d), close unused streams first", maxOpenHTTPStreams)
+	}
+	if r.streams == nil {
+		r.streams = make(map[*extensionHTTPStream]struct{})
+	}
+	stream := &extensionHTTPStream{
+		runtime:  r,
+		body:     body,
+		reader:   bufio.NewReaderSize(body, defaultStreamReadSize),
+		encoding: encoding,
+	}
+	r.streams[stream] = struct{}{}
+	return stream, nil
+}
+
+func (r *extensionRuntime) closeHTTPStreams() {
+	r.streamsMu.Lock()
+	streams := make([]*extensionHTTPStream, 0, len(r.streams))
+	for stream := range r.streams {
+		streams = append(streams, stream)
+	}
+	r.streamsMu.Unlock()
+
+	for _, stream := range streams {
+		stream.close()
+	}
+}
+
+func (s *extensionHTTPStream) close() {
+	if !s.closed.CompareAndSwap(false, true) {
+		return
+	}
+	s.body.Close()
+
+	s.runtime.streamsMu.Lock()
+	delete(s.runtime.streams, s)
+	s.runtime.streamsMu.Unlock()
+}
+
+// read returns up to n bytes, blocking until at least one is available.
+// It returns io.EOF once the body is exhausted and closes the stream.
+func (s *extensionHTTPStream) read(n int) ([]byte, error) {
+	if n <= 0 {
+		n = defaultStreamReadSize
+	}
+	if n > maxStreamReadSize {
+		n = maxStreamReadSize
+	}
+
+	s.readMu.Lock()
+	if s.closed.Load() {
+		s.readMu.Unlock()
+		return nil, io.EOF
+	}
+	buf := make([]byte, n)
+	var read int
+	var err error
+	for read == 0 && err == nil {
+		read, err = s.reader.Read(buf)
+	}
+	s.readMu.Unlock()
+
+	if read > 0 {
+		return buf[:read], nil
+	}
+	if s.closed.Load() {
+		err = io.EOF
+	}
+	s.close()
+	return nil, err
+}
+
+// readLine returns the next line without its line terminator.
+func (s *extensionHTTPStream) readLine() (string, error) {
+	s.readMu.Lock()
+	if s.closed.Load() {
+		s.readMu.Unlock()
+		return "", io.EOF
+	}
+	var line []byte
+	var err error
+	for {
+		var chunk []byte
+		chunk, err = s.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			break
+		}
+		if len(line) > maxStreamLineBytes {
+			err = fmt.Errorf("line exceeds %d bytes", maxStreamLineBytes)
+			break
+		}
+	}
+	s.readMu.Unlock()
+
+	if err != nil && !(err == io.EOF && len(line) > 0) {
+		if s.closed.Load() {
+			err = io.EOF
+		}
+		s.close()
+		return "", err
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+func (s *extensionHTTPStream) readAll() ([]byte, error) {
+	s.readMu.Lock()
+	if s.closed.Load() {
+		s.readMu.Unlock()
+		return nil, nil
+	}
+	data, err := io.ReadAll(s.reader)
+	s.readMu.Unlock()
+	s.close()
+	return data, err
+}
+
+// newStreamHandle exposes stream to JS. read(n) and readLine() block and
+// return null at the end of the body; getReader() provides a web-style
+// reader whose read() resolves on the event loop.
+func (r *extensionRuntime) newStreamHandle(stream *extensionHTTPStream) *goja.Object {
+	handle := r.vm.NewObject()
+
+	handle.Set("read", func(call goja.FunctionCall) goja.Value {
+		n := 0
+		if len(call.Arguments) > 0 && !goja.IsUndefined(call.Arguments[0]) {
+			n = int(call.Arguments[0].ToInteger())
+		}
+		data, err := stream.read(n)
+		if err == io.EOF {
+			return goja.Null()
+		}
+		if err != nil {
+			panic(r.vm.NewGoError(err))
+		}
+		encoded, err := encodeRuntimeBytes(data, stream.encoding)
+		if err != nil {
+			panic(r.vm.NewGoError(err))
+		}
+		return r.vm.ToValue(encoded)
+	})
+
+	handle.Set("readLine", func(call goja.FunctionCall) goja.Value {
+		line, err := stream.readLine()
+		if err == io.EOF {
+			return goja.Null()
+		}
+		if err != nil {
+			panic(r.vm.NewGoError(err))
+		}
+		return r.vm.ToValue(line)
+	})
+
+	handle.Set("close", func(call goja.FunctionCall) goja.Value {
+		stream.close()
+		return goja.Undefined()
+	})
+
+	handle.Set("getReader", func(call goja.FunctionCall) goja.Value {
+		return r.newStreamReader(stream)
+	})
+
+	return handle
+}
+
+func (r *extensionRuntime) newStreamReader(stream *extensionHTTPStream) *goja.Object {
+	reader := r.vm.NewObject()
+
+	reader.Set("read", func(call goja.FunctionCall) goja.Value {
+		promise, resolve, reject := r.vm.NewPromise()
+		settle := func(data []byte, err error) {
+			result := r.vm.NewObject()
+			switch {
+			case err == io.EOF:
+				result.Set("done", true)
+				result.Set("value", goja.Undefined())
+			case err != nil:
+				_ = reject(r.vm.NewGoError(err))
+				return
+			default:
+				array, _ := r.vm.New(r.vm.Get("Uint8Array"), r.vm.ToValue(r.vm.NewArrayBuffer(data)))
+				result.Set("done", false)
+				result.Set("value", array)
+			}
+			_ = resolve(result)
+		}
+
+		if r.eventLoop == nil {
+			settle(stream.read(defaultStreamReadSize))
+			return r.vm.ToValue(promise)
+		}
+		complete := r.eventLoop.startAsync()
+		go func() {
+			data, err := stream.read(defaultStreamReadSize)
+			complete(func() { settle(data, err) })
+		}()
+		return r.vm.ToValue(promise)
+	})
+
+	reader.Set("cancel", func(call goja.FunctionCall) goja.Value {
+		stream.close()
+		promise, resolve, _ := r.vm.NewPromise()
+		_ = resolve(goja.Undefined())
+		return r.vm.ToValue(promise)
+	})
+
+	reader.Set("releaseLock", func(call goja.FunctionCall) goja.Value {
+		return goja.Undefined()
+	})
+
+	return reader
+}
+
+// httpStream implements http.stream(url, options). Options accept method,
+// headers, body and encoding ("utf8" by default, "base64" or "hex" for
+// binary data). The request goes through the same domain allowlist,
+// private-network checks and download cancellation as http.request.
+func (r *extensionRuntime) httpStream(call goja.FunctionCall) goja.Value {
+	if len(call.Arguments) < 1 {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": "URL is required",
+		})
+	}
+
+	urlStr := call.Arguments[0].String()
+	if err := r.validateDomain(urlStr); err != nil {
+		GoLog("[Extension:%s] HTTP stream blocked: %v\n", r.extensionID, err)
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	options := parseRuntimeOptionsArgument(call, 1)
+	method := strings.ToUpper(runtimeOptionString(options, "method", "GET"))
+	encoding := strings.ToLower(runtimeOptionString(options, "encoding", "utf8"))
+	if _, err := encodeRuntimeBytes(nil, encoding); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	var bodyStr string
+	if options != nil {
+		switch v := options["body"].(type) {
+		case nil:
+		case string:
+			bodyStr = v
+		case map[string]interface{}, []interface{}:
+			jsonBytes, err := json.Marshal(v)
+			if err != nil {
+				return r.vm.ToValue(map[string]interface{}{
+					"error": fmt.Sprintf("failed to stringify body: %v", err),
+				})
+			}
+			bodyStr = string(jsonBytes)
+		default:
+			bodyStr = fmt.Sprintf("%v", v)
+		}
+	}
+
+	var reqBody io.Reader
+	if bodyStr != "" {
+		reqBody = strings.NewReader(bodyStr)
+	}
+	req, err := http.NewRequest(method, urlStr, reqBody)
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	req = r.bindDownloadCancelContext(req)
+
+	if options != nil {
+		if h, ok := options["headers"].(map[string]interface{}); ok {
+			for k, v := range h {
+				req.Header.Set(k, fmt.Sprintf("%v", v))
+			}
+		}
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "Spotiflac-Extension/1.0")
+	}
+	if bodyStr != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// The download client's deadline is DownloadTimeout (24h), so the short
+	// API timeout does not cut long-lived bodies off mid-read; streams end
+	// earlier through the request context and close().
+	resp, err := r.downloadClient.Do(req)
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	stream, err := r.openHTTPStream(resp.Body, encoding)
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	handle := r.newStreamHandle(stream)
+	handle.Set("statusCode", resp.StatusCode)
+	handle.Set("status", resp.StatusCode)
+	handle.Set("ok", resp.StatusCode >= 200 && resp.StatusCode < 300)
+	handle.Set("url", resp.Request.URL.String())
+	handle.Set("headers", flattenResponseHeaders(resp.Header))
+	handle.Set("contentLength", resp.ContentLength)
+	return handle
+}
+
+func flattenResponseHeaders(header http.Header) map[string]interface{} {
+	respHeaders := make(map[string]interface{})
+	for k, v := range header {
+		if len(v) == 1 {
+			respHeaders[k] = v[0]
+		} else {
+			respHeaders[k] = v
+		}
+	}
+	return respHeaders
+}
@@ -0,0 +1,225 @@
+package gobackend
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStreamTestRuntime returns a runtime whose HTTP clients reach server for
+// any allowed host, so requests still pass through validateDomain.
+func newStreamTestRuntime(t *testing.T, server *httptest.Server) (*extensionRuntime, func(string) (string, error)) {
+	t.Helper()
+	runtime, vm := newTestExtensionRuntime(t, "stream-ext", ExtensionPermissions{Network: []string{"stream.test"}})
+
+	serverAddr := server.Listener.Addr().String()
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, serverAddr)
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	runtime.httpClient.Transport = transport
+	runtime.downloadClient.Transport = transport
+
+	run := func(script string) (string, error) {
+		result, err := RunWithTimeoutAndRecover(vm, script, 5*time.Second)
+		if err != nil {
+			return "", err
+		}
+		return result.String(), nil
+	}
+	return runtime, run
+}
+
+func TestHTTPStream_ReadLineAndRead(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Header().Set("X-Test", "stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "{\"line\":%d}\r\n", i)
+			flusher.Flush()
+		}
+		fmt.Fprint(w, "tail-without-newline")
+	}))
+	defer server.Close()
+
+	runtime, run := newStreamTestRuntime(t, server)
+
+	got, err := run(`
+		(function() {
+			var s = http.stream('https://stream.test/feed');
+			if (s.error) return 'error: ' + s.error;
+			var lines = [];
+			for (var i = 0; i < 3; i++) {
+				lines.push(JSON.parse(s.readLine()).line);
+			}
+			var rest = '';
+			var chunk;
+			while ((chunk = s.read(4)) !== null) {
+				rest += chunk;
+			}
+			return [s.status, s.headers['X-Test'], lines.join(','), rest, s.readLine()].join('|');
+		})()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "200|stream|1,2,3|tail-without-newline|" {
+		t.Fatalf("result = %q", got)
+	}
+	if len(runtime.streams) != 0 {
+		t.Fatalf("stream not released after EOF: %d open", len(runtime.streams))
+	}
+}
+
+func TestHTTPStream_BinaryEncodingAndClose(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x00, 0xff, 0x10, 0x20})
+	}))
+	defer server.Close()
+
+	runtime, run := newStreamTestRuntime(t, server)
+
+	got, err := run(`
+		(function() {
+			var s = http.stream('https://stream.test/bin', { encoding: 'hex' });
+			var first = s.read(2);
+			s.close();
+			return first + '|' + s.read(2);
+		})()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "00ff|null" {
+		t.Fatalf("result = %q", got)
+	}
+	if len(runtime.streams) != 0 {
+		t.Fatalf("closed stream still tracked")
+	}
+}
+
+func TestHTTPStream_FetchBodyReader(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("x", 100000))
+	}))
+	defer server.Close()
+
+	_, run := newStreamTestRuntime(t, server)
+
+	got, err := run(`
+		(async function() {
+			var res = await fetch('https://stream.test/big', { stream: true });
+			var reader = res.body.getReader();
+			var total = 0;
+			var chunks = 0;
+			while (true) {
+				var part = await reader.read();
+				if (part.done) break;
+				total += part.value.length;
+				chunks++;
+			}
+			var buffered = await fetch('https://stream.test/big');
+			return total + '|' + (chunks > 0) + '|' + buffered.text().length + '|' + buffered.body.read(5);
+		})()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "100000|true|100000|xxxxx" {
+		t.Fatalf("result = %q", got)
+	}
+}
+
+func TestHTTPStream_CloseUnblocksStalledRead(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	runtime, run := newStreamTestRuntime(t, server)
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := run(`
+			(function() {
+				var s = http.stream('https://stream.test/stalled');
+				if (s.error) return 'error: ' + s.error;
+				return String(s.read());
+			})()
+		`)
+		done <- outcome{result, err}
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		runtime.streamsMu.Lock()
+		open := len(runtime.streams)
+		runtime.streamsMu.Unlock()
+		if open == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream was never opened")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		runtime.closeHTTPStreams()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("closeHTTPStreams blocked behind a stalled read")
+	}
+
+	select {
+	case got := <-done:
+		if got.err != nil {
+			t.Fatalf("script failed: %v", got.err)
+		}
+		if got.result != "null" {
+			t.Fatalf("result = %q, want null after close", got.result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read stayed blocked after the stream was closed")
+	}
+}
+
+func TestHTTPStream_RespectsSandbox(t *testing.T) {
+	_, vm := newTestExtensionRuntime(t, "eventloop-ext", eventLoopTestPermissions)
+
+	for _, url := range []string{
+		"https://127.0.0.1/feed",
+		"https://notallowed.example.org/feed",
+		"http://api.allowed.com/feed",
+	} {
+		vm.Set("target", url)
+		result, err := RunWithTimeoutAndRecover(vm, `http.stream(target).error || ''`, 5*time.Second)
+		if err != nil {
+			t.Fatalf("script failed: %v", err)
+		}
+		if result.String() == "" {
+			t.Errorf("expected %s to be blocked", url)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package gobackend
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	maxOpenWebSockets      = 8
+	webSocketDialTimeout   = 30 * time.Second
+	webSocketQueueSize     = 256
+	webSocketMaxFrameBytes = 16 * 1024 * 1024
+)
+
+type webSocketMessage struct {
+	data   []byte
+	binary bool
+}
+
+// webSocketCodec is websocket.Message that keeps the frame type, so text
+// and binary messages can be told apart on receive.
+var webSocketCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		msg := v.(webSocketMessage)
+		if msg.binary {
+			return msg.data, websocket.BinaryFrame, nil
+		}
+		return msg.data, websocket.TextFrame, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		msg := v.(*webSocketMessage)
+		msg.data = data
+		msg.binary = payloadType == websocket.BinaryFrame
+		return nil
+	},
+}
+
+// extensionWebSocket is a client connection opened by ws.connect. A reader
+// goroutine owns the receive side and puts every message into a bounded
+// queue, which receive() reads from or, once an onMessage handler is set,
+// the event loop drains in order. When the queue is full the reader stops
+// reading, so a fast server cannot grow memory.
+type extensionWebSocket struct {
+	runtime  *extensionRuntime
+	conn     *websocket.Conn
+	url      string
+	encoding string
+	messages chan webSocketMessage
+	stop     chan struct{}
+	closed   chan struct{}
+
+	mu        sync.Mutex
+	closing   bool
+	closeErr  error
+	onMessage goja.Callable
+	// dispatchQueued is set while a job draining messages to onMessage is
+	// waiting on the event loop.
+	dispatchQueued bool
+	onClose        []goja.Callable
+	onError        []goja.Callable
+	loopNotify     func(job func())
+}
+
+// dialWebSocketTLS connects to addr and completes the TLS handshake. Like
+// extension HTTP traffic, the connection goes through dialExtension, so DNS
+// rebinding cannot reach a private network after validateDomain passed.
+func dialWebSocketTLS(ctx context.Context, addr, serverName string) (net.Conn, error) {
+	rawConn, err := dialExtension(ctx, &net.Dialer{Timeout: webSocketDialTimeout}, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return webSocketTLSHandshake(ctx, rawConn, serverName)
+}
+
+func webSocketTLSHandshake(ctx context.Context, rawConn net.Conn, serverName string) (net.Conn, error) {
+	tlsConn := tls.Client(rawConn, &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// wsConnect implements ws.connect(url, headers, options). Only wss:// URLs
+// are accepted and they go through the same allowlist and private-network
+// checks as https requests. options.encoding ("base64" by default, or "hex")
+// controls how binary frames are passed to JS.
+func (r *extensionRuntime) wsConnect(call goja.FunctionCall) goja.Value {
+	if len(call.Arguments) < 1 {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": "URL is required",
+		})
+	}
+
+	urlStr := call.Arguments[0].String()
+	parsed, err := url.Parse(urlStr)
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": fmt.Sprintf("invalid URL: %v", err),
+		})
+	}
+	if parsed.Scheme != "wss" {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": "network access denied: only wss is allowed",
+		})
+	}
+
+	httpsURL := *parsed
+	httpsURL.Scheme = "https"
+	if err := r.validateDomain(httpsURL.String()); err != nil {
+		GoLog("[Extension:%s] WebSocket blocked: %v\n", r.extensionID, err)
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	options := parseRuntimeOptionsArgument(call, 2)
+	encoding := strings.ToLower(runtimeOptionString(options, "encoding", "base64"))
+	if _, err := encodeRuntimeBytes(nil, encoding); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	config, err := websocket.NewConfig(urlStr, "https://"+parsed.Host)
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	if len(call.Arguments) > 1 && !goja.IsUndefined(call.Arguments[1]) && !goja.IsNull(call.Arguments[1]) {
+		if h, ok := call.Arguments[1].Export().(map[string]interface{}); ok {
+			for k, v := range h {
+				if strings.EqualFold(k, "Origin") {
+					if origin, err := url.ParseRequestURI(fmt.Sprintf("%v", v)); err == nil {
+						config.Origin = origin
+					}
+					continue
+				}
+				config.Header.Set(k, fmt.Sprintf("%v", v))
+			}
+		}
+	}
+	if config.Header.Get("User-Agent") == "" {
+		config.Header.Set("User-Agent", "Spotiflac-Extension/1.0")
+	}
+
+	// Reuse the download cancellation plumbing: cancelling the active item
+	// closes sockets opened while it was running.
+	cancelReq, _ := http.NewRequest("GET", httpsURL.String(), nil)
+	cancelCtx := r.bindDownloadCancelContext(cancelReq).Context()
+
+	r.webSocketsMu.Lock()
+	openCount := len(r.webSockets)
+	r.webSocketsMu.Unlock()
+	if openCount >= maxOpenWebSockets {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": fmt.Sprintf("too many open WebSockets (max %d)", maxOpenWebSockets),
+		})
+	}
+
+	addr := parsed.Host
+	if parsed.Port() == "" {
+		addr = net.JoinHostPort(parsed.Hostname(), "443")
+	}
+	dial := r.webSocketDial
+	if dial == nil {
+		dial = dialWebSocketTLS
+	}
+
+	dialCtx, cancelDial := context.WithTimeout(cancelCtx, webSocketDialTimeout)
+	defer cancelDial()
+	netConn, err := dial(dialCtx, addr, parsed.Hostname())
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	_ = netConn.SetDeadline(time.Now().Add(webSocketDialTimeout))
+	conn, err := websocket.NewClient(config, netConn)
+	if err != nil {
+		netConn.Close()
+		return r.vm.ToValue(map[string]interface{}{
+			"error": fmt.Sprintf("WebSocket handshake failed: %v", err),
+		})
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	conn.MaxPayloadBytes = webSocketMaxFrameBytes
+
+	socket := &extensionWebSocket{
+		runtime:  r,
+		conn:     conn,
+		url:      urlStr,
+		encoding: encoding,
+		messages: make(chan webSocketMessage, webSocketQueueSize),
+		stop:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	if r.eventLoop != nil {
+		socket.loopNotify = r.eventLoop.startAsync()
+	}
+
+	r.webSocketsMu.Lock()
+	if r.webSockets == nil {
+		r.webSockets = make(map[*extensionWebSocket]struct{})
+	}
+	r.webSockets[socket] = struct{}{}
+	r.webSocketsMu.Unlock()
+
+	go socket.readLoop()
+	go func() {
+		select {
+		case <-cancelCtx.Done():
+			socket.close(fmt.Errorf("download cancelled"))
+		case <-socket.closed:
+		}
+	}()
+
+	GoLog("[Extension:%s] WebSocket connected: %s\n", r.extensionID, parsed.Host)
+	return r.newWebSocketHandle(socket)
+}
+
+func (s *extensionWebSocket) readLoop() {
+	var readErr error
+	for readErr == nil {
+		var msg webSocketMessage
+		if err := webSocketCodec.Receive(s.conn, &msg); err != nil {
+			readErr = err
+			break
+		}
+
+		select {
+		case s.messages <- msg:
+			s.scheduleDispatch()
+		case <-s.stop:
+			readErr = io.EOF
+		}
+	}
+	s.finish(readErr)
+}
+
+// scheduleDispatch queues a job on the event loop that hands queued messages
+// to the onMessage handler, unless one is already waiting.
+func (s *extensionWebSocket) scheduleDispatch() {
+	s.mu.Lock()
+	if s.onMessage == nil || s.dispatchQueued || s.runtime.eventLoop == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.dispatchQueued = true
+	s.mu.Unlock()
+	s.runtime.eventLoop.enqueue(func() { s.dispatchQueuedMessages(webSocketQueueSize) })
+}
+
+// dispatchQueuedMessages passes up to limit queued messages to the handler
+// and schedules another run when more are left.
+func (s *extensionWebSocket) dispatchQueuedMessages(limit int) {
+	s.mu.Lock()
+	s.dispatchQueued = false
+	s.mu.Unlock()
+
+	for i := 0; i < limit; i++ {
+		select {
+		case msg := <-s.messages:
+			s.dispatch(msg)
+		default:
+			return
+		}
+	}
+	if len(s.messages) > 0 {
+		s.scheduleDispatch()
+	}
+}
+
+// close closes the connection; the reader goroutine then reports the close.
+func (s *extensionWebSocket) close(reason error) {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	s.closing = true
+	if reason != nil {
+		s.closeErr = reason
+	}
+	s.mu.Unlock()
+	close(s.stop)
+	s.conn.Close()
+}
+
+// finish runs once on the reader goroutine after the connection is gone.
+func (s *extensionWebSocket) finish(readErr error) {
+	s.mu.Lock()
+	wasClosing := s.closing
+	s.closing = true
+	closeErr := s.closeErr
+	if closeErr == nil && !wasClosing && readErr != nil && readErr != io.EOF {
+		closeErr = readErr
+	}
+	s.closeErr = closeErr
+	notify := s.loopNotify
+	s.mu.Unlock()
+
+	if !wasClosing {
+		s.conn.Close()
+	}
+	close(s.closed)
+
+	s.runtime.webSocketsMu.Lock()
+	delete(s.runtime.webSockets, s)
+	s.runtime.webSocketsMu.Unlock()
+
+	if notify != nil {
+		notify(s.dispatchClose)
+	}
+}
+
+func (s *extensionWebSocket) messageValue(msg webSocketMessage) goja.Value {
+	vm := s.runtime.vm
+	obj := vm.NewObject()
+	obj.Set("binary", msg.binary)
+	if msg.binary {
+		encoded, _ := encodeRuntimeBytes(msg.data, s.encoding)
+		obj.Set("data", encoded)
+	} else {
+		obj.Set("data", string(msg.data))
+	}
+	return obj
+}
+
+func (s *extensionWebSocket) dispatch(msg webSocketMessage) {
+	s.mu.Lock()
+	handler := s.onMessage
+	s.mu.Unlock()
+	if handler == nil {
+		return
+	}
+	if _, err := handler(goja.Undefined(), s.messageValue(msg)); err != nil {
+		GoLog("[Extension:%s] WebSocket onMessage error: %v\n", s.runtime.extensionID, err)
+	}
+}
+
+func (s *extensionWebSocket) dispatchClose() {
+	// Messages that arrived before the close are delivered first.
+	s.mu.Lock()
+	hasHandler := s.onMessage != nil
+	s.mu.Unlock()
+	if hasHandler {
+		s.dispatchQueuedMessages(cap(s.messages))
+	}
+
+	s.mu.Lock()
+	closeErr := s.closeErr
+	onClose := append([]goja.Callable(nil), s.onClose...)
+	onError := append([]goja.Callable(nil), s.onError...)
+	s.mu.Unlock()
+
+	vm := s.runtime.vm
+	if closeErr != nil {
+		for _, handler := range onError {
+			if _, err := handler(goja.Undefined(), vm.ToValue(closeErr.Error())); err != nil {
+				GoLog("[Extension:%s] WebSocket onError error: %v\n", s.runtime.extensionID, err)
+			}
+		}
+	}
+	for _, handler := range onClose {
+		if _, err := handler(goja.Undefined()); err != nil {
+			GoLog("[Extension:%s] WebSocket onClose error: %v\n", s.runtime.extensionID, err)
+		}
+	}
+}
+
+func (s *extensionWebSocket) isOpen() bool {
+	select {
+	case <-s.closed:
+		return false
+	default:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closing
+}
+
+func (r *extensionRuntime) newWebSocketHandle(socket *extensionWebSocket) *goja.Object {
+	handle := r.vm.NewObject()
+	handle.Set("url", socket.url)
+
+	send := func(binary bool) func(call goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			if len(call.Arguments) < 1 {
+				return r.vm.ToValue(map[string]interface{}{"success": false, "error": "data is required"})
+			}
+			if !socket.isOpen() {
+				return r.vm.ToValue(map[string]interface{}{"success": false, "error": "WebSocket is closed"})
+			}
+			msg := webSocketMessage{binary: binary}
+			if binary {
+				encoding := socket.encoding
+				if len(call.Arguments) > 1 && !goja.IsUndefined(call.Arguments[1]) {
+					encoding = call.Arguments[1].String()
+				}
+				data, err := decodeRuntimeBytesValue(call.Arguments[0].Export(), encoding)
+				if err != nil {
+					return r.vm.ToValue(map[string]interface{}{"success": false, "error": err.Error()})
+				}
+				msg.data = data
+			} else {
+				msg.data = []byte(call.Arguments[0].String())
+			}
+			if err := webSocketCodec.Send(socket.conn, msg); err != nil {
+				return r.vm.ToValue(map[string]interface{}{"success": false, "error": err.Error()})
+			}
+			return r.vm.ToValue(map[string]interface{}{"success": true})
+		}
+	}
+	handle.Set("send", send(false))
+	handle.Set("sendBinary", send(true))
+
+	// receive(timeoutMs) blocks for the next queued message and returns null
+	// on timeout or once the socket is closed and drained.
+	handle.Set("receive", func(call goja.FunctionCall) goja.Value {
+		timeout := 30 * time.Second
+		if len(call.Arguments) > 0 && !goja.IsUndefined(call.Arguments[0]) {
+			timeout = time.Duration(call.Arguments[0].ToInteger()) * time.Millisecond
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case msg := <-socket.messages:
+			return socket.messageValue(msg)
+		default:
+		}
+		select {
+		case msg := <-socket.messages:
+			return socket.messageValue(msg)
+		case <-socket.closed:
+			select {
+			case msg := <-socket.messages:
+				return socket.messageValue(msg)
+			default:
+				return goja.Null()
+			}
+		case <-timer.C:
+			return goja.Null()
+		}
+	})
+
+	handle.Set("onMessage", func(call goja.FunctionCall) goja.Value {
+		handler, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			panic(r.vm.NewTypeError("handler must be a function"))
+		}
+		socket.mu.Lock()
+		socket.onMessage = handler
+		socket.mu.Unlock()
+
+		// Messages that arrived before the handler was set go to it first.
+		socket.scheduleDispatch()
+		return goja.Undefined()
+	})
+
+	handle.Set("onClose", func(call goja.FunctionCall) goja.Value {
+		handler, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			panic(r.vm.NewTypeError("handler must be a function"))
+		}
+		socket.mu.Lock()
+		socket.onClose = append(socket.onClose, handler)
+		socket.mu.Unlock()
+		return goja.Undefined()
+	})
+
+	handle.Set("onError", func(call goja.FunctionCall) goja.Value {
+		handler, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			panic(r.vm.NewTypeError("handler must be a function"))
+		}
+		socket.mu.Lock()
+		socket.onError = append(socket.onError, handler)
+		socket.mu.Unlock()
+		return goja.Undefined()
+	})
+
+	handle.Set("isOpen", func(call goja.FunctionCall) goja.Value {
+		return r.vm.ToValue(socket.isOpen())
+	})
+
+	handle.Set("close", func(call goja.FunctionCall) goja.Value {
+		socket.close(nil)
+		return goja.Undefined()
+	})
+
+	return handle
+}
+
+func (r *extensionRuntime) closeWebSockets() {
+	r.webSocketsMu.Lock()
+	sockets := make([]*extensionWebSocket, 0, len(r.webSockets))
+	for socket := range r.webSockets {
+		sockets = append(sockets, socket)
+	}
+	r.webSocketsMu.Unlock()
+
+	for _, socket := range sockets {
+		socket.close(nil)
+	}
+}
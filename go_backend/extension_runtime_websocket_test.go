@@ -0,0 +1,214 @@
+package gobackend
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// newWebSocketTestRuntime returns a runtime whose WebSocket dialer reaches
+// server for any allowed host, so connects still pass through validateDomain.
+func newWebSocketTestRuntime(t *testing.T, handler websocket.Handler) (*extensionRuntime, func(string) (string, error)) {
+	t.Helper()
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+
+	runtime, vm := newTestExtensionRuntime(t, "websocket-ext", ExtensionPermissions{Network: []string{"ws.test"}})
+
+	serverAddr := server.Listener.Addr().String()
+	runtime.webSocketDial = func(ctx context.Context, addr, serverName string) (net.Conn, error) {
+		rawConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", serverAddr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(rawConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+
+	run := func(script string) (string, error) {
+		result, err := RunWithTimeoutAndRecover(vm, script, 5*time.Second)
+		if err != nil {
+			return "", err
+		}
+		return result.String(), nil
+	}
+	return runtime, run
+}
+
+func echoWebSocketHandler(conn *websocket.Conn) {
+	for {
+		var msg webSocketMessage
+		if err := webSocketCodec.Receive(conn, &msg); err != nil {
+			return
+		}
+		if !msg.binary && string(msg.data) == "bye" {
+			return
+		}
+		if err := webSocketCodec.Send(conn, msg); err != nil {
+			return
+		}
+	}
+}
+
+func TestWebSocket_SendReceive(t *testing.T) {
+	_, run := newWebSocketTestRuntime(t, echoWebSocketHandler)
+
+	got, err := run(`
+		(function() {
+			var sock = ws.connect('wss://ws.test/echo', { 'X-Token': 'abc' }, { encoding: 'hex' });
+			if (sock.error) return 'error: ' + sock.error;
+			sock.send('hello');
+			var text = sock.receive(2000);
+			sock.sendBinary('00ff10', 'hex');
+			var bin = sock.receive(2000);
+			sock.send('bye');
+			var end = sock.receive(2000);
+			return [text.data, text.binary, bin.data, bin.binary, end, sock.isOpen()].join('|');
+		})()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "hello|false|00ff10|true||false" {
+		t.Fatalf("result = %q", got)
+	}
+}
+
+func TestWebSocket_CallbacksOnEventLoop(t *testing.T) {
+	runtime, run := newWebSocketTestRuntime(t, echoWebSocketHandler)
+
+	got, err := run(`
+		new Promise(function(resolve, reject) {
+			var sock = ws.connect('wss://ws.test/echo');
+			if (sock.error) { reject(new Error(sock.error)); return; }
+			var received = [];
+			sock.onMessage(function(msg) {
+				received.push(msg.data);
+				if (received.length === 2) sock.send('bye');
+			});
+			sock.onClose(function() { resolve(received.join(',')); });
+			sock.send('one');
+			sock.send('two');
+		})
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "one,two" {
+		t.Fatalf("result = %q", got)
+	}
+	if len(runtime.webSockets) != 0 {
+		t.Fatalf("socket not released after close: %d open", len(runtime.webSockets))
+	}
+}
+
+func TestWebSocket_OnMessageKeepsOrderPastTheQueueSize(t *testing.T) {
+	const total = webSocketQueueSize * 2
+	_, run := newWebSocketTestRuntime(t, func(conn *websocket.Conn) {
+		for i := 0; i < total; i++ {
+			if err := webSocketCodec.Send(conn, webSocketMessage{data: []byte(strconv.Itoa(i))}); err != nil {
+				return
+			}
+		}
+		var msg webSocketMessage
+		webSocketCodec.Receive(conn, &msg)
+	})
+
+	// The handler is set only after the server has filled the queue, so
+	// the buffered messages must come before the ones read afterwards.
+	got, err := run(`
+		new Promise(function(resolve) {
+			var sock = ws.connect('wss://ws.test/flood');
+			var until = Date.now() + 200;
+			while (Date.now() < until) {}
+			var next = 0;
+			sock.onMessage(function(msg) {
+				if (msg.data !== String(next)) {
+					resolve('got ' + msg.data + ' at ' + next);
+					sock.close();
+					return;
+				}
+				next++;
+				if (next === ` + strconv.Itoa(total) + `) {
+					resolve('ok');
+					sock.close();
+				}
+			});
+		})
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "ok" {
+		t.Fatalf("result = %q", got)
+	}
+}
+
+func TestWebSocket_SandboxBlocksDisallowedTargets(t *testing.T) {
+	runtime, run := newWebSocketTestRuntime(t, echoWebSocketHandler)
+	dialed := false
+	runtime.webSocketDial = func(ctx context.Context, addr, serverName string) (net.Conn, error) {
+		dialed = true
+		return nil, context.Canceled
+	}
+
+	cases := map[string]string{
+		"wss://evil.test/socket": "not in allowed list",
+		"ws://ws.test/socket":    "only wss is allowed",
+		"wss://127.0.0.1/socket": "private/local network",
+	}
+	for target, want := range cases {
+		got, err := run(`ws.connect('` + target + `').error`)
+		if err != nil {
+			t.Fatalf("%s: script failed: %v", target, err)
+		}
+		if !strings.Contains(got, want) {
+			t.Fatalf("%s: error = %q, want substring %q", target, got, want)
+		}
+	}
+	if dialed {
+		t.Fatal("blocked target reached the dialer")
+	}
+}
+
+func TestWebSocket_TeardownClosesSockets(t *testing.T) {
+	block := make(chan struct{})
+	runtime, run := newWebSocketTestRuntime(t, func(conn *websocket.Conn) {
+		<-block
+	})
+	defer close(block)
+
+	got, err := run(`ws.connect('wss://ws.test/idle').isOpen()`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	if got != "true" {
+		t.Fatalf("isOpen = %q", got)
+	}
+
+	runtime.closeWebSockets()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		runtime.webSocketsMu.Lock()
+		open := len(runtime.webSockets)
+		runtime.webSocketsMu.Unlock()
+		if open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sockets still open after closeWebSockets", open)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,243 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SettingValidationError is one setting that does not match its manifest
+// declaration.
+type SettingValidationError struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// ExtensionSettingsError lists every invalid setting of one save.
+type ExtensionSettingsError struct {
+	Errors []SettingValidationError `json:"errors"`
+}
+
+func (e *ExtensionSettingsError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		parts[i] = err.Key + ": " + err.Message
+	}
+	return "invalid settings: " + strings.Join(parts, "; ")
+}
+
+// validateExtensionSettings checks settings against the manifest and returns
+// them with values coerced to the declared types: "42" becomes 42 for a
+// number and "true" becomes true for a boolean. Keys starting with "_" and
+// keys the manifest does not declare are kept as they are. Missing required
+// settings take their default when there is one; the app saves one field at
+// a time, so settings that are still missing are only reported by
+// requiredSettingErrors.
+func validateExtensionSettings(manifest *ExtensionManifest, settings map[string]interface{}) (map[string]interface{}, []SettingValidationError) {
+	result := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		result[key] = value
+	}
+	var errs []SettingValidationError
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, SettingValidationError{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, setting := range manifest.Settings {
+		key := setting.Key
+		if setting.Type == SettingTypeButton {
+			delete(result, key)
+			continue
+		}
+
+		value, present := result[key]
+		if present {
+			coerced, err := coerceSettingValue(setting, value)
+			if err != nil {
+				fail(key, "%v", err)
+				continue
+			}
+			if coerced == nil {
+				delete(result, key)
+				present = false
+			} else {
+				result[key] = coerced
+			}
+		}
+		if !present {
+			if setting.Required && setting.Default != nil {
+				result[key] = setting.Default
+			}
+			continue
+		}
+
+		switch v := result[key].(type) {
+		case float64:
+			if setting.Min != nil && v < *setting.Min {
+				fail(key, "must be at least %s", formatSettingNumber(*setting.Min))
+			}
+			if setting.Max != nil && v > *setting.Max {
+				fail(key, "must be at most %s", formatSettingNumber(*setting.Max))
+			}
+		case string:
+			if setting.Type == SettingTypeSelect && len(setting.Options) > 0 && !containsString(setting.Options, v) {
+				fail(key, "must be one of %s", strings.Join(setting.Options, ", "))
+			}
+			if setting.Pattern != "" {
+				re, err := regexp.Compile("^(?:" + setting.Pattern + ")$")
+				if err == nil && !re.MatchString(v) {
+					fail(key, "does not match the expected format")
+				}
+			}
+		}
+	}
+	return result, errs
+}
+
+// requiredSettingErrors lists the required settings that have no value. It
+// is checked when an extension is initialized or enabled, not on every save.
+func requiredSettingErrors(manifest *ExtensionManifest, settings map[string]interface{}) []SettingValidationError {
+	var errs []SettingValidationError
+	for _, setting := range manifest.Settings {
+		if !setting.Required || setting.Type == SettingTypeButton || setting.Default != nil {
+			continue
+		}
+		if value, err := coerceSettingValue(setting, settings[setting.Key]); err == nil && value == nil {
+			errs = append(errs, SettingValidationError{Key: setting.Key, Message: "is required"})
+		}
+	}
+	return errs
+}
+
+// coerceSettingValue converts value to the setting's type. It returns nil
+// for values that mean "not set": null and empty strings.
+func coerceSettingValue(setting ExtensionSetting, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok && strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	switch setting.Type {
+	case SettingTypeNumber:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be a number")
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("must be a number")
+	case SettingTypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("must be true or false")
+			}
+			return b, nil
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		}
+		return nil, fmt.Errorf("must be true or false")
+	case SettingTypeString, SettingTypeSelect:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return formatSettingNumber(v), nil
+		case int:
+			return strconv.Itoa(v), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		return nil, fmt.Errorf("must be text")
+	}
+	return value, nil
+}
+
+func formatSettingNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// migrateExtensionSettingsLocked brings stored settings up to the manifest
+// dataVersion by calling the extension's migrateSettings(oldVersion,
+// settings) hook, so renamed or reshaped keys keep the user's values. The
+// hook returns the new settings; returning nothing keeps them unchanged.
+// This is the only place the data version advances when a newer release
+// first runs. When the hook fails or returns invalid settings, the stored
+// settings and data version are left alone and migration is retried on the
+// next start. The caller must hold ext.VMMu with the VM initialized.
+func migrateExtensionSettingsLocked(ext *loadedExtension) error {
+	target := ext.Manifest.DataVersion
+	from := getExtensionDataVersion(ext.ID)
+	if from >= target {
+		return nil
+	}
+	store := GetExtensionSettingsStore()
+	stored := store.GetAll(ext.ID)
+
+	settings := make(map[string]interface{}, len(stored))
+	for key, value := range stored {
+		if !strings.HasPrefix(key, "_") {
+			settings[key] = value
+		}
+	}
+
+	if len(settings) > 0 {
+		settingsJSON, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		script := fmt.Sprintf(`
+			(function() {
+				if (typeof extension === 'undefined' || typeof extension.migrateSettings !== 'function') {
+					return null;
+				}
+				var migrated = extension.migrateSettings(%d, %s);
+				return migrated === undefined ? null : JSON.stringify(migrated);
+			})()
+		`, from, string(settingsJSON))
+		result, err := RunWithTimeoutAndRecover(ext.VM, script, DefaultJSTimeout)
+		if err != nil {
+			return fmt.Errorf("migrateSettings failed: %w", err)
+		}
+		if migratedJSON, ok := result.Export().(string); ok {
+			var migrated map[string]interface{}
+			if err := json.Unmarshal([]byte(migratedJSON), &migrated); err != nil || migrated == nil {
+				return fmt.Errorf("migrateSettings must return an object")
+			}
+			validated, errs := validateExtensionSettings(ext.Manifest, migrated)
+			if len(errs) > 0 {
+				return fmt.Errorf("migrateSettings returned %w", &ExtensionSettingsError{Errors: errs})
+			}
+			settings = validated
+		}
+	}
+
+	for key, value := range stored {
+		if strings.HasPrefix(key, "_") {
+			settings[key] = value
+		}
+	}
+	settings[dataVersionSettingKey] = target
+	if err := store.SetAll(ext.ID, settings); err != nil {
+		return err
+	}
+	GoLog("[Extension] Migrated settings of %s from version %d to %d\n", ext.ID, from, target)
+	return nil
+}
@@ -0,0 +1,215 @@
+package gobackend
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateExtensionSettings(t *testing.T) {
+	manifest, err := ParseManifest([]byte(`{
+		"name": "settings-ext", "version": "1.0.0", "description": "d", "type": ["metadata_provider"],
+		"settings": [
+			{"key": "limit", "type": "number", "min": 1, "max": 50},
+			{"key": "lossless", "type": "boolean"},
+			{"key": "region", "type": "select", "options": ["us", "eu"]},
+			{"key": "token", "type": "string", "required": true, "pattern": "[a-f0-9]{8}"},
+			{"key": "server", "type": "string", "required": true, "default": "https://a.example"},
+			{"key": "login", "type": "button", "action": "login"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseManifest: %v", err)
+	}
+
+	settings, errs := validateExtensionSettings(manifest, map[string]interface{}{
+		"limit": "20", "lossless": "true", "region": "eu", "token": "deadbeef",
+		"login": "clicked", "_enabled": true, "undeclared": 1.0,
+	})
+	if len(errs) != 0 {
+		t.Fatalf("errors = %+v", errs)
+	}
+	want := map[string]interface{}{
+		"limit": 20.0, "lossless": true, "region": "eu", "token": "deadbeef",
+		"server": "https://a.example", "_enabled": true, "undeclared": 1.0,
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Fatalf("settings = %#v\nwant %#v", settings, want)
+	}
+
+	_, errs = validateExtensionSettings(manifest, map[string]interface{}{
+		"limit": 99.0, "lossless": "maybe", "region": "asia", "token": "DEADBEEF-1",
+	})
+	got := make([]string, len(errs))
+	for i, err := range errs {
+		got[i] = err.Key + ": " + err.Message
+	}
+	wantErrs := []string{
+		"limit: must be at most 50",
+		"lossless: must be true or false",
+		"region: must be one of us, eu",
+		"token: does not match the expected format",
+	}
+	if strings.Join(got, "\n") != strings.Join(wantErrs, "\n") {
+		t.Fatalf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantErrs, "\n"))
+	}
+	// A save may leave required settings empty; they are checked on
+	// initialize and enable.
+	partial, errs := validateExtensionSettings(manifest, map[string]interface{}{"token": "", "limit": 5.0})
+	if len(errs) != 0 {
+		t.Fatalf("a partial save was rejected: %+v", errs)
+	}
+	if errs := requiredSettingErrors(manifest, partial); len(errs) != 1 || errs[0].Key != "token" || errs[0].Message != "is required" {
+		t.Fatalf("empty required setting: %+v", errs)
+	}
+
+	for _, bad := range []string{
+		`{"key": "a", "type": "string", "min": 1}`,
+		`{"key": "a", "type": "number", "min": 5, "max": 1}`,
+		`{"key": "a", "type": "string", "pattern": "("}`,
+	} {
+		data := `{"name": "x", "version": "1.0.0", "description": "d", "type": ["metadata_provider"], "settings": [` + bad + `]}`
+		if _, err := ParseManifest([]byte(data)); err == nil {
+			t.Errorf("ParseManifest accepted setting %s", bad)
+		}
+	}
+}
+
+func TestMigrateExtensionSettings(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	manager := newUpdateTestManager(t, t.TempDir())
+	dir := filepath.Join(manager.extensionsDir, "migrate-ext")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"manifest.json": `{"name": "migrate-ext", "version": "2.0.0", "description": "d", "type": ["metadata_provider"], "dataVersion": 2,
+			"settings": [{"key": "quality", "type": "select", "options": ["low", "high"]}]}`,
+		"index.js": `
+			var initialized = null;
+			registerExtension({
+				initialize: function(settings) { initialized = settings; },
+				migrateSettings: function(oldVersion, settings) {
+					if (oldVersion < 1) { settings.quality = settings.bitrate > 256 ? "high" : "low"; delete settings.bitrate; }
+					return settings;
+				}
+			});`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := GetExtensionSettingsStore()
+	if err := store.SetAll("migrate-ext", map[string]interface{}{"bitrate": 320.0, "_enabled": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ext, err := manager.loadExtensionFromDirectory(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := ext.ensureRuntimeReady(); err != nil {
+		t.Fatalf("ensureRuntimeReady: %v", err)
+	}
+	want := map[string]interface{}{"quality": "high", "_enabled": true, dataVersionSettingKey: 2}
+	if got := store.GetAll("migrate-ext"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("stored settings = %#v\nwant %#v", got, want)
+	}
+	ext.VMMu.Lock()
+	initialized, err := ext.VM.RunString(`JSON.stringify(initialized)`)
+	ext.VMMu.Unlock()
+	if err != nil || initialized.String() != `{"quality":"high"}` {
+		t.Fatalf("initialize received %v, %v", initialized, err)
+	}
+}
+
+func TestMigrateExtensionSettings_FailureDisablesAndRetries(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	manager := newUpdateTestManager(t, t.TempDir())
+	dir := filepath.Join(manager.extensionsDir, "migrate-loop")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"manifest.json": `{"name": "migrate-loop", "version": "2.0.0", "description": "d", "type": ["metadata_provider"], "dataVersion": 1}`,
+		"index.js":      `registerExtension({ migrateSettings: function(oldVersion, settings) { while (true) {} } });`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := GetExtensionSettingsStore()
+	if err := store.SetAll("migrate-loop", map[string]interface{}{"bitrate": 320.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	ext, err := manager.loadExtensionFromDirectory(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// The migration runs under the extension's quotas like any other call,
+	// so a loop is interrupted instead of holding the VM forever.
+	ext.quota.setLimits(ExtensionQuotaLimits{CPUTimeMs: 200, CPUWindowSeconds: 1})
+	done := make(chan error, 1)
+	go func() { done <- ext.ensureRuntimeReady() }()
+	select {
+	case err = <-done:
+	case <-time.After(DefaultJSTimeout + 5*time.Second):
+		t.Fatal("migrateSettings was never interrupted")
+	}
+	if err == nil || !strings.Contains(err.Error(), "settings migration failed") {
+		t.Fatalf("ensureRuntimeReady err = %v", err)
+	}
+	if ext.Enabled || !strings.Contains(ext.Error, "settings migration failed") {
+		t.Fatalf("extension state: enabled=%v error=%q", ext.Enabled, ext.Error)
+	}
+	if got := store.GetAll("migrate-loop"); !reflect.DeepEqual(got, map[string]interface{}{"bitrate": 320.0}) {
+		t.Fatalf("settings changed after a failed migration: %#v", got)
+	}
+	if got := getExtensionDataVersion("migrate-loop"); got != 0 {
+		t.Fatalf("data version advanced after a failed migration: %d", got)
+	}
+}
+
+func TestRequiredSettingsCheckedOnEnable(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	manager := newUpdateTestManager(t, t.TempDir())
+	dir := filepath.Join(manager.extensionsDir, "required-ext")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"manifest.json": `{"name": "required-ext", "version": "1.0.0", "description": "d", "type": ["metadata_provider"],
+			"settings": [{"key": "token", "type": "string", "required": true}, {"key": "region", "type": "string"}]}`,
+		"index.js": `registerExtension({ initialize: function(settings) {} });`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := manager.loadExtensionFromDirectory(dir); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	var settingsErr *ExtensionSettingsError
+	if err := manager.SetExtensionEnabled("required-ext", true); !errors.As(err, &settingsErr) || settingsErr.Errors[0].Key != "token" {
+		t.Fatalf("enable without the required setting: %v", err)
+	}
+	if err := manager.InitializeExtension("required-ext", map[string]interface{}{"region": "eu"}); !errors.As(err, &settingsErr) {
+		t.Fatalf("initialize without the required setting: %v", err)
+	}
+
+	if err := GetExtensionSettingsStore().SetAll("required-ext", map[string]interface{}{"token": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetExtensionEnabled("required-ext", true); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestExtensionPackage(t *testing.T, path, name, version, script string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	w := zip.NewWriter(f)
+	files := map[string]string{
+		"manifest.json": fmt.Sprintf(`{"name":%q,"version":%q,"description":"d","type":["metadata_provider"]}`, name, version),
+		"index.js":      script,
+	}
+	for fileName, content := range files {
+		fw, err := w.Create(fileName)
+		if err != nil {
+			t.Fatalf("create %s: %v", fileName, err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	f.Close()
+}
+
+func newSigningTestKey(t *testing.T) (publicKey, privateKey string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv.Seed())
+}
+
+func newSigningTestManager(t *testing.T, policy string, trusted ...TrustedExtensionKey) *extensionManager {
+	t.Helper()
+	useTempSettingsStore(t)
+	previousPolicy := getExtensionSignaturePolicy()
+	previousKeys := getTrustedExtensionKeys()
+	t.Cleanup(func() {
+		setExtensionSignaturePolicy(previousPolicy)
+		setTrustedExtensionKeys(previousKeys)
+	})
+	if err := setExtensionSignaturePolicy(policy); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if err := setTrustedExtensionKeys(trusted); err != nil {
+		t.Fatalf("set trusted keys: %v", err)
+	}
+
+	root := t.TempDir()
+	return &extensionManager{
+		extensions:    make(map[string]*loadedExtension),
+		extensionsDir: filepath.Join(root, "extensions"),
+		dataDir:       filepath.Join(root, "data"),
+	}
+}
+
+const signingTestScript = `registerExtension({});`
+
+func TestSigning_RequirePolicy(t *testing.T) {
+	publisherKey, publisherPriv := newSigningTestKey(t)
+	strangerKey, strangerPriv := newSigningTestKey(t)
+	manager := newSigningTestManager(t, SignaturePolicyRequire, TrustedExtensionKey{Name: "Team", PublicKey: publisherKey})
+	dir := t.TempDir()
+
+	unsigned := filepath.Join(dir, "unsigned.spotiflac-ext")
+	writeTestExtensionPackage(t, unsigned, "signed-ext", "1.0.0", signingTestScript)
+	if _, err := manager.LoadExtensionFromFile(unsigned); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("unsigned install: err = %v", err)
+	}
+
+	untrusted := filepath.Join(dir, "untrusted.spotiflac-ext")
+	if err := SignExtensionPackage(unsigned, untrusted, strangerPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if _, err := manager.LoadExtensionFromFile(untrusted); err == nil || !strings.Contains(err.Error(), "untrusted key") {
+		t.Fatalf("untrusted install: err = %v", err)
+	}
+
+	signed := filepath.Join(dir, "signed.spotiflac-ext")
+	if err := SignExtensionPackage(unsigned, signed, publisherPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	ext, err := manager.LoadExtensionFromFile(signed)
+	if err != nil {
+		t.Fatalf("signed install: %v", err)
+	}
+	if !ext.Signature.Valid || !ext.Signature.Trusted || ext.Signature.Publisher != "Team" {
+		t.Fatalf("signature info = %+v", ext.Signature)
+	}
+
+	// The pin is not part of the settings, so writing the old settings key
+	// back through the settings APIs cannot replace it.
+	if err := GetExtensionSettingsStore().SetAll("signed-ext", map[string]interface{}{legacyPublisherKeySettingKey: strangerKey}); err != nil {
+		t.Fatalf("SetAll: %v", err)
+	}
+	if pinned := manager.pinnedPublisherKey("signed-ext"); pinned != publisherKey {
+		t.Fatalf("pinned key = %q, want the installed publisher's", pinned)
+	}
+
+	upgrade := filepath.Join(dir, "upgrade.spotiflac-ext")
+	writeTestExtensionPackage(t, upgrade, "signed-ext", "1.1.0", signingTestScript)
+	hijacked := filepath.Join(dir, "hijacked.spotiflac-ext")
+	if err := SignExtensionPackage(upgrade, hijacked, strangerPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	info, err := manager.checkExtensionUpgradeInternal(hijacked)
+	if err != nil {
+		t.Fatalf("check upgrade: %v", err)
+	}
+	if info.CanUpgrade || !strings.Contains(info.SignatureError, "publisher key changed") {
+		t.Fatalf("upgrade info = %+v", info)
+	}
+	if _, err := manager.UpgradeExtension(hijacked); err == nil {
+		t.Fatal("expected upgrade signed by another key to be refused")
+	}
+}
+
+func TestSigning_MigratesPublisherKeysOutOfSettings(t *testing.T) {
+	publisherKey, _ := newSigningTestKey(t)
+	manager := newSigningTestManager(t, SignaturePolicyWarn)
+	store := GetExtensionSettingsStore()
+	if err := store.SetAll("old-ext", map[string]interface{}{"quality": "high", legacyPublisherKeySettingKey: publisherKey}); err != nil {
+		t.Fatalf("SetAll: %v", err)
+	}
+
+	if pinned := manager.pinnedPublisherKey("old-ext"); pinned != publisherKey {
+		t.Fatalf("migrated pin = %q", pinned)
+	}
+	if _, err := os.Stat(filepath.Join(manager.extensionsDir, publisherKeysFileName)); err != nil {
+		t.Fatalf("pin file not written: %v", err)
+	}
+	if _, ok := store.GetAll("old-ext")[legacyPublisherKeySettingKey]; ok {
+		t.Fatal("legacy pin left in the settings")
+	}
+
+	if err := manager.forgetPublisherKey("old-ext"); err != nil {
+		t.Fatalf("forget: %v", err)
+	}
+	store.Set("old-ext", legacyPublisherKeySettingKey, publisherKey)
+	if pinned := manager.pinnedPublisherKey("old-ext"); pinned != "" {
+		t.Fatalf("settings key read again after migration: %q", pinned)
+	}
+}
+
+func TestSigning_WarnPolicyAndTampering(t *testing.T) {
+	_, firstPriv := newSigningTestKey(t)
+	_, secondPriv := newSigningTestKey(t)
+	manager := newSigningTestManager(t, SignaturePolicyWarn)
+	dir := t.TempDir()
+
+	base := filepath.Join(dir, "base.spotiflac-ext")
+	writeTestExtensionPackage(t, base, "warn-ext", "1.0.0", signingTestScript)
+	signed := filepath.Join(dir, "signed.spotiflac-ext")
+	if err := SignExtensionPackage(base, signed, firstPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// Swap index.js while keeping the original signature.json.
+	tampered := filepath.Join(dir, "tampered.spotiflac-ext")
+	src, err := zip.OpenReader(signed)
+	if err != nil {
+		t.Fatalf("open signed: %v", err)
+	}
+	out, _ := os.Create(tampered)
+	w := zip.NewWriter(out)
+	for _, file := range src.File {
+		if file.Name == "index.js" {
+			fw, _ := w.Create("index.js")
+			fw.Write([]byte(`registerExtension({ evil: true });`))
+			continue
+		}
+		w.Copy(file)
+	}
+	w.Close()
+	out.Close()
+	src.Close()
+	if _, err := manager.LoadExtensionFromFile(tampered); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("tampered install: err = %v", err)
+	}
+
+	ext, err := manager.LoadExtensionFromFile(signed)
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if len(ext.Signature.Warnings) != 1 || !strings.Contains(ext.Signature.Warnings[0], "untrusted key") {
+		t.Fatalf("warnings = %v", ext.Signature.Warnings)
+	}
+
+	upgrade := filepath.Join(dir, "upgrade.spotiflac-ext")
+	writeTestExtensionPackage(t, upgrade, "warn-ext", "1.1.0", signingTestScript)
+	rekeyed := filepath.Join(dir, "rekeyed.spotiflac-ext")
+	if err := SignExtensionPackage(upgrade, rekeyed, secondPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	ext, err = manager.UpgradeExtension(rekeyed)
+	if err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if !ext.Signature.KeyChanged || !strings.Contains(strings.Join(ext.Signature.Warnings, ";"), "publisher key changed") {
+		t.Fatalf("upgrade signature = %+v", ext.Signature)
+	}
+
+	// Editing the installed files is caught when the directory is loaded again.
+	if err := os.WriteFile(filepath.Join(ext.SourceDir, "index.js"), []byte(`registerExtension({});//`), 0644); err != nil {
+		t.Fatalf("edit installed file: %v", err)
+	}
+	delete(manager.extensions, ext.ID)
+	reloaded, err := manager.loadExtensionFromDirectory(ext.SourceDir)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !strings.Contains(reloaded.Error, "does not match") || reloaded.Enabled {
+		t.Fatalf("reloaded error = %q enabled = %v", reloaded.Error, reloaded.Enabled)
+	}
+}
+
+func TestSigning_RegistryDigestAndSignature(t *testing.T) {
+	publisherKey, publisherPriv := newSigningTestKey(t)
+	manager := newSigningTestManager(t, SignaturePolicyRequire, TrustedExtensionKey{Name: "Registry", PublicKey: publisherKey})
+	dir := t.TempDir()
+
+	pkg := filepath.Join(dir, "pkg.spotiflac-ext")
+	writeTestExtensionPackage(t, pkg, "registry-ext", "1.0.0", signingTestScript)
+	signed := filepath.Join(dir, "signed.spotiflac-ext")
+	if err := SignExtensionPackage(pkg, signed, publisherPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	// The registry carries the signature; the package itself stays unsigned.
+	reader, err := zip.OpenReader(signed)
+	if err != nil {
+		t.Fatalf("open signed: %v", err)
+	}
+	sig, err := readPackageSignature(zipPackageEntries(&reader.Reader))
+	reader.Close()
+	if err != nil || sig == nil {
+		t.Fatalf("read signature: %v", err)
+	}
+
+	data, _ := os.ReadFile(pkg)
+	sum := sha256.Sum256(data)
+	download := filepath.Join(dir, "download.spotiflac-ext")
+	os.WriteFile(download, data, 0644)
+
+	bad := &storeExtension{ID: "registry-ext", SHA256: strings.Repeat("0", 64)}
+	if err := recordRegistrySignature(bad, download, ""); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("digest mismatch: err = %v", err)
+	}
+
+	entry := &storeExtension{
+		ID:        "registry-ext",
+		SHA256:    hex.EncodeToString(sum[:]),
+		Signature: sig.Signature,
+		PublicKey: sig.PublicKey,
+	}
+	if err := recordRegistrySignature(entry, download, ""); err != nil {
+		t.Fatalf("registry verification: %v", err)
+	}
+
+	ext, err := manager.LoadExtensionFromFile(download)
+	if err != nil {
+		t.Fatalf("install registry-signed package: %v", err)
+	}
+	if ext.Signature.Source != "registry" || !ext.Signature.Trusted {
+		t.Fatalf("signature = %+v", ext.Signature)
+	}
+
+	delete(manager.extensions, ext.ID)
+	reloaded, err := manager.loadExtensionFromDirectory(ext.SourceDir)
+	if err != nil || reloaded.Error != "" {
+		t.Fatalf("reload: %v %q", err, reloaded.Error)
+	}
+
+	resp := entry.toResponse()
+	raw, _ := json.Marshal(resp)
+	if !resp.Signed || resp.PublisherKeyID != extensionKeyID(publisherKey) {
+		t.Fatalf("store response = %s", raw)
+	}
+}
@@ -34,6 +34,10 @@ type storeExtension struct {
 	Downloads        int      `json:"downloads"`
 	UpdatedAt        string   `json:"updated_at"`
 	MinAppVersion    string   `json:"min_app_version,omitempty"`
+	Changelog        string   `json:"changelog,omitempty"`
+	SHA256           string   `json:"sha256,omitempty"`
+	Signature        string   `json:"signature,omitempty"`
+	PublicKey        string   `json:"public_key,omitempty"`
 	DisplayNameAlt   string   `json:"displayName,omitempty"`
 	DownloadURLAlt   string   `json:"downloadUrl,omitempty"`
 	IconURLAlt       string   `json:"iconUrl,omitempty"`
@@ -90,6 +94,11 @@ type storeExtensionResponse struct {
 	Downloads        int      `json:"downloads"`
 	UpdatedAt        string   `json:"updated_at"`
 	MinAppVersion    string   `json:"min_app_version,omitempty"`
+	Changelog        string   `json:"changelog,omitempty"`
+	Signed           bool     `json:"signed"`
+	PublisherKeyID   string   `json:"publisher_key_id,omitempty"`
+	Registry         string   `json:"registry"`
+	RegistryTrust    string   `json:"registry_trust"`
 	IsInstalled      bool     `json:"is_installed"`
 	InstalledVersion string   `json:"installed_version,omitempty"`
 	HasUpdate        bool     `json:"has_update"`
@@ -108,6 +117,11 @@ func (e *storeExtension) toResponse() storeExtensionResponse {
 		Downloads:     e.Downloads,
 		UpdatedAt:     e.UpdatedAt,
 		MinAppVersion: e.getMinAppVersion(),
+		Changelog:     e.Changelog,
+		Signed:        e.Signature != "" && e.PublicKey != "",
+	}
+	if e.PublicKey != "" {
+		resp.PublisherKeyID = extensionKeyID(e.PublicKey)
 	}
 
 	if len(e.Tags) > 0 {
@@ -118,12 +132,29 @@ func (e *storeExtension) toResponse() storeExtensionResponse {
 }
 
 type extensionStore struct {
-	registryURL string
+	registries  []StoreRegistryConfig
 	cacheDir    string
-	cache       *storeRegistry
+	caches      map[string]*registryCache // keyed by registry URL
+	fetchErrors map[string]string         // keyed by registry name
 	cacheMu     sync.RWMutex
-	cacheTime   time.Time
 	cacheTTL    time.Duration
+	client      *http.Client
+}
+
+// registryCache is the last catalogue fetched for one registry, and the URL,
+// primary or mirror, it came from.
+type registryCache struct {
+	Registry  storeRegistry `json:"registry"`
+	CacheTime int64         `json:"cache_time"`
+	SourceURL string        `json:"source_url,omitempty"`
+}
+
+// storeCatalogEntry is an extension in the merged catalogue, with the
+// registry it was taken from and the URL, primary or mirror, that served it.
+type storeCatalogEntry struct {
+	storeExtension
+	registry StoreRegistryConfig
+	source   string
 }
 
 var (
@@ -132,49 +163,110 @@ var (
 )
 
 const (
-	cacheTTL      = 30 * time.Minute
-	cacheFileName = "store_cache.json"
+	cacheTTL           = 30 * time.Minute
+	cacheFileName      = "store_cache.json"
+	registriesFileName = "store_registries.json"
 )
 
+func newExtensionStore(cacheDir string) *extensionStore {
+	return &extensionStore{
+		cacheDir:    cacheDir,
+		caches:      make(map[string]*registryCache),
+		fetchErrors: make(map[string]string),
+		cacheTTL:    cacheTTL,
+	}
+}
+
 func initExtensionStore(cacheDir string) *extensionStore {
 	extensionStoreMu.Lock()
 	defer extensionStoreMu.Unlock()
 
 	if globalExtensionStore == nil {
-		globalExtensionStore = &extensionStore{
-			registryURL: "",
-			cacheDir:    cacheDir,
-			cacheTTL:    cacheTTL,
-		}
+		globalExtensionStore = newExtensionStore(cacheDir)
+		globalExtensionStore.loadRegistries()
 		globalExtensionStore.loadDiskCache()
 	}
 	return globalExtensionStore
 }
 
+// setRegistryURL points the default registry at registryURL, keeping any
+// other registries. An empty URL removes the default registry. The app calls
+// this on every launch, so it never prunes cached catalogues.
 func (s *extensionStore) setRegistryURL(registryURL string) {
 	s.cacheMu.Lock()
 	defer s.cacheMu.Unlock()
 
-	if s.registryURL == registryURL {
-		return
+	s.registries = withDefaultRegistryURL(s.registries, registryURL)
+	s.saveRegistriesLocked()
+	LogInfo("ExtensionStore", "Registry URL updated to: %s", registryURL)
+}
+
+func (s *extensionStore) getRegistryURL() string {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	for _, cfg := range s.registries {
+		if strings.EqualFold(cfg.Name, defaultRegistryName) {
+			return cfg.URL
+		}
 	}
+	return ""
+}
 
-	s.registryURL = registryURL
-	s.cache = nil
-	s.cacheTime = time.Time{}
+// setRegistries replaces the registry list. Registries must already be
+// normalized.
+func (s *extensionStore) setRegistries(registries []StoreRegistryConfig) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
 
-	if s.cacheDir != "" {
-		cachePath := filepath.Join(s.cacheDir, cacheFileName)
-		os.Remove(cachePath)
-	}
+	s.setRegistriesLocked(registries)
+	LogInfo("ExtensionStore", "Configured %d registries", len(registries))
+}
 
-	LogInfo("ExtensionStore", "Registry URL updated to: %s", registryURL)
+// setRegistriesLocked saves the list and drops the cached catalogues of
+// registries that are no longer configured, so a removed repository does not
+// linger on disk.
+func (s *extensionStore) setRegistriesLocked(registries []StoreRegistryConfig) {
+	s.registries = append([]StoreRegistryConfig(nil), registries...)
+	s.saveRegistriesLocked()
+
+	urls := make(map[string]bool, len(registries))
+	names := make(map[string]bool, len(registries))
+	for _, cfg := range registries {
+		urls[cfg.URL] = true
+		names[cfg.Name] = true
+	}
+	pruned := false
+	for registryURL := range s.caches {
+		if !urls[registryURL] {
+			delete(s.caches, registryURL)
+			pruned = true
+		}
+	}
+	for name := range s.fetchErrors {
+		if !names[name] {
+			delete(s.fetchErrors, name)
+		}
+	}
+	if pruned {
+		s.saveDiskCache()
+	}
 }
 
-func (s *extensionStore) getRegistryURL() string {
+func (s *extensionStore) getRegistries() []StoreRegistryStatus {
 	s.cacheMu.RLock()
 	defer s.cacheMu.RUnlock()
-	return s.registryURL
+
+	result := make([]StoreRegistryStatus, 0, len(s.registries))
+	for _, cfg := range s.registries {
+		status := StoreRegistryStatus{StoreRegistryConfig: cfg, Error: s.fetchErrors[cfg.Name]}
+		if cached := s.caches[cfg.URL]; cached != nil {
+			status.SourceURL = cached.SourceURL
+			status.LastFetched = cached.CacheTime
+			status.ExtensionCount = len(cached.Registry.Extensions)
+		}
+		result = append(result, status)
+	}
+	return result
 }
 
 func getExtensionStore() *extensionStore {
@@ -183,6 +275,52 @@ func getExtensionStore() *extensionStore {
 	return globalExtensionStore
 }
 
+// loadRegistries reads the registry list saved by saveRegistriesLocked.
+// Entries that no longer normalize are dropped rather than failing the load.
+func (s *extensionStore) loadRegistries() {
+	if s.cacheDir == "" {
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.cacheDir, registriesFileName))
+	if err != nil {
+		return
+	}
+	saved, err := parseStoreRegistries(string(data))
+	if err != nil {
+		LogWarn("ExtensionStore", "Ignoring saved registries: %v", err)
+		return
+	}
+
+	registries := make([]StoreRegistryConfig, 0, len(saved))
+	for _, cfg := range saved {
+		normalized, err := normalizeStoreRegistries([]StoreRegistryConfig{cfg})
+		if err != nil {
+			LogWarn("ExtensionStore", "Ignoring saved registry %s: %v", cfg.Name, err)
+			continue
+		}
+		registries = append(registries, normalized[0])
+	}
+	s.registries = registries
+	LogDebug("ExtensionStore", "Loaded %d saved registries", len(registries))
+}
+
+func (s *extensionStore) saveRegistriesLocked() {
+	if s.cacheDir == "" {
+		return
+	}
+
+	data, err := json.Marshal(s.registries)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(s.cacheDir, registriesFileName), data, 0644); err != nil {
+		LogWarn("ExtensionStore", "Failed to save registries: %v", err)
+	}
+}
+
+// loadDiskCache reads the cached catalogues. Caches written before multiple
+// registries were supported have no registries map and are ignored.
 func (s *extensionStore) loadDiskCache() {
 	if s.cacheDir == "" {
 		return
@@ -195,30 +333,33 @@ func (s *extensionStore) loadDiskCache() {
 	}
 
 	var cacheData struct {
-		Registry  storeRegistry `json:"registry"`
-		CacheTime int64         `json:"cache_time"`
+		Registries map[string]*registryCache `json:"registries"`
 	}
 
 	if err := json.Unmarshal(data, &cacheData); err != nil {
 		return
 	}
 
-	s.cache = &cacheData.Registry
-	s.cacheTime = time.Unix(cacheData.CacheTime, 0)
-	LogDebug("ExtensionStore", "Loaded %d extensions from disk cache", len(s.cache.Extensions))
+	total := 0
+	for registryURL, cached := range cacheData.Registries {
+		if cached == nil {
+			continue
+		}
+		s.caches[registryURL] = cached
+		total += len(cached.Registry.Extensions)
+	}
+	LogDebug("ExtensionStore", "Loaded %d extensions from %d cached registries", total, len(s.caches))
 }
 
 func (s *extensionStore) saveDiskCache() {
-	if s.cacheDir == "" || s.cache == nil {
+	if s.cacheDir == "" {
 		return
 	}
 
 	cacheData := struct {
-		Registry  storeRegistry `json:"registry"`
-		CacheTime int64         `json:"cache_time"`
+		Registries map[string]*registryCache `json:"registries"`
 	}{
-		Registry:  *s.cache,
-		CacheTime: s.cacheTime.Unix(),
+		Registries: s.caches,
 	}
 
 	data, err := json.Marshal(cacheData)
@@ -230,51 +371,148 @@ func (s *extensionStore) saveDiskCache() {
 	os.WriteFile(cachePath, data, 0644)
 }
 
-func (s *extensionStore) fetchRegistry(forceRefresh bool) (*storeRegistry, error) {
+func (s *extensionStore) httpClient(timeout time.Duration) *http.Client {
+	if s.client != nil {
+		return s.client
+	}
+	return NewHTTPClientWithTimeout(timeout)
+}
+
+// fetchCatalog returns the merged catalogue of every enabled registry. A
+// registry that cannot be reached falls back to its mirrors and then to its
+// cached copy; it is skipped if none of those work, unless every registry
+// failed.
+func (s *extensionStore) fetchCatalog(forceRefresh bool) ([]storeCatalogEntry, error) {
 	s.cacheMu.Lock()
 	defer s.cacheMu.Unlock()
 
-	if s.registryURL == "" {
+	ordered := orderedRegistries(s.registries)
+	if len(ordered) == 0 {
 		return nil, fmt.Errorf("no registry URL configured. Please add a repository URL first")
 	}
 
-	if !forceRefresh && s.cache != nil && time.Since(s.cacheTime) < s.cacheTTL {
-		LogDebug("ExtensionStore", "Using cached registry (%d extensions)", len(s.cache.Extensions))
-		return s.cache, nil
+	type fetchResult struct {
+		registry *storeRegistry
+		source   string
+		fetched  bool
+		err      error
 	}
+	results := make([]fetchResult, len(ordered))
+	var wg sync.WaitGroup
+	for i, cfg := range ordered {
+		cached := s.caches[cfg.URL]
+		if !forceRefresh && cached != nil && time.Since(time.Unix(cached.CacheTime, 0)) < s.cacheTTL {
+			results[i] = fetchResult{registry: &cached.Registry, source: cached.SourceURL}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cfg StoreRegistryConfig) {
+			defer wg.Done()
+			registry, source, err := s.fetchRegistrySources(cfg)
+			results[i] = fetchResult{registry: registry, source: source, fetched: err == nil, err: err}
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	var catalog []storeCatalogEntry
+	var failures []error
+	seen := make(map[string]string) // id -> registry name
+	dirty := false
+	for i, cfg := range ordered {
+		result := results[i]
+		switch {
+		case result.err != nil:
+			s.fetchErrors[cfg.Name] = result.err.Error()
+			cached := s.caches[cfg.URL]
+			if cached == nil {
+				LogWarn("ExtensionStore", "Registry %s unavailable: %v", cfg.Name, result.err)
+				failures = append(failures, result.err)
+				continue
+			}
+			LogWarn("ExtensionStore", "Registry %s unavailable, using cached registry: %v", cfg.Name, result.err)
+			result.registry = &cached.Registry
+		case result.fetched:
+			delete(s.fetchErrors, cfg.Name)
+			s.caches[cfg.URL] = &registryCache{
+				Registry:  *result.registry,
+				CacheTime: time.Now().Unix(),
+				SourceURL: result.source,
+			}
+			dirty = true
+		default:
+			LogDebug("ExtensionStore", "Using cached registry %s (%d extensions)", cfg.Name, len(result.registry.Extensions))
+		}
 
-	if err := requireHTTPSURL(s.registryURL, "registry"); err != nil {
+		for _, ext := range result.registry.Extensions {
+			if winner, ok := seen[ext.ID]; ok {
+				if winner != cfg.Name {
+					LogDebug("ExtensionStore", "%s from %s is shadowed by %s", ext.ID, cfg.Name, winner)
+				}
+				continue
+			}
+			seen[ext.ID] = cfg.Name
+			catalog = append(catalog, storeCatalogEntry{storeExtension: ext, registry: cfg, source: result.source})
+		}
+	}
+
+	if dirty {
+		s.saveDiskCache()
+	}
+	if len(failures) == len(ordered) {
+		if len(failures) == 1 {
+			return nil, failures[0]
+		}
+		messages := make([]string, len(failures))
+		for i, err := range failures {
+			messages[i] = fmt.Sprintf("%s: %v", ordered[i].Name, err)
+		}
+		return nil, fmt.Errorf("no registry could be fetched: %s", strings.Join(messages, "; "))
+	}
+
+	return catalog, nil
+}
+
+// fetchRegistrySources tries the registry URL and then each mirror, and
+// returns the first catalogue that downloads and parses.
+func (s *extensionStore) fetchRegistrySources(cfg StoreRegistryConfig) (*storeRegistry, string, error) {
+	var firstErr error
+	for _, source := range append([]string{cfg.URL}, cfg.Mirrors...) {
+		registry, err := s.fetchRegistryURL(source)
+		if err == nil {
+			if source != cfg.URL {
+				LogInfo("ExtensionStore", "Registry %s served from mirror %s", cfg.Name, source)
+			}
+			return registry, source, nil
+		}
+		LogWarn("ExtensionStore", "Registry %s: %s failed: %v", cfg.Name, source, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", firstErr
+}
+
+func (s *extensionStore) fetchRegistryURL(registryURL string) (*storeRegistry, error) {
+	if err := requireHTTPSURL(registryURL, "registry"); err != nil {
 		return nil, err
 	}
 
-	LogInfo("ExtensionStore", "Fetching registry from %s", s.registryURL)
+	LogInfo("ExtensionStore", "Fetching registry from %s", registryURL)
 
-	client := NewHTTPClientWithTimeout(30 * time.Second)
-	req, err := http.NewRequest(http.MethodGet, s.registryURL, nil)
+	client := s.httpClient(30 * time.Second)
+	req, err := http.NewRequest(http.MethodGet, registryURL, nil)
 	if err != nil {
-		if s.cache != nil {
-			LogWarn("ExtensionStore", "Failed to build registry request, using cached registry: %v", err)
-			return s.cache, nil
-		}
 		return nil, fmt.Errorf("failed to build registry request: %w", err)
 	}
 	req.Header.Set("Cache-Control", "no-cache")
 	req.Header.Set("Pragma", "no-cache")
 	resp, err := client.Do(req)
 	if err != nil {
-		if s.cache != nil {
-			LogWarn("ExtensionStore", "Network error, using cached registry: %v", err)
-			return s.cache, nil
-		}
 		return nil, fmt.Errorf("failed to fetch registry: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		if s.cache != nil {
-			LogWarn("ExtensionStore", "HTTP %d, using cached registry", resp.StatusCode)
-			return s.cache, nil
-		}
 		return nil, fmt.Errorf("registry returned HTTP %d", resp.StatusCode)
 	}
 
@@ -288,16 +526,12 @@ func (s *extensionStore) fetchRegistry(forceRefresh bool) (*storeRegistry, error
 		return nil, fmt.Errorf("failed to parse registry: %w", err)
 	}
 
-	s.cache = &registry
-	s.cacheTime = time.Now()
-	s.saveDiskCache()
-
 	LogInfo("ExtensionStore", "Fetched %d extensions from registry", len(registry.Extensions))
 	return &registry, nil
 }
 
 func (s *extensionStore) getExtensionsWithStatus(forceRefresh bool) ([]storeExtensionResponse, error) {
-	registry, err := s.fetchRegistry(forceRefresh)
+	catalog, err := s.fetchCatalog(forceRefresh)
 	if err != nil {
 		return nil, err
 	}
@@ -311,16 +545,18 @@ func (s *extensionStore) getExtensionsWithStatus(forceRefresh bool) ([]storeExte
 		}
 	}
 
-	LogDebug("ExtensionStore", "Building store response for %d registry extensions (%d installed)", len(registry.Extensions), len(installed))
+	LogDebug("ExtensionStore", "Building store response for %d registry extensions (%d installed)", len(catalog), len(installed))
 
-	result := make([]storeExtensionResponse, 0, len(registry.Extensions))
-	for i := range registry.Extensions {
-		ext := &registry.Extensions[i]
-		resp := ext.toResponse()
-		if installedVersion, ok := installed[ext.ID]; ok {
+	result := make([]storeExtensionResponse, 0, len(catalog))
+	for i := range catalog {
+		entry := &catalog[i]
+		resp := entry.toResponse()
+		resp.Registry = entry.registry.Name
+		resp.RegistryTrust = entry.registry.Trust
+		if installedVersion, ok := installed[entry.ID]; ok {
 			resp.IsInstalled = true
 			resp.InstalledVersion = installedVersion
-			resp.HasUpdate = compareVersions(ext.Version, installedVersion) > 0
+			resp.HasUpdate = compareVersions(entry.Version, installedVersion) > 0
 		}
 
 		result = append(result, resp)
@@ -331,30 +567,35 @@ func (s *extensionStore) getExtensionsWithStatus(forceRefresh bool) ([]storeExte
 }
 
 func (s *extensionStore) downloadExtension(extensionID string, destPath string) error {
-	registry, err := s.fetchRegistry(false)
+	catalog, err := s.fetchCatalog(false)
 	if err != nil {
 		return err
 	}
 
-	var ext *storeExtension
-	for _, e := range registry.Extensions {
-		if e.ID == extensionID {
-			ext = &e
+	var entry *storeCatalogEntry
+	for i := range catalog {
+		if catalog[i].ID == extensionID {
+			entry = &catalog[i]
 			break
 		}
 	}
 
-	if ext == nil {
+	if entry == nil {
 		return fmt.Errorf("extension %s not found in store", extensionID)
 	}
+	ext := &entry.storeExtension
+
+	if entry.registry.Trust == RegistryTrustUntrusted && (ext.Signature == "" || ext.PublicKey == "") {
+		return fmt.Errorf("extension %s comes from untrusted registry '%s' and is not signed by it", extensionID, entry.registry.Name)
+	}
 
 	if err := requireHTTPSURL(ext.getDownloadURL(), "extension download"); err != nil {
 		return err
 	}
 
-	LogInfo("ExtensionStore", "Downloading %s from %s", ext.getDisplayName(), ext.getDownloadURL())
+	LogInfo("ExtensionStore", "Downloading %s from %s (registry: %s)", ext.getDisplayName(), ext.getDownloadURL(), entry.registry.Name)
 
-	client := NewHTTPClientWithTimeout(5 * time.Minute)
+	client := s.httpClient(5 * time.Minute)
 	req, err := http.NewRequest(http.MethodGet, ext.getDownloadURL(), nil)
 	if err != nil {
 		return fmt.Errorf("failed to build download request: %w", err)
@@ -375,14 +616,23 @@ func (s *extensionStore) downloadExtension(extensionID string, destPath string)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer out.Close()
 
 	_, err = io.Copy(out, resp.Body)
+	out.Close()
 	if err != nil {
 		os.Remove(destPath)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
+	trustedBy := ""
+	if registryVouches(entry.registry, entry.source, ext.PublicKey) {
+		trustedBy = entry.registry.Name
+	}
+	if err := recordRegistrySignature(ext, destPath, trustedBy); err != nil {
+		os.Remove(destPath)
+		return err
+	}
+
 	LogInfo("ExtensionStore", "Downloaded %s to %s", ext.getDisplayName(), destPath)
 	return nil
 }
@@ -518,8 +768,8 @@ func (s *extensionStore) clearCache() {
 	s.cacheMu.Lock()
 	defer s.cacheMu.Unlock()
 
-	s.cache = nil
-	s.cacheTime = time.Time{}
+	s.caches = make(map[string]*registryCache)
+	s.fetchErrors = make(map[string]string)
 
 	if s.cacheDir != "" {
 		cachePath := filepath.Join(s.cacheDir, cacheFileName)
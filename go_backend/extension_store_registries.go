@@ -0,0 +1,215 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Registry trust levels. A trusted registry vouches for the packages signed
+// with its pinned public key, so such a signature counts as a trusted
+// publisher when the catalogue came from the registry's own URL. Packages
+// from an untrusted registry are only downloaded when the registry signs
+// them.
+const (
+	RegistryTrustTrusted   = "trusted"
+	RegistryTrustCommunity = "community"
+	RegistryTrustUntrusted = "untrusted"
+)
+
+// defaultRegistryName is the registry managed by SetStoreRegistryURLJSON.
+const defaultRegistryName = "default"
+
+// StoreRegistryConfig is one extension repository the store reads from.
+// When several registries list the same extension ID, the one with the
+// highest priority wins; ties go to the more trusted registry, then to the
+// one listed first.
+type StoreRegistryConfig struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Mirrors   []string `json:"mirrors,omitempty"`
+	Enabled   bool     `json:"enabled"`
+	Priority  int      `json:"priority"`
+	Trust     string   `json:"trust"`
+	PublicKey string   `json:"public_key,omitempty"`
+}
+
+// StoreRegistryStatus is a configured registry with the result of its last
+// fetch.
+type StoreRegistryStatus struct {
+	StoreRegistryConfig
+	SourceURL      string `json:"source_url,omitempty"`
+	LastFetched    int64  `json:"last_fetched,omitempty"`
+	ExtensionCount int    `json:"extension_count"`
+	Error          string `json:"error,omitempty"`
+}
+
+func registryTrustRank(trust string) int {
+	switch trust {
+	case RegistryTrustTrusted:
+		return 2
+	case RegistryTrustUntrusted:
+		return 0
+	}
+	return 1
+}
+
+// parseStoreRegistries decodes a registry list. Registries are enabled unless
+// they say otherwise.
+func parseStoreRegistries(data string) ([]StoreRegistryConfig, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("invalid registry list: %w", err)
+	}
+	registries := make([]StoreRegistryConfig, 0, len(raw))
+	for i, item := range raw {
+		cfg := StoreRegistryConfig{Enabled: true}
+		if err := json.Unmarshal(item, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid registry at index %d: %w", i, err)
+		}
+		registries = append(registries, cfg)
+	}
+	return registries, nil
+}
+
+// normalizeStoreRegistries resolves GitHub repository URLs, requires https
+// everywhere, fills in missing names and trust levels and rejects duplicate
+// names and malformed public keys.
+func normalizeStoreRegistries(registries []StoreRegistryConfig) ([]StoreRegistryConfig, error) {
+	seen := make(map[string]bool, len(registries))
+	result := make([]StoreRegistryConfig, 0, len(registries))
+	for _, cfg := range registries {
+		resolved, err := resolveRegistryURL(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireHTTPSURL(resolved, "registry"); err != nil {
+			return nil, err
+		}
+		cfg.URL = resolved
+
+		cfg.Name = strings.TrimSpace(cfg.Name)
+		if cfg.Name == "" {
+			cfg.Name = registryNameFromURL(resolved)
+		}
+		key := strings.ToLower(cfg.Name)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate registry name: %s", cfg.Name)
+		}
+		seen[key] = true
+
+		switch cfg.Trust {
+		case "":
+			cfg.Trust = RegistryTrustCommunity
+		case RegistryTrustTrusted, RegistryTrustCommunity, RegistryTrustUntrusted:
+		default:
+			return nil, fmt.Errorf("registry '%s': invalid trust level %q (must be 'trusted', 'community' or 'untrusted')", cfg.Name, cfg.Trust)
+		}
+
+		cfg.PublicKey = strings.TrimSpace(cfg.PublicKey)
+		if cfg.PublicKey != "" {
+			if _, err := decodeExtensionPublicKey(cfg.PublicKey); err != nil {
+				return nil, fmt.Errorf("registry '%s' public key: %w", cfg.Name, err)
+			}
+		}
+
+		var mirrors []string
+		for _, mirror := range cfg.Mirrors {
+			resolvedMirror, err := resolveRegistryURL(mirror)
+			if err != nil {
+				return nil, fmt.Errorf("registry '%s' mirror: %w", cfg.Name, err)
+			}
+			if err := requireHTTPSURL(resolvedMirror, "registry mirror"); err != nil {
+				return nil, err
+			}
+			if resolvedMirror != cfg.URL && !containsString(mirrors, resolvedMirror) {
+				mirrors = append(mirrors, resolvedMirror)
+			}
+		}
+		cfg.Mirrors = mirrors
+
+		result = append(result, cfg)
+	}
+	return result, nil
+}
+
+// registryNameFromURL names a registry after its GitHub repository, or its
+// host for anything else.
+func registryNameFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	if parsed.Host == "raw.githubusercontent.com" {
+		parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+		if len(parts) >= 2 {
+			return parts[0] + "/" + parts[1]
+		}
+	}
+	return parsed.Host
+}
+
+// orderedRegistries returns the enabled registries in the order their
+// entries win ID conflicts.
+func orderedRegistries(registries []StoreRegistryConfig) []StoreRegistryConfig {
+	enabled := make([]StoreRegistryConfig, 0, len(registries))
+	for _, cfg := range registries {
+		if cfg.Enabled {
+			enabled = append(enabled, cfg)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		if enabled[i].Priority != enabled[j].Priority {
+			return enabled[i].Priority > enabled[j].Priority
+		}
+		return registryTrustRank(enabled[i].Trust) > registryTrustRank(enabled[j].Trust)
+	})
+	return enabled
+}
+
+// withDefaultRegistryURL returns registries with the default registry
+// pointed at registryURL, added if missing, or removed when registryURL is
+// empty. Other registries are left alone.
+func withDefaultRegistryURL(registries []StoreRegistryConfig, registryURL string) []StoreRegistryConfig {
+	result := make([]StoreRegistryConfig, 0, len(registries)+1)
+	found := false
+	for _, cfg := range registries {
+		if !strings.EqualFold(cfg.Name, defaultRegistryName) {
+			result = append(result, cfg)
+			continue
+		}
+		found = true
+		if registryURL == "" {
+			continue
+		}
+		if cfg.URL != registryURL {
+			// Mirrors and the signing key belong to the old repository.
+			cfg.URL = registryURL
+			cfg.Mirrors = nil
+			cfg.PublicKey = ""
+		}
+		result = append(result, cfg)
+	}
+	if !found && registryURL != "" {
+		result = append(result, StoreRegistryConfig{
+			Name:    defaultRegistryName,
+			URL:     registryURL,
+			Enabled: true,
+			Trust:   RegistryTrustCommunity,
+		})
+	}
+	return result
+}
+
+// registryVouches reports whether a trusted registry vouches for a package
+// signed with publicKey in a catalogue served from source. Trust belongs to
+// the registry's own URL and pinned key, so a mirror or a key the catalogue
+// names on its own never makes a package trusted.
+func registryVouches(cfg StoreRegistryConfig, source, publicKey string) bool {
+	return cfg.Trust == RegistryTrustTrusted &&
+		cfg.PublicKey != "" &&
+		source == cfg.URL &&
+		strings.TrimSpace(publicKey) == cfg.PublicKey
+}
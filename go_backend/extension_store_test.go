@@ -0,0 +1,205 @@
+package gobackend
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newRegistryTestStore(t *testing.T, registries map[string]string) *extensionStore {
+	t.Helper()
+	store := newExtensionStore(t.TempDir())
+	store.client = &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, ok := registries[req.URL.String()]
+			status := http.StatusOK
+			if !ok {
+				status = http.StatusServiceUnavailable
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Header:     make(http.Header),
+				Request:    req,
+			}, nil
+		}),
+	}
+	return store
+}
+
+func TestExtensionStore_MergesRegistriesByPriority(t *testing.T) {
+	store := newRegistryTestStore(t, map[string]string{
+		"https://main.example/registry.json":   `{"extensions":[{"id":"shared","name":"shared","version":"1.0.0"},{"id":"main-only","name":"main-only","version":"1.0.0"}]}`,
+		"https://mirror.example/registry.json": `{"extensions":[{"id":"shared","name":"shared","version":"2.0.0"}]}`,
+		"https://off.example/registry.json":    `{"extensions":[{"id":"disabled-only","name":"disabled-only","version":"1.0.0"}]}`,
+	})
+	registries, err := parseStoreRegistries(`[
+		{"name": "main", "url": "https://main.example/registry.json"},
+		{"name": "beta", "url": "https://beta.example/registry.json", "mirrors": ["https://mirror.example/registry.json"], "priority": 10, "trust": "trusted"},
+		{"name": "off", "url": "https://off.example/registry.json", "enabled": false, "priority": 20}
+	]`)
+	if err != nil {
+		t.Fatalf("parseStoreRegistries: %v", err)
+	}
+	registries, err = normalizeStoreRegistries(registries)
+	if err != nil {
+		t.Fatalf("normalizeStoreRegistries: %v", err)
+	}
+	store.setRegistries(registries)
+
+	extensions, err := store.getExtensionsWithStatus(false)
+	if err != nil {
+		t.Fatalf("getExtensionsWithStatus: %v", err)
+	}
+	got := make(map[string]storeExtensionResponse)
+	for _, ext := range extensions {
+		got[ext.ID] = ext
+	}
+	if len(got) != 2 {
+		t.Fatalf("catalogue = %+v, want shared and main-only", extensions)
+	}
+	if shared := got["shared"]; shared.Registry != "beta" || shared.Version != "2.0.0" || shared.RegistryTrust != RegistryTrustTrusted {
+		t.Fatalf("shared = %+v, want version 2.0.0 from the higher-priority beta registry", shared)
+	}
+	if mainOnly := got["main-only"]; mainOnly.Registry != "main" || mainOnly.RegistryTrust != RegistryTrustCommunity {
+		t.Fatalf("main-only = %+v", mainOnly)
+	}
+
+	for _, status := range store.getRegistries() {
+		if status.Name == "beta" && (status.SourceURL != "https://mirror.example/registry.json" || status.ExtensionCount != 1) {
+			t.Fatalf("beta status = %+v, want it served from the mirror", status)
+		}
+	}
+
+	// The cached catalogues survive a restart.
+	reloaded := newExtensionStore(store.cacheDir)
+	reloaded.loadDiskCache()
+	reloaded.client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatalf("unexpected request to %s with a fresh cache", req.URL)
+		return nil, nil
+	})}
+	reloaded.setRegistries(registries)
+	if extensions, err := reloaded.getExtensionsWithStatus(false); err != nil || len(extensions) != 2 {
+		t.Fatalf("cached catalogue = %+v, %v", extensions, err)
+	}
+}
+
+func TestExtensionStore_RegistryConfiguration(t *testing.T) {
+	store := newRegistryTestStore(t, map[string]string{
+		"https://other.example/registry.json": `{"extensions":[{"id":"unsigned","name":"unsigned","version":"1.0.0","download_url":"https://other.example/unsigned.spotiflac-ext"}]}`,
+	})
+	store.setRegistries([]StoreRegistryConfig{
+		{Name: "other", URL: "https://other.example/registry.json", Enabled: true, Trust: RegistryTrustUntrusted},
+	})
+
+	store.setRegistryURL("https://default.example/registry.json")
+	if got := store.getRegistryURL(); got != "https://default.example/registry.json" {
+		t.Fatalf("getRegistryURL = %q", got)
+	}
+	if len(store.getRegistries()) != 2 {
+		t.Fatalf("setRegistryURL should keep other registries: %+v", store.getRegistries())
+	}
+
+	// The default registry is unreachable but the other one still works.
+	err := store.downloadExtension("unsigned", filepath.Join(t.TempDir(), "unsigned.spotiflac-ext"))
+	if err == nil || !strings.Contains(err.Error(), "untrusted registry 'other'") {
+		t.Fatalf("download from untrusted registry: err = %v", err)
+	}
+
+	store.setRegistryURL("")
+	if got := store.getRegistries(); len(got) != 1 || got[0].Name != "other" {
+		t.Fatalf("clearing the registry URL should only remove the default registry: %+v", got)
+	}
+
+	invalid := []struct {
+		name       string
+		registries []StoreRegistryConfig
+		message    string
+	}{
+		{"http", []StoreRegistryConfig{{Name: "a", URL: "http://a.example/registry.json"}}, "must use https"},
+		{"duplicate name", []StoreRegistryConfig{
+			{Name: "a", URL: "https://a.example/registry.json"},
+			{Name: "A", URL: "https://b.example/registry.json"},
+		}, "duplicate registry name"},
+		{"trust", []StoreRegistryConfig{{Name: "a", URL: "https://a.example/registry.json", Trust: "official"}}, "invalid trust level"},
+		{"mirror", []StoreRegistryConfig{{Name: "a", URL: "https://a.example/registry.json", Mirrors: []string{"http://m.example/registry.json"}}}, "registry mirror URL must use https"},
+		{"public key", []StoreRegistryConfig{{Name: "a", URL: "https://a.example/registry.json", PublicKey: "not-a-key"}}, "registry 'a' public key"},
+	}
+	for _, tt := range invalid {
+		if _, err := normalizeStoreRegistries(tt.registries); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.message)
+		}
+	}
+
+	named, err := normalizeStoreRegistries([]StoreRegistryConfig{{URL: "https://raw.githubusercontent.com/owner/repo/main/registry.json"}})
+	if err != nil || named[0].Name != "owner/repo" || named[0].Trust != RegistryTrustCommunity {
+		t.Fatalf("defaults = %+v, %v", named, err)
+	}
+}
+
+func TestExtensionStore_RegistriesSurviveRelaunch(t *testing.T) {
+	store := newRegistryTestStore(t, map[string]string{
+		"https://default.example/registry.json": `{"extensions":[{"id":"a","name":"a","version":"1.0.0"}]}`,
+		"https://extra.example/registry.json":   `{"extensions":[{"id":"b","name":"b","version":"1.0.0"}]}`,
+	})
+	store.setRegistryURL("https://default.example/registry.json")
+	store.setRegistries(append(store.registries, StoreRegistryConfig{
+		Name: "extra", URL: "https://extra.example/registry.json", Enabled: true, Trust: RegistryTrustCommunity,
+	}))
+	if extensions, err := store.getExtensionsWithStatus(false); err != nil || len(extensions) != 2 {
+		t.Fatalf("catalogue = %+v, %v", extensions, err)
+	}
+
+	// On launch the app restores the default registry URL it saved.
+	reloaded := newExtensionStore(store.cacheDir)
+	reloaded.loadRegistries()
+	reloaded.loadDiskCache()
+	reloaded.setRegistryURL("https://default.example/registry.json")
+
+	statuses := reloaded.getRegistries()
+	if len(statuses) != 2 || statuses[1].Name != "extra" {
+		t.Fatalf("registries after relaunch = %+v", statuses)
+	}
+	for _, status := range statuses {
+		if status.ExtensionCount != 1 || status.LastFetched == 0 {
+			t.Fatalf("cached catalogue of %s was pruned: %+v", status.Name, status)
+		}
+	}
+}
+
+func TestRegistryVouches_RequiresOwnURLAndPinnedKey(t *testing.T) {
+	key, _ := newSigningTestKey(t)
+	otherKey, _ := newSigningTestKey(t)
+	cfg := StoreRegistryConfig{
+		Name:      "official",
+		URL:       "https://official.example/registry.json",
+		Mirrors:   []string{"https://mirror.example/registry.json"},
+		Trust:     RegistryTrustTrusted,
+		PublicKey: key,
+	}
+	community := cfg
+	community.Trust = RegistryTrustCommunity
+	unpinned := cfg
+	unpinned.PublicKey = ""
+
+	tests := []struct {
+		name   string
+		cfg    StoreRegistryConfig
+		source string
+		key    string
+		want   bool
+	}{
+		{"own url and key", cfg, cfg.URL, key, true},
+		{"served by mirror", cfg, cfg.Mirrors[0], key, false},
+		{"key named by the catalogue", cfg, cfg.URL, otherKey, false},
+		{"no pinned key", unpinned, cfg.URL, key, false},
+		{"community registry", community, cfg.URL, key, false},
+	}
+	for _, tt := range tests {
+		if got := registryVouches(tt.cfg, tt.source, tt.key); got != tt.want {
+			t.Errorf("%s: registryVouches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -19,6 +19,8 @@ func (e *JSExecutionError) Error() string {
 	return e.Message
 }
 
+// RunWithTimeout runs script and, when it evaluates to a promise, spins the
+// VM's event loop until the promise settles or the timeout expires.
 func RunWithTimeout(vm *goja.Runtime, script string, timeout time.Duration) (goja.Value, error) {
 	if vm == nil {
 		return nil, fmt.Errorf("extension runtime unavailable")
@@ -30,6 +32,8 @@ func RunWithTimeout(vm *goja.Runtime, script string, timeout time.Duration) (goj
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
 
 	type result struct {
 		value goja.Value
@@ -38,6 +42,7 @@ func RunWithTimeout(vm *goja.Runtime, script string, timeout time.Duration) (goj
 	resultCh := make(chan result, 1)
 
 	var interrupted bool
+	var quotaErr error
 	var interruptMu sync.Mutex
 
 	go func() {
@@ -59,12 +64,44 @@ func RunWithTimeout(vm *goja.Runtime, script string, timeout time.Duration) (goj
 			}
 		}()
 
+		if quota := getExtensionQuota(vm); quota != nil {
+			if err := quota.checkCPU(0); err != nil {
+				resultCh <- result{nil, err}
+				return
+			}
+			run := startQuotaRun(quota)
+			defer run.finish()
+			stopMonitor := run.monitor(func(err error) {
+				interruptMu.Lock()
+				quotaErr = err
+				interruptMu.Unlock()
+				cancelRun()
+				vm.Interrupt(err)
+			})
+			defer stopMonitor()
+		}
+
+		// Let callbacks queued since the last call (background actions,
+		// timers) make progress before running the new script.
+		if loop := getExtensionEventLoop(vm); loop != nil {
+			loop.runPending()
+		}
+
 		val, err := vm.RunString(script)
+		if err == nil {
+			val, err = awaitExtensionResult(runCtx, vm, val)
+		}
 		resultCh <- result{val, err}
 	}()
 
 	select {
 	case res := <-resultCh:
+		interruptMu.Lock()
+		exceeded := quotaErr
+		interruptMu.Unlock()
+		if exceeded != nil {
+			return nil, exceeded
+		}
 		return res.value, res.err
 	case <-ctx.Done():
 		interruptMu.Lock()
@@ -0,0 +1,579 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stagingDirName holds downloaded updates inside the extensions directory
+// until the next InitExtensionSystem swaps them in. It has no manifest.json,
+// so LoadExtensionsFromDirectory skips it.
+const stagingDirName = ".staging"
+
+// autoUpdateFileName keeps the automatic update switch and the time of the
+// last pass in the extensions directory.
+const autoUpdateFileName = ".auto_update.json"
+
+const (
+	// extensionAutoUpdateStartDelay lets the app finish loading extensions
+	// and the store before the first pass after a start.
+	extensionAutoUpdateStartDelay = time.Minute
+	extensionAutoUpdateCheckEvery = time.Hour
+	extensionAutoUpdateInterval   = 24 * time.Hour
+)
+
+// ExtensionUpdateInfo is an installed extension with a newer version in the
+// store.
+type ExtensionUpdateInfo struct {
+	ExtensionID    string `json:"extension_id"`
+	DisplayName    string `json:"display_name"`
+	CurrentVersion string `json:"current_version"`
+	NewVersion     string `json:"new_version"`
+	MinAppVersion  string `json:"min_app_version,omitempty"`
+	// Compatible is false when the app is older than MinAppVersion. Such
+	// updates are listed but never installed automatically.
+	Compatible bool   `json:"compatible"`
+	Changelog  string `json:"changelog,omitempty"`
+	Registry   string `json:"registry,omitempty"`
+	// Staged is true when this version is downloaded and will be installed
+	// on the next start.
+	Staged bool `json:"staged"`
+	// PinnedVersion is set when the user pinned the installed version. Such
+	// updates are listed but never installed.
+	PinnedVersion string `json:"pinned_version,omitempty"`
+}
+
+// ExtensionUpdateResult reports what happened to one update, either when it
+// was staged or when it was applied on start.
+type ExtensionUpdateResult struct {
+	ExtensionID string `json:"extension_id"`
+	FromVersion string `json:"from_version,omitempty"`
+	Version     string `json:"version"`
+	// Status is "staged", "skipped" or "failed" when staging, and "applied",
+	// "rolled_back" or "discarded" when applying.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// stagedExtensionUpdate is written next to a staged extension directory as
+// <id>.json.
+type stagedExtensionUpdate struct {
+	ExtensionID string `json:"extension_id"`
+	FromVersion string `json:"from_version"`
+	Version     string `json:"version"`
+	StagedAt    int64  `json:"staged_at"`
+}
+
+// extensionAutoUpdateState is the content of autoUpdateFileName.
+type extensionAutoUpdateState struct {
+	Enabled bool  `json:"enabled"`
+	LastRun int64 `json:"last_run,omitempty"`
+}
+
+var (
+	extensionUpdateResults   []ExtensionUpdateResult
+	extensionAutoUpdateMu    sync.RWMutex
+	extensionAutoUpdateRunMu sync.Mutex
+	extensionAutoUpdateOnce  sync.Once
+)
+
+func (m *extensionManager) autoUpdatePath() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.extensionsDir == "" {
+		return ""
+	}
+	return filepath.Join(m.extensionsDir, autoUpdateFileName)
+}
+
+func (m *extensionManager) loadAutoUpdateState() extensionAutoUpdateState {
+	var state extensionAutoUpdateState
+	path := m.autoUpdatePath()
+	if path == "" {
+		return state
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		GoLog("[Extension] Failed to read auto-update settings: %v\n", err)
+	}
+	return state
+}
+
+func (m *extensionManager) saveAutoUpdateState(state extensionAutoUpdateState) error {
+	path := m.autoUpdatePath()
+	if path == "" {
+		return fmt.Errorf("extension system not initialized")
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (m *extensionManager) setExtensionAutoUpdate(enabled bool) error {
+	extensionAutoUpdateMu.Lock()
+	defer extensionAutoUpdateMu.Unlock()
+	state := m.loadAutoUpdateState()
+	state.Enabled = enabled
+	return m.saveAutoUpdateState(state)
+}
+
+func (m *extensionManager) isExtensionAutoUpdateEnabled() bool {
+	extensionAutoUpdateMu.RLock()
+	defer extensionAutoUpdateMu.RUnlock()
+	return m.loadAutoUpdateState().Enabled
+}
+
+// maybeRunExtensionAutoUpdate runs an update pass when automatic updates are
+// on, the store is initialized and the last pass is older than
+// extensionAutoUpdateInterval. It reports whether a pass ran.
+func (m *extensionManager) maybeRunExtensionAutoUpdate(store *extensionStore, now time.Time) bool {
+	if store == nil {
+		return false
+	}
+	extensionAutoUpdateMu.Lock()
+	state := m.loadAutoUpdateState()
+	if !state.Enabled || now.Sub(time.Unix(state.LastRun, 0)) < extensionAutoUpdateInterval {
+		extensionAutoUpdateMu.Unlock()
+		return false
+	}
+	state.LastRun = now.Unix()
+	if err := m.saveAutoUpdateState(state); err != nil {
+		GoLog("[Extension] Failed to save auto-update settings: %v\n", err)
+	}
+	extensionAutoUpdateMu.Unlock()
+
+	results, err := m.runExtensionAutoUpdate(store)
+	if err != nil {
+		GoLog("[Extension] Automatic update check failed: %v\n", err)
+		return true
+	}
+	staged := 0
+	for _, result := range results {
+		if result.Status == "staged" {
+			staged++
+		}
+	}
+	GoLog("[Extension] Automatic update check: %d update(s) staged for the next start\n", staged)
+	return true
+}
+
+// startExtensionAutoUpdates runs maybeRunExtensionAutoUpdate shortly after
+// the first InitExtensionSystem and then every extensionAutoUpdateCheckEvery
+// for the life of the process.
+func (m *extensionManager) startExtensionAutoUpdates() {
+	extensionAutoUpdateOnce.Do(func() {
+		go func() {
+			time.Sleep(extensionAutoUpdateStartDelay)
+			m.maybeRunExtensionAutoUpdate(getExtensionStore(), time.Now())
+			ticker := time.NewTicker(extensionAutoUpdateCheckEvery)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				m.maybeRunExtensionAutoUpdate(getExtensionStore(), now)
+			}
+		}()
+	})
+}
+
+func getExtensionUpdateResults() []ExtensionUpdateResult {
+	extensionAutoUpdateMu.RLock()
+	defer extensionAutoUpdateMu.RUnlock()
+	return append([]ExtensionUpdateResult{}, extensionUpdateResults...)
+}
+
+// isAppVersionCompatible reports whether the running app satisfies
+// minAppVersion. An unknown app version is assumed to be compatible.
+func isAppVersionCompatible(minAppVersion string) bool {
+	appVersion := GetAppVersion()
+	if strings.TrimSpace(minAppVersion) == "" || appVersion == "" {
+		return true
+	}
+	// Build metadata such as "3.2.0+120" is not part of the comparison.
+	appVersion = strings.SplitN(appVersion, "+", 2)[0]
+	return compareVersions(appVersion, strings.TrimSpace(minAppVersion)) >= 0
+}
+
+func (m *extensionManager) stagingDir() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.extensionsDir == "" {
+		return ""
+	}
+	return filepath.Join(m.extensionsDir, stagingDirName)
+}
+
+// checkExtensionUpdates compares installed extensions with the store
+// catalogue.
+func (m *extensionManager) checkExtensionUpdates(store *extensionStore, forceRefresh bool) ([]ExtensionUpdateInfo, error) {
+	catalog, err := store.fetchCatalog(forceRefresh)
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]*storeCatalogEntry, len(catalog))
+	for i := range catalog {
+		available[catalog[i].ID] = &catalog[i]
+	}
+
+	staged := m.readStagedUpdates()
+	updates := []ExtensionUpdateInfo{}
+	for _, ext := range m.GetAllExtensions() {
+		entry, ok := available[ext.ID]
+		if !ok || compareVersions(entry.Version, ext.Manifest.Version) <= 0 {
+			continue
+		}
+		displayName := ext.Manifest.DisplayName
+		if displayName == "" {
+			displayName = entry.getDisplayName()
+		}
+		minAppVersion := entry.getMinAppVersion()
+		update := ExtensionUpdateInfo{
+			ExtensionID:    ext.ID,
+			DisplayName:    displayName,
+			CurrentVersion: ext.Manifest.Version,
+			NewVersion:     entry.Version,
+			MinAppVersion:  minAppVersion,
+			Compatible:     isAppVersionCompatible(minAppVersion),
+			Changelog:      entry.Changelog,
+			Registry:       entry.registry.Name,
+			PinnedVersion:  getPinnedExtensionVersion(ext.ID),
+		}
+		if pending, ok := staged[ext.ID]; ok && pending.Version == entry.Version {
+			update.Staged = true
+		}
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].ExtensionID < updates[j].ExtensionID })
+	return updates, nil
+}
+
+// runExtensionAutoUpdate downloads every compatible update and stages it for
+// the next start. Updates that ask for new permissions are skipped, since
+// the user has to approve those.
+func (m *extensionManager) runExtensionAutoUpdate(store *extensionStore) ([]ExtensionUpdateResult, error) {
+	extensionAutoUpdateRunMu.Lock()
+	defer extensionAutoUpdateRunMu.Unlock()
+
+	stagingDir := m.stagingDir()
+	if stagingDir == "" {
+		return nil, fmt.Errorf("extension system not initialized")
+	}
+	updates, err := m.checkExtensionUpdates(store, true)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ExtensionUpdateResult{}
+	for _, update := range updates {
+		result := ExtensionUpdateResult{
+			ExtensionID: update.ExtensionID,
+			FromVersion: update.CurrentVersion,
+			Version:     update.NewVersion,
+		}
+		switch {
+		case update.Staged:
+			continue
+		case update.PinnedVersion != "":
+			result.Status = "skipped"
+			result.Error = fmt.Sprintf("pinned to v%s", update.PinnedVersion)
+		case !update.Compatible:
+			result.Status = "skipped"
+			result.Error = fmt.Sprintf("requires app version %s or newer", update.MinAppVersion)
+		default:
+			if err := m.downloadAndStageUpdate(store, update.ExtensionID, stagingDir); err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+				GoLog("[Extension] Auto-update of %s to v%s failed: %v\n", update.ExtensionID, update.NewVersion, err)
+			} else {
+				result.Status = "staged"
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (m *extensionManager) downloadAndStageUpdate(store *extensionStore, extensionID, stagingDir string) error {
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	packagePath := filepath.Join(stagingDir, sanitizeFilename(extensionID)+".download.spotiflac-ext")
+	defer os.Remove(packagePath)
+
+	if err := store.downloadExtension(extensionID, packagePath); err != nil {
+		return err
+	}
+	_, err := m.stageExtensionUpdate(packagePath)
+	return err
+}
+
+// stageExtensionUpdate checks a package the way UpgradeExtension does and
+// extracts it into the staging directory. The installed version keeps
+// running until applyStagedUpdates swaps the directories.
+func (m *extensionManager) stageExtensionUpdate(packagePath string) (*stagedExtensionUpdate, error) {
+	stagingDir := m.stagingDir()
+	if stagingDir == "" {
+		return nil, fmt.Errorf("extension system not initialized")
+	}
+
+	zipReader, err := zip.OpenReader(packagePath)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open extension file. The file may be corrupted or not a valid extension package")
+	}
+	defer zipReader.Close()
+
+	entries := zipPackageEntries(&zipReader.Reader)
+	var manifestData []byte
+	hasIndexJS := false
+	for _, entry := range entries {
+		switch filepath.Base(entry.name) {
+		case "manifest.json":
+			rc, err := entry.open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open manifest.json: %w", err)
+			}
+			manifestData, err = io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest.json: %w", err)
+			}
+		case "index.js":
+			hasIndexJS = true
+		}
+	}
+	if manifestData == nil {
+		return nil, fmt.Errorf("Invalid extension package: manifest.json not found")
+	}
+	if !hasIndexJS {
+		return nil, fmt.Errorf("Invalid extension package: index.js not found")
+	}
+	manifest, err := ParseManifest(manifestData)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid extension manifest: %w", err)
+	}
+	// The catalogue's min_app_version may be missing or stale; the package
+	// decides.
+	if !isAppVersionCompatible(manifest.MinAppVersion) {
+		return nil, fmt.Errorf("Extension '%s' v%s requires app version %s or newer", manifest.Name, manifest.Version, manifest.MinAppVersion)
+	}
+
+	existing, err := m.GetExtension(manifest.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Extension '%s' is not installed", manifest.Name)
+	}
+	if compareVersions(manifest.Version, existing.Manifest.Version) <= 0 {
+		return nil, fmt.Errorf("Extension '%s' v%s is not newer than the installed v%s", manifest.Name, manifest.Version, existing.Manifest.Version)
+	}
+	if pinned := getPinnedExtensionVersion(manifest.Name); pinned != "" {
+		return nil, fmt.Errorf("Extension '%s' is pinned to v%s", manifest.Name, pinned)
+	}
+	if diff := diffExtensionPermissions(&existing.Manifest.Permissions, &manifest.Permissions); len(diff.Added) > 0 {
+		return nil, fmt.Errorf("update asks for new permissions (%s) and must be approved", strings.Join(diff.Added, ", "))
+	}
+	if err := checkExtensionDataVersion(manifest); err != nil {
+		return nil, err
+	}
+
+	signature, err := m.enforcePackageSignature(manifest.Name, entries, packagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &stagedExtensionUpdate{
+		ExtensionID: manifest.Name,
+		FromVersion: existing.Manifest.Version,
+		Version:     manifest.Version,
+		StagedAt:    time.Now().Unix(),
+	}
+	stagedDir := filepath.Join(stagingDir, manifest.Name)
+	tmpDir := stagedDir + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	if err := extractExtensionPackage(&zipReader.Reader, tmpDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	// The registry signature only lives in memory, so keep it with the
+	// files for the checks on the next start.
+	if err := saveRegistrySignature(tmpDir, signature); err != nil {
+		GoLog("[Extension] Warning: failed to save registry signature: %v\n", err)
+	}
+
+	os.RemoveAll(stagedDir)
+	if err := os.Rename(tmpDir, stagedDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to stage update: %w", err)
+	}
+	data, err := json.Marshal(staged)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(stagedDir+".json", data, 0644); err != nil {
+		os.RemoveAll(stagedDir)
+		return nil, fmt.Errorf("failed to stage update: %w", err)
+	}
+
+	GoLog("[Extension] Staged %s v%s; it will be installed on next start\n", manifest.Name, manifest.Version)
+	return staged, nil
+}
+
+func (m *extensionManager) readStagedUpdates() map[string]stagedExtensionUpdate {
+	staged := make(map[string]stagedExtensionUpdate)
+	stagingDir := m.stagingDir()
+	if stagingDir == "" {
+		return staged
+	}
+	files, _ := filepath.Glob(filepath.Join(stagingDir, "*.json"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var update stagedExtensionUpdate
+		if json.Unmarshal(data, &update) != nil || update.ExtensionID == "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(stagingDir, update.ExtensionID)); err == nil && info.IsDir() {
+			staged[update.ExtensionID] = update
+		}
+	}
+	return staged
+}
+
+// applyStagedUpdates swaps staged versions into place. Each swap is two
+// renames, with the old version kept aside until the new one passes
+// validateExtensionLoad; if it does not, the old version is put back.
+// Extensions that are already loaded are left for the next start.
+func (m *extensionManager) applyStagedUpdates() []ExtensionUpdateResult {
+	stagingDir := m.stagingDir()
+	results := []ExtensionUpdateResult{}
+	if stagingDir == "" {
+		return results
+	}
+
+	m.mu.RLock()
+	extensionsDir := m.extensionsDir
+	dataDir := m.dataDir
+	m.mu.RUnlock()
+
+	// A swap interrupted between its two renames leaves only the backup.
+	backups, _ := filepath.Glob(filepath.Join(stagingDir, "*.old"))
+	for _, backup := range backups {
+		live := filepath.Join(extensionsDir, strings.TrimSuffix(filepath.Base(backup), ".old"))
+		if _, err := os.Stat(live); os.IsNotExist(err) {
+			if err := os.Rename(backup, live); err == nil {
+				GoLog("[Extension] Restored %s after an interrupted update\n", filepath.Base(live))
+			}
+		} else {
+			os.RemoveAll(backup)
+		}
+	}
+
+	for id, staged := range m.readStagedUpdates() {
+		if _, err := m.GetExtension(id); err == nil {
+			continue
+		}
+		result := m.applyStagedUpdate(staged, stagingDir, extensionsDir, dataDir)
+		os.Remove(filepath.Join(stagingDir, id+".json"))
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ExtensionID < results[j].ExtensionID })
+
+	extensionAutoUpdateMu.Lock()
+	extensionUpdateResults = results
+	extensionAutoUpdateMu.Unlock()
+	return results
+}
+
+func (m *extensionManager) applyStagedUpdate(staged stagedExtensionUpdate, stagingDir, extensionsDir, dataDir string) ExtensionUpdateResult {
+	id := staged.ExtensionID
+	result := ExtensionUpdateResult{ExtensionID: id, FromVersion: staged.FromVersion, Version: staged.Version}
+	stagedDir := filepath.Join(stagingDir, id)
+	live := filepath.Join(extensionsDir, id)
+	backup := filepath.Join(stagingDir, id+".old")
+
+	discard := func(reason string) ExtensionUpdateResult {
+		os.RemoveAll(stagedDir)
+		result.Status = "discarded"
+		result.Error = reason
+		GoLog("[Extension] Discarded staged update of %s: %s\n", id, reason)
+		return result
+	}
+
+	newManifest, err := readManifestFile(filepath.Join(stagedDir, "manifest.json"))
+	if err != nil || newManifest.Name != id {
+		return discard("staged files are not a valid extension")
+	}
+	liveManifest, err := readManifestFile(filepath.Join(live, "manifest.json"))
+	if err != nil {
+		return discard("extension is no longer installed")
+	}
+	if compareVersions(newManifest.Version, liveManifest.Version) <= 0 {
+		return discard(fmt.Sprintf("v%s is already installed", liveManifest.Version))
+	}
+	result.FromVersion = liveManifest.Version
+	if pinned := getPinnedExtensionVersion(id); pinned != "" {
+		return discard(fmt.Sprintf("pinned to v%s", pinned))
+	}
+
+	os.RemoveAll(backup)
+	if err := os.Rename(live, backup); err != nil {
+		return discard(fmt.Sprintf("failed to move the installed version aside: %v", err))
+	}
+	if err := os.Rename(stagedDir, live); err != nil {
+		os.Rename(backup, live)
+		return discard(fmt.Sprintf("failed to install the staged version: %v", err))
+	}
+
+	ext := &loadedExtension{
+		ID:        id,
+		Manifest:  newManifest,
+		DataDir:   filepath.Join(dataDir, id),
+		SourceDir: live,
+		quota:     newExtensionQuota(id),
+	}
+	if err := validateExtensionLoad(ext); err != nil {
+		os.RemoveAll(live)
+		if restoreErr := os.Rename(backup, live); restoreErr != nil {
+			GoLog("[Extension] Failed to restore %s v%s: %v\n", id, liveManifest.Version, restoreErr)
+		}
+		result.Status = "rolled_back"
+		result.Error = err.Error()
+		GoLog("[Extension] Rolled back %s to v%s: %v\n", id, liveManifest.Version, err)
+		return result
+	}
+
+	if err := m.archiveExtensionVersion(id, backup); err != nil {
+		GoLog("[Extension] Warning: failed to archive v%s of %s: %v\n", liveManifest.Version, id, err)
+	}
+	os.RemoveAll(backup)
+	if entries, err := dirPackageEntries(live); err == nil {
+		if info, err := inspectPackageSignature(entries, ""); err == nil {
+			m.pinPublisherKey(id, info)
+		}
+	}
+	result.Status = "applied"
+	GoLog("[Extension] Updated %s from v%s to v%s\n", id, liveManifest.Version, newManifest.Version)
+	return result
+}
+
+func readManifestFile(path string) (*ExtensionManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseInstalledManifest(data)
+}
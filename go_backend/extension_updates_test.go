@@ -0,0 +1,242 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newUpdateTestManager(t *testing.T, root string) *extensionManager {
+	t.Helper()
+	manager := &extensionManager{extensions: make(map[string]*loadedExtension)}
+	if err := manager.SetDirectories(filepath.Join(root, "extensions"), filepath.Join(root, "data")); err != nil {
+		t.Fatalf("SetDirectories: %v", err)
+	}
+	return manager
+}
+
+func installedVersion(t *testing.T, manager *extensionManager, id string) string {
+	t.Helper()
+	manifest, err := readManifestFile(filepath.Join(manager.extensionsDir, id, "manifest.json"))
+	if err != nil {
+		t.Fatalf("read installed manifest: %v", err)
+	}
+	return manifest.Version
+}
+
+func TestExtensionUpdates_StageApplyAndRollback(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	root := t.TempDir()
+	pkgDir := t.TempDir()
+	manager := newUpdateTestManager(t, root)
+
+	v1 := filepath.Join(pkgDir, "v1.spotiflac-ext")
+	writeTestExtensionPackage(t, v1, "update-ext", "1.0.0", signingTestScript)
+	if _, err := manager.LoadExtensionFromFile(v1); err != nil {
+		t.Fatalf("install v1: %v", err)
+	}
+
+	v2 := filepath.Join(pkgDir, "v2.spotiflac-ext")
+	writeTestExtensionPackage(t, v2, "update-ext", "2.0.0", signingTestScript)
+	if _, err := manager.stageExtensionUpdate(v2); err != nil {
+		t.Fatalf("stage v2: %v", err)
+	}
+	if got := installedVersion(t, manager, "update-ext"); got != "1.0.0" {
+		t.Fatalf("staging must not touch the installed version, got %s", got)
+	}
+
+	// Loaded extensions are never swapped under a running VM.
+	if results := manager.applyStagedUpdates(); len(results) != 0 {
+		t.Fatalf("applied while loaded: %+v", results)
+	}
+
+	restarted := newUpdateTestManager(t, root)
+	results := restarted.applyStagedUpdates()
+	if len(results) != 1 || results[0].Status != "applied" || results[0].FromVersion != "1.0.0" || results[0].Version != "2.0.0" {
+		t.Fatalf("apply results = %+v", results)
+	}
+	loaded, errs := restarted.LoadExtensionsFromDirectory(restarted.extensionsDir)
+	if len(errs) != 0 || len(loaded) != 1 {
+		t.Fatalf("load after update: %v, %v", loaded, errs)
+	}
+	if ext, _ := restarted.GetExtension("update-ext"); ext.Manifest.Version != "2.0.0" || ext.Error != "" {
+		t.Fatalf("loaded extension = v%s (%s)", ext.Manifest.Version, ext.Error)
+	}
+
+	broken := filepath.Join(pkgDir, "v3.spotiflac-ext")
+	writeTestExtensionPackage(t, broken, "update-ext", "3.0.0", `var x = 1;`)
+	if _, err := restarted.stageExtensionUpdate(broken); err != nil {
+		t.Fatalf("stage v3: %v", err)
+	}
+	results = newUpdateTestManager(t, root).applyStagedUpdates()
+	if len(results) != 1 || results[0].Status != "rolled_back" || !strings.Contains(results[0].Error, "registerExtension") {
+		t.Fatalf("rollback results = %+v", results)
+	}
+	if got := installedVersion(t, restarted, "update-ext"); got != "2.0.0" {
+		t.Fatalf("after rollback installed version = %s, want 2.0.0", got)
+	}
+	if leftovers, _ := os.ReadDir(filepath.Join(root, "extensions", stagingDirName)); len(leftovers) != 0 {
+		names := make([]string, len(leftovers))
+		for i, entry := range leftovers {
+			names[i] = entry.Name()
+		}
+		t.Fatalf("staging directory not cleaned up: %v", names)
+	}
+}
+
+func TestExtensionUpdates_CheckAndAutoUpdate(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	previousAppVersion := GetAppVersion()
+	t.Cleanup(func() { SetAppVersion(previousAppVersion) })
+	SetAppVersion("3.0.0+120")
+
+	root := t.TempDir()
+	pkgDir := t.TempDir()
+	manager := newUpdateTestManager(t, root)
+	for _, id := range []string{"fresh-ext", "future-ext"} {
+		pkg := filepath.Join(pkgDir, id+".spotiflac-ext")
+		writeTestExtensionPackage(t, pkg, id, "1.0.0", signingTestScript)
+		if _, err := manager.LoadExtensionFromFile(pkg); err != nil {
+			t.Fatalf("install %s: %v", id, err)
+		}
+	}
+	update := filepath.Join(pkgDir, "fresh-ext-1.1.0.spotiflac-ext")
+	writeTestExtensionPackage(t, update, "fresh-ext", "1.1.0", signingTestScript)
+	updateData, _ := os.ReadFile(update)
+
+	store := newRegistryTestStore(t, map[string]string{
+		"https://registry.example/registry.json": `{"extensions":[
+			{"id":"fresh-ext","name":"fresh-ext","version":"1.1.0","changelog":"Faster search","minAppVersion":"3.0.0","download_url":"https://registry.example/fresh-ext.spotiflac-ext"},
+			{"id":"future-ext","name":"future-ext","version":"2.0.0","min_app_version":"4.0.0"},
+			{"id":"other-ext","name":"other-ext","version":"5.0.0"}
+		]}`,
+		"https://registry.example/fresh-ext.spotiflac-ext": string(updateData),
+	})
+	store.setRegistryURL("https://registry.example/registry.json")
+
+	updates, err := manager.checkExtensionUpdates(store, false)
+	if err != nil {
+		t.Fatalf("checkExtensionUpdates: %v", err)
+	}
+	got := make([]string, len(updates))
+	for i, u := range updates {
+		got[i] = fmt.Sprintf("%s %s->%s compatible=%v changelog=%q", u.ExtensionID, u.CurrentVersion, u.NewVersion, u.Compatible, u.Changelog)
+	}
+	want := []string{
+		`fresh-ext 1.0.0->1.1.0 compatible=true changelog="Faster search"`,
+		`future-ext 1.0.0->2.0.0 compatible=false changelog=""`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("updates:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	results, err := manager.runExtensionAutoUpdate(store)
+	if err != nil {
+		t.Fatalf("runExtensionAutoUpdate: %v", err)
+	}
+	if len(results) != 2 || results[0].Status != "staged" || results[1].Status != "skipped" {
+		t.Fatalf("auto-update results = %+v", results)
+	}
+	updates, _ = manager.checkExtensionUpdates(store, false)
+	if !updates[0].Staged || updates[1].Staged {
+		t.Fatalf("staged flags = %+v", updates)
+	}
+	if results, _ := manager.runExtensionAutoUpdate(store); len(results) != 1 || results[0].ExtensionID != "future-ext" {
+		t.Fatalf("a staged update should not be downloaded again: %+v", results)
+	}
+}
+
+func TestExtensionUpdates_AutoUpdateIsSavedAndScheduled(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	root := t.TempDir()
+	pkgDir := t.TempDir()
+	manager := newUpdateTestManager(t, root)
+	pkg := filepath.Join(pkgDir, "sched-ext.spotiflac-ext")
+	writeTestExtensionPackage(t, pkg, "sched-ext", "1.0.0", signingTestScript)
+	if _, err := manager.LoadExtensionFromFile(pkg); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	update := filepath.Join(pkgDir, "sched-ext-1.1.0.spotiflac-ext")
+	writeTestExtensionPackage(t, update, "sched-ext", "1.1.0", signingTestScript)
+	updateData, _ := os.ReadFile(update)
+	store := newRegistryTestStore(t, map[string]string{
+		"https://registry.example/registry.json": `{"extensions":[
+			{"id":"sched-ext","name":"sched-ext","version":"1.1.0","download_url":"https://registry.example/sched-ext.spotiflac-ext"}
+		]}`,
+		"https://registry.example/sched-ext.spotiflac-ext": string(updateData),
+	})
+	store.setRegistryURL("https://registry.example/registry.json")
+
+	now := time.Now()
+	if manager.maybeRunExtensionAutoUpdate(store, now) {
+		t.Fatal("ran while automatic updates are off")
+	}
+	if err := manager.setExtensionAutoUpdate(true); err != nil {
+		t.Fatalf("setExtensionAutoUpdate: %v", err)
+	}
+	restarted := newUpdateTestManager(t, root)
+	if !restarted.isExtensionAutoUpdateEnabled() {
+		t.Fatal("the setting did not survive a restart")
+	}
+
+	if !manager.maybeRunExtensionAutoUpdate(store, now) {
+		t.Fatal("did not run once enabled")
+	}
+	updates, _ := manager.checkExtensionUpdates(store, false)
+	if len(updates) != 1 || !updates[0].Staged {
+		t.Fatalf("updates after the scheduled pass = %+v", updates)
+	}
+	if restarted.maybeRunExtensionAutoUpdate(store, now.Add(time.Hour)) {
+		t.Fatal("ran again before the interval passed")
+	}
+	if !restarted.maybeRunExtensionAutoUpdate(store, now.Add(extensionAutoUpdateInterval)) {
+		t.Fatal("did not run once the interval passed")
+	}
+}
+
+func TestExtensionUpdates_StageChecksPackageMinAppVersion(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	previousAppVersion := GetAppVersion()
+	t.Cleanup(func() { SetAppVersion(previousAppVersion) })
+	SetAppVersion("3.0.0")
+
+	pkgDir := t.TempDir()
+	manager := newUpdateTestManager(t, t.TempDir())
+	pkg := filepath.Join(pkgDir, "min-ext.spotiflac-ext")
+	writeTestExtensionPackage(t, pkg, "min-ext", "1.0.0", signingTestScript)
+	if _, err := manager.LoadExtensionFromFile(pkg); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+
+	update := filepath.Join(pkgDir, "min-ext-2.0.0.spotiflac-ext")
+	f, err := os.Create(update)
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"manifest.json": `{"name":"min-ext","version":"2.0.0","description":"d","type":["metadata_provider"],"minAppVersion":"4.0.0"}`,
+		"index.js":      signingTestScript,
+	} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	f.Close()
+
+	if _, err := manager.stageExtensionUpdate(update); err == nil || !strings.Contains(err.Error(), "requires app version 4.0.0") {
+		t.Fatalf("stage = %v, want a min app version error", err)
+	}
+	if staged, _ := os.ReadDir(manager.stagingDir()); len(staged) != 0 {
+		t.Fatalf("an incompatible update was staged")
+	}
+}
@@ -0,0 +1,335 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// versionsDirName holds the packages of replaced versions inside the
+// extensions directory, as .versions/<id>/<version>.spotiflac-ext. It has
+// no manifest.json, so LoadExtensionsFromDirectory skips it.
+const versionsDirName = ".versions"
+
+const (
+	pinnedVersionSettingKey = "_pinned_version"
+	// dataVersionSettingKey is the highest manifest dataVersion that has run
+	// against the extension's storage and settings. It advances when a
+	// release first starts (see migrateExtensionSettingsLocked), not when a
+	// package is merely installed or its settings are saved.
+	dataVersionSettingKey = "_data_version"
+
+	defaultExtensionVersionHistory = 3
+)
+
+var (
+	extensionVersionHistory   = defaultExtensionVersionHistory
+	extensionVersionHistoryMu sync.RWMutex
+)
+
+// ExtensionVersionInfo is one archived package of an extension.
+type ExtensionVersionInfo struct {
+	Version     string `json:"version"`
+	DataVersion int    `json:"data_version,omitempty"`
+	ArchivedAt  int64  `json:"archived_at"`
+	Size        int64  `json:"size"`
+	// Compatible is false when the stored data has a newer dataVersion than
+	// this package understands, so rolling back to it is refused.
+	Compatible bool `json:"compatible"`
+}
+
+// ExtensionVersionHistory lists the versions an extension can roll back to.
+type ExtensionVersionHistory struct {
+	ExtensionID      string                 `json:"extension_id"`
+	InstalledVersion string                 `json:"installed_version"`
+	PinnedVersion    string                 `json:"pinned_version,omitempty"`
+	DataVersion      int                    `json:"data_version"`
+	Versions         []ExtensionVersionInfo `json:"versions"`
+}
+
+// setExtensionVersionHistory sets how many replaced versions are kept per
+// extension. Zero turns archiving off; existing archives are trimmed on the
+// next upgrade.
+func setExtensionVersionHistory(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	extensionVersionHistoryMu.Lock()
+	extensionVersionHistory = limit
+	extensionVersionHistoryMu.Unlock()
+}
+
+func getExtensionVersionHistory() int {
+	extensionVersionHistoryMu.RLock()
+	defer extensionVersionHistoryMu.RUnlock()
+	return extensionVersionHistory
+}
+
+func getPinnedExtensionVersion(extensionID string) string {
+	value, err := GetExtensionSettingsStore().Get(extensionID, pinnedVersionSettingKey)
+	if err != nil {
+		return ""
+	}
+	pinned, _ := value.(string)
+	return pinned
+}
+
+func getExtensionDataVersion(extensionID string) int {
+	value, err := GetExtensionSettingsStore().Get(extensionID, dataVersionSettingKey)
+	if err != nil {
+		return 0
+	}
+	// Settings read back from disk are float64.
+	switch v := value.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// checkExtensionDataVersion refuses a package that is older than the data it
+// would read. Packages without dataVersion are version 0.
+func checkExtensionDataVersion(manifest *ExtensionManifest) error {
+	if stored := getExtensionDataVersion(manifest.Name); manifest.DataVersion < stored {
+		return fmt.Errorf("Extension '%s' v%s uses data version %d, but its stored data is version %d", manifest.Name, manifest.Version, manifest.DataVersion, stored)
+	}
+	return nil
+}
+
+func (m *extensionManager) extensionVersionsDir(extensionID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.extensionsDir == "" {
+		return ""
+	}
+	return filepath.Join(m.extensionsDir, versionsDirName, extensionID)
+}
+
+// archiveExtensionVersion packs an installed extension directory into the
+// version history before it is replaced, then trims the history.
+func (m *extensionManager) archiveExtensionVersion(extensionID, sourceDir string) error {
+	limit := getExtensionVersionHistory()
+	versionsDir := m.extensionVersionsDir(extensionID)
+	if versionsDir == "" {
+		return nil
+	}
+	if limit == 0 {
+		return pruneExtensionVersions(versionsDir, 0)
+	}
+
+	manifest, err := readManifestFile(filepath.Join(sourceDir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+	pkg, err := packExtensionDir(sourceDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(versionsDir, 0755); err != nil {
+		return err
+	}
+	archivePath := filepath.Join(versionsDir, sanitizeFilename(manifest.Version)+".spotiflac-ext")
+	tmpPath := archivePath + ".tmp"
+	if err := os.WriteFile(tmpPath, pkg, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return pruneExtensionVersions(versionsDir, limit)
+}
+
+// pruneExtensionVersions keeps the limit most recently archived packages.
+func pruneExtensionVersions(versionsDir string, limit int) error {
+	files, err := filepath.Glob(filepath.Join(versionsDir, "*.spotiflac-ext"))
+	if err != nil || len(files) <= limit {
+		return err
+	}
+	modTimes := make(map[string]int64, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime().UnixNano()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return modTimes[files[i]] > modTimes[files[j]] })
+	for _, file := range files[limit:] {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPackageManifest(packagePath string) (*ExtensionManifest, error) {
+	zipReader, err := zip.OpenReader(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+	for _, file := range zipReader.File {
+		if filepath.Clean(file.Name) != "manifest.json" {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return parseInstalledManifest(data)
+	}
+	return nil, fmt.Errorf("manifest.json not found")
+}
+
+// archivedExtensionVersions maps each archived version to its package path.
+func (m *extensionManager) archivedExtensionVersions(extensionID string) (map[string]string, map[string]*ExtensionManifest) {
+	paths := make(map[string]string)
+	manifests := make(map[string]*ExtensionManifest)
+	versionsDir := m.extensionVersionsDir(extensionID)
+	if versionsDir == "" {
+		return paths, manifests
+	}
+	files, _ := filepath.Glob(filepath.Join(versionsDir, "*.spotiflac-ext"))
+	for _, file := range files {
+		manifest, err := readPackageManifest(file)
+		if err != nil || manifest.Name != extensionID {
+			continue
+		}
+		paths[manifest.Version] = file
+		manifests[manifest.Version] = manifest
+	}
+	return paths, manifests
+}
+
+func (m *extensionManager) listExtensionVersions(extensionID string) (*ExtensionVersionHistory, error) {
+	ext, err := m.GetExtension(extensionID)
+	if err != nil {
+		return nil, err
+	}
+	history := &ExtensionVersionHistory{
+		ExtensionID:      extensionID,
+		InstalledVersion: ext.Manifest.Version,
+		PinnedVersion:    getPinnedExtensionVersion(extensionID),
+		DataVersion:      getExtensionDataVersion(extensionID),
+		Versions:         []ExtensionVersionInfo{},
+	}
+	paths, manifests := m.archivedExtensionVersions(extensionID)
+	for version, path := range paths {
+		info := ExtensionVersionInfo{
+			Version:     version,
+			DataVersion: manifests[version].DataVersion,
+			Compatible:  manifests[version].DataVersion >= history.DataVersion,
+		}
+		if stat, err := os.Stat(path); err == nil {
+			info.ArchivedAt = stat.ModTime().Unix()
+			info.Size = stat.Size()
+		}
+		history.Versions = append(history.Versions, info)
+	}
+	sort.Slice(history.Versions, func(i, j int) bool {
+		return compareVersions(history.Versions[i].Version, history.Versions[j].Version) > 0
+	})
+	return history, nil
+}
+
+// RollbackExtension reinstalls an archived version. An empty version picks
+// the newest archived version older than the installed one. The installed
+// version is archived in turn, so the rollback can be undone. A pin on a
+// different version is cleared.
+func (m *extensionManager) RollbackExtension(extensionID, version string) (*loadedExtension, error) {
+	ext, err := m.GetExtension(extensionID)
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := m.archivedExtensionVersions(extensionID)
+	if version == "" {
+		for archived := range paths {
+			if compareVersions(archived, ext.Manifest.Version) < 0 && (version == "" || compareVersions(archived, version) > 0) {
+				version = archived
+			}
+		}
+		if version == "" {
+			return nil, fmt.Errorf("no previous version of '%s' is available", ext.Manifest.DisplayName)
+		}
+	}
+	archivePath, ok := paths[version]
+	if !ok {
+		return nil, fmt.Errorf("v%s of '%s' is not in the version history", version, ext.Manifest.DisplayName)
+	}
+
+	// The package is installed from a copy and leaves the history, so it
+	// does not count when the installed version is archived and the history
+	// is trimmed.
+	tmpDir, err := os.MkdirTemp("", "spotiflac-rollback-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	packagePath := filepath.Join(tmpDir, filepath.Base(archivePath))
+	if err := os.WriteFile(packagePath, data, 0644); err != nil {
+		return nil, err
+	}
+
+	if pinned := getPinnedExtensionVersion(extensionID); pinned != "" && pinned != version {
+		if err := GetExtensionSettingsStore().Remove(extensionID, pinnedVersionSettingKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(archivePath); err != nil {
+		return nil, err
+	}
+	rolledBack, err := m.replaceExtension(packagePath, true)
+	if err != nil {
+		if restoreErr := os.WriteFile(archivePath, data, 0644); restoreErr != nil {
+			GoLog("[Extension] Failed to restore archived v%s of %s: %v\n", version, extensionID, restoreErr)
+		}
+		return nil, err
+	}
+	GoLog("[Extension] Rolled back %s from v%s to v%s\n", extensionID, ext.Manifest.Version, version)
+	return rolledBack, nil
+}
+
+// PinExtensionVersion keeps an extension at version: store updates skip it
+// and UpgradeExtension refuses it until it is unpinned with an empty
+// version. Pinning an archived version rolls back to it first.
+func (m *extensionManager) PinExtensionVersion(extensionID, version string) error {
+	ext, err := m.GetExtension(extensionID)
+	if err != nil {
+		return err
+	}
+	store := GetExtensionSettingsStore()
+	version = strings.TrimSpace(version)
+	if version == "" {
+		if err := store.Remove(extensionID, pinnedVersionSettingKey); err != nil {
+			return err
+		}
+		GoLog("[Extension] Unpinned %s\n", extensionID)
+		return nil
+	}
+	if version != ext.Manifest.Version {
+		if _, err := m.RollbackExtension(extensionID, version); err != nil {
+			return err
+		}
+	}
+	if err := store.Set(extensionID, pinnedVersionSettingKey, version); err != nil {
+		return err
+	}
+	GoLog("[Extension] Pinned %s to v%s\n", extensionID, version)
+	return nil
+}
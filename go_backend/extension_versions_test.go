@@ -0,0 +1,117 @@
+package gobackend
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDataVersionTestPackage(t *testing.T, dir, version string, dataVersion int) string {
+	t.Helper()
+	path := filepath.Join(dir, "versions-ext-"+version+".spotiflac-ext")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create package: %v", err)
+	}
+	w := zip.NewWriter(f)
+	files := map[string]string{
+		"manifest.json": fmt.Sprintf(`{"name":"versions-ext","version":%q,"description":"d","type":["metadata_provider"],"dataVersion":%d}`, version, dataVersion),
+		"index.js":      signingTestScript,
+	}
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	f.Close()
+	return path
+}
+
+func TestExtensionVersions_HistoryRollbackAndPin(t *testing.T) {
+	newSigningTestManager(t, SignaturePolicyOff)
+	t.Cleanup(func() { setExtensionVersionHistory(defaultExtensionVersionHistory) })
+	setExtensionVersionHistory(2)
+	pkgDir := t.TempDir()
+	manager := newUpdateTestManager(t, t.TempDir())
+
+	if _, err := manager.LoadExtensionFromFile(writeDataVersionTestPackage(t, pkgDir, "1.0.0", 0)); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	for _, version := range []string{"1.1.0", "1.2.0", "1.3.0"} {
+		if _, err := manager.UpgradeExtension(writeDataVersionTestPackage(t, pkgDir, version, 1)); err != nil {
+			t.Fatalf("upgrade to %s: %v", version, err)
+		}
+	}
+	// The data version only advances once a release has run.
+	if got := getExtensionDataVersion("versions-ext"); got != 0 {
+		t.Fatalf("data version before the upgrade ran = %d", got)
+	}
+	if err := manager.SetExtensionEnabled("versions-ext", true); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+
+	versions := func() string {
+		history, err := manager.listExtensionVersions("versions-ext")
+		if err != nil {
+			t.Fatalf("listExtensionVersions: %v", err)
+		}
+		got := make([]string, len(history.Versions))
+		for i, v := range history.Versions {
+			got[i] = v.Version
+		}
+		return history.InstalledVersion + " [" + strings.Join(got, " ") + "] pinned=" + history.PinnedVersion
+	}
+	if got := versions(); got != "1.3.0 [1.2.0 1.1.0] pinned=" {
+		t.Fatalf("history = %s", got)
+	}
+
+	if _, err := manager.RollbackExtension("versions-ext", ""); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if got := versions(); got != "1.2.0 [1.3.0 1.1.0] pinned=" {
+		t.Fatalf("after rollback = %s", got)
+	}
+	if installedVersion(t, manager, "versions-ext") != "1.2.0" {
+		t.Fatal("rollback did not replace the installed files")
+	}
+
+	if err := manager.PinExtensionVersion("versions-ext", "1.1.0"); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	if got := versions(); got != "1.1.0 [1.3.0 1.2.0] pinned=1.1.0" {
+		t.Fatalf("after pin = %s", got)
+	}
+	newer := writeDataVersionTestPackage(t, pkgDir, "1.4.0", 1)
+	if _, err := manager.UpgradeExtension(newer); err == nil || !strings.Contains(err.Error(), "pinned to v1.1.0") {
+		t.Fatalf("upgrade of a pinned extension: err = %v", err)
+	}
+	if _, err := manager.stageExtensionUpdate(newer); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Fatalf("staging an update of a pinned extension: err = %v", err)
+	}
+
+	if err := manager.PinExtensionVersion("versions-ext", ""); err != nil {
+		t.Fatalf("unpin: %v", err)
+	}
+	// Version 1.3.0 ran with data version 1, which a dataVersion 0 package cannot read.
+	if _, err := manager.UpgradeExtension(writeDataVersionTestPackage(t, pkgDir, "2.0.0", 0)); err == nil || !strings.Contains(err.Error(), "stored data is version 1") {
+		t.Fatalf("upgrade to an older data version: err = %v", err)
+	}
+	if _, err := manager.UpgradeExtension(newer); err != nil {
+		t.Fatalf("upgrade after unpin: %v", err)
+	}
+
+	if err := manager.RemoveExtension("versions-ext"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(manager.extensionsDir, versionsDirName, "versions-ext")); !os.IsNotExist(err) {
+		t.Fatalf("version history left behind: %v", err)
+	}
+}
@@ -0,0 +1,393 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-flac/flacvorbis/v2"
+	"github.com/go-flac/go-flac/v2"
+)
+
+const (
+	flacCueSheetCatalogSize  = 128
+	flacCueSheetReservedSize = 258
+	flacCueSheetISRCSize     = 12
+	flacCueTrackReservedSize = 13
+	flacCueIndexReservedSize = 3
+	flacCueLeadOutCD         = 170
+	flacCueLeadOutNonCD      = 255
+	flacCDSampleRate         = 44100
+	flacCDLeadInSamples      = 88200 // 2 seconds, the minimum for CD-DA
+)
+
+// readEmbeddedFLACCueSheet returns the cue sheet stored inside a FLAC image,
+// or nil when there is none. A CUESHEET Vorbis comment is preferred because
+// it carries titles; otherwise the binary CUESHEET metadata block is decoded.
+// Album-level fields missing from the sheet are filled from the file's tags,
+// and every track is pointed at the FLAC file itself.
+func readEmbeddedFLACCueSheet(filePath string) (*CueSheet, error) {
+	f, err := flac.ParseFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+	defer f.Close()
+
+	var cmt *flacvorbis.MetaDataBlockVorbisComment
+	var cueBlock *flac.MetaDataBlock
+	for _, meta := range f.Meta {
+		switch meta.Type {
+		case flac.VorbisComment:
+			if cmt == nil {
+				cmt, _ = flacvorbis.ParseFromMetaDataBlock(*meta)
+			}
+		case flac.CueSheet:
+			if cueBlock == nil {
+				cueBlock = meta
+			}
+		}
+	}
+
+	var sheet *CueSheet
+	if cmt != nil {
+		if cueText := getComment(cmt, "CUESHEET"); strings.TrimSpace(cueText) != "" {
+			sheet, err = ParseCueString(cueText)
+			if err != nil {
+				GoLog("[CueSheet] Ignoring invalid CUESHEET tag in %s: %v\n", filePath, err)
+				sheet = nil
+			}
+		}
+	}
+
+	if sheet == nil && cueBlock != nil {
+		streamInfo, err := f.GetStreamInfo()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream info: %w", err)
+		}
+		sheet, err = decodeFLACCueSheetBlock(cueBlock.Data, streamInfo.SampleRate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sheet == nil || sheet.IsMultiFile() {
+		return nil, nil
+	}
+
+	if cmt != nil {
+		if sheet.Title == "" {
+			sheet.Title = getComment(cmt, "ALBUM")
+		}
+		if sheet.Performer == "" {
+			sheet.Performer = getJoinedComment(cmt, "ALBUMARTIST")
+		}
+		if sheet.Performer == "" {
+			sheet.Performer = getJoinedComment(cmt, "ARTIST")
+		}
+		if sheet.Genre == "" {
+			sheet.Genre = getComment(cmt, "GENRE")
+		}
+		if sheet.Date == "" {
+			sheet.Date = getComment(cmt, "DATE")
+		}
+	}
+
+	fileName := filepath.Base(filePath)
+	sheet.FileName = fileName
+	sheet.FileType = "WAVE"
+	sheet.Files = []CueFile{{FileName: fileName, FileType: "WAVE"}}
+	for i := range sheet.Tracks {
+		sheet.Tracks[i].FileName = fileName
+		sheet.Tracks[i].FileType = "WAVE"
+		for j := range sheet.Tracks[i].Indexes {
+			sheet.Tracks[i].Indexes[j].FileName = fileName
+		}
+	}
+
+	return sheet, nil
+}
+
+// decodeFLACCueSheetBlock parses a METADATA_BLOCK_CUESHEET. Offsets are in
+// samples; the lead-out and non-audio tracks are dropped.
+func decodeFLACCueSheetBlock(data []byte, sampleRate int) (*CueSheet, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate for cue sheet block")
+	}
+
+	r := bytes.NewReader(data)
+	catalog := make([]byte, flacCueSheetCatalogSize)
+	var leadIn uint64
+	header := make([]byte, 1+flacCueSheetReservedSize)
+	var trackCount uint8
+
+	if _, err := io.ReadFull(r, catalog); err != nil {
+		return nil, fmt.Errorf("cue sheet block truncated: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &leadIn); err != nil {
+		return nil, fmt.Errorf("cue sheet block truncated: %w", err)
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("cue sheet block truncated: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &trackCount); err != nil {
+		return nil, fmt.Errorf("cue sheet block truncated: %w", err)
+	}
+
+	sheet := &CueSheet{Catalog: strings.TrimRight(string(catalog), "\x00")}
+
+	for i := 0; i < int(trackCount); i++ {
+		var offset uint64
+		var number uint8
+		isrc := make([]byte, flacCueSheetISRCSize)
+		trackFlags := make([]byte, 1+flacCueTrackReservedSize)
+		var indexCount uint8
+
+		if err := binary.Read(r, binary.BigEndian, &offset); err != nil {
+			return nil, fmt.Errorf("cue sheet track %d truncated: %w", i+1, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &number); err != nil {
+			return nil, fmt.Errorf("cue sheet track %d truncated: %w", i+1, err)
+		}
+		if _, err := io.ReadFull(r, isrc); err != nil {
+			return nil, fmt.Errorf("cue sheet track %d truncated: %w", i+1, err)
+		}
+		if _, err := io.ReadFull(r, trackFlags); err != nil {
+			return nil, fmt.Errorf("cue sheet track %d truncated: %w", i+1, err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &indexCount); err != nil {
+			return nil, fmt.Errorf("cue sheet track %d truncated: %w", i+1, err)
+		}
+
+		track := CueTrack{
+			Number:   int(number),
+			ISRC:     strings.TrimRight(string(isrc), "\x00"),
+			DataType: "AUDIO",
+			PreGap:   -1,
+		}
+		if trackFlags[0]&0x80 != 0 {
+			track.DataType = "MODE1/2352"
+		}
+		if trackFlags[0]&0x40 != 0 {
+			track.Flags = []string{"PRE"}
+		}
+
+		for j := 0; j < int(indexCount); j++ {
+			var indexOffset uint64
+			var indexNumber uint8
+			if err := binary.Read(r, binary.BigEndian, &indexOffset); err != nil {
+				return nil, fmt.Errorf("cue sheet index truncated: %w", err)
+			}
+			if err := binary.Read(r, binary.BigEndian, &indexNumber); err != nil {
+				return nil, fmt.Errorf("cue sheet index truncated: %w", err)
+			}
+			if _, err := r.Seek(flacCueIndexReservedSize, io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("cue sheet index truncated: %w", err)
+			}
+
+			seconds := float64(offset+indexOffset) / float64(sampleRate)
+			track.Indexes = append(track.Indexes, CueIndex{Number: int(indexNumber), Time: seconds})
+			switch indexNumber {
+			case 0:
+				track.PreGap = seconds
+			case 1:
+				track.StartTime = seconds
+			}
+		}
+
+		if number == flacCueLeadOutCD || number == flacCueLeadOutNonCD || track.DataType != "AUDIO" {
+			continue
+		}
+		sheet.Tracks = append(sheet.Tracks, track)
+	}
+
+	if len(sheet.Tracks) == 0 {
+		return nil, fmt.Errorf("no audio tracks in cue sheet block")
+	}
+
+	return sheet, nil
+}
+
+// encodeFLACCueSheetBlock builds a METADATA_BLOCK_CUESHEET for a single-file
+// sheet. CD-DA layout (lead-in, sector-aligned offsets, lead-out 170) is used
+// for 44.1 kHz audio.
+func encodeFLACCueSheetBlock(sheet *CueSheet, sampleRate int, totalSamples int64) ([]byte, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate for cue sheet block")
+	}
+	if len(sheet.Tracks) == 0 || len(sheet.Tracks) > 99 {
+		return nil, fmt.Errorf("cue sheet must have between 1 and 99 tracks")
+	}
+
+	isCD := sampleRate == flacCDSampleRate
+	toSamples := func(seconds float64) uint64 {
+		if seconds <= 0 {
+			return 0
+		}
+		// Cue timestamps have 1/75 s resolution; keep offsets on those
+		// boundaries so CD images stay sector aligned.
+		frames := math.Round(seconds * 75)
+		return uint64(frames * float64(sampleRate) / 75)
+	}
+
+	var buf bytes.Buffer
+	catalog := make([]byte, flacCueSheetCatalogSize)
+	copy(catalog, sheet.Catalog)
+	buf.Write(catalog)
+
+	leadIn := uint64(0)
+	header := make([]byte, 1+flacCueSheetReservedSize)
+	if isCD {
+		leadIn = flacCDLeadInSamples
+		header[0] = 0x80
+	}
+	binary.Write(&buf, binary.BigEndian, leadIn)
+	buf.Write(header)
+	buf.WriteByte(byte(len(sheet.Tracks) + 1))
+
+	for _, track := range sheet.Tracks {
+		indexes := cueTrackIndexes(sheet, track)
+		trackOffset := toSamples(indexes[0].Time)
+
+		binary.Write(&buf, binary.BigEndian, trackOffset)
+		buf.WriteByte(byte(track.Number))
+
+		isrc := make([]byte, flacCueSheetISRCSize)
+		copy(isrc, track.ISRC)
+		buf.Write(isrc)
+
+		trackFlags := make([]byte, 1+flacCueTrackReservedSize)
+		if track.DataType != "" && track.DataType != "AUDIO" {
+			trackFlags[0] |= 0x80
+		}
+		for _, flag := range track.Flags {
+			if flag == "PRE" {
+				trackFlags[0] |= 0x40
+			}
+		}
+		buf.Write(trackFlags)
+
+		buf.WriteByte(byte(len(indexes)))
+		for _, index := range indexes {
+			binary.Write(&buf, binary.BigEndian, toSamples(index.Time)-trackOffset)
+			buf.WriteByte(byte(index.Number))
+			buf.Write(make([]byte, flacCueIndexReservedSize))
+		}
+	}
+
+	leadOut := byte(flacCueLeadOutNonCD)
+	if isCD {
+		leadOut = flacCueLeadOutCD
+	}
+	binary.Write(&buf, binary.BigEndian, uint64(totalSamples))
+	buf.WriteByte(leadOut)
+	buf.Write(make([]byte, flacCueSheetISRCSize+1+flacCueTrackReservedSize))
+	buf.WriteByte(0)
+
+	return buf.Bytes(), nil
+}
+
+// EmbedCueSheetInFLAC stores sheet inside a single-file FLAC image, both as a
+// CUESHEET metadata block and as a CUESHEET Vorbis comment. Existing embedded
+// cue data is replaced.
+func EmbedCueSheetInFLAC(filePath string, sheet *CueSheet) error {
+	if sheet == nil || len(sheet.Tracks) == 0 {
+		return fmt.Errorf("cue sheet has no tracks")
+	}
+	if sheet.IsMultiFile() {
+		return fmt.Errorf("cannot embed a cue sheet that references %d files", len(sheet.Files))
+	}
+
+	f, err := flac.ParseFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+	defer f.Close()
+
+	streamInfo, err := f.GetStreamInfo()
+	if err != nil {
+		return fmt.Errorf("failed to read stream info: %w", err)
+	}
+
+	embedded := *sheet
+	fileName := filepath.Base(filePath)
+	embedded.FileName = fileName
+	embedded.FileType = "WAVE"
+	embedded.Files = []CueFile{{FileName: fileName, FileType: "WAVE"}}
+	embedded.Tracks = make([]CueTrack, len(sheet.Tracks))
+	for i, track := range sheet.Tracks {
+		track.FileName = fileName
+		track.FileType = "WAVE"
+		indexes := make([]CueIndex, len(track.Indexes))
+		for j, index := range track.Indexes {
+			index.FileName = fileName
+			indexes[j] = index
+		}
+		track.Indexes = indexes
+		embedded.Tracks[i] = track
+	}
+
+	blockData, err := encodeFLACCueSheetBlock(&embedded, streamInfo.SampleRate, streamInfo.SampleCount)
+	if err != nil {
+		return err
+	}
+
+	var cmtIdx int = -1
+	var cmt *flacvorbis.MetaDataBlockVorbisComment
+	for i := len(f.Meta) - 1; i >= 0; i-- {
+		if f.Meta[i].Type == flac.CueSheet {
+			f.Meta = append(f.Meta[:i], f.Meta[i+1:]...)
+		}
+	}
+	for idx, meta := range f.Meta {
+		if meta.Type == flac.VorbisComment {
+			cmtIdx = idx
+			cmt, err = flacvorbis.ParseFromMetaDataBlock(*meta)
+			if err != nil {
+				return fmt.Errorf("failed to parse vorbis comment: %w", err)
+			}
+			break
+		}
+	}
+
+	if cmt == nil {
+		cmt = flacvorbis.New()
+	}
+	setComment(cmt, "CUESHEET", FormatCueSheet(&embedded))
+
+	cmtBlock := cmt.Marshal()
+	if cmtIdx >= 0 {
+		f.Meta[cmtIdx] = &cmtBlock
+	} else {
+		f.Meta = append(f.Meta, &cmtBlock)
+	}
+	f.Meta = append(f.Meta, &flac.MetaDataBlock{Type: flac.CueSheet, Data: blockData})
+
+	return f.Save(filePath)
+}
+
+// scanEmbeddedCueSheetTracks expands a FLAC image with an embedded cue sheet
+// into virtual "#trackNN" tracks, taking the cover and scan time of the
+// image's own result. It returns nil when the file has no cue data.
+func scanEmbeddedCueSheetTracks(filePath string, image *LibraryScanResult) []LibraryScanResult {
+	sheet, err := readEmbeddedFLACCueSheet(filePath)
+	if err != nil || sheet == nil || len(sheet.Tracks) < 2 {
+		return nil
+	}
+	tracks, err := scanCueSheetForLibrary(filePath, sheet, filePath, "", image.FileModTime, "", image.ScannedAt)
+	if err != nil {
+		GoLog("[LibraryScan] Embedded cue sheet of %s: %v\n", filePath, err)
+		return nil
+	}
+	for i := range tracks {
+		// SAF paths have no extension to take the format from.
+		tracks[i].Format = "cue+flac"
+		if image.CoverPath != "" {
+			tracks[i].CoverPath = image.CoverPath
+		}
+	}
+	return tracks
+}
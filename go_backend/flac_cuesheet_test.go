@@ -0,0 +1,142 @@
+package gobackend
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeMinimalFLAC writes a FLAC stream with only a STREAMINFO block and a
+// fake frame header, enough for metadata parsing and rewriting.
+func writeMinimalFLAC(t *testing.T, path string, sampleRate int, totalSamples int64) {
+	t.Helper()
+
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint16(streamInfo[0:], 4096)
+	binary.BigEndian.PutUint16(streamInfo[2:], 4096)
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bps-1, 36 bits samples.
+	packed := uint64(sampleRate)<<44 | uint64(1)<<41 | uint64(15)<<36 | uint64(totalSamples)
+	binary.BigEndian.PutUint64(streamInfo[10:], packed)
+
+	data := []byte("fLaC")
+	data = append(data, 0x80, 0, 0, byte(len(streamInfo)))
+	data = append(data, streamInfo...)
+	data = append(data, 0xFF, 0xF8, 0x00, 0x00)
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestEmbedCueSheetInFLACRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	flacPath := filepath.Join(dir, "image.flac")
+	writeMinimalFLAC(t, flacPath, 44100, 44100*300)
+
+	sheet, err := ParseCueString(`PERFORMER "Artist"
+TITLE "Album"
+FILE "image.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "One"
+    ISRC USAAA0000001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Two"
+    INDEX 00 02:00:00
+    INDEX 01 02:02:37
+`)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+
+	if err := EmbedCueSheetInFLAC(flacPath, sheet); err != nil {
+		t.Fatalf("EmbedCueSheetInFLAC failed: %v", err)
+	}
+
+	embedded, err := readEmbeddedFLACCueSheet(flacPath)
+	if err != nil || embedded == nil {
+		t.Fatalf("expected embedded cue sheet, got %v (err=%v)", embedded, err)
+	}
+	if embedded.FileName != "image.flac" || embedded.Tracks[1].Title != "Two" {
+		t.Fatalf("unexpected embedded sheet: %+v", embedded)
+	}
+
+	scanned, err := scanAudioFile(flacPath, "now")
+	if err != nil {
+		t.Fatalf("scanAudioFile failed: %v", err)
+	}
+	results := scanned.CueTracks
+	if len(results) != 2 || results[0].FilePath != flacPath+"#track01" {
+		t.Fatalf("unexpected virtual tracks: %+v", results)
+	}
+	if results[1].Duration != 177 {
+		t.Fatalf("unexpected last track duration %d", results[1].Duration)
+	}
+}
+
+func TestDecodeFLACCueSheetBlockWithoutVorbisTag(t *testing.T) {
+	sheet, err := ParseCueString(`FILE "x.wav" WAVE
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    FLAGS PRE
+    INDEX 00 01:00:00
+    INDEX 01 01:02:00
+`)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+
+	block, err := encodeFLACCueSheetBlock(sheet, 44100, 44100*180)
+	if err != nil {
+		t.Fatalf("encodeFLACCueSheetBlock failed: %v", err)
+	}
+	decoded, err := decodeFLACCueSheetBlock(block, 44100)
+	if err != nil {
+		t.Fatalf("decodeFLACCueSheetBlock failed: %v", err)
+	}
+
+	if len(decoded.Tracks) != 2 {
+		t.Fatalf("expected lead-out to be dropped, got %d tracks", len(decoded.Tracks))
+	}
+	second := decoded.Tracks[1]
+	if second.PreGap != 60 || second.StartTime != 62 || len(second.Flags) != 1 {
+		t.Fatalf("unexpected decoded track: %+v", second)
+	}
+}
+
+func TestIncrementalScanReplacesImageWithEmbeddedCueTracks(t *testing.T) {
+	dir := t.TempDir()
+	flacPath := filepath.Join(dir, "image.flac")
+	writeMinimalFLAC(t, flacPath, 44100, 44100*300)
+	sheet, err := ParseCueString(`FILE "image.flac" WAVE
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 01 02:00:00
+`)
+	if err != nil {
+		t.Fatalf("ParseCueString failed: %v", err)
+	}
+	if err := EmbedCueSheetInFLAC(flacPath, sheet); err != nil {
+		t.Fatalf("EmbedCueSheetInFLAC failed: %v", err)
+	}
+
+	// The image was indexed as a single track before it had a cue sheet.
+	raw, err := scanLibraryFolderIncrementalWithExistingFiles(dir, map[string]int64{flacPath: 1})
+	if err != nil {
+		t.Fatalf("incremental scan failed: %v", err)
+	}
+	var result IncrementalScanResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Scanned) != 2 || result.Scanned[0].FilePath != flacPath+"#track01" {
+		t.Fatalf("scanned = %+v", result.Scanned)
+	}
+	if len(result.DeletedPaths) != 1 || result.DeletedPaths[0] != flacPath {
+		t.Fatalf("deleted = %v, want the whole-image entry", result.DeletedPaths)
+	}
+}
@@ -12,6 +12,7 @@ require (
 	github.com/refraction-networking/utls v1.8.2
 	golang.org/x/mobile v0.0.0-20260312152759-81488f6aeb60
 	golang.org/x/net v0.52.0
+	golang.org/x/sys v0.42.0
 	golang.org/x/text v0.35.0
 )
 
@@ -24,6 +25,5 @@ require (
 	golang.org/x/crypto v0.49.0 // indirect
 	golang.org/x/mod v0.34.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
-	golang.org/x/sys v0.42.0 // indirect
 	golang.org/x/tools v0.43.0 // indirect
 )
@@ -62,10 +62,11 @@ var (
 )
 
 var sharedTransport = &http.Transport{
-	DialContext: (&net.Dialer{
+	Proxy: proxyForRequest,
+	DialContext: resolvingDialer{&net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
-	}).DialContext,
+	}}.DialContext,
 	MaxIdleConns:          100,
 	MaxIdleConnsPerHost:   10,
 	MaxConnsPerHost:       20,
@@ -80,10 +81,11 @@ var sharedTransport = &http.Transport{
 }
 
 var metadataTransport = &http.Transport{
-	DialContext: (&net.Dialer{
+	Proxy: proxyForRequest,
+	DialContext: resolvingDialer{&net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
-	}).DialContext,
+	}}.DialContext,
 	MaxIdleConns:          30,
 	MaxIdleConnsPerHost:   5,
 	MaxConnsPerHost:       10,
@@ -97,26 +99,54 @@ var metadataTransport = &http.Transport{
 	DisableCompression:    true,
 }
 
+// extensionTransport carries extension traffic. It dials through
+// dialExtensionContext, which routes through the configured proxy itself and
+// refuses private addresses at connect time.
+var extensionTransport = &http.Transport{
+	DialContext:           dialExtensionContext,
+	MaxIdleConns:          50,
+	MaxIdleConnsPerHost:   10,
+	MaxConnsPerHost:       20,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+	DisableKeepAlives:     false,
+	ForceAttemptHTTP2:     true,
+	WriteBufferSize:       64 * 1024,
+	ReadBufferSize:        64 * 1024,
+	DisableCompression:    true,
+}
+
+// The policy transports apply the per-host limits in httputil_policy.go.
+// Metadata requests also go through the on-disk cache in httputil_cache.go.
+// The network taps record or replay traffic (httputil_cassette.go).
+var (
+	sharedPolicyTransport    = newHostPolicyTransport(newNetworkTapTransport(sharedTransport))
+	metadataPolicyTransport  = newHostPolicyTransport(newNetworkTapTransport(metadataTransport))
+	metadataCacheTransport   = newHTTPCacheTransport(metadataHTTPCache, metadataPolicyTransport)
+	extensionPolicyTransport = newHostPolicyTransport(newNetworkTapTransport(extensionTransport))
+)
+
 var sharedClient = &http.Client{
-	Transport: newCompatibilityTransport(sharedTransport),
+	Transport: newCompatibilityTransport(sharedPolicyTransport),
 	Timeout:   DefaultTimeout,
 }
 
 var downloadClient = &http.Client{
-	Transport: newCompatibilityTransport(sharedTransport),
+	Transport: newCompatibilityTransport(sharedPolicyTransport),
 	Timeout:   DownloadTimeout,
 }
 
 func NewHTTPClientWithTimeout(timeout time.Duration) *http.Client {
 	return &http.Client{
-		Transport: newCompatibilityTransport(sharedTransport),
+		Transport: newCompatibilityTransport(sharedPolicyTransport),
 		Timeout:   timeout,
 	}
 }
 
 func NewMetadataHTTPClient(timeout time.Duration) *http.Client {
 	return &http.Client{
-		Transport: newCompatibilityTransport(metadataTransport),
+		Transport: newCompatibilityTransport(metadataCacheTransport),
 		Timeout:   timeout,
 	}
 }
@@ -132,6 +162,7 @@ func GetDownloadClient() *http.Client {
 func CloseIdleConnections() {
 	sharedTransport.CloseIdleConnections()
 	metadataTransport.CloseIdleConnections()
+	extensionTransport.CloseIdleConnections()
 }
 
 func SetNetworkCompatibilityOptions(allowHTTP, insecureTLS bool) {
@@ -144,6 +175,7 @@ func SetNetworkCompatibilityOptions(allowHTTP, insecureTLS bool) {
 
 	applyTLSCompatibility(sharedTransport, insecureTLS)
 	applyTLSCompatibility(metadataTransport, insecureTLS)
+	applyTLSCompatibility(extensionTransport, insecureTLS)
 	CloseIdleConnections()
 
 	GoLog("[HTTP] Network compatibility options updated: allow_http=%v insecure_tls=%v\n", allowHTTP, insecureTLS)
@@ -528,7 +560,7 @@ func CheckAndLogISPBlocking(err error, requestURL string, tag string) bool {
 		LogError(tag, "Domain: %s", ispErr.Domain)
 		LogError(tag, "Reason: %s", ispErr.Reason)
 		LogError(tag, "Original error: %v", ispErr.OriginalErr)
-		LogError(tag, "Suggestion: Try using a VPN or changing your DNS to 1.1.1.1 or 8.8.8.8")
+		LogError(tag, "Suggestion: Try using a VPN, enabling DNS-over-HTTPS or changing your DNS to 1.1.1.1 or 8.8.8.8")
 		return true
 	}
 	return false
@@ -0,0 +1,579 @@
+package gobackend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHTTPCacheMaxSizeMB = 100
+	httpCacheMetaExt          = ".meta"
+	httpCacheBodyExt          = ".body"
+)
+
+// ErrHTTPCacheMiss is returned in offline mode for requests that are not
+// cached.
+var ErrHTTPCacheMiss = errors.New("offline: response is not cached")
+
+// HTTPCacheRule overrides the freshness lifetime of responses from Host
+// whose path starts with PathPrefix. Rules win over the response's
+// Cache-Control and Expires headers. Entries are keyed by URL, so requests
+// carrying Authorization or Cookie headers bypass the cache and rules only
+// ever apply to anonymous lookups.
+type HTTPCacheRule struct {
+	Host       string `json:"host"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+// HTTPCacheConfig configures the on-disk cache of the metadata transport.
+// An empty Dir disables it. In Offline mode requests are answered only from
+// the cache, stale or not.
+type HTTPCacheConfig struct {
+	Dir       string          `json:"dir"`
+	MaxSizeMB int             `json:"max_size_mb,omitempty"`
+	Offline   bool            `json:"offline,omitempty"`
+	Rules     []HTTPCacheRule `json:"rules,omitempty"`
+}
+
+type HTTPCacheStats struct {
+	Entries     int   `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	StaleServed int64 `json:"stale_served"`
+	Offline     bool  `json:"offline"`
+}
+
+// defaultHTTPCacheRules cover album, artist, playlist and track lookups,
+// whose APIs send no useful cache headers.
+var defaultHTTPCacheRules = []HTTPCacheRule{
+	{Host: "api.deezer.com", PathPrefix: "/2.0/album/", TTLSeconds: 6 * 3600},
+	{Host: "api.deezer.com", PathPrefix: "/2.0/artist/", TTLSeconds: 6 * 3600},
+	{Host: "api.deezer.com", PathPrefix: "/2.0/playlist/", TTLSeconds: 3600},
+	{Host: "api.deezer.com", PathPrefix: "/2.0/track/", TTLSeconds: 24 * 3600},
+	{Host: "tidal.com", PathPrefix: "/v1/pages/album", TTLSeconds: 6 * 3600},
+	{Host: "tidal.com", PathPrefix: "/v1/pages/artist", TTLSeconds: 6 * 3600},
+	{Host: "tidal.com", PathPrefix: "/v1/playlists/", TTLSeconds: 3600},
+	{Host: "tidal.com", PathPrefix: "/v1/tracks/", TTLSeconds: 24 * 3600},
+	{Host: "api.zarz.moe", PathPrefix: "/v1/qbz/album/get", TTLSeconds: 6 * 3600},
+	{Host: "api.zarz.moe", PathPrefix: "/v1/qbz/artist/get", TTLSeconds: 6 * 3600},
+	{Host: "api.zarz.moe", PathPrefix: "/v1/qbz/playlist/get", TTLSeconds: 3600},
+	{Host: "api.zarz.moe", PathPrefix: "/v1/qbz/track/get", TTLSeconds: 24 * 3600},
+}
+
+type httpCacheMeta struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	StoredAt   time.Time   `json:"stored_at"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	Size       int64       `json:"size"`
+	// Vary holds the request headers named by the response's Vary header,
+	// which a request must match to be served this entry.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+type httpCacheIndexEntry struct {
+	size     int64
+	lastUsed time.Time
+}
+
+type httpCache struct {
+	mu    sync.Mutex
+	cfg   HTTPCacheConfig
+	index map[string]*httpCacheIndexEntry
+	total int64
+	stats HTTPCacheStats
+}
+
+var metadataHTTPCache = &httpCache{index: make(map[string]*httpCacheIndexEntry)}
+
+func normalizeHTTPCacheConfig(cfg HTTPCacheConfig) (HTTPCacheConfig, error) {
+	cfg.Dir = strings.TrimSpace(cfg.Dir)
+	if cfg.MaxSizeMB < 0 {
+		return cfg, fmt.Errorf("invalid cache size %d MB", cfg.MaxSizeMB)
+	}
+	if cfg.MaxSizeMB == 0 {
+		cfg.MaxSizeMB = defaultHTTPCacheMaxSizeMB
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = defaultHTTPCacheRules
+	}
+	rules := make([]HTTPCacheRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rule.Host = strings.ToLower(strings.TrimSpace(rule.Host))
+		if rule.Host == "" {
+			return cfg, fmt.Errorf("cache rule host is required")
+		}
+		if rule.TTLSeconds < 0 {
+			return cfg, fmt.Errorf("cache rule %s%s: invalid TTL %d", rule.Host, rule.PathPrefix, rule.TTLSeconds)
+		}
+		rules = append(rules, rule)
+	}
+	cfg.Rules = rules
+	return cfg, nil
+}
+
+// configure applies cfg and rebuilds the LRU index from the files in the
+// cache directory, using their modification time as the last use.
+func (c *httpCache) configure(cfg HTTPCacheConfig) error {
+	cfg, err := normalizeHTTPCacheConfig(cfg)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]*httpCacheIndexEntry)
+	var total int64
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		entries, err := os.ReadDir(cfg.Dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".tmp") {
+				os.Remove(filepath.Join(cfg.Dir, entry.Name()))
+				continue
+			}
+			key, ok := strings.CutSuffix(entry.Name(), httpCacheMetaExt)
+			if !ok {
+				continue
+			}
+			metaInfo, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			bodyInfo, err := os.Stat(filepath.Join(cfg.Dir, key+httpCacheBodyExt))
+			if err != nil {
+				os.Remove(filepath.Join(cfg.Dir, entry.Name()))
+				continue
+			}
+			index[key] = &httpCacheIndexEntry{size: bodyInfo.Size(), lastUsed: metaInfo.ModTime()}
+			total += bodyInfo.Size()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+	c.index = index
+	c.total = total
+	c.stats = HTTPCacheStats{}
+	c.evictLocked()
+	GoLog("[HTTPCache] dir=%q entries=%d size=%dKB max=%dMB offline=%v\n", cfg.Dir, len(index), total/1024, cfg.MaxSizeMB, cfg.Offline)
+	return nil
+}
+
+func (c *httpCache) config() HTTPCacheConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg
+}
+
+func (c *httpCache) setOffline(offline bool) {
+	c.mu.Lock()
+	c.cfg.Offline = offline
+	c.mu.Unlock()
+}
+
+func (c *httpCache) getStats() HTTPCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.index)
+	stats.Bytes = c.total
+	stats.Offline = c.cfg.Offline
+	return stats
+}
+
+func (c *httpCache) clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.index {
+		c.removeLocked(key)
+	}
+	c.total = 0
+	return nil
+}
+
+func (c *httpCache) count(field *int64) {
+	c.mu.Lock()
+	*field++
+	c.mu.Unlock()
+}
+
+func httpCacheKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:16])
+}
+
+// httpCacheVary returns the values of the request headers that header's Vary
+// names.
+func httpCacheVary(req *http.Request, header http.Header) map[string]string {
+	var vary map[string]string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = strings.Join(req.Header.Values(name), ",")
+		}
+	}
+	return vary
+}
+
+// matches reports whether req may be served this entry.
+func (meta *httpCacheMeta) matches(req *http.Request) bool {
+	if meta.URL != req.URL.String() {
+		return false
+	}
+	for name, value := range meta.Vary {
+		if strings.Join(req.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *httpCache) load(dir, key string) (*httpCacheMeta, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, key+httpCacheMetaExt))
+	if err != nil {
+		return nil, false
+	}
+	var meta httpCacheMeta
+	if json.Unmarshal(data, &meta) != nil {
+		return nil, false
+	}
+	return &meta, true
+}
+
+// store writes the body before the metadata so a crash never leaves
+// metadata pointing at a partial body.
+func (c *httpCache) store(dir, key string, meta *httpCacheMeta, body []byte) error {
+	if body != nil {
+		if err := writeHTTPCacheFile(dir, key+httpCacheBodyExt, body); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeHTTPCacheFile(dir, key+httpCacheMetaExt, data); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.index[key]; ok {
+		c.total -= entry.size
+	}
+	c.index[key] = &httpCacheIndexEntry{size: meta.Size, lastUsed: time.Now()}
+	c.total += meta.Size
+	c.evictLocked()
+	return nil
+}
+
+// writeHTTPCacheFile replaces dir/name through a unique temporary file, so
+// concurrent fetches of the same URL never interleave their writes.
+func writeHTTPCacheFile(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (c *httpCache) touch(dir, key string) {
+	now := time.Now()
+	c.mu.Lock()
+	if entry, ok := c.index[key]; ok {
+		entry.lastUsed = now
+	}
+	c.mu.Unlock()
+	os.Chtimes(filepath.Join(dir, key+httpCacheMetaExt), now, now)
+}
+
+// evictLocked removes least recently used entries until the cache fits.
+func (c *httpCache) evictLocked() {
+	limit := int64(c.cfg.MaxSizeMB) * 1024 * 1024
+	for c.total > limit && len(c.index) > 0 {
+		oldestKey := ""
+		var oldest time.Time
+		for key, entry := range c.index {
+			if oldestKey == "" || entry.lastUsed.Before(oldest) {
+				oldestKey, oldest = key, entry.lastUsed
+			}
+		}
+		c.total -= c.index[oldestKey].size
+		c.removeLocked(oldestKey)
+	}
+}
+
+func (c *httpCache) removeLocked(key string) {
+	delete(c.index, key)
+	if c.cfg.Dir == "" {
+		return
+	}
+	os.Remove(filepath.Join(c.cfg.Dir, key+httpCacheMetaExt))
+	os.Remove(filepath.Join(c.cfg.Dir, key+httpCacheBodyExt))
+}
+
+// freshness returns how long a response stays fresh and whether it may be
+// stored at all. A matching rule wins over the response headers, but a
+// response with "Vary: *" is never stored.
+func (cfg HTTPCacheConfig) freshness(req *http.Request, header http.Header) (time.Duration, bool) {
+	host := strings.ToLower(req.URL.Hostname())
+	ruleTTL := -1
+	matched := -1
+	for _, rule := range cfg.Rules {
+		if hostMatchesDomain(host, rule.Host) && strings.HasPrefix(req.URL.Path, rule.PathPrefix) && len(rule.PathPrefix) > matched {
+			ruleTTL = rule.TTLSeconds
+			matched = len(rule.PathPrefix)
+		}
+	}
+	for _, line := range header.Values("Vary") {
+		if strings.Contains(line, "*") {
+			return 0, false
+		}
+	}
+	if ruleTTL >= 0 {
+		return time.Duration(ruleTTL) * time.Second, true
+	}
+
+	hasValidator := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case directive == "no-cache":
+			return 0, hasValidator
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, seconds > 0 || hasValidator
+			}
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		base := time.Now()
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			base = date
+		}
+		if ttl := expires.Sub(base); ttl > 0 {
+			return ttl, true
+		}
+	}
+	return 0, hasValidator
+}
+
+func (c *httpCache) response(req *http.Request, dir, key string, meta *httpCacheMeta, status string) (*http.Response, error) {
+	body, err := os.Open(filepath.Join(dir, key+httpCacheBodyExt))
+	if err != nil {
+		return nil, err
+	}
+	c.touch(dir, key)
+	header := meta.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("X-Cache", status)
+	header.Set("Age", strconv.Itoa(int(time.Since(meta.StoredAt).Seconds())))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", meta.StatusCode, http.StatusText(meta.StatusCode)),
+		StatusCode:    meta.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: meta.Size,
+		Request:       req,
+	}, nil
+}
+
+// httpCacheTransport serves GET requests from the on-disk cache,
+// revalidates stale entries with ETag/Last-Modified, and falls back to
+// stale entries when the network fails.
+type httpCacheTransport struct {
+	cache *httpCache
+	base  http.RoundTripper
+}
+
+func newHTTPCacheTransport(cache *httpCache, base http.RoundTripper) http.RoundTripper {
+	return &httpCacheTransport{cache: cache, base: base}
+}
+
+func (t *httpCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := t.cache.config()
+	if cfg.Dir == "" || req.Method != http.MethodGet || req.Header.Get("Range") != "" ||
+		req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" || networkCassetteActive() {
+		return t.base.RoundTrip(req)
+	}
+
+	key := httpCacheKey(req.URL.String())
+	meta, cached := t.cache.load(cfg.Dir, key)
+	if cached && !meta.matches(req) {
+		cached = false
+	}
+	if cfg.Offline {
+		if !cached {
+			t.cache.count(&t.cache.stats.Misses)
+			return nil, fmt.Errorf("%w: %s", ErrHTTPCacheMiss, req.URL.Redacted())
+		}
+		t.cache.count(&t.cache.stats.StaleServed)
+		return t.cache.response(req, cfg.Dir, key, meta, "OFFLINE")
+	}
+	if cached && time.Now().Before(meta.ExpiresAt) && !strings.Contains(req.Header.Get("Cache-Control"), "no-cache") {
+		if resp, err := t.cache.response(req, cfg.Dir, key, meta, "HIT"); err == nil {
+			t.cache.count(&t.cache.stats.Hits)
+			return resp, nil
+		}
+		cached = false
+	}
+
+	outReq := req
+	if cached && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		etag, lastModified := meta.Header.Get("ETag"), meta.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			outReq = req.Clone(req.Context())
+			if etag != "" {
+				outReq.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				outReq.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(outReq)
+	if err != nil {
+		if cached && req.Context().Err() == nil {
+			LogDebug("HTTPCache", "Network error for %s, serving stale entry: %v", req.URL.Redacted(), err)
+			t.cache.count(&t.cache.stats.StaleServed)
+			return t.cache.response(req, cfg.Dir, key, meta, "STALE")
+		}
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached && outReq != req:
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if meta.Header == nil {
+			meta.Header = http.Header{}
+		}
+		for name, values := range resp.Header {
+			meta.Header[name] = values
+		}
+		ttl, _ := cfg.freshness(req, meta.Header)
+		meta.StoredAt = time.Now()
+		meta.ExpiresAt = meta.StoredAt.Add(ttl)
+		if err := t.cache.store(cfg.Dir, key, meta, nil); err != nil {
+			LogDebug("HTTPCache", "Failed to update %s: %v", req.URL.Redacted(), err)
+		}
+		t.cache.count(&t.cache.stats.Revalidated)
+		return t.cache.response(req, cfg.Dir, key, meta, "REVALIDATED")
+	case resp.StatusCode >= 500 && cached:
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		t.cache.count(&t.cache.stats.StaleServed)
+		return t.cache.response(req, cfg.Dir, key, meta, "STALE")
+	case resp.StatusCode != http.StatusOK:
+		return resp, nil
+	}
+
+	t.cache.count(&t.cache.stats.Misses)
+	ttl, storable := cfg.freshness(req, resp.Header)
+	if !storable {
+		return resp, nil
+	}
+	// Entries larger than an eighth of the cache would evict too much.
+	maxEntry := int64(cfg.MaxSizeMB) * 1024 * 1024 / 8
+	if resp.ContentLength > maxEntry {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEntry+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > maxEntry {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	if isJSONErrorBody(body) {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+
+	now := time.Now()
+	meta = &httpCacheMeta{
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		StoredAt:   now,
+		ExpiresAt:  now.Add(ttl),
+		Size:       int64(len(body)),
+		Vary:       httpCacheVary(req, resp.Header),
+	}
+	if err := t.cache.store(cfg.Dir, key, meta, body); err != nil {
+		LogDebug("HTTPCache", "Failed to store %s: %v", req.URL.Redacted(), err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+// isJSONErrorBody reports whether body is a JSON object with a top-level
+// "error" key. Deezer reports quota and lookup errors that way with HTTP
+// 200, and those must not be cached for the lifetime of a rule.
+func isJSONErrorBody(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' || !bytes.Contains(trimmed, []byte(`"error"`)) {
+		return false
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(trimmed, &object) != nil {
+		return false
+	}
+	_, hasError := object["error"]
+	return hasError
+}
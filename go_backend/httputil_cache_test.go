@@ -0,0 +1,248 @@
+package gobackend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestHTTPCache(t *testing.T, cfg HTTPCacheConfig) *httpCache {
+	t.Helper()
+	cache := &httpCache{index: make(map[string]*httpCacheIndexEntry)}
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	if err := cache.configure(cfg); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	return cache
+}
+
+func cachedGet(t *testing.T, transport http.RoundTripper, rawURL string) (string, string, error) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), resp.Header.Get("X-Cache"), nil
+}
+
+func TestHTTPCache_RuleTTLAndETagRevalidation(t *testing.T) {
+	cache := newTestHTTPCache(t, HTTPCacheConfig{Rules: []HTTPCacheRule{{Host: "api.music.example", PathPrefix: "/album/", TTLSeconds: 3600}}})
+
+	var calls, conditional int32
+	transport := newHTTPCacheTransport(cache, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		header := http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-cache"}}
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&conditional, 1)
+			return &http.Response{StatusCode: http.StatusNotModified, Header: header, Body: http.NoBody}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader("body of " + req.URL.Path))}, nil
+	}))
+
+	// The rule makes the album fresh for an hour despite no-cache.
+	for i, wantStatus := range []string{"", "HIT"} {
+		body, status, err := cachedGet(t, transport, "https://api.music.example/album/1")
+		if err != nil || body != "body of /album/1" || status != wantStatus {
+			t.Fatalf("request %d: %q, %q, %v", i, body, status, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, want 1", calls)
+	}
+
+	// Without a rule, no-cache with an ETag is stored but revalidated.
+	for i, wantStatus := range []string{"", "REVALIDATED", "REVALIDATED"} {
+		body, status, err := cachedGet(t, transport, "https://api.music.example/search?q=x")
+		if err != nil || body != "body of /search" || status != wantStatus {
+			t.Fatalf("search request %d: %q, %q, %v", i, body, status, err)
+		}
+	}
+	if conditional != 2 {
+		t.Fatalf("conditional requests = %d, want 2", conditional)
+	}
+	if stats := cache.getStats(); stats.Hits != 1 || stats.Revalidated != 2 || stats.Entries != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestHTTPCache_StaleOnErrorAndOffline(t *testing.T) {
+	cache := newTestHTTPCache(t, HTTPCacheConfig{Rules: []HTTPCacheRule{}})
+
+	online := atomic.Bool{}
+	online.Store(true)
+	transport := newHTTPCacheTransport(cache, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if !online.Load() {
+			return nil, errors.New("dial tcp: network is unreachable")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Cache-Control": {"max-age=0"}, "Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, Body: io.NopCloser(strings.NewReader("artist"))}, nil
+	}))
+
+	if _, _, err := cachedGet(t, transport, "https://api.music.example/artist/7"); err != nil {
+		t.Fatal(err)
+	}
+	online.Store(false)
+	if body, status, err := cachedGet(t, transport, "https://api.music.example/artist/7"); err != nil || body != "artist" || status != "STALE" {
+		t.Fatalf("stale on error: %q, %q, %v", body, status, err)
+	}
+	if _, _, err := cachedGet(t, transport, "https://api.music.example/artist/8"); err == nil {
+		t.Fatal("uncached request succeeded while the network is down")
+	}
+
+	cache.setOffline(true)
+	online.Store(true)
+	if body, status, err := cachedGet(t, transport, "https://api.music.example/artist/7"); err != nil || body != "artist" || status != "OFFLINE" {
+		t.Fatalf("offline: %q, %q, %v", body, status, err)
+	}
+	if _, _, err := cachedGet(t, transport, "https://api.music.example/artist/8"); !errors.Is(err, ErrHTTPCacheMiss) {
+		t.Fatalf("offline miss: err = %v", err)
+	}
+}
+
+func TestHTTPCache_LRUEvictionAndPersistence(t *testing.T) {
+	dir := t.TempDir()
+	cache := newTestHTTPCache(t, HTTPCacheConfig{Dir: dir, MaxSizeMB: 1, Rules: []HTTPCacheRule{{Host: "cdn.music.example", TTLSeconds: 60}}})
+
+	payload := strings.Repeat("x", 100*1024)
+	transport := newHTTPCacheTransport(cache, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := payload
+		if strings.Contains(req.URL.Path, "error") {
+			body = `{"error": {"code": 4, "message": "Quota limit exceeded"}}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}))
+
+	for i := 0; i < 12; i++ {
+		if _, _, err := cachedGet(t, transport, fmt.Sprintf("https://cdn.music.example/item/%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Keep item 0 recently used so item 1 is evicted first.
+		if i > 0 {
+			cachedGet(t, transport, "https://cdn.music.example/item/0")
+		}
+	}
+	if _, status, _ := cachedGet(t, transport, "https://cdn.music.example/item/0"); status != "HIT" {
+		t.Fatalf("recently used entry was evicted (status %q)", status)
+	}
+	if _, status, _ := cachedGet(t, transport, "https://cdn.music.example/item/1"); status == "HIT" {
+		t.Fatal("least recently used entry was not evicted")
+	}
+	if stats := cache.getStats(); stats.Bytes > 1024*1024 {
+		t.Fatalf("cache holds %d bytes, over the 1 MB cap", stats.Bytes)
+	}
+
+	cachedGet(t, transport, "https://cdn.music.example/error")
+	if _, status, _ := cachedGet(t, transport, "https://cdn.music.example/error"); status == "HIT" {
+		t.Fatal("JSON error body was cached")
+	}
+
+	reopened := newTestHTTPCache(t, HTTPCacheConfig{Dir: dir, MaxSizeMB: 1})
+	if stats := reopened.getStats(); stats.Entries != cache.getStats().Entries || stats.Entries == 0 {
+		t.Fatalf("reopened entries = %d, want %d", stats.Entries, cache.getStats().Entries)
+	}
+	if err := reopened.clear(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("%d files left after clear", len(files))
+	}
+}
+
+func TestHTTPCache_VaryAndCredentials(t *testing.T) {
+	cache := newTestHTTPCache(t, HTTPCacheConfig{Rules: []HTTPCacheRule{{Host: "api.music.example", TTLSeconds: 3600}}})
+
+	var calls int32
+	transport := newHTTPCacheTransport(cache, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		header := http.Header{"Vary": {"Accept-Language"}}
+		if strings.HasPrefix(req.URL.Path, "/any/") {
+			header.Set("Vary", "*")
+		}
+		body := req.URL.Path + " " + req.Header.Get("Accept-Language") + " " + req.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}))
+	get := func(rawURL string, header http.Header) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", rawURL, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header.Get("X-Cache")
+	}
+
+	get("https://api.music.example/album/1", http.Header{"Accept-Language": {"en"}})
+	if body, status := get("https://api.music.example/album/1", http.Header{"Accept-Language": {"de"}}); status == "HIT" || body != "/album/1 de " {
+		t.Fatalf("different Accept-Language: %q, %q", body, status)
+	}
+	if body, status := get("https://api.music.example/album/1", http.Header{"Accept-Language": {"de"}}); status != "HIT" || body != "/album/1 de " {
+		t.Fatalf("same Accept-Language: %q, %q", body, status)
+	}
+
+	for _, header := range []http.Header{{"Authorization": {"Bearer a"}}, {"Authorization": {"Bearer b"}}, {"Cookie": {"session=1"}}} {
+		if _, status := get("https://api.music.example/me/1", header); status != "" {
+			t.Fatalf("credentialed request served from the cache (%q)", status)
+		}
+	}
+	get("https://api.music.example/any/1", nil)
+	if _, status := get("https://api.music.example/any/1", nil); status != "" {
+		t.Fatalf("Vary: * response was cached (%q)", status)
+	}
+	if calls != 7 {
+		t.Fatalf("calls = %d, want 7", calls)
+	}
+	if stats := cache.getStats(); stats.Entries != 1 {
+		t.Fatalf("entries = %d, want 1", stats.Entries)
+	}
+}
+
+func TestHTTPCache_ConcurrentStoresLeaveNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	cache := newTestHTTPCache(t, HTTPCacheConfig{Dir: dir})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := []byte(strings.Repeat(strconv.Itoa(i), 64*1024))
+			meta := &httpCacheMeta{URL: "https://api.music.example/album/1", StatusCode: http.StatusOK, Size: int64(len(body))}
+			if err := cache.store(dir, "key", meta, body); err != nil {
+				t.Errorf("store %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	body, err := os.ReadFile(filepath.Join(dir, "key"+httpCacheBodyExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 64*1024 || strings.Trim(string(body), string(body[:1])) != "" {
+		t.Fatal("cached body mixes the writes of concurrent stores")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Fatalf("%d files in the cache directory, want the body and meta only", len(files))
+	}
+}
@@ -0,0 +1,476 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	NetworkCassetteRecord = "record"
+	NetworkCassetteReplay = "replay"
+
+	networkCassetteVersion = 1
+	// Bodies beyond this are cut off and marked truncated, so recording a
+	// download does not put the whole file in the cassette.
+	maxCassetteBodySize = 2 * 1024 * 1024
+	cassetteRedacted    = "REDACTED"
+)
+
+// cassetteSensitiveParams are query parameters and JSON keys whose values
+// are replaced with "REDACTED" in cassettes. Requests are redacted the same
+// way before matching, so replay still finds them.
+var cassetteSensitiveParams = map[string]bool{
+	"access_token":    true,
+	"api_key":         true,
+	"apikey":          true,
+	"client_secret":   true,
+	"id_token":        true,
+	"key":             true,
+	"password":        true,
+	"refresh_token":   true,
+	"request_sig":     true,
+	"secret":          true,
+	"sig":             true,
+	"signature":       true,
+	"token":           true,
+	"user_auth_token": true,
+}
+
+// cassetteSensitiveHeaders are never written to a cassette, along with any
+// header whose name mentions a token or an API key.
+var cassetteSensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"cookie":              true,
+	"proxy-authorization": true,
+	"set-cookie":          true,
+}
+
+// NetworkCassette is a HAR-like recording of the requests sent through the
+// shared transports.
+type NetworkCassette struct {
+	Version    int                    `json:"version"`
+	RecordedAt time.Time              `json:"recorded_at"`
+	Entries    []NetworkCassetteEntry `json:"entries"`
+}
+
+// NetworkCassetteEntry is one request and either its response or the
+// transport error it failed with.
+type NetworkCassetteEntry struct {
+	StartedAt time.Time                `json:"started_at"`
+	TimeMs    int64                    `json:"time_ms"`
+	Request   NetworkCassetteRequest   `json:"request"`
+	Response  *NetworkCassetteResponse `json:"response,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+type NetworkCassetteRequest struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+}
+
+type NetworkCassetteResponse struct {
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+	Truncated  bool              `json:"truncated,omitempty"`
+}
+
+type networkCassetteState struct {
+	mode     string
+	path     string
+	mu       sync.Mutex
+	cassette NetworkCassette
+	served   map[int]bool
+}
+
+var (
+	networkCassetteMu sync.RWMutex
+	networkCassette   *networkCassetteState
+)
+
+// startNetworkCassette records all traffic of the shared transports to
+// path, or replays the cassette at path instead of using the network.
+func startNetworkCassette(mode, path string) error {
+	if strings.TrimSpace(path) == "" {
+		return fmt.Errorf("cassette path is required")
+	}
+	state := &networkCassetteState{mode: mode, path: path, served: make(map[int]bool)}
+	switch mode {
+	case NetworkCassetteRecord:
+		state.cassette = NetworkCassette{Version: networkCassetteVersion, RecordedAt: time.Now().UTC()}
+	case NetworkCassetteReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &state.cassette); err != nil {
+			return fmt.Errorf("invalid cassette %s: %w", path, err)
+		}
+		if state.cassette.Version > networkCassetteVersion {
+			return fmt.Errorf("cassette version %d is newer than supported version %d", state.cassette.Version, networkCassetteVersion)
+		}
+	default:
+		return fmt.Errorf("invalid cassette mode %q (must be 'record' or 'replay')", mode)
+	}
+
+	networkCassetteMu.Lock()
+	if networkCassette != nil {
+		networkCassetteMu.Unlock()
+		return fmt.Errorf("a network cassette is already active (%s)", networkCassette.mode)
+	}
+	networkCassette = state
+	networkCassetteMu.Unlock()
+
+	GoLog("[HTTP] Network cassette %s started: %s (%d entries)\n", mode, path, len(state.cassette.Entries))
+	return nil
+}
+
+// stopNetworkCassette ends recording or replay. A recording is written to
+// the path it was started with.
+func stopNetworkCassette() error {
+	networkCassetteMu.Lock()
+	state := networkCassette
+	networkCassette = nil
+	networkCassetteMu.Unlock()
+	if state == nil {
+		return nil
+	}
+	GoLog("[HTTP] Network cassette %s stopped: %s\n", state.mode, state.path)
+	if state.mode != NetworkCassetteRecord {
+		return nil
+	}
+
+	state.mu.Lock()
+	data, err := json.MarshalIndent(state.cassette, "", "  ")
+	state.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(state.path, data, 0644)
+}
+
+// discardNetworkCassette ends recording or replay without writing anything.
+func discardNetworkCassette() {
+	networkCassetteMu.Lock()
+	networkCassette = nil
+	networkCassetteMu.Unlock()
+}
+
+func activeNetworkCassette() *networkCassetteState {
+	networkCassetteMu.RLock()
+	defer networkCassetteMu.RUnlock()
+	return networkCassette
+}
+
+// networkCassetteActive reports whether traffic is being recorded or
+// replayed. The cache transport steps aside while it is: a cache hit during
+// recording would leave the request out of the cassette.
+func networkCassetteActive() bool {
+	return activeNetworkCassette() != nil
+}
+
+// networkCassetteReplaying reports whether requests are answered from a
+// cassette. Host policies step aside only then, so recordings keep the
+// rate limits, retries and circuit breakers of production traffic.
+func networkCassetteReplaying() bool {
+	state := activeNetworkCassette()
+	return state != nil && state.mode == NetworkCassetteReplay
+}
+
+// networkTapTransport sits directly above the transports that talk to the
+// network and records or replays their traffic while a cassette is active.
+type networkTapTransport struct {
+	base http.RoundTripper
+}
+
+func newNetworkTapTransport(base http.RoundTripper) http.RoundTripper {
+	return &networkTapTransport{base: base}
+}
+
+func (t *networkTapTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := activeNetworkCassette()
+	if state == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	var requestBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	if state.mode == NetworkCassetteReplay {
+		return state.replay(req, requestBody)
+	}
+	return state.record(t.base, req, requestBody)
+}
+
+func (s *networkCassetteState) record(base http.RoundTripper, req *http.Request, requestBody []byte) (*http.Response, error) {
+	entry := NetworkCassetteEntry{
+		StartedAt: time.Now().UTC(),
+		Request: NetworkCassetteRequest{
+			Method:  cassetteMethod(req),
+			URL:     sanitizeCassetteURL(req.URL),
+			Headers: sanitizeCassetteHeaders(req.Header),
+		},
+	}
+	entry.Request.Body, entry.Request.BodyBase64 = encodeCassetteBody(sanitizeCassetteBody(requestBody))
+
+	resp, err := base.RoundTrip(req)
+	entry.TimeMs = time.Since(entry.StartedAt).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+		s.append(entry)
+		return nil, err
+	}
+	entry.Response = &NetworkCassetteResponse{Status: resp.StatusCode, Headers: sanitizeCassetteHeaders(resp.Header)}
+	index := s.append(entry)
+	resp.Body = &cassetteRecordingBody{ReadCloser: resp.Body, state: s, index: index}
+	return resp, nil
+}
+
+func (s *networkCassetteState) append(entry NetworkCassetteEntry) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cassette.Entries = append(s.cassette.Entries, entry)
+	return len(s.cassette.Entries) - 1
+}
+
+// replay serves the first unserved entry with the same method, redacted URL
+// and request body, repeating the last match once all have been served.
+func (s *networkCassetteState) replay(req *http.Request, requestBody []byte) (*http.Response, error) {
+	method := cassetteMethod(req)
+	targetURL := sanitizeCassetteURL(req.URL)
+	body, bodyBase64 := encodeCassetteBody(sanitizeCassetteBody(requestBody))
+
+	s.mu.Lock()
+	match := -1
+	for i, entry := range s.cassette.Entries {
+		if !strings.EqualFold(entry.Request.Method, method) || entry.Request.URL != targetURL {
+			continue
+		}
+		if entry.Request.Body != body || entry.Request.BodyBase64 != bodyBase64 {
+			continue
+		}
+		match = i
+		if !s.served[i] {
+			break
+		}
+	}
+	if match >= 0 {
+		s.served[match] = true
+	}
+	s.mu.Unlock()
+
+	if match < 0 {
+		return nil, fmt.Errorf("no cassette entry for %s %s", method, targetURL)
+	}
+	entry := s.cassette.Entries[match]
+	if entry.Error != "" || entry.Response == nil {
+		return nil, errors.New(entry.Error)
+	}
+	return entry.Response.httpResponse(req)
+}
+
+func (r *NetworkCassetteResponse) httpResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if r.BodyBase64 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(r.BodyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("cassette entry for %s has invalid body_base64", req.URL.Redacted())
+		}
+		body = decoded
+	}
+	header := make(http.Header, len(r.Headers))
+	for key, value := range r.Headers {
+		header.Set(key, value)
+	}
+	header.Del("Content-Length")
+	var reader io.Reader = bytes.NewReader(body)
+	contentLength := int64(len(body))
+	if r.Truncated {
+		// Only the start of the body was recorded; fail the read after it
+		// rather than pass a partial download off as complete.
+		reader = io.MultiReader(reader, truncatedCassetteBody{size: len(body)})
+		contentLength = -1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(reader),
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+// truncatedCassetteBody ends the replay of a body that was cut off at
+// maxCassetteBodySize when it was recorded.
+type truncatedCassetteBody struct {
+	size int
+}
+
+func (b truncatedCassetteBody) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("cassette holds only the first %d bytes of this body: %w", b.size, io.ErrUnexpectedEOF)
+}
+
+// cassetteRecordingBody copies what the caller reads, up to
+// maxCassetteBodySize, into the cassette entry when the body is closed.
+type cassetteRecordingBody struct {
+	io.ReadCloser
+	state     *networkCassetteState
+	index     int
+	buf       bytes.Buffer
+	truncated bool
+	once      sync.Once
+}
+
+func (b *cassetteRecordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if room := maxCassetteBodySize - b.buf.Len(); room >= n {
+			b.buf.Write(p[:n])
+		} else {
+			b.buf.Write(p[:max(room, 0)])
+			b.truncated = true
+		}
+	}
+	return n, err
+}
+
+func (b *cassetteRecordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.state.mu.Lock()
+		defer b.state.mu.Unlock()
+		response := b.state.cassette.Entries[b.index].Response
+		response.Body, response.BodyBase64 = encodeCassetteBody(sanitizeCassetteBody(b.buf.Bytes()))
+		response.Truncated = b.truncated
+	})
+	return err
+}
+
+func cassetteMethod(req *http.Request) string {
+	if req.Method == "" {
+		return http.MethodGet
+	}
+	return req.Method
+}
+
+func encodeCassetteBody(body []byte) (string, string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+func sanitizeCassetteURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	changed := false
+	for key := range query {
+		if cassetteSensitiveParams[strings.ToLower(key)] {
+			query.Set(key, cassetteRedacted)
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+func sanitizeCassetteHeaders(header http.Header) map[string]string {
+	sanitized := make(map[string]string, len(header))
+	for key := range header {
+		lower := strings.ToLower(key)
+		if cassetteSensitiveHeaders[lower] || strings.Contains(lower, "token") || strings.Contains(lower, "api-key") {
+			continue
+		}
+		sanitized[key] = header.Get(key)
+	}
+	return sanitized
+}
+
+// sanitizeCassetteBody redacts sensitive keys in JSON and form bodies.
+// Other bodies are returned unchanged.
+func sanitizeCassetteBody(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return body
+	}
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		var value interface{}
+		if json.Unmarshal(trimmed, &value) != nil {
+			return body
+		}
+		if !redactCassetteJSON(value) {
+			return body
+		}
+		redacted, err := json.Marshal(value)
+		if err != nil {
+			return body
+		}
+		return redacted
+	}
+	if form, err := url.ParseQuery(string(trimmed)); err == nil && utf8.Valid(trimmed) && bytes.Contains(trimmed, []byte("=")) {
+		changed := false
+		for key := range form {
+			if cassetteSensitiveParams[strings.ToLower(key)] {
+				form.Set(key, cassetteRedacted)
+				changed = true
+			}
+		}
+		if changed {
+			return []byte(form.Encode())
+		}
+	}
+	return body
+}
+
+func redactCassetteJSON(value interface{}) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if _, isString := child.(string); isString && cassetteSensitiveParams[strings.ToLower(key)] {
+				v[key] = cassetteRedacted
+				changed = true
+				continue
+			}
+			changed = redactCassetteJSON(child) || changed
+		}
+	case []interface{}:
+		for _, child := range v {
+			changed = redactCassetteJSON(child) || changed
+		}
+	}
+	return changed
+}
@@ -0,0 +1,188 @@
+package gobackend
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNetworkCassette_RecordSanitizesAndReplays(t *testing.T) {
+	t.Cleanup(func() { stopNetworkCassette() })
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	var calls int32
+	tap := newNetworkTapTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		if req.URL.Path == "/down" {
+			return nil, errors.New("dial tcp: connection refused")
+		}
+		body, _ := io.ReadAll(req.Body)
+		header := http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"session=abc"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(
+			`{"access_token": "live-token", "echo": ` + string(body) + `}`))}, nil
+	}))
+	send := func(rawURL, body string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodPost, rawURL, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer live-secret")
+		req.Header.Set("X-Tidal-Token", "live-secret")
+		return tap.RoundTrip(req)
+	}
+
+	if err := startNetworkCassette(NetworkCassetteRecord, path); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := send("https://api.music.example/login?token=live-secret&q=1", `{"user": "me", "password": "live-secret"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if _, err := send("https://api.music.example/down", ""); err == nil {
+		t.Fatal("expected the recorded request to fail")
+	}
+	if err := stopNetworkCassette(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"live-secret", "live-token", "session=abc"} {
+		if strings.Contains(string(data), leaked) {
+			t.Fatalf("cassette leaks %q:\n%s", leaked, data)
+		}
+	}
+	if !strings.Contains(string(data), `\"user\":\"me\"`) || !strings.Contains(string(data), "connection refused") {
+		t.Fatalf("cassette misses recorded data:\n%s", data)
+	}
+
+	// Replay matches requests carrying different credentials and never
+	// calls the network.
+	calls = 0
+	if err := startNetworkCassette(NetworkCassetteReplay, path); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = send("https://api.music.example/login?token=other-secret&q=1", `{"user": "me", "password": "other-secret"}`)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"echo":{"password":"REDACTED","user":"me"}`) {
+		t.Fatalf("replayed response %d %s", resp.StatusCode, body)
+	}
+	if _, err := send("https://api.music.example/down", ""); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("replayed error = %v", err)
+	}
+	if _, err := send("https://api.music.example/other", ""); err == nil || !strings.Contains(err.Error(), "no cassette entry") {
+		t.Fatalf("unrecorded request err = %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("replay reached the network %d times", calls)
+	}
+}
+
+func TestNetworkCassette_GlobalClientsReplay(t *testing.T) {
+	t.Cleanup(func() { stopNetworkCassette() })
+	useTestHostPolicies(t, HostPolicy{Host: "api.music.example", RequestsPerSecond: 0.001, Burst: 1})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette := `{"version": 1, "entries": [
+		{"request": {"method": "GET", "url": "https://api.music.example/album/1"}, "response": {"status": 200, "body": "album"}},
+		{"request": {"method": "GET", "url": "https://api.music.example/album/1"}, "response": {"status": 200, "body": "album again"}}
+	]}`
+	if err := os.WriteFile(path, []byte(cassette), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := startNetworkCassette(NetworkCassetteReplay, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := startNetworkCassette(NetworkCassetteRecord, path); err == nil {
+		t.Fatal("second cassette started while one is active")
+	}
+
+	// The rate limit would block the second request for minutes if the
+	// policy were not bypassed during replay.
+	clients := map[string]http.RoundTripper{
+		"shared":    GetSharedClient().Transport,
+		"metadata":  NewMetadataHTTPClient(DefaultTimeout).Transport,
+		"extension": newExtensionHTTPClient(&loadedExtension{ID: "cassette-ext"}, nil, DefaultTimeout).Transport,
+	}
+	for name, transport := range clients {
+		for _, want := range []string{"album", "album again"} {
+			req, _ := http.NewRequest(http.MethodGet, "https://api.music.example/album/1", nil)
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("%s client: %v", name, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != want {
+				t.Fatalf("%s client body = %q, want %q", name, body, want)
+			}
+		}
+		// Restart so the next client sees the entries unserved.
+		stopNetworkCassette()
+		startNetworkCassette(NetworkCassetteReplay, path)
+	}
+}
+
+func TestNetworkCassette_RecordKeepsPoliciesAndFailsTruncatedReplay(t *testing.T) {
+	t.Cleanup(func() { stopNetworkCassette() })
+	useTestHostPolicies(t, HostPolicy{Host: "api.music.example", MaxRetries: 1, InitialDelayMs: 1, MaxDelayMs: 1})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	var calls int32
+	transport := newHostPolicyTransport(newNetworkTapTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return policyTestResponse(http.StatusServiceUnavailable, nil), nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(strings.Repeat("x", maxCassetteBodySize+10)))}, nil
+	})))
+	get := func() (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, "https://api.music.example/file.flac", nil)
+		return transport.RoundTrip(req)
+	}
+
+	if err := startNetworkCassette(NetworkCassetteRecord, path); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := get()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("recording: %v %v", resp, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err := stopNetworkCassette(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("calls = %d, want the policy to retry the 503 while recording", calls)
+	}
+
+	if err := startNetworkCassette(NetworkCassetteReplay, path); err != nil {
+		t.Fatal(err)
+	}
+	if entries := activeNetworkCassette().cassette.Entries; len(entries) != 2 || !entries[1].Response.Truncated {
+		t.Fatalf("recorded entries = %+v", entries)
+	}
+	// Replay bypasses the policy, so the recorded 503 is served as is.
+	resp, err = get()
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("first replay: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	resp, err = get()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("second replay: %v %v", resp, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, io.ErrUnexpectedEOF) || len(body) != maxCassetteBodySize {
+		t.Fatalf("truncated replay read %d bytes, err = %v", len(body), err)
+	}
+}
@@ -0,0 +1,371 @@
+package gobackend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	DNSModeSystem = "system"
+	DNSModeDoH    = "doh"
+	DNSModeStatic = "static"
+
+	dohTimeout        = 10 * time.Second
+	dnsCacheMinTTL    = 30 * time.Second
+	dnsCacheMaxTTL    = time.Hour
+	dnsNegativeTTL    = 30 * time.Second
+	maxDNSCacheSize   = 512
+	maxDoHResponseLen = 64 * 1024
+)
+
+// defaultDoHEndpoints are used when DoH is enabled without endpoints. The
+// IP-literal endpoint still works when the ISP blocks the resolver's name.
+var defaultDoHEndpoints = []string{
+	"https://cloudflare-dns.com/dns-query",
+	"https://1.1.1.1/dns-query",
+	"https://dns.google/dns-query",
+}
+
+// DNSConfig selects how hostnames are resolved by the built-in dialers.
+// "system" uses the OS resolver. "doh" asks DoHEndpoints (RFC 8484) in
+// order. "static" uses the OS resolver for hosts not in Hosts. Hosts
+// overrides apply in the "doh" and "static" modes and map a hostname to one
+// or more IP addresses.
+type DNSConfig struct {
+	Mode         string              `json:"mode"`
+	DoHEndpoints []string            `json:"doh_endpoints,omitempty"`
+	Hosts        map[string][]string `json:"hosts,omitempty"`
+}
+
+type dnsCacheEntry struct {
+	ips       []net.IP
+	err       error
+	expiresAt time.Time
+}
+
+var (
+	dnsConfigMu sync.RWMutex
+	dnsConfig   = DNSConfig{Mode: DNSModeSystem}
+
+	dnsCacheMu sync.Mutex
+	dnsCache   = make(map[string]dnsCacheEntry)
+
+	// dohClient resolves the DoH endpoints themselves with the system
+	// resolver, so it never recurses into resolveHostIPs.
+	dohClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:               proxyForRequest,
+			DialContext:         (&net.Dialer{Timeout: dohTimeout, KeepAlive: 30 * time.Second}).DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: dohTimeout,
+		},
+		Timeout: dohTimeout,
+	}
+)
+
+func normalizeDNSConfig(cfg DNSConfig) (DNSConfig, error) {
+	cfg.Mode = strings.ToLower(strings.TrimSpace(cfg.Mode))
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = DNSModeSystem
+	case DNSModeSystem, DNSModeDoH, DNSModeStatic:
+	default:
+		return cfg, fmt.Errorf("invalid DNS mode %q (must be 'system', 'doh' or 'static')", cfg.Mode)
+	}
+
+	endpoints := make([]string, 0, len(cfg.DoHEndpoints))
+	for _, endpoint := range cfg.DoHEndpoints {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return cfg, fmt.Errorf("invalid DoH endpoint %q (must be an https URL)", endpoint)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if cfg.Mode == DNSModeDoH && len(endpoints) == 0 {
+		endpoints = append(endpoints, defaultDoHEndpoints...)
+	}
+	cfg.DoHEndpoints = endpoints
+
+	hosts := make(map[string][]string, len(cfg.Hosts))
+	for host, addrs := range cfg.Hosts {
+		host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+		if host == "" || net.ParseIP(host) != nil {
+			return cfg, fmt.Errorf("invalid host override %q", host)
+		}
+		if len(addrs) == 0 {
+			return cfg, fmt.Errorf("host override %q: at least one address is required", host)
+		}
+		for _, addr := range addrs {
+			if net.ParseIP(strings.TrimSpace(addr)) == nil {
+				return cfg, fmt.Errorf("host override %q: invalid IP address %q", host, addr)
+			}
+		}
+		hosts[host] = addrs
+	}
+	cfg.Hosts = hosts
+	if cfg.Mode == DNSModeStatic && len(hosts) == 0 {
+		return cfg, fmt.Errorf("static DNS mode requires at least one host override")
+	}
+	return cfg, nil
+}
+
+func setDNSConfig(cfg DNSConfig) error {
+	cfg, err := normalizeDNSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dnsConfigMu.Lock()
+	dnsConfig = cfg
+	dnsConfigMu.Unlock()
+
+	clearDNSCache()
+	CloseIdleConnections()
+	GoLog("[HTTP] DNS mode: %s (endpoints=%d, overrides=%d)\n", cfg.Mode, len(cfg.DoHEndpoints), len(cfg.Hosts))
+	return nil
+}
+
+func getDNSConfig() DNSConfig {
+	dnsConfigMu.RLock()
+	defer dnsConfigMu.RUnlock()
+	return dnsConfig
+}
+
+func clearDNSCache() {
+	dnsCacheMu.Lock()
+	dnsCache = make(map[string]dnsCacheEntry)
+	dnsCacheMu.Unlock()
+}
+
+// resolveHostIPs resolves host with the configured resolver. IP literals are
+// returned as is. Failures are *net.DNSError so IsISPBlocking still
+// recognizes them.
+func resolveHostIPs(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	cfg := getDNSConfig()
+	if cfg.Mode != DNSModeSystem {
+		if addrs, ok := cfg.Hosts[host]; ok {
+			ips := make([]net.IP, 0, len(addrs))
+			for _, addr := range addrs {
+				ips = append(ips, net.ParseIP(strings.TrimSpace(addr)))
+			}
+			return ips, nil
+		}
+	}
+	if cfg.Mode != DNSModeDoH {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+
+	if entry, ok := getDNSCache(host); ok {
+		return entry.ips, entry.err
+	}
+	ips, ttl, err := resolveDoH(ctx, cfg.DoHEndpoints, host)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			setDNSCache(host, nil, err, dnsNegativeTTL)
+		}
+		return nil, err
+	}
+	setDNSCache(host, ips, nil, ttl)
+	return ips, nil
+}
+
+func getDNSCache(host string) (dnsCacheEntry, bool) {
+	dnsCacheMu.Lock()
+	defer dnsCacheMu.Unlock()
+	entry, ok := dnsCache[host]
+	if !ok {
+		return entry, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(dnsCache, host)
+		return entry, false
+	}
+	return entry, true
+}
+
+func setDNSCache(host string, ips []net.IP, err error, ttl time.Duration) {
+	dnsCacheMu.Lock()
+	defer dnsCacheMu.Unlock()
+	if len(dnsCache) >= maxDNSCacheSize {
+		now := time.Now()
+		for key, entry := range dnsCache {
+			if now.After(entry.expiresAt) {
+				delete(dnsCache, key)
+			}
+		}
+		if len(dnsCache) >= maxDNSCacheSize {
+			dnsCache = make(map[string]dnsCacheEntry)
+		}
+	}
+	dnsCache[host] = dnsCacheEntry{ips: ips, err: err, expiresAt: time.Now().Add(ttl)}
+}
+
+// resolveDoH asks each endpoint in turn for A and AAAA records and returns
+// the addresses with IPv4 first, together with the smallest record TTL.
+func resolveDoH(ctx context.Context, endpoints []string, host string) ([]net.IP, time.Duration, error) {
+	var lastErr error
+	for _, endpoint := range endpoints {
+		var ips []net.IP
+		ttl := dnsCacheMaxTTL
+		notFound := true
+		var err error
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			var answer []net.IP
+			var answerTTL time.Duration
+			var found bool
+			answer, answerTTL, found, err = queryDoH(ctx, endpoint, host, qtype)
+			if err != nil {
+				break
+			}
+			if found {
+				notFound = false
+			}
+			ips = append(ips, answer...)
+			if len(answer) > 0 && answerTTL < ttl {
+				ttl = answerTTL
+			}
+		}
+		if err != nil {
+			LogDebug("HTTP", "DoH %s failed for %s: %v", endpoint, host, err)
+			lastErr = err
+			continue
+		}
+		if notFound || len(ips) == 0 {
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: endpoint, IsNotFound: true}
+		}
+		if ttl < dnsCacheMinTTL {
+			ttl = dnsCacheMinTTL
+		}
+		return ips, ttl, nil
+	}
+	return nil, 0, &net.DNSError{Err: fmt.Sprintf("DNS-over-HTTPS lookup failed: %v", lastErr), Name: host, IsTemporary: true}
+}
+
+// queryDoH sends one RFC 8484 POST query. found is false for NXDOMAIN.
+func queryDoH(ctx context.Context, endpoint, host string, qtype dnsmessage.Type) ([]net.IP, time.Duration, bool, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, false, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, false, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseLen))
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(body); err != nil {
+		return nil, 0, false, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, false, nil
+	default:
+		return nil, 0, false, fmt.Errorf("DNS error: %s", msg.RCode)
+	}
+
+	var ips []net.IP
+	ttl := dnsCacheMaxTTL
+	for _, answer := range msg.Answers {
+		var ip net.IP
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			continue
+		}
+		ips = append(ips, ip)
+		if recordTTL := time.Duration(answer.Header.TTL) * time.Second; recordTTL < ttl {
+			ttl = recordTTL
+		}
+	}
+	return ips, ttl, true, nil
+}
+
+// resolvingDialer dials with the configured resolver. It tries each
+// resolved address in turn until one connects.
+type resolvingDialer struct {
+	*net.Dialer
+}
+
+func (d resolvingDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d resolvingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if getDNSConfig().Mode == DNSModeSystem {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolveHostIPs(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		if (network == "tcp4" && ip.To4() == nil) || (network == "tcp6" && ip.To4() != nil) {
+			continue
+		}
+		conn, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no suitable address", Name: host, IsNotFound: true}}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,181 @@
+package gobackend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newTestDoHServer answers A queries for music.example and NXDOMAIN for
+// everything else, counting the queries it receives.
+func newTestDoHServer(t *testing.T, queries *int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(queries, 1)
+		body, _ := io.ReadAll(r.Body)
+		var query dnsmessage.Message
+		if r.Header.Get("Content-Type") != "application/dns-message" || query.Unpack(body) != nil || len(query.Questions) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		question := query.Questions[0]
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+		}
+		switch {
+		case question.Name.String() != "music.example.":
+			reply.RCode = dnsmessage.RCodeNameError
+		case question.Type == dnsmessage.TypeA:
+			reply.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.AResource{A: [4]byte{203, 0, 113, 7}},
+			}}
+		}
+		packed, _ := reply.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := dohClient
+	dohClient = server.Client()
+	t.Cleanup(func() { dohClient = previous })
+	return server
+}
+
+func useTestDNSConfig(t *testing.T, cfg DNSConfig) {
+	t.Helper()
+	t.Cleanup(func() { setDNSConfig(DNSConfig{}) })
+	if err := setDNSConfig(cfg); err != nil {
+		t.Fatalf("setDNSConfig: %v", err)
+	}
+}
+
+func TestDNSConfig_Normalize(t *testing.T) {
+	cfg, err := normalizeDNSConfig(DNSConfig{Mode: "DoH"})
+	if err != nil || strings.Join(cfg.DoHEndpoints, ",") != strings.Join(defaultDoHEndpoints, ",") {
+		t.Fatalf("default endpoints: %+v, %v", cfg, err)
+	}
+
+	invalid := []struct {
+		cfg     DNSConfig
+		message string
+	}{
+		{DNSConfig{Mode: "dot"}, "invalid DNS mode"},
+		{DNSConfig{Mode: DNSModeDoH, DoHEndpoints: []string{"http://dns.example/dns-query"}}, "invalid DoH endpoint"},
+		{DNSConfig{Mode: DNSModeStatic}, "requires at least one host override"},
+		{DNSConfig{Mode: DNSModeStatic, Hosts: map[string][]string{"a.example": {"not-an-ip"}}}, "invalid IP address"},
+		{DNSConfig{Mode: DNSModeStatic, Hosts: map[string][]string{"a.example": nil}}, "at least one address"},
+	}
+	for _, tt := range invalid {
+		if _, err := normalizeDNSConfig(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("normalizeDNSConfig(%+v) err = %v, want %q", tt.cfg, err, tt.message)
+		}
+	}
+}
+
+func TestResolveHostIPs_DoHCache(t *testing.T) {
+	var queries int32
+	server := newTestDoHServer(t, &queries)
+	useTestDNSConfig(t, DNSConfig{Mode: DNSModeDoH, DoHEndpoints: []string{server.URL + "/dns-query"}})
+
+	for i := 0; i < 2; i++ {
+		ips, err := resolveHostIPs(context.Background(), "Music.Example")
+		if err != nil || len(ips) != 1 || ips[0].String() != "203.0.113.7" {
+			t.Fatalf("resolveHostIPs = %v, %v", ips, err)
+		}
+	}
+	if got := atomic.LoadInt32(&queries); got != 2 {
+		t.Fatalf("queries = %d, want 2 (A and AAAA once, then cached)", got)
+	}
+
+	_, err := resolveHostIPs(context.Background(), "blocked.example")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("NXDOMAIN err = %v", err)
+	}
+	if IsISPBlocking(err, "https://blocked.example/") == nil {
+		t.Fatalf("IsISPBlocking did not recognize %v", err)
+	}
+	before := atomic.LoadInt32(&queries)
+	resolveHostIPs(context.Background(), "blocked.example")
+	if atomic.LoadInt32(&queries) != before {
+		t.Fatal("NXDOMAIN answer was not cached")
+	}
+
+	dnsCacheMu.Lock()
+	entry := dnsCache["music.example"]
+	dnsCacheMu.Unlock()
+	if ttl := time.Until(entry.expiresAt); ttl <= 290*time.Second || ttl > 300*time.Second {
+		t.Fatalf("cache TTL = %v, want the record TTL of 300s", ttl)
+	}
+}
+
+func TestResolveHostIPs_StaticOverridesAndPrivateIPCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Write([]byte("hi"))
+			conn.Close()
+		}
+	}()
+
+	useTestDNSConfig(t, DNSConfig{Mode: DNSModeStatic, Hosts: map[string][]string{"api.music.example": {"127.0.0.1"}}})
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	conn, err := resolvingDialer{&net.Dialer{}}.DialContext(context.Background(), "tcp", net.JoinHostPort("api.music.example", port))
+	if err != nil {
+		t.Fatalf("dial through override: %v", err)
+	}
+	reply, _ := io.ReadAll(conn)
+	conn.Close()
+	if string(reply) != "hi" {
+		t.Fatalf("reply = %q", reply)
+	}
+
+	// Extensions pass isPrivateIP before every request; an override to a
+	// local address must be caught there.
+	if !isPrivateIP("api.music.example") {
+		t.Fatal("isPrivateIP ignored the host override")
+	}
+}
+
+func TestDialExtension_RefusesPrivateAddressesAtConnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// The name passed the checks while it resolved to a public address and
+	// points at the device by the time it is dialed.
+	useTestDNSConfig(t, DNSConfig{Mode: DNSModeStatic, Hosts: map[string][]string{"api.music.example": {"93.184.216.34"}}})
+	if isPrivateIP("api.music.example") {
+		t.Fatal("public override reported as private")
+	}
+	useTestDNSConfig(t, DNSConfig{Mode: DNSModeStatic, Hosts: map[string][]string{"api.music.example": {"127.0.0.1"}}})
+	for _, addr := range []string{net.JoinHostPort("api.music.example", port), listener.Addr().String()} {
+		conn, err := dialExtensionContext(context.Background(), "tcp", addr)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("dial %s: connected to a private address", addr)
+		}
+		if !strings.Contains(err.Error(), "private/local network") {
+			t.Fatalf("dial %s: err = %v", addr, err)
+		}
+	}
+}
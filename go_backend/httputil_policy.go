@@ -0,0 +1,503 @@
+package gobackend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+
+	// A Retry-After longer than this is returned to the caller instead of
+	// blocking the request.
+	maxPolicyRetryAfter = time.Minute
+)
+
+// HostPolicy limits and retries requests to one host and its subdomains, or
+// to the host alone when Exact is set. Zero values disable the matching
+// feature: no RequestsPerSecond means no rate limit, no MaxConcurrent means
+// no concurrency cap, and no BreakerThreshold means the circuit never opens.
+type HostPolicy struct {
+	Host              string  `json:"host"`
+	Exact             bool    `json:"exact,omitempty"`
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+	MaxConcurrent     int     `json:"max_concurrent,omitempty"`
+	MaxRetries        int     `json:"max_retries,omitempty"`
+	InitialDelayMs    int     `json:"initial_delay_ms,omitempty"`
+	MaxDelayMs        int     `json:"max_delay_ms,omitempty"`
+	BreakerThreshold  int     `json:"breaker_threshold,omitempty"`
+	BreakerCooldownMs int     `json:"breaker_cooldown_ms,omitempty"`
+}
+
+// HostPolicyStats are the live counters of one policy.
+type HostPolicyStats struct {
+	Host         string `json:"host"`
+	Requests     int64  `json:"requests"`
+	Retries      int64  `json:"retries"`
+	Failures     int64  `json:"failures"`
+	RateLimited  int64  `json:"rate_limited"`
+	Throttled    int64  `json:"throttled"`
+	Rejected     int64  `json:"rejected"`
+	CircuitOpens int64  `json:"circuit_opens"`
+	InFlight     int    `json:"in_flight"`
+	Circuit      string `json:"circuit"`
+}
+
+// CircuitOpenError is returned without sending the request while a host's
+// circuit breaker is open.
+type CircuitOpenError struct {
+	Host    string
+	RetryIn time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s: too many failures, retry in %v", e.Host, e.RetryIn.Round(time.Second))
+}
+
+// defaultHostPolicies follow each service's published limits where there
+// is one. SongLink is left out because songLinkRateLimiter and
+// DoRequestWithRetry already handle it. Tidal is limited to its API hosts:
+// audio and image CDNs are subdomains of tidal.com too, and a long download
+// would hold a concurrency slot meant for API calls.
+var defaultHostPolicies = []HostPolicy{
+	{Host: "api.deezer.com", RequestsPerSecond: 10, Burst: 10, MaxConcurrent: 10, MaxRetries: 2, InitialDelayMs: 500, MaxDelayMs: 4000, BreakerThreshold: 8, BreakerCooldownMs: 30000},
+	{Host: "musicbrainz.org", RequestsPerSecond: 1, Burst: 1, MaxConcurrent: 2, MaxRetries: 2, InitialDelayMs: 1000, MaxDelayMs: 8000, BreakerThreshold: 8, BreakerCooldownMs: 60000},
+	{Host: "qobuz.com", RequestsPerSecond: 5, Burst: 10, MaxConcurrent: 6, MaxRetries: 2, InitialDelayMs: 500, MaxDelayMs: 4000, BreakerThreshold: 8, BreakerCooldownMs: 30000},
+	{Host: "tidal.com", Exact: true, RequestsPerSecond: 5, Burst: 10, MaxConcurrent: 6, MaxRetries: 2, InitialDelayMs: 500, MaxDelayMs: 4000, BreakerThreshold: 8, BreakerCooldownMs: 30000},
+	{Host: "api.tidal.com", RequestsPerSecond: 5, Burst: 10, MaxConcurrent: 6, MaxRetries: 2, InitialDelayMs: 500, MaxDelayMs: 4000, BreakerThreshold: 8, BreakerCooldownMs: 30000},
+	{Host: "openapi.tidal.com", RequestsPerSecond: 5, Burst: 10, MaxConcurrent: 6, MaxRetries: 2, InitialDelayMs: 500, MaxDelayMs: 4000, BreakerThreshold: 8, BreakerCooldownMs: 30000},
+	{Host: "lrclib.net", RequestsPerSecond: 5, Burst: 5, MaxConcurrent: 4, MaxRetries: 1, InitialDelayMs: 1000, MaxDelayMs: 4000, BreakerThreshold: 5, BreakerCooldownMs: 60000},
+	{Host: "lyrics.paxsenix.org", RequestsPerSecond: 2, Burst: 4, MaxConcurrent: 2, MaxRetries: 1, InitialDelayMs: 1000, MaxDelayMs: 4000, BreakerThreshold: 5, BreakerCooldownMs: 60000},
+}
+
+type hostPolicyState struct {
+	policy HostPolicy
+
+	mu        sync.Mutex
+	tokens    float64
+	refilled  time.Time
+	failures  int
+	circuit   string
+	openUntil time.Time
+	stats     HostPolicyStats
+
+	slots chan struct{}
+}
+
+func newHostPolicyState(policy HostPolicy) *hostPolicyState {
+	state := &hostPolicyState{
+		policy:   policy,
+		tokens:   float64(policy.Burst),
+		refilled: time.Now(),
+		circuit:  circuitClosed,
+		stats:    HostPolicyStats{Host: policy.Host},
+	}
+	if policy.MaxConcurrent > 0 {
+		state.slots = make(chan struct{}, policy.MaxConcurrent)
+	}
+	return state
+}
+
+var (
+	hostPoliciesMu sync.RWMutex
+	hostPolicies   = buildHostPolicyStates(defaultHostPolicies)
+)
+
+func normalizeHostPolicy(policy HostPolicy) (HostPolicy, error) {
+	policy.Host = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(policy.Host), "*."))
+	if policy.Host == "" {
+		return policy, fmt.Errorf("host is required")
+	}
+	if policy.RequestsPerSecond < 0 || policy.Burst < 0 || policy.MaxConcurrent < 0 || policy.MaxRetries < 0 ||
+		policy.InitialDelayMs < 0 || policy.MaxDelayMs < 0 || policy.BreakerThreshold < 0 || policy.BreakerCooldownMs < 0 {
+		return policy, fmt.Errorf("%s: values must not be negative", policy.Host)
+	}
+	if policy.RequestsPerSecond > 0 && policy.Burst == 0 {
+		policy.Burst = int(math.Max(1, math.Ceil(policy.RequestsPerSecond)))
+	}
+	if policy.MaxRetries > 0 && policy.InitialDelayMs == 0 {
+		policy.InitialDelayMs = int(DefaultRetryDelay / time.Millisecond)
+	}
+	if policy.MaxDelayMs < policy.InitialDelayMs {
+		policy.MaxDelayMs = policy.InitialDelayMs
+	}
+	if policy.BreakerThreshold > 0 && policy.BreakerCooldownMs == 0 {
+		policy.BreakerCooldownMs = 30000
+	}
+	return policy, nil
+}
+
+func buildHostPolicyStates(policies []HostPolicy) map[string]*hostPolicyState {
+	states := make(map[string]*hostPolicyState, len(policies))
+	for _, policy := range policies {
+		policy, err := normalizeHostPolicy(policy)
+		if err != nil {
+			continue
+		}
+		states[policy.Host] = newHostPolicyState(policy)
+	}
+	return states
+}
+
+// setHostPolicies replaces the policy table. nil restores the defaults.
+// Counters of hosts whose policy did not change are kept.
+func setHostPolicies(policies []HostPolicy) error {
+	if policies == nil {
+		policies = defaultHostPolicies
+	}
+	normalized := make([]HostPolicy, 0, len(policies))
+	seen := make(map[string]bool, len(policies))
+	for _, policy := range policies {
+		policy, err := normalizeHostPolicy(policy)
+		if err != nil {
+			return fmt.Errorf("invalid host policy: %w", err)
+		}
+		if seen[policy.Host] {
+			return fmt.Errorf("duplicate host policy for %s", policy.Host)
+		}
+		seen[policy.Host] = true
+		normalized = append(normalized, policy)
+	}
+
+	hostPoliciesMu.Lock()
+	defer hostPoliciesMu.Unlock()
+	states := make(map[string]*hostPolicyState, len(normalized))
+	for _, policy := range normalized {
+		if existing, ok := hostPolicies[policy.Host]; ok && existing.policy == policy {
+			states[policy.Host] = existing
+			continue
+		}
+		states[policy.Host] = newHostPolicyState(policy)
+	}
+	hostPolicies = states
+	GoLog("[HTTP] Host policies updated: %d hosts\n", len(states))
+	return nil
+}
+
+func getHostPolicies() []HostPolicy {
+	hostPoliciesMu.RLock()
+	defer hostPoliciesMu.RUnlock()
+	policies := make([]HostPolicy, 0, len(hostPolicies))
+	for _, state := range hostPolicies {
+		policies = append(policies, state.policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Host < policies[j].Host })
+	return policies
+}
+
+func getHostPolicyStats() []HostPolicyStats {
+	hostPoliciesMu.RLock()
+	states := make([]*hostPolicyState, 0, len(hostPolicies))
+	for _, state := range hostPolicies {
+		states = append(states, state)
+	}
+	hostPoliciesMu.RUnlock()
+
+	stats := make([]HostPolicyStats, 0, len(states))
+	for _, state := range states {
+		state.mu.Lock()
+		s := state.stats
+		s.Circuit = state.circuit
+		if s.Circuit == circuitOpen && time.Now().After(state.openUntil) {
+			s.Circuit = circuitHalfOpen
+		}
+		state.mu.Unlock()
+		s.InFlight = len(state.slots)
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
+
+// hostPolicyFor returns the state of the longest policy host matching host,
+// or nil when no policy applies. An Exact policy only matches its own host.
+func hostPolicyFor(host string) *hostPolicyState {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	hostPoliciesMu.RLock()
+	defer hostPoliciesMu.RUnlock()
+	var best *hostPolicyState
+	for key, state := range hostPolicies {
+		matches := hostMatchesDomain(host, key)
+		if state.policy.Exact {
+			matches = host == key
+		}
+		if matches && (best == nil || len(key) > len(best.policy.Host)) {
+			best = state
+		}
+	}
+	return best
+}
+
+// waitForToken takes one token from the bucket, sleeping until one is
+// available. Tokens are reserved up front so waiters are served in order.
+func (s *hostPolicyState) waitForToken(ctx context.Context) error {
+	if s.policy.RequestsPerSecond <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	now := time.Now()
+	s.tokens = math.Min(float64(s.policy.Burst), s.tokens+now.Sub(s.refilled).Seconds()*s.policy.RequestsPerSecond)
+	s.refilled = now
+	s.tokens--
+	wait := time.Duration(0)
+	if s.tokens < 0 {
+		wait = time.Duration(-s.tokens / s.policy.RequestsPerSecond * float64(time.Second))
+		s.stats.Throttled++
+	}
+	s.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	if err := sleepContext(ctx, wait); err != nil {
+		s.mu.Lock()
+		s.tokens++
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *hostPolicyState) acquireSlot(ctx context.Context) error {
+	if s.slots == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *hostPolicyState) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// allow reports whether the circuit lets a request through. After the
+// cooldown one probe request is let through; its result closes or reopens
+// the circuit.
+func (s *hostPolicyState) allow() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Requests++
+	switch s.circuit {
+	case circuitOpen:
+		if remaining := time.Until(s.openUntil); remaining > 0 {
+			s.stats.Rejected++
+			return &CircuitOpenError{Host: s.policy.Host, RetryIn: remaining}
+		}
+		s.circuit = circuitHalfOpen
+	case circuitHalfOpen:
+		s.stats.Rejected++
+		return &CircuitOpenError{Host: s.policy.Host}
+	}
+	return nil
+}
+
+func (s *hostPolicyState) record(failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !failed {
+		s.failures = 0
+		if s.circuit == circuitHalfOpen {
+			s.circuit = circuitClosed
+			GoLog("[HTTP] Circuit closed for %s\n", s.policy.Host)
+		}
+		return
+	}
+	s.stats.Failures++
+	s.failures++
+	if s.policy.BreakerThreshold <= 0 {
+		return
+	}
+	if s.circuit == circuitHalfOpen || s.failures >= s.policy.BreakerThreshold {
+		s.circuit = circuitOpen
+		s.openUntil = time.Now().Add(time.Duration(s.policy.BreakerCooldownMs) * time.Millisecond)
+		s.stats.CircuitOpens++
+		GoLog("[HTTP] Circuit opened for %s after %d failures\n", s.policy.Host, s.failures)
+	}
+}
+
+// cancelProbe lets the next request probe again when the probe request
+// ended without a result, e.g. because it was cancelled.
+func (s *hostPolicyState) cancelProbe() {
+	s.mu.Lock()
+	if s.circuit == circuitHalfOpen {
+		s.circuit = circuitOpen
+		s.openUntil = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+func (s *hostPolicyState) retryDelay(attempt int) time.Duration {
+	delay := time.Duration(s.policy.InitialDelayMs) * time.Millisecond
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Duration(s.policy.MaxDelayMs)*time.Millisecond)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hostPolicyTransport applies the policy table to every request sent
+// through base. Hosts without a policy pass straight through.
+type hostPolicyTransport struct {
+	base http.RoundTripper
+}
+
+func newHostPolicyTransport(base http.RoundTripper) http.RoundTripper {
+	return &hostPolicyTransport{base: base}
+}
+
+func (t *hostPolicyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req == nil || req.URL == nil {
+		return t.base.RoundTrip(req)
+	}
+	state := hostPolicyFor(req.URL.Hostname())
+	if state == nil || networkCassetteReplaying() {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	maxRetries := state.policy.MaxRetries
+	if !isRetryableRequest(req) {
+		maxRetries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := state.allow(); err != nil {
+			return nil, err
+		}
+		if err := state.waitForToken(ctx); err != nil {
+			state.cancelProbe()
+			return nil, err
+		}
+		if err := state.acquireSlot(ctx); err != nil {
+			state.cancelProbe()
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 {
+			var err error
+			if attemptReq, err = rewindRequest(req); err != nil {
+				state.releaseSlot()
+				state.cancelProbe()
+				return nil, err
+			}
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			state.releaseSlot()
+			if ctx.Err() != nil {
+				state.cancelProbe()
+				return nil, err
+			}
+			state.record(true)
+			if attempt >= maxRetries {
+				return nil, err
+			}
+			delay = state.retryDelay(attempt)
+			GoLog("[HTTP] %s request failed (attempt %d/%d): %v, retrying in %v\n", state.policy.Host, attempt+1, maxRetries+1, err, delay)
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			if resp.StatusCode == http.StatusTooManyRequests {
+				state.mu.Lock()
+				state.stats.RateLimited++
+				state.mu.Unlock()
+				state.record(false)
+			} else {
+				state.record(true)
+			}
+			delay = state.retryDelay(attempt)
+			if retryAfter := getRetryAfterDuration(resp); retryAfter > 0 {
+				delay = retryAfter
+			}
+			if attempt >= maxRetries || delay > maxPolicyRetryAfter {
+				resp.Body = &policySlotBody{ReadCloser: resp.Body, release: state.releaseSlot}
+				return resp, nil
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+			state.releaseSlot()
+			GoLog("[HTTP] %s returned HTTP %d (attempt %d/%d), retrying in %v\n", state.policy.Host, resp.StatusCode, attempt+1, maxRetries+1, delay)
+		default:
+			state.record(false)
+			resp.Body = &policySlotBody{ReadCloser: resp.Body, release: state.releaseSlot}
+			return resp, nil
+		}
+
+		state.mu.Lock()
+		state.stats.Retries++
+		state.mu.Unlock()
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	retryReq := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retryReq.Body = body
+	}
+	return retryReq, nil
+}
+
+// policySlotBody keeps the concurrency slot until the caller has finished
+// reading the response: at EOF or a read error, or on Close, whichever comes
+// first.
+type policySlotBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *policySlotBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *policySlotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
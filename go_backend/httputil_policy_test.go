@@ -0,0 +1,247 @@
+package gobackend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useTestHostPolicies(t *testing.T, policies ...HostPolicy) {
+	t.Helper()
+	t.Cleanup(func() { setHostPolicies(nil) })
+	if err := setHostPolicies(policies); err != nil {
+		t.Fatalf("setHostPolicies: %v", err)
+	}
+}
+
+func policyTestResponse(status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader("body"))}
+}
+
+func statsFor(t *testing.T, host string) HostPolicyStats {
+	t.Helper()
+	for _, stats := range getHostPolicyStats() {
+		if stats.Host == host {
+			return stats
+		}
+	}
+	t.Fatalf("no stats for %s", host)
+	return HostPolicyStats{}
+}
+
+func TestHostPolicy_RetriesHonorRetryAfter(t *testing.T) {
+	useTestHostPolicies(t, HostPolicy{Host: "api.music.example", MaxRetries: 2, InitialDelayMs: 1, MaxDelayMs: 5})
+
+	var calls int32
+	var gaps []time.Duration
+	last := time.Now()
+	transport := newHostPolicyTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gaps = append(gaps, time.Since(last))
+		last = time.Now()
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			return policyTestResponse(http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}), nil
+		case 2:
+			return nil, errors.New("connection reset by peer")
+		}
+		return policyTestResponse(http.StatusOK, nil), nil
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://v1.api.music.example/search", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip = %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+	if gaps[1] < time.Second {
+		t.Fatalf("retry after 429 came after %v, want Retry-After of 1s", gaps[1])
+	}
+	stats := statsFor(t, "api.music.example")
+	if stats.Retries != 2 || stats.RateLimited != 1 || stats.Failures != 1 || stats.InFlight != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// Requests with a body that cannot be replayed are sent once.
+	calls = 0
+	post, _ := http.NewRequest(http.MethodPost, "https://api.music.example/login", io.NopCloser(strings.NewReader("x")))
+	if _, err := transport.RoundTrip(post); err != nil || calls != 1 {
+		t.Fatalf("POST: calls = %d, err = %v", calls, err)
+	}
+}
+
+func TestHostPolicy_TokenBucketAndConcurrency(t *testing.T) {
+	useTestHostPolicies(t, HostPolicy{Host: "api.music.example", RequestsPerSecond: 20, Burst: 2, MaxConcurrent: 1})
+
+	var inFlight, maxInFlight int32
+	transport := newHostPolicyTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if n <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return policyTestResponse(http.StatusOK, nil), nil
+	}))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "https://api.music.example/", nil)
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Errorf("RoundTrip: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	// Two requests fit the burst; the other four wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("6 requests at 20/s with burst 2 took %v", elapsed)
+	}
+	if maxInFlight != 1 {
+		t.Fatalf("max concurrent requests = %d, want 1", maxInFlight)
+	}
+	if stats := statsFor(t, "api.music.example"); stats.Throttled != 4 || stats.Requests != 6 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestHostPolicy_CircuitBreaker(t *testing.T) {
+	useTestHostPolicies(t, HostPolicy{Host: "api.music.example", BreakerThreshold: 2, BreakerCooldownMs: 50})
+
+	healthy := atomic.Bool{}
+	var calls int32
+	transport := newHostPolicyTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		if healthy.Load() {
+			return policyTestResponse(http.StatusOK, nil), nil
+		}
+		return policyTestResponse(http.StatusServiceUnavailable, nil), nil
+	}))
+	send := func() (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://api.music.example/", nil)
+		resp, err := transport.RoundTrip(req)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	send()
+	send()
+	var openErr *CircuitOpenError
+	if _, err := send(); !errors.As(err, &openErr) || calls != 2 {
+		t.Fatalf("third request: err = %v, calls = %d", err, calls)
+	}
+	if stats := statsFor(t, "api.music.example"); stats.Circuit != circuitOpen || stats.CircuitOpens != 1 || stats.Rejected != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := send(); err != nil || calls != 3 {
+		t.Fatalf("failed probe should be sent: err = %v, calls = %d", err, calls)
+	}
+	if _, err := send(); !errors.As(err, &openErr) {
+		t.Fatalf("failed probe should reopen the circuit, err = %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	healthy.Store(true)
+	if resp, err := send(); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("probe: %v, %v", resp, err)
+	}
+	if stats := statsFor(t, "api.music.example"); stats.Circuit != circuitClosed {
+		t.Fatalf("circuit after successful probe = %s", stats.Circuit)
+	}
+}
+
+func TestSetHostPolicies_Validation(t *testing.T) {
+	t.Cleanup(func() { setHostPolicies(nil) })
+	if err := setHostPolicies([]HostPolicy{{Host: "a.example"}, {Host: "*.A.example"}}); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatalf("duplicate hosts: err = %v", err)
+	}
+	if err := setHostPolicies([]HostPolicy{{Host: "a.example", MaxRetries: -1}}); err == nil {
+		t.Fatal("negative retries accepted")
+	}
+	if err := SetHostPoliciesJSON(`[{"host": "api.music.example", "requests_per_second": 0.5}]`); err != nil {
+		t.Fatal(err)
+	}
+	if policies := getHostPolicies(); len(policies) != 1 || policies[0].Burst != 1 {
+		t.Fatalf("policies = %+v", policies)
+	}
+	if hostPolicyFor("api.deezer.com") != nil {
+		t.Fatal("replaced table still has the default Deezer policy")
+	}
+	if err := SetHostPoliciesJSON(""); err != nil || hostPolicyFor("api.deezer.com") == nil {
+		t.Fatalf("restoring defaults: %v", err)
+	}
+}
+
+func TestHostPolicy_DefaultTidalPolicyCoversOnlyAPIHosts(t *testing.T) {
+	t.Cleanup(func() { setHostPolicies(nil) })
+	setHostPolicies(nil)
+	for host, want := range map[string]string{
+		"tidal.com":                 "tidal.com",
+		"api.tidal.com":             "api.tidal.com",
+		"openapi.tidal.com":         "openapi.tidal.com",
+		"resources.tidal.com":       "",
+		"sp-pr-fa.audio.tidal.com":  "",
+		"listen.tidal.com":          "",
+		"www.qobuz.com":             "qobuz.com",
+		"api.deezer.com":            "api.deezer.com",
+		"e-cdns-proxy-1.dzcdn.net.": "",
+	} {
+		got := ""
+		if state := hostPolicyFor(host); state != nil {
+			got = state.policy.Host
+		}
+		if got != want {
+			t.Errorf("policy for %s = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestHostPolicy_SlotReleasedAtEOF(t *testing.T) {
+	useTestHostPolicies(t, HostPolicy{Host: "api.music.example", MaxConcurrent: 1})
+	transport := newHostPolicyTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return policyTestResponse(http.StatusOK, nil), nil
+	}))
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.music.example/", nil)
+		resp, err := transport.RoundTrip(req)
+		cancel()
+		if err != nil {
+			t.Fatalf("request %d: %v (slot kept after the body was read to EOF)", i, err)
+		}
+		// Read to EOF but never close.
+		if body, _ := io.ReadAll(resp.Body); string(body) != "body" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+	if stats := statsFor(t, "api.music.example"); stats.InFlight != 0 {
+		t.Fatalf("in flight = %d after both bodies were read", stats.InFlight)
+	}
+}
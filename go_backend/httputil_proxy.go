@@ -0,0 +1,348 @@
+package gobackend
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	ProxyTypeHTTP   = "http"
+	ProxyTypeSOCKS5 = "socks5"
+
+	ProxyRouteProxy  = "proxy"
+	ProxyRouteDirect = "direct"
+
+	defaultProxyTestURL = "https://www.gstatic.com/generate_204"
+	proxyTestTimeout    = 15 * time.Second
+)
+
+// proxyServiceDomains maps the service names accepted in
+// ProxyConfig.Services to the domains they use. Subdomains match too.
+var proxyServiceDomains = map[string][]string{
+	"amazon":      {"music.amazon.com"},
+	"deezer":      {"deezer.com", "dzcdn.net"},
+	"lyrics":      {"lrclib.net", "lyrics.paxsenix.org", "musixmatch.com"},
+	"musicbrainz": {"musicbrainz.org", "coverartarchive.org"},
+	"qobuz":       {"qobuz.com"},
+	"songlink":    {"song.link", "odesli.co"},
+	"spotify":     {"spotify.com", "scdn.co", "spotifycdn.com"},
+	"tidal":       {"tidal.com", "tidalhifi.com"},
+	"youtube":     {"youtube.com", "googlevideo.com", "ytimg.com"},
+}
+
+// ProxyConfig routes outbound connections through an HTTP CONNECT or SOCKS5
+// proxy. DefaultRoute decides what happens to hosts no entry in Services
+// matches. Services keys are service names from proxyServiceDomains or
+// domains such as "example.com"; values are "proxy" or "direct". To send
+// only Qobuz and lyrics through the proxy, set DefaultRoute to "direct" and
+// both services to "proxy".
+type ProxyConfig struct {
+	Enabled      bool              `json:"enabled"`
+	Type         string            `json:"type"`
+	Host         string            `json:"host"`
+	Port         int               `json:"port"`
+	Username     string            `json:"username,omitempty"`
+	Password     string            `json:"password,omitempty"`
+	DefaultRoute string            `json:"default_route,omitempty"`
+	Services     map[string]string `json:"services,omitempty"`
+}
+
+// ProxyTestResult is the outcome of TestProxyConnectionJSON.
+type ProxyTestResult struct {
+	OK         bool   `json:"ok"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+var (
+	proxyConfigMu sync.RWMutex
+	proxyConfig   ProxyConfig
+)
+
+func normalizeProxyConfig(cfg ProxyConfig) (ProxyConfig, error) {
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+	cfg.Type = strings.ToLower(strings.TrimSpace(cfg.Type))
+	switch cfg.Type {
+	case "":
+		cfg.Type = ProxyTypeHTTP
+	case ProxyTypeHTTP, ProxyTypeSOCKS5:
+	default:
+		return cfg, fmt.Errorf("invalid proxy type %q (must be 'http' or 'socks5')", cfg.Type)
+	}
+	cfg.Host = strings.TrimSpace(cfg.Host)
+	if cfg.Host == "" {
+		return cfg, fmt.Errorf("proxy host is required")
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("invalid proxy port %d", cfg.Port)
+	}
+
+	switch cfg.DefaultRoute {
+	case "":
+		cfg.DefaultRoute = ProxyRouteProxy
+	case ProxyRouteProxy, ProxyRouteDirect:
+	default:
+		return cfg, fmt.Errorf("invalid default route %q (must be 'proxy' or 'direct')", cfg.DefaultRoute)
+	}
+
+	services := make(map[string]string, len(cfg.Services))
+	for key, route := range cfg.Services {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, known := proxyServiceDomains[key]; !known && !strings.Contains(key, ".") {
+			return cfg, fmt.Errorf("unknown service %q (use a domain or one of: %s)", key, strings.Join(proxyServiceNames(), ", "))
+		}
+		if route != ProxyRouteProxy && route != ProxyRouteDirect {
+			return cfg, fmt.Errorf("service %q: invalid route %q (must be 'proxy' or 'direct')", key, route)
+		}
+		services[strings.TrimPrefix(key, "*.")] = route
+	}
+	cfg.Services = services
+	return cfg, nil
+}
+
+func proxyServiceNames() []string {
+	names := make([]string, 0, len(proxyServiceDomains))
+	for name := range proxyServiceDomains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func setProxyConfig(cfg ProxyConfig) error {
+	cfg, err := normalizeProxyConfig(cfg)
+	if err != nil {
+		return err
+	}
+	proxyConfigMu.Lock()
+	proxyConfig = cfg
+	proxyConfigMu.Unlock()
+
+	// Pooled connections were dialed with the old route.
+	CloseIdleConnections()
+	if cfg.Enabled {
+		GoLog("[HTTP] Proxy enabled: %s://%s default=%s services=%d\n", cfg.Type, net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)), cfg.DefaultRoute, len(cfg.Services))
+	} else {
+		GoLog("[HTTP] Proxy disabled\n")
+	}
+	return nil
+}
+
+func getProxyConfig() ProxyConfig {
+	proxyConfigMu.RLock()
+	defer proxyConfigMu.RUnlock()
+	return proxyConfig
+}
+
+func (cfg ProxyConfig) url() *url.URL {
+	u := &url.URL{Scheme: cfg.Type, Host: net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))}
+	if cfg.Username != "" {
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+	return u
+}
+
+func hostMatchesDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// route returns the proxy URL for connections to host, or nil to connect
+// directly. A domain entry beats a service entry, and the longest domain
+// wins.
+func (cfg ProxyConfig) route(host string) *url.URL {
+	if !cfg.Enabled {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); (ip != nil && ip.IsLoopback()) || host == "localhost" {
+		return nil
+	}
+
+	decision := cfg.DefaultRoute
+	matched := ""
+	for key, route := range cfg.Services {
+		if domains, ok := proxyServiceDomains[key]; ok {
+			for _, domain := range domains {
+				if matched == "" && hostMatchesDomain(host, domain) {
+					decision = route
+				}
+			}
+			continue
+		}
+		if hostMatchesDomain(host, key) && len(key) > len(matched) {
+			decision = route
+			matched = key
+		}
+	}
+	if decision == ProxyRouteDirect {
+		return nil
+	}
+	return cfg.url()
+}
+
+// proxyForRequest is the Proxy func of the shared transports.
+func proxyForRequest(req *http.Request) (*url.URL, error) {
+	return getProxyConfig().route(req.URL.Hostname()), nil
+}
+
+// dialContextWithProxy dials addr directly or through the configured proxy,
+// for transports that dial themselves instead of using http.Transport.
+func dialContextWithProxy(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	proxyURL := getProxyConfig().route(host)
+	if proxyURL == nil {
+		return resolvingDialer{dialer}.DialContext(ctx, network, addr)
+	}
+	return dialThroughProxy(ctx, dialer, proxyURL, addr)
+}
+
+func dialThroughProxy(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	switch proxyURL.Scheme {
+	case ProxyTypeSOCKS5:
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, resolvingDialer{dialer})
+		if err != nil {
+			return nil, err
+		}
+		conn, err := socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("socks5 proxy %s: %w", proxyURL.Host, err)
+		}
+		return conn, nil
+	case ProxyTypeHTTP:
+		return dialHTTPConnect(ctx, dialer, proxyURL, addr)
+	}
+	return nil, fmt.Errorf("unsupported proxy type %q", proxyURL.Scheme)
+}
+
+// dialHTTPConnect opens a tunnel to addr with an HTTP CONNECT request.
+func dialHTTPConnect(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := resolvingDialer{dialer}.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("http proxy %s: %w", proxyURL.Host, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s: %w", proxyURL.Host, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s: %w", proxyURL.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s refused CONNECT to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes the proxy sent right after its CONNECT
+// response before reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// testProxyConnection requests testURL through the proxy in cfg, whatever
+// its routes say, so a proxy can be checked before it is saved.
+func testProxyConnection(cfg ProxyConfig, testURL string) ProxyTestResult {
+	if strings.TrimSpace(testURL) == "" {
+		testURL = defaultProxyTestURL
+	}
+	result := ProxyTestResult{URL: testURL}
+	cfg.Enabled = true
+	cfg, err := normalizeProxyConfig(cfg)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyURL(cfg.url()),
+		TLSHandshakeTimeout: 10 * time.Second,
+		DisableKeepAlives:   true,
+	}
+	applyTLSCompatibility(transport, GetNetworkCompatibilityOptions().InsecureTLS)
+	client := &http.Client{Transport: transport, Timeout: proxyTestTimeout}
+
+	req, err := http.NewRequest(http.MethodGet, testURL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", userAgentForURL(req.URL))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	// Any answer from the test URL means the proxy got through; a 407
+	// comes from the proxy itself.
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		result.Error = "proxy authentication failed"
+	case resp.StatusCode >= 500:
+		result.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	default:
+		result.OK = true
+	}
+	return result
+}
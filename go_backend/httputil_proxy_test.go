@@ -0,0 +1,183 @@
+package gobackend
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestConnectProxy serves CONNECT tunnels and plain proxied GETs for
+// user:secret.
+func newTestConnectProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			w.Header().Set("X-Proxied", r.URL.String())
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(target, buf)
+			target.Close()
+		}()
+		io.Copy(conn, target)
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testProxyConfigFor(t *testing.T, server *httptest.Server, password string) ProxyConfig {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	portNum, _ := strconv.Atoi(port)
+	return ProxyConfig{Enabled: true, Type: ProxyTypeHTTP, Host: host, Port: portNum, Username: "user", Password: password}
+}
+
+func TestProxyConfig_Routes(t *testing.T) {
+	cfg, err := normalizeProxyConfig(ProxyConfig{
+		Enabled: true, Type: "SOCKS5", Host: "10.0.0.2", Port: 1080,
+		DefaultRoute: ProxyRouteDirect,
+		Services:     map[string]string{"qobuz": "proxy", "Lyrics": "proxy", "*.example.org": "proxy", "cdn.example.org": "direct"},
+	})
+	if err != nil {
+		t.Fatalf("normalizeProxyConfig: %v", err)
+	}
+	routes := map[string]bool{
+		"www.qobuz.com":       true,
+		"lrclib.net":          true,
+		"api.deezer.com":      false,
+		"a.example.org":       true,
+		"x.cdn.example.org":   false,
+		"notexample.org":      false,
+		"localhost":           false,
+		"127.0.0.1":           false,
+		"lyrics.paxsenix.org": true,
+	}
+	for host, proxied := range routes {
+		got := cfg.route(host)
+		if (got != nil) != proxied {
+			t.Errorf("route(%s) = %v, want proxied=%v", host, got, proxied)
+		}
+		if got != nil && got.String() != "socks5://10.0.0.2:1080" {
+			t.Errorf("route(%s) = %s", host, got)
+		}
+	}
+
+	invalid := []struct {
+		cfg     ProxyConfig
+		message string
+	}{
+		{ProxyConfig{Enabled: true, Type: "ftp", Host: "h", Port: 1}, "invalid proxy type"},
+		{ProxyConfig{Enabled: true, Port: 1}, "proxy host is required"},
+		{ProxyConfig{Enabled: true, Host: "h", Port: 70000}, "invalid proxy port"},
+		{ProxyConfig{Enabled: true, Host: "h", Port: 1, Services: map[string]string{"napster": "proxy"}}, "unknown service"},
+		{ProxyConfig{Enabled: true, Host: "h", Port: 1, Services: map[string]string{"qobuz": "yes"}}, "invalid route"},
+	}
+	for _, tt := range invalid {
+		if _, err := normalizeProxyConfig(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("normalizeProxyConfig(%+v) err = %v, want %q", tt.cfg, err, tt.message)
+		}
+	}
+}
+
+func TestProxy_HTTPConnectTunnel(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "echo %s", line)
+		conn.Close()
+	}()
+
+	server := newTestConnectProxy(t)
+	proxyURL := testProxyConfigFor(t, server, "secret").url()
+	conn, err := dialThroughProxy(context.Background(), &net.Dialer{}, proxyURL, target.Addr().String())
+	if err != nil {
+		t.Fatalf("dialThroughProxy: %v", err)
+	}
+	fmt.Fprint(conn, "hello\n")
+	reply, _ := io.ReadAll(conn)
+	conn.Close()
+	if string(reply) != "echo hello\n" {
+		t.Fatalf("reply through tunnel = %q", reply)
+	}
+
+	badURL := testProxyConfigFor(t, server, "wrong").url()
+	if _, err := dialThroughProxy(context.Background(), &net.Dialer{}, badURL, target.Addr().String()); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("wrong credentials: err = %v", err)
+	}
+}
+
+func TestProxy_ConnectionTest(t *testing.T) {
+	server := newTestConnectProxy(t)
+	result := testProxyConnection(testProxyConfigFor(t, server, "secret"), "http://music.example/ping")
+	if !result.OK || result.StatusCode != http.StatusNoContent {
+		t.Fatalf("result = %+v", result)
+	}
+
+	result = testProxyConnection(testProxyConfigFor(t, server, "wrong"), "http://music.example/ping")
+	if result.OK || result.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("wrong credentials result = %+v", result)
+	}
+
+	closed := testProxyConfigFor(t, server, "secret")
+	server.Close()
+	if result := testProxyConnection(closed, ""); result.OK || result.Error == "" || result.URL != defaultProxyTestURL {
+		t.Fatalf("unreachable proxy result = %+v", result)
+	}
+}
+
+func TestProxy_SharedTransportUsesRoute(t *testing.T) {
+	t.Cleanup(func() { setProxyConfig(ProxyConfig{}) })
+	server := newTestConnectProxy(t)
+	cfg := testProxyConfigFor(t, server, "secret")
+	cfg.DefaultRoute = ProxyRouteDirect
+	cfg.Services = map[string]string{"music.example": ProxyRouteProxy}
+	if err := setProxyConfig(cfg); err != nil {
+		t.Fatalf("setProxyConfig: %v", err)
+	}
+
+	for rawURL, want := range map[string]string{
+		"http://music.example/a": "http://user:secret@" + net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		"http://other.example/b": "",
+	} {
+		u, _ := url.Parse(rawURL)
+		got, err := sharedTransport.Proxy(&http.Request{URL: u})
+		if err != nil || (got == nil && want != "") || (got != nil && got.String() != want) {
+			t.Errorf("Proxy(%s) = %v, %v; want %q", rawURL, got, err, want)
+		}
+	}
+}
@@ -41,7 +41,7 @@ func (t *utlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	port := t.getPort(req.URL)
 	addr := net.JoinHostPort(host, port)
 
-	conn, err := t.dialer.DialContext(req.Context(), "tcp", addr)
+	conn, err := dialContextWithProxy(req.Context(), t.dialer, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +92,7 @@ func (t *utlsTransport) getPort(u *url.URL) string {
 var cloudflareBypassTransport = newUTLSTransport()
 
 var cloudflareBypassClient = &http.Client{
-	Transport: cloudflareBypassTransport,
+	Transport: newHostPolicyTransport(newNetworkTapTransport(cloudflareBypassTransport)),
 	Timeout:   DefaultTimeout,
 }
 
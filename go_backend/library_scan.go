@@ -38,6 +38,10 @@ type LibraryScanResult struct {
 	Copyright            string `json:"copyright,omitempty"`
 	Format               string `json:"format,omitempty"`
 	MetadataFromFilename bool   `json:"metadataFromFilename,omitempty"`
+
+	// CueTracks holds the virtual tracks of a FLAC image with an embedded
+	// cue sheet. When set, they replace the image itself in the library.
+	CueTracks []LibraryScanResult `json:"cueTracks,omitempty"`
 }
 
 type LibraryScanProgress struct {
@@ -169,6 +173,7 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 		libraryScanProgressMu.Lock()
 		libraryScanProgress.IsComplete = true
 		libraryScanProgressMu.Unlock()
+		emitLibraryScannedEvent(false, 0, 0, 0, 0, 0)
 		return "[]", nil
 	}
 
@@ -193,7 +198,9 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 						sheet:     sheet,
 						audioPath: audioPath,
 					}
-					cueReferencedAudioFiles[audioPath] = true
+					for _, referencedPath := range resolveCueSheetAudioPaths(sheet, audioPath) {
+						cueReferencedAudioFiles[referencedPath] = true
+					}
 				}
 			}
 		}
@@ -253,6 +260,11 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 			continue
 		}
 
+		if len(result.CueTracks) > 0 {
+			results = append(results, result.CueTracks...)
+			GoLog("[LibraryScan] Embedded cue sheet %s: %d tracks\n", filepath.Base(filePath), len(result.CueTracks))
+			continue
+		}
 		results = append(results, *result)
 	}
 
@@ -262,6 +274,7 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 	libraryScanProgressMu.Unlock()
 
 	GoLog("[LibraryScan] Scan complete: %d tracks found, %d errors\n", len(results), errorCount)
+	emitLibraryScannedEvent(false, totalFiles, len(results), 0, 0, errorCount)
 
 	jsonBytes, err := json.Marshal(results)
 	if err != nil {
@@ -389,6 +402,7 @@ func scanFLACFile(filePath string, result *LibraryScanResult, displayNameHint st
 	}
 
 	applyDefaultLibraryMetadata(filePath, displayNameHint, result)
+	result.CueTracks = scanEmbeddedCueSheetTracks(filePath, result)
 
 	return result, nil
 }
@@ -725,27 +739,29 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 	var filesToScan []libraryAudioFileInfo
 	skippedCount := 0
 	existingCueTrackModTimes := make(map[string]int64)
+	existingCueTrackPaths := make(map[string][]string)
 	for existingPath, modTime := range existingFiles {
 		if idx := strings.LastIndex(existingPath, "#track"); idx > 0 {
 			baseCuePath := existingPath[:idx]
 			if _, exists := existingCueTrackModTimes[baseCuePath]; !exists {
 				existingCueTrackModTimes[baseCuePath] = modTime
 			}
+			existingCueTrackPaths[baseCuePath] = append(existingCueTrackPaths[baseCuePath], existingPath)
 		}
 	}
 
 	for _, f := range currentFiles {
 		existingModTime, exists := existingFiles[f.path]
 		if !exists {
-			if strings.ToLower(filepath.Ext(f.path)) == ".cue" {
-				if cueTrackModTime, hasCueTracks := existingCueTrackModTimes[f.path]; hasCueTracks {
-					if f.modTime == cueTrackModTime {
-						skippedCount++
-					} else {
-						filesToScan = append(filesToScan, f)
-					}
-					continue
+			// Cue sheets and FLAC images with an embedded cue are stored as
+			// "#trackNN" virtual entries rather than under their own path.
+			if cueTrackModTime, hasCueTracks := existingCueTrackModTimes[f.path]; hasCueTracks {
+				if f.modTime == cueTrackModTime {
+					skippedCount++
+				} else {
+					filesToScan = append(filesToScan, f)
 				}
+				continue
 			}
 			filesToScan = append(filesToScan, f)
 		} else if f.modTime != existingModTime {
@@ -777,6 +793,7 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 		libraryScanProgress.IsComplete = true
 		libraryScanProgress.ProgressPct = 100
 		libraryScanProgressMu.Unlock()
+		emitLibraryScannedEvent(true, totalFiles, 0, skippedCount, len(deletedPaths), 0)
 
 		result := IncrementalScanResult{
 			Scanned:      []LibraryScanResult{},
@@ -805,7 +822,9 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 						sheet:     sheet,
 						audioPath: audioPath,
 					}
-					cueReferencedAudioFilesInc[audioPath] = true
+					for _, referencedPath := range resolveCueSheetAudioPaths(sheet, audioPath) {
+						cueReferencedAudioFilesInc[referencedPath] = true
+					}
 				}
 			}
 		}
@@ -862,7 +881,18 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 			continue
 		}
 
+		// A FLAC that gained or lost an embedded cue sheet switches between
+		// one entry under its own path and "#trackNN" entries, so the
+		// entries of the other form are removed.
+		if len(result.CueTracks) > 0 {
+			results = append(results, result.CueTracks...)
+			if _, indexed := existingFiles[f.path]; indexed {
+				deletedPaths = append(deletedPaths, f.path)
+			}
+			continue
+		}
 		results = append(results, *result)
+		deletedPaths = append(deletedPaths, existingCueTrackPaths[f.path]...)
 	}
 
 	libraryScanProgressMu.Lock()
@@ -874,6 +904,7 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 
 	GoLog("[LibraryScan] Incremental scan complete: %d scanned, %d skipped, %d deleted, %d errors\n",
 		len(results), skippedCount, len(deletedPaths), errorCount)
+	emitLibraryScannedEvent(true, totalFiles, len(results), skippedCount, len(deletedPaths), errorCount)
 
 	scanResult := IncrementalScanResult{
 		Scanned:      results,
@@ -1718,6 +1718,55 @@ func GetM4AQuality(filePath string) (AudioQuality, error) {
 	return AudioQuality{BitDepth: bitDepth, SampleRate: sampleRate}, nil
 }
 
+// GetM4ADuration returns the duration in whole seconds from the movie
+// header (mvhd) of an M4A file.
+func GetM4ADuration(filePath string) (int, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open M4A file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat M4A file: %w", err)
+	}
+	fileSize := info.Size()
+
+	moovHeader, moovFound, err := findAtomInRange(f, 0, fileSize, "moov", fileSize)
+	if err != nil || !moovFound {
+		return 0, fmt.Errorf("moov atom not found")
+	}
+	mvhdHeader, mvhdFound, err := findAtomInRange(f, moovHeader.offset+moovHeader.headerSize, moovHeader.size-moovHeader.headerSize, "mvhd", fileSize)
+	if err != nil || !mvhdFound {
+		return 0, fmt.Errorf("mvhd atom not found")
+	}
+
+	// Version 0 stores 32-bit times and duration, version 1 64-bit ones:
+	//   v0: version+flags(4) created(4) modified(4) timescale(4) duration(4)
+	//   v1: version+flags(4) created(8) modified(8) timescale(4) duration(8)
+	buf := make([]byte, 32)
+	n, err := f.ReadAt(buf, mvhdHeader.offset+mvhdHeader.headerSize)
+	if err != nil && n < 20 {
+		return 0, fmt.Errorf("failed to read mvhd atom: %w", err)
+	}
+	var timescale, duration uint64
+	if buf[0] == 1 {
+		if n < 32 {
+			return 0, fmt.Errorf("mvhd atom too short")
+		}
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:24]))
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("mvhd atom has no timescale")
+	}
+	return int(duration / timescale), nil
+}
+
 func readALACSpecificConfig(f *os.File, sampleOffset, fileSize int64) (int, int, bool) {
 	if sampleOffset < 4 {
 		return 0, 0, false
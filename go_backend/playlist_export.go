@@ -0,0 +1,340 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	PlaylistFormatM3U8 = "m3u8"
+	PlaylistFormatXSPF = "xspf"
+	PlaylistFormatJSPF = "jspf"
+)
+
+type PlaylistExportEntry struct {
+	FilePath    string `json:"file_path"`
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album,omitempty"`
+	AlbumArtist string `json:"album_artist,omitempty"`
+	DurationMS  int64  `json:"duration_ms,omitempty"`
+	TrackNumber int    `json:"track_number,omitempty"`
+	ISRC        string `json:"isrc,omitempty"`
+	CoverURL    string `json:"cover_url,omitempty"`
+	Position    int    `json:"position,omitempty"` // 1-based order in the source album/playlist
+}
+
+type PlaylistExport struct {
+	Title   string                `json:"title,omitempty"`
+	Creator string                `json:"creator,omitempty"`
+	Entries []PlaylistExportEntry `json:"entries"`
+}
+
+// parsePlaylistExportJSON accepts either a PlaylistExport object or a bare
+// array of entries.
+func parsePlaylistExportJSON(entriesJSON string) (*PlaylistExport, error) {
+	trimmed := strings.TrimSpace(entriesJSON)
+	playlist := &PlaylistExport{}
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &playlist.Entries); err != nil {
+			return nil, fmt.Errorf("failed to parse playlist entries: %w", err)
+		}
+		return playlist, nil
+	}
+	if err := json.Unmarshal([]byte(trimmed), playlist); err != nil {
+		return nil, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+	return playlist, nil
+}
+
+func normalizePlaylistFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), ".")) {
+	case "m3u8", "m3u":
+		return PlaylistFormatM3U8, nil
+	case "xspf":
+		return PlaylistFormatXSPF, nil
+	case "jspf":
+		return PlaylistFormatJSPF, nil
+	default:
+		return "", fmt.Errorf("unsupported playlist format: %s", format)
+	}
+}
+
+// playlistEntryLocation returns the entry's path relative to relativeTo when
+// possible, using forward slashes so the playlist works across platforms.
+// Paths that cannot be made relative, e.g. on another volume, stay absolute.
+func playlistEntryLocation(filePath, relativeTo string) (string, bool) {
+	if relativeTo == "" || !filepath.IsAbs(filePath) {
+		return filepath.ToSlash(filePath), !filepath.IsAbs(filePath)
+	}
+	rel, err := filepath.Rel(relativeTo, filePath)
+	if err != nil {
+		return filepath.ToSlash(filePath), false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// playlistEntryURI renders a location as a URI for XSPF/JSPF: relative paths
+// are percent-encoded per segment, absolute paths become file:// URLs.
+func playlistEntryURI(filePath, relativeTo string) string {
+	location, relative := playlistEntryLocation(filePath, relativeTo)
+	if relative {
+		segments := strings.Split(location, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return strings.Join(segments, "/")
+	}
+	return (&url.URL{Scheme: "file", Path: location}).String()
+}
+
+// playlistText strips characters that would break line-based formats and
+// replaces invalid UTF-8.
+func playlistText(s string) string {
+	s = strings.ToValidUTF8(s, "�")
+	s = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+	return strings.TrimSpace(s)
+}
+
+func renderM3U8Playlist(playlist *PlaylistExport, relativeTo string) []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if title := playlistText(playlist.Title); title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", title)
+	}
+
+	for _, entry := range playlist.Entries {
+		seconds := int64(-1)
+		if entry.DurationMS > 0 {
+			seconds = (entry.DurationMS + 500) / 1000
+		}
+		display := playlistText(entry.Title)
+		if artist := playlistText(entry.Artist); artist != "" {
+			display = artist + " - " + display
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", seconds, display)
+		if album := playlistText(entry.Album); album != "" {
+			fmt.Fprintf(&b, "#EXTALB:%s\n", album)
+		}
+		if artist := playlistText(entry.Artist); artist != "" {
+			fmt.Fprintf(&b, "#EXTART:%s\n", artist)
+		}
+		location, _ := playlistEntryLocation(entry.FilePath, relativeTo)
+		b.WriteString(location)
+		b.WriteByte('\n')
+	}
+
+	return []byte(b.String())
+}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"playlist"`
+	Version   string      `xml:"version,attr"`
+	Namespace string      `xml:"xmlns,attr"`
+	Title     string      `xml:"title,omitempty"`
+	Creator   string      `xml:"creator,omitempty"`
+	Tracks    []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier,omitempty"`
+	Title      string `xml:"title,omitempty"`
+	Creator    string `xml:"creator,omitempty"`
+	Album      string `xml:"album,omitempty"`
+	TrackNum   int    `xml:"trackNum,omitempty"`
+	Duration   int64  `xml:"duration,omitempty"`
+	Image      string `xml:"image,omitempty"`
+}
+
+func renderXSPFPlaylist(playlist *PlaylistExport, relativeTo string) ([]byte, error) {
+	doc := xspfPlaylist{
+		Version:   "1",
+		Namespace: "http://xspf.org/ns/0/",
+		Title:     playlistText(playlist.Title),
+		Creator:   playlistText(playlist.Creator),
+	}
+	for _, entry := range playlist.Entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location:   playlistEntryURI(entry.FilePath, relativeTo),
+			Identifier: playlistISRCIdentifier(entry.ISRC),
+			Title:      playlistText(entry.Title),
+			Creator:    playlistText(entry.Artist),
+			Album:      playlistText(entry.Album),
+			TrackNum:   entry.TrackNumber,
+			Duration:   entry.DurationMS,
+			Image:      entry.CoverURL,
+		})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode XSPF: %w", err)
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title   string      `json:"title,omitempty"`
+	Creator string      `json:"creator,omitempty"`
+	Track   []jspfTrack `json:"track"`
+}
+
+type jspfTrack struct {
+	Location   []string `json:"location"`
+	Identifier []string `json:"identifier,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Creator    string   `json:"creator,omitempty"`
+	Album      string   `json:"album,omitempty"`
+	TrackNum   int      `json:"trackNum,omitempty"`
+	Duration   int64    `json:"duration,omitempty"`
+	Image      string   `json:"image,omitempty"`
+}
+
+func renderJSPFPlaylist(playlist *PlaylistExport, relativeTo string) ([]byte, error) {
+	doc := jspfDocument{Playlist: jspfPlaylist{
+		Title:   playlistText(playlist.Title),
+		Creator: playlistText(playlist.Creator),
+		Track:   []jspfTrack{},
+	}}
+	for _, entry := range playlist.Entries {
+		track := jspfTrack{
+			Location: []string{playlistEntryURI(entry.FilePath, relativeTo)},
+			Title:    playlistText(entry.Title),
+			Creator:  playlistText(entry.Artist),
+			Album:    playlistText(entry.Album),
+			TrackNum: entry.TrackNumber,
+			Duration: entry.DurationMS,
+			Image:    entry.CoverURL,
+		}
+		if identifier := playlistISRCIdentifier(entry.ISRC); identifier != "" {
+			track.Identifier = []string{identifier}
+		}
+		doc.Playlist.Track = append(doc.Playlist.Track, track)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode JSPF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func playlistISRCIdentifier(isrc string) string {
+	isrc = strings.TrimSpace(isrc)
+	if isrc == "" {
+		return ""
+	}
+	return "isrc:" + strings.ToUpper(isrc)
+}
+
+// RenderPlaylist encodes playlist in format. Entry paths are written relative
+// to relativeTo when it is set.
+func RenderPlaylist(format string, playlist *PlaylistExport, relativeTo string) ([]byte, error) {
+	format, err := normalizePlaylistFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case PlaylistFormatXSPF:
+		return renderXSPFPlaylist(playlist, relativeTo)
+	case PlaylistFormatJSPF:
+		return renderJSPFPlaylist(playlist, relativeTo)
+	default:
+		return renderM3U8Playlist(playlist, relativeTo), nil
+	}
+}
+
+func writePlaylistFile(format string, playlist *PlaylistExport, outputPath, relativeTo string) (string, error) {
+	if outputPath == "" {
+		return "", fmt.Errorf("output path is required")
+	}
+
+	data, err := RenderPlaylist(format, playlist, relativeTo)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create playlist directory: %w", err)
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write playlist: %w", err)
+	}
+
+	result := map[string]interface{}{
+		"path":    outputPath,
+		"entries": len(playlist.Entries),
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// ExportPlaylistJSON writes entriesJSON (a PlaylistExport object or an array
+// of entries) to outputPath as m3u8, xspf or jspf.
+func ExportPlaylistJSON(format, entriesJSON, outputPath, relativeTo string) (string, error) {
+	playlist, err := parsePlaylistExportJSON(entriesJSON)
+	if err != nil {
+		return "", err
+	}
+	return writePlaylistFile(format, playlist, outputPath, relativeTo)
+}
+
+// WriteDownloadPlaylistJSON writes a playlist named after a finished album or
+// playlist download into outputDir, with paths relative to it. Entries with
+// a position are sorted by it, followed by the others in their given order.
+// Entries without a file, such as failed downloads, are left out.
+func WriteDownloadPlaylistJSON(format, playlistName, outputDir, entriesJSON string) (string, error) {
+	if outputDir == "" {
+		return "", fmt.Errorf("output directory is required")
+	}
+
+	playlist, err := parsePlaylistExportJSON(entriesJSON)
+	if err != nil {
+		return "", err
+	}
+	format, err = normalizePlaylistFormat(format)
+	if err != nil {
+		return "", err
+	}
+
+	if playlistName != "" {
+		playlist.Title = playlistName
+	}
+
+	var positioned, unpositioned []PlaylistExportEntry
+	for _, entry := range playlist.Entries {
+		switch {
+		case strings.TrimSpace(entry.FilePath) == "":
+		case entry.Position > 0:
+			positioned = append(positioned, entry)
+		default:
+			unpositioned = append(unpositioned, entry)
+		}
+	}
+	sort.SliceStable(positioned, func(i, j int) bool {
+		return positioned[i].Position < positioned[j].Position
+	})
+	playlist.Entries = append(positioned, unpositioned...)
+
+	outputPath := filepath.Join(outputDir, sanitizeFilename(playlist.Title)+"."+format)
+	return writePlaylistFile(format, playlist, outputPath, outputDir)
+}
@@ -0,0 +1,97 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderM3U8PlaylistUsesRelativePaths(t *testing.T) {
+	playlist := &PlaylistExport{
+		Title: "Mix",
+		Entries: []PlaylistExportEntry{{
+			FilePath:   "/music/Artist/Album/01 - Café.flac",
+			Title:      "Café",
+			Artist:     "Artist",
+			Album:      "Album",
+			DurationMS: 180400,
+		}},
+	}
+
+	data, err := RenderPlaylist("m3u", playlist, "/music/Artist")
+	if err != nil {
+		t.Fatalf("RenderPlaylist failed: %v", err)
+	}
+
+	want := "#EXTM3U\n#PLAYLIST:Mix\n#EXTINF:180,Artist - Café\n#EXTALB:Album\n#EXTART:Artist\nAlbum/01 - Café.flac\n"
+	if string(data) != want {
+		t.Fatalf("unexpected m3u8:\n%s", data)
+	}
+}
+
+func TestRenderXSPFAndJSPFEncodeLocations(t *testing.T) {
+	playlist := &PlaylistExport{Entries: []PlaylistExportEntry{
+		{FilePath: "/music/A & B/01 #1.flac", Title: "One", ISRC: "usaaa0000001"},
+		{FilePath: "/other/02.flac", Title: "Two"},
+	}}
+
+	xspf, err := RenderPlaylist("xspf", playlist, "/music")
+	if err != nil {
+		t.Fatalf("RenderPlaylist(xspf) failed: %v", err)
+	}
+	if !strings.Contains(string(xspf), "<location>A%20&amp;%20B/01%20%231.flac</location>") {
+		t.Fatalf("unexpected xspf location:\n%s", xspf)
+	}
+	if !strings.Contains(string(xspf), "<location>../other/02.flac</location>") {
+		t.Fatalf("expected relative path outside the base dir:\n%s", xspf)
+	}
+
+	jspf, err := RenderPlaylist("jspf", playlist, "")
+	if err != nil {
+		t.Fatalf("RenderPlaylist(jspf) failed: %v", err)
+	}
+	var doc jspfDocument
+	if err := json.Unmarshal(jspf, &doc); err != nil {
+		t.Fatalf("invalid jspf: %v", err)
+	}
+	first := doc.Playlist.Track[0]
+	if first.Location[0] != "file:///music/A%20&%20B/01%20%231.flac" || first.Identifier[0] != "isrc:USAAA0000001" {
+		t.Fatalf("unexpected jspf track: %+v", first)
+	}
+}
+
+func TestWriteDownloadPlaylistKeepsSourceOrder(t *testing.T) {
+	dir := t.TempDir()
+	entries := `[
+		{"file_path":"` + filepath.Join(dir, "b.flac") + `","title":"B","position":3},
+		{"file_path":"` + filepath.Join(dir, "c.flac") + `","title":"C"},
+		{"file_path":"","title":"Failed","position":2},
+		{"file_path":"` + filepath.Join(dir, "a.flac") + `","title":"A","position":1}
+	]`
+
+	resultJSON, err := WriteDownloadPlaylistJSON("m3u8", "My: Album", dir, entries)
+	if err != nil {
+		t.Fatalf("WriteDownloadPlaylistJSON failed: %v", err)
+	}
+	var result struct {
+		Path    string `json:"path"`
+		Entries int    `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+		t.Fatalf("invalid result: %v", err)
+	}
+	if result.Path != filepath.Join(dir, "My Album.m3u8") || result.Entries != 3 {
+		t.Fatalf("unexpected result: %s", resultJSON)
+	}
+
+	data, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	a, b, c := strings.Index(string(data), "a.flac"), strings.Index(string(data), "b.flac"), strings.Index(string(data), "c.flac")
+	if a > b || b > c {
+		t.Fatalf("expected positioned entries in order, then the rest:\n%s", data)
+	}
+}
@@ -21,9 +21,10 @@ import (
 )
 
 type QobuzDownloader struct {
-	client *http.Client
-	appID  string
-	apiURL string
+	client         *http.Client
+	metadataClient *http.Client
+	appID          string
+	apiURL         string
 }
 
 var (
@@ -775,8 +776,9 @@ func containsQueryQobuz(queries []string, query string) bool {
 func NewQobuzDownloader() *QobuzDownloader {
 	qobuzDownloaderOnce.Do(func() {
 		globalQobuzDownloader = &QobuzDownloader{
-			client: NewHTTPClientWithTimeout(DefaultTimeout),
-			appID:  "798273057",
+			client:         NewHTTPClientWithTimeout(DefaultTimeout),
+			metadataClient: NewMetadataHTTPClient(DefaultTimeout),
+			appID:          "798273057",
 		}
 	})
 	return globalQobuzDownloader
@@ -833,7 +835,7 @@ func (q *QobuzDownloader) getQobuzJSON(requestURL string, target interface{}) er
 		return err
 	}
 
-	resp, err := DoRequestWithUserAgent(q.client, req)
+	resp, err := DoRequestWithUserAgent(q.metadataClient, req)
 	if err != nil {
 		return err
 	}
@@ -21,8 +21,9 @@ import (
 )
 
 type TidalDownloader struct {
-	client *http.Client
-	apiURL string
+	client         *http.Client
+	metadataClient *http.Client
+	apiURL         string
 }
 
 var (
@@ -226,7 +227,8 @@ type tidalPublicTrackSearchResponse struct {
 func NewTidalDownloader() *TidalDownloader {
 	tidalDownloaderOnce.Do(func() {
 		globalTidalDownloader = &TidalDownloader{
-			client: NewHTTPClientWithTimeout(DefaultTimeout),
+			client:         NewHTTPClientWithTimeout(DefaultTimeout),
+			metadataClient: NewMetadataHTTPClient(DefaultTimeout),
 		}
 
 		apis := globalTidalDownloader.GetAvailableAPIs()
@@ -522,7 +524,7 @@ func (t *TidalDownloader) getTidalMetadataJSON(requestURL string, target interfa
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("x-tidal-token", tidalPublicToken)
 
-	resp, err := DoRequestWithUserAgent(t.client, req)
+	resp, err := DoRequestWithUserAgent(t.metadataClient, req)
 	if err != nil {
 		return err
 	}
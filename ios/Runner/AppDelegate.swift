@@ -977,6 +977,12 @@ import Gobackend  // Import Go framework
             GobackendSetLibraryCoverCacheDirJSON(cacheDir)
             return nil
             
+        case "setDuplicateIndexCacheDir":
+            let args = call.arguments as! [String: Any]
+            let cacheDir = args["cache_dir"] as! String
+            GobackendSetDuplicateIndexCacheDir(cacheDir)
+            return nil
+            
         case "scanLibraryFolder":
             let args = call.arguments as! [String: Any]
             let folderPath = args["folder_path"] as! String
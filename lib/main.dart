@@ -12,6 +12,7 @@ import 'package:spotiflac_android/providers/library_collections_provider.dart';
 import 'package:spotiflac_android/providers/local_library_provider.dart';
 import 'package:spotiflac_android/providers/settings_provider.dart';
 import 'package:spotiflac_android/services/notification_service.dart';
+import 'package:spotiflac_android/services/platform_bridge.dart';
 import 'package:spotiflac_android/services/share_intent_service.dart';
 import 'package:spotiflac_android/services/cover_cache_manager.dart';
 import 'package:spotiflac_android/utils/local_library_scan_prefs.dart';
@@ -224,6 +225,10 @@ class _EagerInitializationState extends ConsumerState<_EagerInitialization>
   Future<void> _initializeAppServices() async {
     try {
       await CoverCacheManager.initialize();
+      final appSupportDir = await getApplicationSupportDirectory();
+      await PlatformBridge.setDuplicateIndexCacheDir(
+        '${appSupportDir.path}/duplicate_index',
+      );
       await Future.wait([
         NotificationService().initialize(),
         ShareIntentService().initialize(),
@@ -1080,6 +1080,13 @@ class PlatformBridge {
     });
   }
 
+  static Future<void> setDuplicateIndexCacheDir(String cacheDir) async {
+    _log.i('setDuplicateIndexCacheDir: $cacheDir');
+    await _channel.invokeMethod('setDuplicateIndexCacheDir', {
+      'cache_dir': cacheDir,
+    });
+  }
+
   static Future<List<Map<String, dynamic>>> scanLibraryFolder(
     String folderPath,
   ) async {